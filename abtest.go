@@ -0,0 +1,126 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/cu-library/lorica/metrics"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// The variants of an A/B test.
+const (
+	VariantControl   = "control"
+	VariantAlternate = "alternate"
+)
+
+var (
+	abVersion = flag.String("abversion", "", "An alternate Summon API version, like 2.1.0, which some "+
+		"requests are sent to instead of the version in their path, to compare the two.")
+	abProfile = flag.String("abprofile", "", "A prefix from -credentialprefixes, like /sandbox, whose "+
+		"credentials are used for the alternate requests, to compare two Summon profiles.")
+	abFraction = flag.Float64("abfraction", 0, "The fraction of requests, from 0 to 1, sent to the alternate "+
+		"version or profile. Requests with a session ID stay with one variant for the whole session.")
+	abOrigins = flag.String("aborigins", "", "A list of origins, delimited by the ; character, whose requests "+
+		"are always sent to the alternate version or profile.")
+
+	// abCredentials are the credentials of the alternate profile, if there is one.
+	abCredentials *credentials
+
+	// versionPattern matches the version at the start of a Summon API path.
+	versionPattern = regexp.MustCompile(`^/[0-9]+\.[0-9]+\.[0-9]+/`)
+
+	abUpstreamDuration = metrics.NewHistogramVec("lorica_ab_upstream_duration_seconds",
+		"The time taken by the Summon API to respond, by A/B test variant, in seconds.", nil, "variant")
+	abUpstreamResponses = metrics.NewCounterVec("lorica_ab_upstream_responses_total",
+		"The number of responses from the Summon API, by A/B test variant and status class. "+
+			"Requests which failed without a response count as 5xx.", "variant", "status_class")
+)
+
+// setupABTest checks the A/B test flags, and finds the alternate profile's credentials.
+func setupABTest() error {
+	if *abFraction < 0 || *abFraction > 1 {
+		return fmt.Errorf("the fraction %v should be between 0 and 1", *abFraction)
+	}
+	if *abVersion != "" && !versionPattern.MatchString("/"+*abVersion+"/") {
+		return fmt.Errorf("the version %v should look like 2.0.0", *abVersion)
+	}
+	abCredentials = nil
+	if *abProfile != "" {
+		for _, p := range credentialPrefixes {
+			if p.prefix == *abProfile {
+				creds := p.credentials
+				abCredentials = &creds
+			}
+		}
+		if abCredentials == nil {
+			return fmt.Errorf("the profile %v isn't one of the credential prefixes", *abProfile)
+		}
+	}
+	return nil
+}
+
+// abEnabled returns true if there is an alternate version or profile to compare.
+func abEnabled() bool {
+	return *abVersion != "" || abCredentials != nil
+}
+
+// chooseVariant returns the variant the request should be sent to.
+func chooseVariant(r *http.Request) string {
+	if !abEnabled() {
+		return VariantControl
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		for _, abOrigin := range splitList(*abOrigins) {
+			if normalizeOrigin(origin) == normalizeOrigin(abOrigin) {
+				return VariantAlternate
+			}
+		}
+	}
+	if *abFraction <= 0 {
+		return VariantControl
+	}
+
+	// The session hash is a stable, evenly distributed number for the session.
+	point := rand.Float64()
+	if hash := getRequestInfo(r).session; len(hash) >= 8 {
+		n, err := strconv.ParseUint(hash[:8], 16, 32)
+		if err == nil {
+			point = float64(n) / (1 << 32)
+		}
+	}
+	if point < *abFraction {
+		return VariantAlternate
+	}
+	return VariantControl
+}
+
+// applyVariant returns the path and credentials to use for the variant.
+func applyVariant(variant, path string, creds credentials) (string, credentials) {
+	if variant != VariantAlternate {
+		return path, creds
+	}
+	if *abVersion != "" && versionPattern.MatchString(path) {
+		path = versionPattern.ReplaceAllLiteralString(path, "/"+*abVersion+"/")
+	}
+	if abCredentials != nil {
+		creds = *abCredentials
+	}
+	return path, creds
+}
+
+// recordVariant records the Summon API's response to a variant's request.
+func recordVariant(variant string, statusCode int, duration time.Duration) {
+	if !abEnabled() {
+		return
+	}
+	abUpstreamDuration.With(variant).Observe(duration.Seconds())
+	abUpstreamResponses.With(variant, statusClassLabel(statusCode)).Inc()
+}
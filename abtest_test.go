@@ -0,0 +1,124 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Bad A/B test flags are errors, and the profile's credentials are found.
+func TestSetupABTest(t *testing.T) {
+	oldCredentialPrefixes := credentialPrefixes
+	credentialPrefixes = []prefixCredentials{{prefix: "/sandbox", credentials: credentials{"SANDBOX", "key"}}}
+	oldABVersion, oldABProfile, oldABFraction := *abVersion, *abProfile, *abFraction
+	defer func() {
+		credentialPrefixes = oldCredentialPrefixes
+		*abVersion, *abProfile, *abFraction = oldABVersion, oldABProfile, oldABFraction
+		abCredentials = nil
+	}()
+
+	for _, test := range []struct {
+		version, profile string
+		fraction         float64
+	}{
+		{"2.1", "", 0.1},
+		{"2.1.0", "/prod", 0.1},
+		{"2.1.0", "", 1.5},
+	} {
+		*abVersion, *abProfile, *abFraction = test.version, test.profile, test.fraction
+		if setupABTest() == nil {
+			t.Errorf("No error for A/B test flags %+v", test)
+		}
+	}
+
+	*abVersion, *abProfile, *abFraction = "2.1.0", "/sandbox", 0.1
+	if err := setupABTest(); err != nil {
+		t.Fatal(err)
+	}
+	if abCredentials == nil || abCredentials.accessID != "SANDBOX" {
+		t.Errorf("Profile credentials not found, got %v", abCredentials)
+	}
+}
+
+// Mock the Summon API, and test that test origins are sent to the alternate version.
+func TestProxyHanderABOrigin(t *testing.T) {
+	var path string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		fmt.Fprintln(w, "")
+	}))
+	defer ts.Close()
+
+	oldAPIURL := *apiURL
+	*apiURL = ts.URL
+	oldABVersion, oldABOrigins := *abVersion, *abOrigins
+	*abVersion, *abOrigins = "2.1.0", "http://test.library.example"
+	defer func() {
+		*apiURL = oldAPIURL
+		*abVersion, *abOrigins = oldABVersion, oldABOrigins
+	}()
+
+	for origin, expected := range map[string]string{
+		"http://test.library.example": "/2.1.0/search",
+		"http://library.example":      "/2.0.0/search",
+	} {
+		req, err := http.NewRequest("GET", "/2.0.0/search?s.q=test", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Origin", origin)
+		proxyHandler(httptest.NewRecorder(), req)
+		if path != expected {
+			t.Errorf("Request from %v sent to %v, expected %v", origin, path, expected)
+		}
+	}
+}
+
+// Requests in one session always get the same variant.
+func TestChooseVariantSession(t *testing.T) {
+	oldABVersion, oldABFraction := *abVersion, *abFraction
+	*abVersion, *abFraction = "2.1.0", 0.5
+	defer func() { *abVersion, *abFraction = oldABVersion, oldABFraction }()
+
+	variants := make(map[string]int)
+	for i := 0; i < 20; i++ {
+		req, err := http.NewRequest("GET", "/2.0.0/search", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("x-summon-session-id", fmt.Sprintf("session-%v", i))
+		req, _ = withRequestInfo(req)
+		variant := chooseVariant(req)
+		for j := 0; j < 5; j++ {
+			if chooseVariant(req) != variant {
+				t.Fatal("A session changed variants.")
+			}
+		}
+		variants[variant]++
+	}
+	if variants[VariantControl] == 0 || variants[VariantAlternate] == 0 {
+		t.Errorf("Sessions weren't split between the variants, got %v", variants)
+	}
+}
+
+// applyVariant only changes the alternate variant's version.
+func TestApplyVariant(t *testing.T) {
+	oldABVersion := *abVersion
+	*abVersion = "2.1.0"
+	defer func() { *abVersion = oldABVersion }()
+
+	path, _ := applyVariant(VariantAlternate, "/2.0.0/search/2.0.0", credentials{})
+	if path != "/2.1.0/search/2.0.0" {
+		t.Errorf("Alternate path is wrong, got %v", path)
+	}
+	path, _ = applyVariant(VariantControl, "/2.0.0/search", credentials{})
+	if !strings.HasPrefix(path, "/2.0.0/") {
+		t.Errorf("Control path was changed, got %v", path)
+	}
+}
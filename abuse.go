@@ -0,0 +1,302 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	l "github.com/cu-library/lorica/loglevel"
+	"github.com/cu-library/lorica/metrics"
+	"github.com/didip/tollbooth/libstring"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultAbuseBlockDuration is the default time a client is blocked for after it is flagged.
+	DefaultAbuseBlockDuration = 15 * time.Minute
+
+	// AbuseDeepPage is the page number after which sequential paging is suspicious.
+	AbuseDeepPage = 20
+
+	// AbuseSequentialPages is how many pages in a row a client can request past AbuseDeepPage.
+	AbuseSequentialPages = 10
+
+	// AbuseRegularIntervals is how many identical intervals between requests are suspicious.
+	AbuseRegularIntervals = 10
+
+	// AbuseIntervalJitter is how close intervals have to be to count as identical.
+	AbuseIntervalJitter = 50 * time.Millisecond
+
+	// AbuseNoSessionRequests is how many requests in a row a client can send without a session ID.
+	AbuseNoSessionRequests = 100
+
+	// AbuseAlphabeticalQueries is how many queries in alphabetical order are suspicious.
+	AbuseAlphabeticalQueries = 10
+
+	// AbuseClientIdleTime is how long a client's activity is remembered after its last request.
+	AbuseClientIdleTime = 10 * time.Minute
+
+	// MaxAbuseClients is the most clients whose activity is remembered at once.
+	MaxAbuseClients = 10000
+)
+
+// The reasons a client is flagged.
+const (
+	AbuseDeepPaging          = "deep_paging"
+	AbuseRegularTiming       = "regular_intervals"
+	AbuseNoSession           = "no_session"
+	AbuseAlphabeticalQuerier = "alphabetical_queries"
+)
+
+var (
+	abuseDetection = flag.Bool("abusedetection", false, "Temporarily block clients which look like scrapers: "+
+		"paging deep into results one page at a time, sending requests at identical intervals, never sending "+
+		"a session ID, or searching for queries in alphabetical order.")
+	abuseBlockDuration = flag.Duration("abuseblockduration", DefaultAbuseBlockDuration, "The time a client "+
+		"which looks like a scraper is blocked for.")
+
+	// abuse tracks client activity, and the blocked clients.
+	abuse = newAbuseDetector()
+
+	abuseFlagsTotal = metrics.NewCounterVec("lorica_abuse_flags_total",
+		"The number of times a client was blocked for looking like a scraper.", "reason")
+	abuseRejectedTotal = metrics.NewCounterVec("lorica_abuse_rejected_total",
+		"The number of requests rejected because the client was blocked.", "reason")
+	_ = metrics.NewCollectorFunc("abuse", func(w io.Writer) {
+		metrics.WriteGauge(w, "lorica_abuse_blocked_clients", "The number of clients currently blocked.",
+			float64(len(abuse.Blocked())))
+	})
+)
+
+// clientActivity is what is remembered about a client's recent requests.
+type clientActivity struct {
+	last                time.Time
+	intervals           []time.Duration
+	lastPage            int
+	sequentialPages     int
+	lastQuery           string
+	alphabeticalQueries int
+	noSession           int
+}
+
+// blockedClient is a client which was flagged, and why.
+type blockedClient struct {
+	IP     string    `json:"ip"`
+	Reason string    `json:"reason"`
+	Until  time.Time `json:"until"`
+}
+
+// abuseDetector flags clients whose requests look like a scraper's.
+type abuseDetector struct {
+	sync.Mutex
+	clients map[string]*clientActivity
+	blocked map[string]blockedClient
+	now     func() time.Time
+}
+
+func newAbuseDetector() *abuseDetector {
+	return &abuseDetector{
+		clients: make(map[string]*clientActivity),
+		blocked: make(map[string]blockedClient),
+		now:     time.Now,
+	}
+}
+
+// Observe records a request from the client, and returns the reason
+// the client should be blocked, or an empty string.
+func (d *abuseDetector) Observe(ip string, r *http.Request) string {
+	d.Lock()
+	defer d.Unlock()
+
+	now := d.now()
+	c, ok := d.clients[ip]
+	if !ok {
+		if len(d.clients) >= MaxAbuseClients {
+			d.forgetIdleClients(now)
+			if len(d.clients) >= MaxAbuseClients {
+				return ""
+			}
+		}
+		c = &clientActivity{}
+		d.clients[ip] = c
+	}
+
+	query := r.URL.Query()
+	reason := ""
+
+	// Paging deep into the results, one page at a time.
+	page, _ := strconv.Atoi(query.Get("s.pn"))
+	if page > AbuseDeepPage && page == c.lastPage+1 {
+		c.sequentialPages++
+	} else {
+		c.sequentialPages = 0
+	}
+	c.lastPage = page
+	if c.sequentialPages >= AbuseSequentialPages {
+		reason = AbuseDeepPaging
+	}
+
+	// Requests sent at identical intervals.
+	if !c.last.IsZero() {
+		c.intervals = append(c.intervals, now.Sub(c.last))
+		if len(c.intervals) > AbuseRegularIntervals {
+			c.intervals = c.intervals[1:]
+		}
+		if len(c.intervals) == AbuseRegularIntervals && identicalIntervals(c.intervals) {
+			reason = AbuseRegularTiming
+		}
+	}
+	c.last = now
+
+	// Never sending a session ID.
+	if r.Header.Get("x-summon-session-id") == "" {
+		c.noSession++
+	} else {
+		c.noSession = 0
+	}
+	if c.noSession >= AbuseNoSessionRequests {
+		reason = AbuseNoSession
+	}
+
+	// Searching for queries in alphabetical order. Searching as you type
+	// extends the last query, so it isn't counted as a new query.
+	if q := query.Get("s.q"); q != "" {
+		switch {
+		case c.lastQuery != "" && strings.HasPrefix(q, c.lastQuery):
+		case c.lastQuery != "" && q > c.lastQuery:
+			c.alphabeticalQueries++
+		default:
+			c.alphabeticalQueries = 0
+		}
+		c.lastQuery = q
+		if c.alphabeticalQueries >= AbuseAlphabeticalQueries {
+			reason = AbuseAlphabeticalQuerier
+		}
+	}
+
+	if reason != "" {
+		d.blocked[ip] = blockedClient{IP: ip, Reason: reason, Until: now.Add(*abuseBlockDuration)}
+		delete(d.clients, ip)
+	}
+	return reason
+}
+
+// forgetIdleClients removes the activity of clients which haven't sent a request recently.
+func (d *abuseDetector) forgetIdleClients(now time.Time) {
+	for ip, c := range d.clients {
+		if now.Sub(c.last) > AbuseClientIdleTime {
+			delete(d.clients, ip)
+		}
+	}
+}
+
+// identicalIntervals returns true if all the intervals are within AbuseIntervalJitter of each other.
+func identicalIntervals(intervals []time.Duration) bool {
+	min, max := intervals[0], intervals[0]
+	for _, i := range intervals {
+		if i < min {
+			min = i
+		}
+		if i > max {
+			max = i
+		}
+	}
+	return max-min <= AbuseIntervalJitter
+}
+
+// IsBlocked returns the reason the client is blocked, if it is.
+func (d *abuseDetector) IsBlocked(ip string) (blockedClient, bool) {
+	d.Lock()
+	defer d.Unlock()
+	b, ok := d.blocked[ip]
+	if ok && !d.now().Before(b.Until) {
+		delete(d.blocked, ip)
+		return blockedClient{}, false
+	}
+	return b, ok
+}
+
+// Unblock removes the block on a client, and returns false if it wasn't blocked.
+func (d *abuseDetector) Unblock(ip string) bool {
+	d.Lock()
+	defer d.Unlock()
+	_, ok := d.blocked[ip]
+	delete(d.blocked, ip)
+	delete(d.clients, ip)
+	return ok
+}
+
+// Blocked returns the blocked clients, ordered by IP address.
+func (d *abuseDetector) Blocked() []blockedClient {
+	d.Lock()
+	defer d.Unlock()
+	now := d.now()
+	blocked := []blockedClient{}
+	for ip, b := range d.blocked {
+		if !now.Before(b.Until) {
+			delete(d.blocked, ip)
+			continue
+		}
+		blocked = append(blocked, b)
+	}
+	sort.Slice(blocked, func(i, j int) bool { return blocked[i].IP < blocked[j].IP })
+	return blocked
+}
+
+// detectAbuse is a middleware which rejects requests from blocked clients,
+// and blocks clients whose requests look like a scraper's.
+func detectAbuse(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := libstring.RemoteIP(clientIPLookups(), 0, r)
+		if b, ok := abuse.IsBlocked(ip); ok {
+			abuseRejectedTotal.With(b.Reason).Inc()
+			audit.Record(r, AuditAbuseBlocked, b.Reason)
+			tarpit(r)
+			w.Header().Set("Retry-After", strconv.Itoa(int(b.Until.Sub(abuse.now()).Seconds())+1))
+			sendRejection(w, http.StatusForbidden, blockedBody)
+			return
+		}
+		if reason := abuse.Observe(ip, r); reason != "" {
+			abuseFlagsTotal.With(reason).Inc()
+			audit.Record(r, AuditAbuseFlagged, reason)
+			l.Logf(l.WarnMessage, "Blocking %v for %v: %v", ip, *abuseBlockDuration, reason)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// blockedClientsHandler lists the blocked clients as JSON.
+func blockedClientsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(abuse.Blocked())
+}
+
+// unblockHandler removes the block on the client given by the ip parameter.
+func unblockHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		sendJSONError(w, http.StatusMethodNotAllowed, "Only POST requests accepted.", nil)
+		return
+	}
+	ip := r.FormValue("ip")
+	if ip == "" {
+		sendJSONError(w, http.StatusBadRequest, "The ip parameter is required.", nil)
+		return
+	}
+	if !abuse.Unblock(ip) {
+		sendJSONError(w, http.StatusNotFound, fmt.Sprintf("%v is not blocked.", ip), nil)
+		return
+	}
+	l.Logf(l.InfoMessage, "Unblocked %v.", ip)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "Unblocked %v.\n", ip)
+}
@@ -0,0 +1,138 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// observeRequests sends the detector one request per query, and returns the first reason a client was flagged.
+func observeRequests(t *testing.T, d *abuseDetector, step func(i int) time.Duration, session string, queries ...string) string {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	d.now = func() time.Time { return now }
+	for i, query := range queries {
+		req, err := http.NewRequest("GET", "/2.0.0/search?"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if session != "" {
+			req.Header.Set("x-summon-session-id", session)
+		}
+		if reason := d.Observe("192.0.2.1", req); reason != "" {
+			return reason
+		}
+		now = now.Add(step(i))
+	}
+	return ""
+}
+
+// irregular returns intervals which aren't identical.
+func irregular(i int) time.Duration {
+	return time.Duration(i%3) * time.Second
+}
+
+// Each scraper pattern is flagged, and ordinary use isn't.
+func TestAbuseDetectorObserve(t *testing.T) {
+	var deepPages, alphabetical, typed, ordinary, repeated []string
+	for i := 0; i < 15; i++ {
+		deepPages = append(deepPages, fmt.Sprintf("s.q=test&s.pn=%v", 30+i))
+		alphabetical = append(alphabetical, "s.q="+string('a'+byte(i)))
+		typed = append(typed, "s.q="+"photosynthesis!"[:i+1])
+		ordinary = append(ordinary, fmt.Sprintf("s.q=test&s.pn=%v", i%3+1))
+	}
+	for i := 0; i < AbuseNoSessionRequests; i++ {
+		repeated = append(repeated, "s.q=test")
+	}
+
+	if reason := observeRequests(t, newAbuseDetector(), irregular, "session", deepPages...); reason != AbuseDeepPaging {
+		t.Errorf("Deep paging not flagged, got %#v", reason)
+	}
+	if reason := observeRequests(t, newAbuseDetector(), irregular, "session", alphabetical...); reason != AbuseAlphabeticalQuerier {
+		t.Errorf("Alphabetical queries not flagged, got %#v", reason)
+	}
+	regular := func(i int) time.Duration { return 2 * time.Second }
+	if reason := observeRequests(t, newAbuseDetector(), regular, "session", ordinary...); reason != AbuseRegularTiming {
+		t.Errorf("Regular intervals not flagged, got %#v", reason)
+	}
+	if reason := observeRequests(t, newAbuseDetector(), irregular, "", repeated...); reason != AbuseNoSession {
+		t.Errorf("No session not flagged, got %#v", reason)
+	}
+	if reason := observeRequests(t, newAbuseDetector(), irregular, "session", ordinary...); reason != "" {
+		t.Errorf("Ordinary use was flagged, got %#v", reason)
+	}
+	if reason := observeRequests(t, newAbuseDetector(), irregular, "session", typed...); reason != "" {
+		t.Errorf("Searching as you type was flagged, got %#v", reason)
+	}
+}
+
+// Blocks expire, and can be removed early.
+func TestAbuseDetectorBlocks(t *testing.T) {
+	d := newAbuseDetector()
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	d.now = func() time.Time { return now }
+	d.blocked["192.0.2.1"] = blockedClient{IP: "192.0.2.1", Reason: AbuseNoSession, Until: now.Add(time.Minute)}
+	d.blocked["192.0.2.2"] = blockedClient{IP: "192.0.2.2", Reason: AbuseNoSession, Until: now.Add(time.Hour)}
+
+	if _, ok := d.IsBlocked("192.0.2.1"); !ok {
+		t.Error("Client not blocked.")
+	}
+	now = now.Add(2 * time.Minute)
+	if _, ok := d.IsBlocked("192.0.2.1"); ok {
+		t.Error("Block didn't expire.")
+	}
+	if len(d.Blocked()) != 1 {
+		t.Errorf("Expected 1 blocked client, got %v", d.Blocked())
+	}
+	if !d.Unblock("192.0.2.2") || d.Unblock("192.0.2.2") {
+		t.Error("Unblock returned the wrong result.")
+	}
+	if _, ok := d.IsBlocked("192.0.2.2"); ok {
+		t.Error("Client still blocked after Unblock.")
+	}
+}
+
+// A blocked client is rejected, and unblocked through the admin endpoint.
+func TestDetectAbuseAndUnblock(t *testing.T) {
+	oldAbuse := abuse
+	abuse = newAbuseDetector()
+	defer func() { abuse = oldAbuse }()
+	abuse.blocked["192.0.2.1"] = blockedClient{IP: "192.0.2.1", Reason: AbuseNoSession, Until: time.Now().Add(time.Hour)}
+
+	handler := detectAbuse(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	req, err := http.NewRequest("GET", "/2.0.0/search?s.q=test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.RemoteAddr = "192.0.2.1:1234"
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden || w.Header().Get("Retry-After") == "" {
+		t.Errorf("Blocked client not rejected, got %v", w.Code)
+	}
+
+	mux := newAdminMux()
+	list := httptest.NewRecorder()
+	mux.ServeHTTP(list, httptest.NewRequest("GET", "/admin/blocked", nil))
+	if !strings.Contains(list.Body.String(), "192.0.2.1") {
+		t.Errorf("Blocked client not listed, got %v", list.Body.String())
+	}
+
+	unblock := httptest.NewRecorder()
+	mux.ServeHTTP(unblock, httptest.NewRequest("POST", "/admin/unblock?ip=192.0.2.1", nil))
+	if unblock.Code != http.StatusOK {
+		t.Errorf("Unblock failed, got %v: %v", unblock.Code, unblock.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Unblocked client was rejected, got %v", w.Code)
+	}
+}
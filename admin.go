@@ -0,0 +1,107 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"github.com/cu-library/lorica/metrics"
+	"net/http"
+	"net/http/pprof"
+)
+
+var adminAddress = flag.String("adminaddress", "", "Address for the metrics, health check, profiling, and admin "+
+	"endpoints to bind on. If not set, /metrics, /healthz, and /readyz are served on the main address, "+
+	"and profiling and the admin endpoints are disabled.")
+
+// adminEndpoint is a profiling or admin endpoint. Besides serving it, the
+// fields describe it in the OpenAPI document.
+type adminEndpoint struct {
+	path    string
+	action  string
+	methods []string
+	summary string
+	params  []string
+
+	// response is a value of the type of the JSON response. If it is nil, the
+	// response has the contentType.
+	response    interface{}
+	contentType string
+
+	handler http.HandlerFunc
+}
+
+// adminEndpoints returns the profiling and admin endpoints.
+func adminEndpoints() []adminEndpoint {
+	return []adminEndpoint{
+		{"/debug/pprof/", "pprof index", []string{"GET"}, "Lists the runtime profiles.",
+			nil, nil, "text/html", pprof.Index},
+		{"/debug/pprof/cmdline", "pprof cmdline", []string{"GET"}, "Sends Lorica's command line.",
+			nil, nil, "text/plain", pprof.Cmdline},
+		{"/debug/pprof/profile", "pprof profile", []string{"GET"}, "Sends a CPU profile.",
+			[]string{"seconds"}, nil, "application/octet-stream", pprof.Profile},
+		{"/debug/pprof/symbol", "pprof symbol", []string{"GET", "POST"}, "Looks up program counters.",
+			nil, nil, "text/plain", pprof.Symbol},
+		{"/debug/pprof/trace", "pprof trace", []string{"GET"}, "Sends an execution trace.",
+			[]string{"seconds"}, nil, "application/octet-stream", pprof.Trace},
+
+		{"/admin/blocked", "list blocked clients", []string{"GET"}, "Lists the clients blocked for abuse.",
+			nil, []blockedClient{}, "", blockedClientsHandler},
+		{"/admin/unblock", "unblock client", []string{"POST"}, "Unblocks a client.",
+			[]string{"ip"}, nil, "text/plain", unblockHandler},
+		{"/admin/upstream", "switch upstream", []string{"GET", "POST"}, "Shows or switches the Summon API URL and profile.",
+			[]string{"url", "profile"}, upstreamTarget{}, "", upstreamHandler},
+		{"/admin/upstream/rollback", "roll back upstream", []string{"POST"}, "Switches back to the previous Summon API URL and profile.",
+			nil, nil, "text/plain", upstreamRollbackHandler},
+		{"/admin/credentials", "switch credentials", []string{"GET", "POST"}, "Lists the credential versions, or switches the active one.",
+			[]string{"version"}, []credentialVersionStatus{}, "", credentialsHandler},
+		{"/admin/credentials/reload", "reload credentials", []string{"POST"}, "Reloads the credential versions.",
+			nil, []credentialVersionStatus{}, "", credentialsReloadHandler},
+		{"/admin/ratelimits", "list rate limits", []string{"GET"}, "Lists the rate limiters and their most limited clients.",
+			[]string{"top"}, nil, "application/json", rateLimitsHandler},
+		{"/admin/capture", "capture bodies", []string{"GET", "POST", "DELETE"}, "Lists, starts, or stops capturing request and response bodies.",
+			[]string{"duration", "sample", "ids"}, []bodyCapture{}, "", captureHandler},
+		{"/admin/analytics", "list usage", []string{"GET"}, "Lists the usage of each tenant.",
+			[]string{"tenant"}, nil, "application/json", analyticsHandler},
+		{"/admin/export", "export query log", []string{"GET"}, "Exports the query log.",
+			[]string{"from", "to", "format"}, nil, "text/csv", exportHandler},
+		{"/admin/evidence", "read evidence", []string{"GET"}, "Sends what was stored about a request the Summon API failed.",
+			[]string{"id"}, []evidenceEntry{}, "", evidenceHandler},
+		{"/admin/logs/stream", "stream logs", []string{"GET"}, "Streams log records as server-sent events.",
+			[]string{"level", "component"}, nil, "text/event-stream", logStreamHandler},
+		{"/admin/cache", "purge cache", []string{"DELETE"}, "Purges cached responses, and the CDN.",
+			[]string{"prefix"}, nil, "application/json", cachePurgeHandler},
+		{"/admin/jobs", "list or run jobs", []string{"GET", "POST"}, "Lists the periodic jobs, or runs one now.",
+			[]string{"job"}, []jobStatus{}, "", jobsHandler},
+	}
+}
+
+// newAdminMux returns a ServeMux with the metrics, health check,
+// profiling, and admin endpoints. It should only be served on the admin
+// address. When there are admin tokens, the profiling and admin endpoints need one.
+func newAdminMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	registerPublicAdminHandlers(mux)
+
+	// Every request is audited, including the ones refused for their admin token.
+	for _, e := range adminEndpoints() {
+		mux.Handle(e.path, auditAdmin(e.action, requireAdminRole(e.handler)))
+	}
+
+	return mux
+}
+
+// registerPublicAdminHandlers adds the endpoints which are served on the
+// main address when there is no admin address.
+func registerPublicAdminHandlers(mux *http.ServeMux) {
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/healthz", healthzHandler)
+	mux.HandleFunc("/readyz", readyzHandler)
+}
+
+// healthzHandler reports that the process is alive and serving requests.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
@@ -0,0 +1,43 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// The admin mux should serve metrics, the health check, and profiling.
+func TestAdminMux(t *testing.T) {
+	mux := newAdminMux()
+	for _, path := range []string{"/metrics", "/healthz", "/readyz", "/debug/pprof/"} {
+		req, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("Admin mux returned %v for %v.", w.Code, path)
+		}
+	}
+}
+
+// Profiling shouldn't be available on the public endpoints.
+func TestPublicAdminHandlers(t *testing.T) {
+	mux := http.NewServeMux()
+	registerPublicAdminHandlers(mux)
+
+	req, err := http.NewRequest("GET", "/debug/pprof/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Profiling was served on the public endpoints, got %v.", w.Code)
+	}
+}
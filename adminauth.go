@@ -0,0 +1,179 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const (
+	// The admin roles. Observers can read, and operators can do anything.
+	RoleObserver = "observer"
+	RoleOperator = "operator"
+)
+
+var (
+	adminTokenList = flag.String("admintokens", "", "Tokens for the admin endpoints, delimited by the ; character. "+
+		"Each looks like observer:TOKEN or operator:TOKEN. Observers can only make GET and HEAD requests, and "+
+		"operators can do anything. A role can have several tokens, so a new token can be added before the old "+
+		"one is removed. When there are tokens, the admin endpoints need one, sent in the "+AdminTokenHeader+
+		" header or as an Authorization: Bearer token. -admintoken is an operator token too.")
+	adminTokensFile = flag.String("admintokensfile", "", "A file of admin tokens like -admintokens, one per "+
+		"line, which is reloaded when it changes. Lines starting with # are ignored.")
+
+	// adminAuth holds the admin tokens. It is set once the flags are parsed.
+	adminAuth = &adminAuthenticator{}
+
+	// operatorOnlyReads are the admin endpoints which observers can't read,
+	// because they show patrons' searches or Lorica's command line.
+	operatorOnlyReads = []string{"/debug/pprof/", "/admin/capture", "/admin/export", "/admin/evidence", "/admin/logs/stream"}
+)
+
+// adminAuthenticator checks the tokens sent to the admin endpoints.
+type adminAuthenticator struct {
+	sync.RWMutex
+	roles map[string]*keySet
+}
+
+// Set replaces the tokens with entries like observer:TOKEN.
+func (a *adminAuthenticator) Set(entries []string) error {
+	roles := map[string]*keySet{RoleObserver: newKeySet(), RoleOperator: newKeySet()}
+	for i, entry := range entries {
+		parts := strings.SplitN(entry, ":", 2)
+		role := strings.ToLower(strings.TrimSpace(parts[0]))
+		// The entry isn't printed, so the token doesn't end up in a log.
+		if len(parts) != 2 || strings.TrimSpace(parts[1]) == "" {
+			return fmt.Errorf("admin token %v should look like %v:TOKEN", i+1, RoleObserver)
+		}
+		keys, ok := roles[role]
+		if !ok {
+			return fmt.Errorf("admin token %v has the role %v, it should be %v or %v", i+1, role, RoleObserver, RoleOperator)
+		}
+		keys.Add(strings.TrimSpace(parts[1]))
+	}
+	a.Lock()
+	defer a.Unlock()
+	a.roles = roles
+	return nil
+}
+
+// Enabled returns true if there are admin tokens, so the admin endpoints need one.
+func (a *adminAuthenticator) Enabled() bool {
+	observers, operators := a.Len()
+	return observers+operators > 0
+}
+
+// Len returns the number of observer and operator tokens.
+func (a *adminAuthenticator) Len() (int, int) {
+	a.RLock()
+	defer a.RUnlock()
+	return a.roles[RoleObserver].Len(), a.roles[RoleOperator].Len()
+}
+
+// Role returns the role of the token, or an empty string if it isn't one.
+// Both roles are always checked, so the time taken doesn't tell them apart.
+func (a *adminAuthenticator) Role(token string) string {
+	a.RLock()
+	defer a.RUnlock()
+	observer, operator := a.roles[RoleObserver].Contains(token), a.roles[RoleOperator].Contains(token)
+	switch {
+	case operator:
+		return RoleOperator
+	case observer:
+		return RoleObserver
+	}
+	return ""
+}
+
+// adminTokenEntries returns the admin tokens from -admintokens, -admintoken,
+// and -admintokensfile. -admintoken is always an operator token, so an
+// operator who only set it has protected admin endpoints.
+func adminTokenEntries() ([]string, error) {
+	entries := splitList(*adminTokenList)
+	if *adminToken != "" {
+		entries = append(entries, RoleOperator+":"+*adminToken)
+	}
+	if *adminTokensFile == "" {
+		return entries, nil
+	}
+	lines, err := readListFile(*adminTokensFile)
+	if err != nil {
+		return nil, err
+	}
+	return append(entries, lines...), nil
+}
+
+// reloadAdminTokens reloads the admin tokens. If any are malformed, the tokens
+// loaded before are kept. Once there are tokens, they aren't replaced by none,
+// so a truncated file doesn't open the admin endpoints to everyone.
+func reloadAdminTokens() error {
+	entries, err := adminTokenEntries()
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 && adminAuth.Enabled() {
+		return errors.New("there are no admin tokens, so the tokens loaded before are kept")
+	}
+	return adminAuth.Set(entries)
+}
+
+// adminRequestToken returns the admin token sent with the request.
+func adminRequestToken(r *http.Request) string {
+	if token := r.Header.Get(AdminTokenHeader); token != "" {
+		return token
+	}
+	authorization := r.Header.Get("Authorization")
+	if len(authorization) > 7 && strings.EqualFold(authorization[:7], "Bearer ") {
+		return strings.TrimSpace(authorization[7:])
+	}
+	return ""
+}
+
+// requireAdminRole is a middleware which lets a request through if it has an
+// admin token whose role can make it. Observers can make GET and HEAD
+// requests to endpoints which aren't operator only, and operators can make
+// any request. Without admin tokens, every request is let through.
+func requireAdminRole(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !adminAuth.Enabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		attempt := startAuthAttempt()
+		role := adminAuth.Role(adminRequestToken(r))
+		if role == "" {
+			attempt.Failed()
+			audit.Record(r, AuditAuthFailed, "admin token missing or wrong")
+			w.Header().Set("WWW-Authenticate", `Bearer realm="lorica admin"`)
+			sendJSONError(w, http.StatusUnauthorized, "The admin endpoints need an admin token.",
+				[]string{"Send an observer or operator token in the " + AdminTokenHeader + " header."})
+			return
+		}
+		if role != RoleOperator && !observerAllowed(r) {
+			audit.Record(r, AuditAuthFailed, "observer token used for "+r.Method+" "+r.URL.Path)
+			sendJSONError(w, http.StatusForbidden, "Only operators can make this request.", nil)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// observerAllowed returns true if an observer can make the request.
+func observerAllowed(r *http.Request) bool {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return false
+	}
+	for _, path := range operatorOnlyReads {
+		if strings.HasPrefix(r.URL.Path, path) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,178 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Tokens are parsed by role, and malformed ones don't replace the tokens loaded before.
+func TestAdminAuthenticator(t *testing.T) {
+	a := &adminAuthenticator{}
+	if a.Enabled() {
+		t.Error("Admin auth was enabled without tokens.")
+	}
+	if err := a.Set([]string{"observer:look", "Operator: change ", "operator:next"}); err != nil {
+		t.Fatal(err)
+	}
+	for token, want := range map[string]string{"look": RoleObserver, "change": RoleOperator, "next": RoleOperator,
+		"wrong": "", "": ""} {
+		if got := a.Role(token); got != want {
+			t.Errorf("The role of %q was %q", token, got)
+		}
+	}
+	for _, bad := range [][]string{{"look"}, {"observer:"}, {"admin:secret"}} {
+		err := a.Set(bad)
+		if err == nil {
+			t.Errorf("%v was accepted.", bad)
+		} else if strings.Contains(err.Error(), "secret") {
+			t.Errorf("The error showed the token: %v", err)
+		}
+	}
+	if a.Role("look") != RoleObserver {
+		t.Error("Malformed tokens replaced the tokens.")
+	}
+}
+
+// Observers can read, operators can do anything, and requests without a token are refused.
+func TestRequireAdminRole(t *testing.T) {
+	oldSleep, oldAdminAuth := authSleep, adminAuth
+	authSleep = func(time.Duration) {}
+	adminAuth = &adminAuthenticator{}
+	defer func() { authSleep, adminAuth = oldSleep, oldAdminAuth }()
+	mux := newAdminMux()
+	request := func(method, path, header, token string) int {
+		r := httptest.NewRequest(method, path, nil)
+		if header != "" {
+			r.Header.Set(header, token)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := request("GET", "/admin/ratelimits", "", ""); code != http.StatusOK {
+		t.Errorf("Without tokens, an admin endpoint returned %v", code)
+	}
+
+	adminAuth.Set([]string{"observer:look", "operator:change"})
+	for _, c := range []struct {
+		method, path, header, token string
+		code                        int
+	}{
+		{"GET", "/admin/ratelimits", "", "", http.StatusUnauthorized},
+		{"GET", "/admin/ratelimits", AdminTokenHeader, "wrong", http.StatusUnauthorized},
+		{"GET", "/admin/ratelimits", AdminTokenHeader, "look", http.StatusOK},
+		{"GET", "/admin/ratelimits", "Authorization", "Bearer look", http.StatusOK},
+		{"POST", "/admin/unblock", AdminTokenHeader, "look", http.StatusForbidden},
+		{"POST", "/admin/unblock", AdminTokenHeader, "change", http.StatusBadRequest},
+		{"GET", "/admin/export", AdminTokenHeader, "look", http.StatusForbidden},
+		{"GET", "/debug/pprof/", AdminTokenHeader, "look", http.StatusForbidden},
+		{"GET", "/admin/logs/stream", AdminTokenHeader, "look", http.StatusForbidden},
+		{"GET", "/debug/pprof/", "Authorization", "bearer change", http.StatusOK},
+		{"GET", "/metrics", "", "", http.StatusOK},
+	} {
+		if code := request(c.method, c.path, c.header, c.token); code != c.code {
+			t.Errorf("%v %v with %v %q returned %v", c.method, c.path, c.header, c.token, code)
+		}
+	}
+}
+
+// Tokens come from the flags and the file, and -admintoken is an operator token.
+func TestAdminTokenEntries(t *testing.T) {
+	file, err := ioutil.TempFile("", "lorica")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("# Help desk\nobserver:desk\n\n")
+	file.Close()
+
+	oldList, oldFile, oldToken := *adminTokenList, *adminTokensFile, *adminToken
+	defer func() { *adminTokenList, *adminTokensFile, *adminToken = oldList, oldFile, oldToken }()
+
+	*adminTokenList, *adminTokensFile, *adminToken = "", "", "legacy"
+	if entries, err := adminTokenEntries(); err != nil || strings.Join(entries, " ") != "operator:legacy" {
+		t.Errorf("-admintoken alone gave %v, %v", entries, err)
+	}
+	*adminTokenList, *adminTokensFile = "operator:ops", file.Name()
+	entries, err := adminTokenEntries()
+	if err != nil || strings.Join(entries, " ") != "operator:ops operator:legacy observer:desk" {
+		t.Errorf("The tokens were %v, %v", entries, err)
+	}
+}
+
+// With only -admintoken set, the admin endpoints need it.
+func TestRequireAdminRoleAdminTokenOnly(t *testing.T) {
+	oldSleep, oldAdminAuth := authSleep, adminAuth
+	oldList, oldFile, oldToken := *adminTokenList, *adminTokensFile, *adminToken
+	authSleep = func(time.Duration) {}
+	defer func() {
+		authSleep, adminAuth = oldSleep, oldAdminAuth
+		*adminTokenList, *adminTokensFile, *adminToken = oldList, oldFile, oldToken
+	}()
+	*adminTokenList, *adminTokensFile, *adminToken, adminAuth = "", "", "legacy", &adminAuthenticator{}
+	if err := reloadAdminTokens(); err != nil {
+		t.Fatal(err)
+	}
+
+	mux := newAdminMux()
+	for _, c := range []struct {
+		path, token string
+		code        int
+	}{
+		{"/admin/evidence", "", http.StatusUnauthorized},
+		{"/admin/export", "", http.StatusUnauthorized},
+		{"/admin/capture", "", http.StatusUnauthorized},
+		{"/debug/pprof/", "", http.StatusUnauthorized},
+		{"/debug/pprof/", "legacy", http.StatusOK},
+	} {
+		r := httptest.NewRequest("GET", c.path, nil)
+		if c.token != "" {
+			r.Header.Set(AdminTokenHeader, c.token)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		if w.Code != c.code {
+			t.Errorf("GET %v with token %q returned %v", c.path, c.token, w.Code)
+		}
+	}
+}
+
+// An emptied tokens file doesn't remove the tokens, which would open the admin endpoints.
+func TestReloadAdminTokensEmpty(t *testing.T) {
+	file, err := ioutil.TempFile("", "lorica")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("operator:ops\n")
+	file.Close()
+
+	oldList, oldFile, oldToken, oldAdminAuth := *adminTokenList, *adminTokensFile, *adminToken, adminAuth
+	defer func() {
+		*adminTokenList, *adminTokensFile, *adminToken, adminAuth = oldList, oldFile, oldToken, oldAdminAuth
+	}()
+	*adminTokenList, *adminTokensFile, *adminToken, adminAuth = "", file.Name(), "", &adminAuthenticator{}
+
+	if err := reloadAdminTokens(); err != nil || !adminAuth.Enabled() {
+		t.Fatalf("The tokens weren't loaded: %v", err)
+	}
+	if err := ioutil.WriteFile(file.Name(), []byte("# Nothing yet\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloadAdminTokens(); err == nil {
+		t.Error("No error for a tokens file without tokens.")
+	}
+	if adminAuth.Role("ops") != RoleOperator {
+		t.Error("An emptied tokens file removed the tokens.")
+	}
+}
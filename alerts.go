@@ -0,0 +1,256 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	l "github.com/cu-library/lorica/loglevel"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultAlertCooldown is the default time before an alert rule can trigger again.
+	DefaultAlertCooldown = 30 * time.Minute
+
+	// DefaultAlertMinRequests is the default number of responses needed before a percentage rule is checked.
+	DefaultAlertMinRequests = 20
+
+	// AlertCheckInterval is how often the alert rules are checked.
+	AlertCheckInterval = 30 * time.Second
+)
+
+var (
+	alertRules = flag.String("alertrules", "", "A list of alert rules, delimited by the ; character. "+
+		"Each rule is a status code or class, a threshold, and a window. For example, 5xx>5%/5m alerts when more than 5% "+
+		"of responses in the last five minutes were 5xx errors, and 429>100/10m alerts when more than 100 responses "+
+		"in the last ten minutes were 429s.")
+	alertWebhook     = flag.String("alertwebhook", "", "A URL which will receive a JSON POST request when an alert rule triggers.")
+	alertEmail       = flag.String("alertemail", "", "A list of email addresses, delimited by the ; character, which will be emailed when an alert rule triggers.")
+	alertSMTPServer  = flag.String("alertsmtpserver", "localhost:25", "The SMTP server (host:port) used to send alert emails.")
+	alertEmailFrom   = flag.String("alertemailfrom", "lorica@localhost", "The From address of alert emails.")
+	alertCooldown    = flag.Duration("alertcooldown", DefaultAlertCooldown, "The time to wait before an alert rule can trigger again.")
+	alertMinRequests = flag.Int("alertminrequests", DefaultAlertMinRequests, "The minimum number of responses in the window "+
+		"before a percentage alert rule is checked.")
+)
+
+// alertRule describes a condition on recent responses which should be reported.
+type alertRule struct {
+	spec      string
+	class     string
+	percent   bool
+	threshold float64
+	window    time.Duration
+	lastFired time.Time
+}
+
+// alert is the message sent to notifiers when a rule is triggered.
+type alert struct {
+	Rule     string    `json:"rule"`
+	Value    float64   `json:"value"`
+	Matched  int       `json:"matched"`
+	Total    int       `json:"total"`
+	Window   string    `json:"window"`
+	Host     string    `json:"host"`
+	Time     time.Time `json:"time"`
+	Message  string    `json:"message"`
+	Instance string    `json:"instance"`
+}
+
+// notifier is something which can tell a person about an alert.
+type notifier interface {
+	Notify(a alert) error
+}
+
+// webhookNotifier POSTs alerts as JSON to a URL.
+type webhookNotifier struct {
+	url string
+}
+
+// Notify sends the alert to the webhook.
+func (n webhookNotifier) Notify(a alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: *timeout}
+	resp, err := client.Post(n.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return withoutURL(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %v", resp.Status)
+	}
+	return nil
+}
+
+// emailNotifier sends alerts by email.
+type emailNotifier struct {
+	server string
+	from   string
+	to     []string
+}
+
+// Notify sends the alert by email.
+func (n emailNotifier) Notify(a alert) error {
+	msg := fmt.Sprintf("From: %v\r\nTo: %v\r\nSubject: Lorica alert: %v\r\n\r\n%v\r\n",
+		n.from, strings.Join(n.to, ", "), a.Rule, a.Message)
+	return smtp.SendMail(n.server, nil, n.from, n.to, []byte(msg))
+}
+
+// alerter checks the alert rules against recent responses.
+type alerter struct {
+	sync.Mutex
+	rules     []*alertRule
+	notifiers []notifier
+	stats     *responseStats
+	cooldown  time.Duration
+	now       func() time.Time
+}
+
+// parseAlertRules parses a list of alert rules, delimited by the ; character.
+func parseAlertRules(specs string) ([]*alertRule, error) {
+	var rules []*alertRule
+	for _, spec := range strings.Split(specs, ";") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		rule, err := parseAlertRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// parseAlertRule parses a rule like 5xx>5%/5m.
+func parseAlertRule(spec string) (*alertRule, error) {
+	rule := &alertRule{spec: spec}
+
+	parts := strings.SplitN(spec, ">", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("alert rule '%v' should contain a >", spec)
+	}
+	rule.class = strings.ToLower(strings.TrimSpace(parts[0]))
+	if len(rule.class) != 3 {
+		return nil, fmt.Errorf("alert rule '%v' should start with a status code or class like 5xx", spec)
+	}
+
+	parts = strings.SplitN(parts[1], "/", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("alert rule '%v' should contain a / followed by a window", spec)
+	}
+	threshold := strings.TrimSpace(parts[0])
+	if strings.HasSuffix(threshold, "%") {
+		rule.percent = true
+		threshold = strings.TrimSuffix(threshold, "%")
+	}
+	var err error
+	rule.threshold, err = strconv.ParseFloat(threshold, 64)
+	if err != nil {
+		return nil, fmt.Errorf("alert rule '%v' has a bad threshold: %v", spec, err)
+	}
+	rule.window, err = time.ParseDuration(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return nil, fmt.Errorf("alert rule '%v' has a bad window: %v", spec, err)
+	}
+	if rule.window <= 0 || rule.window > StatsRetention {
+		return nil, fmt.Errorf("alert rule '%v' window should be between 0 and %v", spec, StatsRetention)
+	}
+	return rule, nil
+}
+
+// Check evaluates every rule, and sends notifications for those which triggered.
+func (a *alerter) Check() {
+	a.Lock()
+	defer a.Unlock()
+
+	now := a.now()
+	for _, rule := range a.rules {
+		matched, total := a.stats.Count(rule.class, rule.window)
+		value := float64(matched)
+		if rule.percent {
+			if total < *alertMinRequests {
+				continue
+			}
+			value = 100 * float64(matched) / float64(total)
+		}
+		if value <= rule.threshold {
+			continue
+		}
+		if !rule.lastFired.IsZero() && now.Sub(rule.lastFired) < a.cooldown {
+			l.Logf(l.DebugMessage, "Alert rule %v triggered during cooldown.", rule.spec)
+			continue
+		}
+		rule.lastFired = now
+
+		hostname, _ := os.Hostname()
+		msg := alert{
+			Rule:     rule.spec,
+			Value:    value,
+			Matched:  matched,
+			Total:    total,
+			Window:   rule.window.String(),
+			Host:     hostname,
+			Time:     now.UTC(),
+			Instance: *address,
+			Message: fmt.Sprintf("Alert rule %v triggered on %v (%v): %v of %v responses in the last %v matched %v.",
+				rule.spec, hostname, *address, matched, total, rule.window, rule.class),
+		}
+		l.Log(l.WarnMessage, msg.Message)
+		for _, n := range a.notifiers {
+			if err := n.Notify(msg); err != nil {
+				l.Logf(l.ErrorMessage, "Unable to send alert notification: %v", err)
+			}
+		}
+	}
+}
+
+// newAlerterFromFlags builds an alerter from the command line flags.
+// It returns nil if no alert rules are configured.
+func newAlerterFromFlags() (*alerter, error) {
+	rules, err := parseAlertRules(*alertRules)
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	a := &alerter{
+		rules:    rules,
+		stats:    responses,
+		cooldown: *alertCooldown,
+		now:      time.Now,
+	}
+	if *alertWebhook != "" {
+		a.notifiers = append(a.notifiers, webhookNotifier{url: *alertWebhook})
+	}
+	if *alertEmail != "" {
+		n := emailNotifier{server: *alertSMTPServer, from: *alertEmailFrom}
+		for _, to := range strings.Split(*alertEmail, ";") {
+			to = strings.TrimSpace(to)
+			if to != "" {
+				n.to = append(n.to, to)
+			}
+		}
+		a.notifiers = append(a.notifiers, n)
+	}
+	if len(a.notifiers) == 0 {
+		l.Log(l.WarnMessage, "Alert rules are configured, but no webhook or email notifier is. Alerts will only be logged.")
+	}
+	return a, nil
+}
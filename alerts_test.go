@@ -0,0 +1,136 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testNotifier remembers the alerts it was sent.
+type testNotifier struct {
+	alerts []alert
+}
+
+func (n *testNotifier) Notify(a alert) error {
+	n.alerts = append(n.alerts, a)
+	return nil
+}
+
+// Good and bad alert rules should be parsed properly.
+func TestParseAlertRules(t *testing.T) {
+	rules, err := parseAlertRules("5xx>5%/5m; 429>100/10m;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 {
+		t.Fatalf("Expected 2 rules, got %v.", len(rules))
+	}
+	if rules[0].class != "5xx" || !rules[0].percent || rules[0].threshold != 5 || rules[0].window != 5*time.Minute {
+		t.Errorf("First rule parsed incorrectly: %#v", rules[0])
+	}
+	if rules[1].class != "429" || rules[1].percent || rules[1].threshold != 100 || rules[1].window != 10*time.Minute {
+		t.Errorf("Second rule parsed incorrectly: %#v", rules[1])
+	}
+
+	for _, bad := range []string{"5xx", "5xx>5%", "5xx>x/5m", "5xx>5/forever", "5xx>5/2h", "5>5/5m"} {
+		if _, err := parseAlertRules(bad); err == nil {
+			t.Errorf("Expected an error parsing rule %v.", bad)
+		}
+	}
+}
+
+// Rules should trigger when over the threshold, and respect the cooldown.
+func TestAlerterCheck(t *testing.T) {
+	now := time.Date(2016, 1, 1, 12, 0, 0, 0, time.UTC)
+	stats := newResponseStats()
+	stats.now = func() time.Time { return now }
+
+	rules, err := parseAlertRules("5xx>5%/5m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &testNotifier{}
+	a := &alerter{
+		rules:     rules,
+		notifiers: []notifier{n},
+		stats:     stats,
+		cooldown:  time.Hour,
+		now:       func() time.Time { return now },
+	}
+
+	for i := 0; i < 95; i++ {
+		stats.Record(http.StatusOK)
+	}
+	for i := 0; i < 5; i++ {
+		stats.Record(http.StatusBadGateway)
+	}
+	a.Check()
+	if len(n.alerts) != 0 {
+		t.Fatal("Rule triggered at exactly the threshold.")
+	}
+
+	stats.Record(http.StatusBadGateway)
+	a.Check()
+	if len(n.alerts) != 1 {
+		t.Fatal("Rule did not trigger over the threshold.")
+	}
+
+	a.Check()
+	if len(n.alerts) != 1 {
+		t.Fatal("Rule triggered again during cooldown.")
+	}
+}
+
+// Percentage rules shouldn't trigger on only a few responses.
+func TestAlerterMinRequests(t *testing.T) {
+	stats := newResponseStats()
+	rules, err := parseAlertRules("5xx>5%/5m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &testNotifier{}
+	a := &alerter{rules: rules, notifiers: []notifier{n}, stats: stats, now: time.Now}
+
+	stats.Record(http.StatusBadGateway)
+	a.Check()
+	if len(n.alerts) != 0 {
+		t.Error("Rule triggered with fewer than the minimum number of responses.")
+	}
+}
+
+// The webhook notifier should POST the alert as JSON.
+func TestWebhookNotifier(t *testing.T) {
+	var received alert
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			t.Errorf("Webhook got a %v request, expected POST.", r.Method)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer ts.Close()
+
+	err := webhookNotifier{url: ts.URL}.Notify(alert{Rule: "5xx>5%/5m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received.Rule != "5xx>5%/5m" {
+		t.Errorf("Webhook received the wrong alert: %#v", received)
+	}
+
+	// The webhook's token isn't logged when it can't be reached.
+	url := ts.URL + "/services/webhooksecret"
+	ts.Close()
+	err = webhookNotifier{url: url}.Notify(alert{Rule: "5xx>5%/5m"})
+	if err == nil || strings.Contains(err.Error(), "webhooksecret") {
+		t.Errorf("Unreachable webhook returned %v", err)
+	}
+}
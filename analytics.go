@@ -0,0 +1,183 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/cu-library/lorica/metrics"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The values of the cache label when the response cache was used.
+const (
+	CacheHit  = "hit"
+	CacheMiss = "miss"
+)
+
+var (
+	tenantList = flag.String("tenants", "", "A list of tenants, delimited by the ; character, which group origins "+
+		"for usage analytics, like library=https://library.example.edu,https://*.example.edu. "+
+		"Requests from other origins are grouped by origin.")
+
+	// tenants are the parsed tenants.
+	tenants []tenant
+
+	// usage holds the usage of each tenant since Lorica started.
+	usage = newUsageStats()
+
+	tenantRequestsTotal = metrics.NewCounterVec("lorica_tenant_requests_total",
+		"The number of requests handled for each tenant.", "tenant", "status_class", "cache")
+)
+
+// tenant is a named group of origins, like the sites of one member library.
+type tenant struct {
+	name    string
+	origins []string
+}
+
+// parseTenants parses a list of tenants, delimited by the ; character.
+func parseTenants(list string) ([]tenant, error) {
+	var parsed []tenant
+	seen := make(map[string]bool)
+	for _, entry := range splitList(list) {
+		parts := strings.SplitN(entry, "=", 2)
+		name := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || name == "" {
+			return nil, fmt.Errorf("tenant entry %v should look like name=origin,origin", entry)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("tenant %v is listed more than once", name)
+		}
+		seen[name] = true
+		t := tenant{name: name}
+		for _, origin := range strings.Split(parts[1], ",") {
+			if origin = strings.TrimSpace(origin); origin == "" {
+				continue
+			}
+			if _, err := path.Match(origin, ""); err != nil {
+				return nil, fmt.Errorf("tenant %v has a bad origin pattern %v", name, origin)
+			}
+			t.origins = append(t.origins, origin)
+		}
+		if len(t.origins) == 0 {
+			return nil, fmt.Errorf("tenant %v has no origins", name)
+		}
+		parsed = append(parsed, t)
+	}
+	return parsed, nil
+}
+
+// tenantLabel returns the tenant whose origins include the origin. Origins
+// which don't belong to a tenant are labelled like the origin label.
+func tenantLabel(origin string) string {
+	normalized := normalizeOrigin(origin)
+	for _, t := range tenants {
+		for _, pattern := range t.origins {
+			if originMatches(pattern, normalized) {
+				return t.name
+			}
+		}
+	}
+	return originLabel(origin)
+}
+
+// tenantUsage counts a tenant's requests.
+type tenantUsage struct {
+	Tenant       string `json:"tenant"`
+	Requests     int64  `json:"requests"`
+	ClientErrors int64  `json:"client_errors"`
+	ServerErrors int64  `json:"server_errors"`
+	CacheHits    int64  `json:"cache_hits"`
+	CacheMisses  int64  `json:"cache_misses"`
+
+	// ErrorRate is the fraction of requests which got a 4xx or 5xx response.
+	ErrorRate float64 `json:"error_rate"`
+
+	// CacheHitRatio is the fraction of cache lookups which were hits.
+	CacheHitRatio float64 `json:"cache_hit_ratio"`
+}
+
+// usageStats counts requests by tenant.
+type usageStats struct {
+	sync.Mutex
+	since   time.Time
+	tenants map[string]*tenantUsage
+}
+
+func newUsageStats() *usageStats {
+	return &usageStats{since: time.Now(), tenants: make(map[string]*tenantUsage)}
+}
+
+// Record counts a response for the tenant.
+func (s *usageStats) Record(tenantName string, statusCode int, cache string) {
+	tenantRequestsTotal.With(tenantName, statusClassLabel(statusCode), cache).Inc()
+
+	s.Lock()
+	defer s.Unlock()
+	u, ok := s.tenants[tenantName]
+	if !ok {
+		u = &tenantUsage{Tenant: tenantName}
+		s.tenants[tenantName] = u
+	}
+	u.Requests++
+	switch {
+	case statusCode >= 500:
+		u.ServerErrors++
+	case statusCode >= 400:
+		u.ClientErrors++
+	}
+	switch cache {
+	case CacheHit:
+		u.CacheHits++
+	case CacheMiss:
+		u.CacheMisses++
+	}
+}
+
+// Usage returns the usage of each tenant, most requests first.
+func (s *usageStats) Usage() []tenantUsage {
+	s.Lock()
+	defer s.Unlock()
+	all := []tenantUsage{}
+	for _, u := range s.tenants {
+		copied := *u
+		copied.ErrorRate = float64(u.ClientErrors+u.ServerErrors) / float64(u.Requests)
+		if lookups := u.CacheHits + u.CacheMisses; lookups > 0 {
+			copied.CacheHitRatio = float64(u.CacheHits) / float64(lookups)
+		}
+		all = append(all, copied)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Requests != all[j].Requests {
+			return all[i].Requests > all[j].Requests
+		}
+		return all[i].Tenant < all[j].Tenant
+	})
+	return all
+}
+
+// analyticsHandler lists the usage of each tenant since Lorica started.
+// The tenant parameter limits the list to one tenant.
+func analyticsHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.FormValue("tenant")
+	list := []tenantUsage{}
+	for _, u := range usage.Usage() {
+		if name == "" || u.Tenant == name {
+			list = append(list, u)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(struct {
+		Since   time.Time     `json:"since"`
+		Tenants []tenantUsage `json:"tenants"`
+	}{usage.since, list})
+}
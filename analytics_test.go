@@ -0,0 +1,71 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseTenants(t *testing.T) {
+	parsed, err := parseTenants("library=https://library.example.edu, https://*.example.edu; museum=http://museum.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed) != 2 || parsed[0].name != "library" || len(parsed[0].origins) != 2 || parsed[1].origins[0] != "http://museum.example" {
+		t.Errorf("Tenants were %+v", parsed)
+	}
+	for _, bad := range []string{"library", "=http://a", "library=", "a=http://a;a=http://b", "a=http://[bad"} {
+		if _, err := parseTenants(bad); err == nil {
+			t.Errorf("Tenants %v didn't return an error.", bad)
+		}
+	}
+}
+
+// Requests are counted for the tenant of their origin, or the origin label.
+func TestAnalyticsHandler(t *testing.T) {
+	oldTenants, oldUsage, oldAllowedOrigins := tenants, usage, *allowedOrigins
+	tenants, _ = parseTenants("library=https://*.example.edu")
+	usage = newUsageStats()
+	*allowedOrigins = "http://test.com"
+	defer func() {
+		tenants, usage, *allowedOrigins = oldTenants, oldUsage, oldAllowedOrigins
+	}()
+
+	usage.Record(tenantLabel("https://www.example.edu"), http.StatusOK, CacheHit)
+	usage.Record(tenantLabel("https://search.example.edu"), http.StatusOK, CacheMiss)
+	usage.Record(tenantLabel("https://search.example.edu"), http.StatusBadGateway, CacheNone)
+	usage.Record(tenantLabel("https://search.example.edu"), http.StatusBadRequest, CacheNone)
+	usage.Record(tenantLabel("http://test.com"), http.StatusOK, CacheNone)
+
+	w := httptest.NewRecorder()
+	analyticsHandler(w, httptest.NewRequest("GET", "/admin/analytics", nil))
+	var got struct {
+		Tenants []tenantUsage `json:"tenants"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Tenants) != 2 {
+		t.Fatalf("Usage was %+v", got.Tenants)
+	}
+	library := got.Tenants[0]
+	if library.Tenant != "library" || library.Requests != 4 || library.ErrorRate != 0.5 || library.CacheHitRatio != 0.5 {
+		t.Errorf("Library usage was %+v", library)
+	}
+	if got.Tenants[1].Tenant != "http://test.com" || got.Tenants[1].CacheHitRatio != 0 {
+		t.Errorf("Origin usage was %+v", got.Tenants[1])
+	}
+
+	w = httptest.NewRecorder()
+	analyticsHandler(w, httptest.NewRequest("GET", "/admin/analytics?tenant=http://test.com", nil))
+	got.Tenants = nil
+	json.NewDecoder(w.Body).Decode(&got)
+	if len(got.Tenants) != 1 || got.Tenants[0].Requests != 1 {
+		t.Errorf("Filtered usage was %+v", got.Tenants)
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	l "github.com/cu-library/lorica/loglevel"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// The events recorded in the audit log.
+const (
+	AuditAdminAction        = "admin_action"
+	AuditRateLimited        = "rate_limited"
+	AuditOriginMismatch     = "origin_mismatch"
+	AuditBadPreflight       = "bad_preflight"
+	AuditSummonAuthFailed   = "summon_auth_failed"
+	AuditSessionReuse       = "session_reuse"
+	AuditAbuseFlagged       = "abuse_flagged"
+	AuditAbuseBlocked       = "abuse_blocked"
+	AuditChallenged         = "challenged"
+	AuditAuthFailed         = "auth_failed"
+	AuditQuotaExceeded      = "quota_exceeded"
+	AuditEndpointNotAllowed = "endpoint_not_allowed"
+	AuditUpstreamOverride   = "upstream_override"
+)
+
+var (
+	auditLogPath = flag.String("auditlog", "", "A file which a JSON line is appended to for every admin action "+
+		"and every rejected request, for security review after incidents. If not set, there is no audit log.")
+
+	// audit is the audit log. If it is nil, nothing is recorded.
+	audit *auditLog
+)
+
+// auditEntry is one line of the audit log. The query string is never
+// recorded, since it holds what the patron searched for.
+type auditEntry struct {
+	Time       time.Time `json:"time"`
+	RequestID  string    `json:"request_id"`
+	Event      string    `json:"event"`
+	Detail     string    `json:"detail,omitempty"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	RemoteAddr string    `json:"remote_addr"`
+	Origin     string    `json:"origin,omitempty"`
+	Session    string    `json:"session,omitempty"`
+}
+
+// auditLog appends entries to a writer as JSON lines.
+// If it was opened from a file, the file can be purged.
+type auditLog struct {
+	sync.Mutex
+	w    io.Writer
+	file *os.File
+	path string
+	now  func() time.Time
+}
+
+// openAuditLog opens the file for appending, creating it if needed.
+func openAuditLog(path string) (*auditLog, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{w: file, file: file, path: path, now: time.Now}, nil
+}
+
+// Purge removes the entries which the retention policy says should be purged.
+func (a *auditLog) Purge(policy retentionPolicy) (int, error) {
+	if a == nil || a.file == nil {
+		return 0, nil
+	}
+	a.Lock()
+	defer a.Unlock()
+
+	// Close the file while it is replaced, then open the new one.
+	a.file.Close()
+	purged, purgeErr := purgeJSONLines(a.path, policy, a.now())
+	file, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		a.w = ioutil.Discard
+		return purged, err
+	}
+	a.w, a.file = file, file
+	return purged, purgeErr
+}
+
+// purgeAuditLog purges the audit log entries the retention policy no longer keeps.
+func purgeAuditLog(a *auditLog) error {
+	purged, err := a.Purge(retentionPolicyFromFlags())
+	if err != nil {
+		return fmt.Errorf("unable to purge audit log: %v", err)
+	}
+	if purged > 0 {
+		l.Logf(l.InfoMessage, "Purged %v audit log entries.", purged)
+	}
+	return nil
+}
+
+// Record appends an entry for the request. It is safe to call on a nil auditLog.
+func (a *auditLog) Record(r *http.Request, event, detail string) {
+	if a == nil {
+		return
+	}
+	id := getRequestInfo(r).id
+	if id == "" {
+		id = requestID(r)
+	}
+	remoteAddr, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remoteAddr = r.RemoteAddr
+	}
+
+	a.Lock()
+	defer a.Unlock()
+	line, err := json.Marshal(auditEntry{
+		Time:       a.now().UTC(),
+		RequestID:  id,
+		Event:      event,
+		Detail:     detail,
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		RemoteAddr: remoteAddr,
+		Origin:     r.Header.Get("Origin"),
+		Session:    sessionHash(r.Header.Get("x-summon-session-id")),
+	})
+	if err != nil {
+		l.Logf(l.ErrorMessage, "Unable to build audit log entry: %v", err)
+		return
+	}
+	if _, err := a.w.Write(append(line, '\n')); err != nil {
+		l.Logf(l.ErrorMessage, "Unable to write to audit log: %v", err)
+	}
+}
+
+// auditOriginMismatch records a CORS request from an origin which isn't allowed.
+// It should be called after setACAOHeader.
+func auditOriginMismatch(w http.ResponseWriter, r *http.Request) {
+	if w.Header().Get("Access-Control-Allow-Origin") == "" {
+		audit.Record(r, AuditOriginMismatch, "")
+	}
+}
+
+// auditAdmin is a middleware which records every request as an admin action.
+func auditAdmin(action string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		audit.Record(r, AuditAdminAction, action)
+		next.ServeHTTP(w, r)
+	})
+}
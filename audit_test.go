@@ -0,0 +1,85 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Entries are JSON lines, without the query string.
+func TestAuditLogRecord(t *testing.T) {
+	var b bytes.Buffer
+	a := &auditLog{w: &b, now: func() time.Time { return time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC) }}
+
+	req, err := http.NewRequest("GET", "/2.0.0/search?s.q=private", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set("Origin", "http://evil.example")
+	req.Header.Set("X-Request-ID", "abc123")
+	req, _ = withRequestInfo(req)
+	a.Record(req, AuditOriginMismatch, "")
+	a.Record(req, AuditRateLimited, "")
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 audit log lines, got %v", len(lines))
+	}
+	if strings.Contains(lines[0], "private") {
+		t.Error("The query string was recorded in the audit log.")
+	}
+	var entry auditEntry
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Event != AuditOriginMismatch || entry.RequestID != "abc123" || entry.RemoteAddr != "192.0.2.1" ||
+		entry.Origin != "http://evil.example" || entry.Path != "/2.0.0/search" || !entry.Time.Equal(a.now()) {
+		t.Errorf("Audit log entry is wrong, got %+v", entry)
+	}
+}
+
+// Recording to a nil audit log does nothing.
+func TestAuditLogNil(t *testing.T) {
+	var a *auditLog
+	req, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Record(req, AuditRateLimited, "")
+}
+
+// A CORS request from an origin which isn't allowed is recorded.
+func TestProxyHanderAuditOriginMismatch(t *testing.T) {
+	var b bytes.Buffer
+	oldAudit := audit
+	audit = &auditLog{w: &b, now: time.Now}
+	defer func() { audit = oldAudit }()
+
+	oldAllowedOrigins := *allowedOrigins
+	*allowedOrigins = "http://good.example"
+	defer func() { *allowedOrigins = oldAllowedOrigins }()
+
+	req, err := http.NewRequest("OPTIONS", "/2.0.0/search", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Origin", "http://evil.example")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	w := httptest.NewRecorder()
+	proxyHandler(w, req)
+	if !strings.Contains(b.String(), AuditOriginMismatch) {
+		t.Errorf("Origin mismatch not recorded, got %v", b.String())
+	}
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), `"code":"`+ErrorOriginRejected+`"`) {
+		t.Errorf("Preflight from an origin which isn't allowed got %v: %v", w.Code, w.Body.String())
+	}
+}
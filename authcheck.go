@@ -0,0 +1,82 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"time"
+)
+
+// AuthFailureTime is the least time a failed check of a credential takes,
+// so how it failed, like a malformed token or a wrong signature, can't be
+// told from how long it took.
+const AuthFailureTime = 10 * time.Millisecond
+
+// authSleep waits out the rest of AuthFailureTime. Tests replace it.
+var authSleep = time.Sleep
+
+// secureEqual returns true if the given credential is the expected one. Both are
+// hashed before they are compared in constant time, so the time taken doesn't
+// depend on where they differ or on their lengths.
+func secureEqual(given, expected string) bool {
+	a, b := sha256.Sum256([]byte(given)), sha256.Sum256([]byte(expected))
+	return subtle.ConstantTimeCompare(a[:], b[:]) == 1
+}
+
+// keySet is a set of secret keys, like API keys. A key is compared with every
+// key in the set, so the time taken doesn't depend on which key matched, or
+// whether any did. The keys themselves aren't kept, only their hashes.
+type keySet struct {
+	digests [][sha256.Size]byte
+}
+
+func newKeySet() *keySet {
+	return &keySet{}
+}
+
+// Add adds a key to the set.
+func (ks *keySet) Add(key string) {
+	ks.digests = append(ks.digests, sha256.Sum256([]byte(key)))
+}
+
+// Contains returns true if the key is in the set. The empty key never is.
+func (ks *keySet) Contains(key string) bool {
+	if ks == nil {
+		return false
+	}
+	digest := sha256.Sum256([]byte(key))
+	found := 0
+	for i := range ks.digests {
+		found |= subtle.ConstantTimeCompare(digest[:], ks.digests[i][:])
+	}
+	return found == 1 && key != ""
+}
+
+// Len returns the number of keys in the set.
+func (ks *keySet) Len() int {
+	if ks == nil {
+		return 0
+	}
+	return len(ks.digests)
+}
+
+// authAttempt times a check of a credential, so a failure can be made to take
+// as long as every other failure. Start one before the check, and call Failed
+// on every path which rejects the credential.
+type authAttempt struct {
+	start time.Time
+}
+
+func startAuthAttempt() authAttempt {
+	return authAttempt{start: time.Now()}
+}
+
+// Failed waits until AuthFailureTime has passed since the attempt started.
+func (a authAttempt) Failed() {
+	if remaining := AuthFailureTime - time.Since(a.start); remaining > 0 {
+		authSleep(remaining)
+	}
+}
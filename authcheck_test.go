@@ -0,0 +1,79 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSecureEqual(t *testing.T) {
+	if !secureEqual("token", "token") || secureEqual("token", "tokens") || secureEqual("", "token") {
+		t.Error("Credentials were compared incorrectly.")
+	}
+}
+
+func TestKeySet(t *testing.T) {
+	ks := newKeySet()
+	ks.Add("abc")
+	ks.Add("def")
+	if !ks.Contains("abc") || !ks.Contains("def") || ks.Contains("ab") || ks.Contains("") || ks.Len() != 2 {
+		t.Error("The key set didn't match the keys added to it.")
+	}
+	var empty *keySet
+	if empty.Contains("abc") || empty.Len() != 0 {
+		t.Error("A missing key set matched a key.")
+	}
+}
+
+// Failed attempts wait until AuthFailureTime has passed, successful ones don't wait.
+func TestAuthAttempt(t *testing.T) {
+	var slept time.Duration
+	oldSleep := authSleep
+	authSleep = func(d time.Duration) { slept += d }
+	defer func() { authSleep = oldSleep }()
+
+	startAuthAttempt().Failed()
+	if slept <= 0 || slept > AuthFailureTime {
+		t.Errorf("A failed attempt waited %v", slept)
+	}
+
+	slept = 0
+	attempt := authAttempt{start: time.Now().Add(-time.Second)}
+	attempt.Failed()
+	if slept != 0 {
+		t.Errorf("A slow failed attempt waited another %v", slept)
+	}
+}
+
+// Wrong admin tokens wait like other failures, and requests without one don't wait.
+func TestValidAdminTokenTiming(t *testing.T) {
+	waits := 0
+	oldSleep, oldAdminToken := authSleep, *adminToken
+	authSleep = func(time.Duration) { waits++ }
+	*adminToken = "letmein"
+	defer func() { authSleep, *adminToken = oldSleep, oldAdminToken }()
+
+	for _, c := range []struct {
+		token string
+		valid bool
+		waits int
+	}{
+		{"", false, 0},
+		{"letmein", true, 0},
+		{"letmeout", false, 1},
+		{"l", false, 1},
+	} {
+		waits = 0
+		r := httptest.NewRequest("GET", "/", nil)
+		if c.token != "" {
+			r.Header.Set(AdminTokenHeader, c.token)
+		}
+		if valid := validAdminToken(r); valid != c.valid || waits != c.waits {
+			t.Errorf("Token %q was valid %v after %v waits", c.token, valid, waits)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/cu-library/lorica/metrics"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+const (
+	// BatchPath is the path of the batch endpoint. It can follow a credential
+	// prefix, like /sandbox/batch, to search with that prefix's credentials.
+	BatchPath = "/batch"
+
+	// SearchPath is the Summon API path of the searches Lorica makes for
+	// clients, like the queries in a batch.
+	SearchPath = "/2.0.0/search"
+
+	// ErrorInvalidBatch is the code of the error sent when the batch can't be read.
+	ErrorInvalidBatch = "invalid_batch"
+)
+
+var (
+	batchMax = flag.Int("batchmax", 0, "The most queries in one request to /batch. Each query counts against "+
+		"the client's rate limit and quota, so a client needs a rate which allows this many at once. "+
+		"0 turns off /batch.")
+	batchConcurrency = flag.Int("batchconcurrency", 4, "The most queries from one request to /batch "+
+		"which are sent to the Summon API at once.")
+
+	batchQueriesTotal = metrics.NewCounterVec("lorica_batch_queries_total",
+		"The number of queries sent in requests to /batch, by the class of their status code.", "status_class")
+)
+
+// batchResult is the result of one query in a batch. The body is Summon's
+// response when it is JSON, otherwise the error says what went wrong.
+type batchResult struct {
+	Query  string          `json:"query"`
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// responseBuffer is a http.ResponseWriter which keeps the response to a
+// search Lorica makes for the client, like one query in a batch.
+type responseBuffer struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseBuffer) Header() http.Header {
+	return rec.header
+}
+
+func (rec *responseBuffer) WriteHeader(statusCode int) {
+	if rec.status == 0 {
+		rec.status = statusCode
+	}
+}
+
+func (rec *responseBuffer) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.body.Write(b)
+}
+
+// result returns the query's batchResult.
+func (rec *responseBuffer) result(query string) batchResult {
+	result := batchResult{Query: query, Status: rec.status}
+	if result.Status == 0 {
+		result.Status = http.StatusOK
+	}
+	if body := bytes.TrimSpace(rec.body.Bytes()); json.Valid(body) {
+		result.Body = body
+	} else {
+		result.Error = http.StatusText(result.Status)
+	}
+	return result
+}
+
+// withBatch is a middleware which answers requests to the batch endpoint,
+// and passes every other request to next. Each query in a batch is handled
+// by next, so it is checked, signed, and counted against the client's rate
+// limit and quota like any other search.
+func withBatch(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if *batchMax <= 0 || !strings.HasSuffix(r.URL.Path, BatchPath) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		batchHandler(w, r, next)
+	})
+}
+
+// batchHandler reads a JSON array of query strings, sends them to the
+// search endpoint at once, at most -batchconcurrency at a time, and sends
+// back an array of their results in the same order.
+func batchHandler(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	if r.Header.Get("Origin") != "" {
+		if r.Method == "OPTIONS" {
+			batchPreflight(w, r)
+			return
+		}
+		setACAOHeader(w, r)
+		auditOriginMismatch(w, r)
+	}
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST, OPTIONS")
+		sendJSONErrorCode(w, http.StatusMethodNotAllowed, ErrorMethodNotAllowed, "Only POST requests are accepted by "+BatchPath+".", nil)
+		return
+	}
+
+	batch, err := readBatch(r.Body)
+	if err == errBodyTooLarge {
+		sendJSONErrorCode(w, http.StatusRequestEntityTooLarge, ErrorBodyTooLarge,
+			fmt.Sprintf("The request body is larger than the maximum of %v bytes.", MaxPostBodySize), nil)
+		return
+	} else if err != nil {
+		sendJSONErrorCode(w, http.StatusBadRequest, ErrorInvalidBatch, fmt.Sprintf("Unable to read the batch, %v.", err),
+			[]string{`Send a JSON array of query strings, like ["s.q=forest", "s.q=river&s.ps=5"].`})
+		return
+	}
+
+	// Every query in the batch uses the same session, so only one is issued.
+	header := cloneHeader(r.Header)
+	if header.Get("x-summon-session-id") == "" && *issueSessions {
+		sessionID, err := newSessionID()
+		if err != nil {
+			sendJSONErrorCode(w, http.StatusInternalServerError, ErrorInternal, "Unable to make a session ID.", nil)
+			return
+		}
+		header.Set("x-summon-session-id", sessionID)
+		w.Header().Set("x-summon-session-id", sessionID)
+		getRequestInfo(r).session = sessionHash(sessionID)
+	}
+
+	path := strings.TrimSuffix(r.URL.Path, BatchPath) + SearchPath
+	results := make([]batchResult, len(batch))
+	concurrency := *batchConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, query := range batch {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int, query string) {
+			defer func() { <-slots; wg.Done() }()
+			// Each query is its own request, with its own requestInfo.
+			sub, _ := withRequestInfo(searchRequest(r, header, path, query))
+			rec := &responseBuffer{header: make(http.Header)}
+			next.ServeHTTP(rec, sub)
+			results[i] = rec.result(query)
+			batchQueriesTotal.With(statusClassLabel(results[i].Status)).Inc()
+			queries.Record(sub, results[i].Status)
+		}(i, query)
+	}
+	wg.Wait()
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(results)
+}
+
+// readBatch reads the JSON array of query strings in a batch request's body.
+func readBatch(body io.Reader) ([]string, error) {
+	b, err := ioutil.ReadAll(io.LimitReader(body, MaxPostBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > MaxPostBodySize {
+		return nil, errBodyTooLarge
+	}
+	var queries []string
+	if err := json.Unmarshal(b, &queries); err != nil {
+		return nil, errors.New("the body isn't a JSON array of query strings")
+	}
+	if len(queries) == 0 {
+		return nil, errors.New("it doesn't have any queries")
+	}
+	if len(queries) > *batchMax {
+		return nil, fmt.Errorf("it has %v queries, more than the maximum of %v", len(queries), *batchMax)
+	}
+	for i, query := range queries {
+		query = strings.TrimPrefix(query, "?")
+		if _, err := url.ParseQuery(query); err != nil {
+			return nil, fmt.Errorf("query %v isn't a valid query string: %v", i+1, err)
+		}
+		queries[i] = query
+	}
+	return queries, nil
+}
+
+// searchRequest builds a search request Lorica makes for the client, like one
+// query in a batch. It has the client request's headers, but not its body,
+// origin, or conditional headers, and asks for JSON so Lorica can read the result.
+func searchRequest(r *http.Request, header http.Header, path, query string) *http.Request {
+	sub := new(http.Request)
+	*sub = *r
+	sub.Method = "GET"
+	sub.URL = &url.URL{Path: path, RawQuery: query}
+	sub.RequestURI = sub.URL.RequestURI()
+	sub.Header = cloneHeader(header)
+	for _, name := range []string{"Origin", "Content-Type", "Content-Length", "If-None-Match", "If-Modified-Since"} {
+		sub.Header.Del(name)
+	}
+	sub.Header.Set("Accept", "application/json")
+	sub.Body = http.NoBody
+	sub.ContentLength = 0
+	sub, _ = withResponseOrigin(sub)
+	return sub
+}
+
+// batchPreflight answers a CORS preflight request for the batch endpoint,
+// which takes POST requests with a JSON body.
+func batchPreflight(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Access-Control-Request-Method") != "POST" {
+		preflightRequestsTotal.With(PreflightRejected).Inc()
+		audit.Record(r, AuditBadPreflight, "Access-Control-Request-Method "+r.Header.Get("Access-Control-Request-Method"))
+		sendJSONErrorCode(w, http.StatusBadRequest, ErrorBadPreflight,
+			"Access-Control-Request-Method header should be POST for "+BatchPath+".", nil)
+		return
+	}
+	allowedHeaders := append(allowedCORSRequestHeaders(), "content-type")
+	if requested := requestedCORSHeaders(r); requested != "" {
+		for _, name := range strings.Split(requested, ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name != "content-type" && !corsRequestHeadersAllowed(name) {
+				preflightRequestsTotal.With(PreflightRejected).Inc()
+				audit.Record(r, AuditBadPreflight, "Access-Control-Request-Headers "+requested)
+				sendJSONErrorCode(w, http.StatusBadRequest, ErrorBadPreflight,
+					"Access-Control-Request-Headers header should only contain "+strings.Join(allowedHeaders, ", ")+".", nil)
+				return
+			}
+		}
+	}
+	setACAOHeader(w, r)
+	auditOriginMismatch(w, r)
+	if w.Header().Get("Access-Control-Allow-Origin") == "" {
+		preflightRequestsTotal.With(PreflightRejected).Inc()
+		sendJSONErrorCode(w, http.StatusForbidden, ErrorOriginRejected,
+			"The origin "+r.Header.Get("Origin")+" isn't allowed.", nil)
+		return
+	}
+	preflightRequestsTotal.With(PreflightBuilt).Inc()
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", strings.Join(allowedHeaders, ", "))
+	w.Header().Set("Access-Control-Max-Age", DefaultMaxAge)
+	w.Write([]byte{})
+}
@@ -0,0 +1,176 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestReadBatch(t *testing.T) {
+	oldBatchMax := *batchMax
+	*batchMax = 10
+	defer func() { *batchMax = oldBatchMax }()
+
+	for _, c := range []struct {
+		body string
+		ok   bool
+	}{
+		{`["s.q=forest", "?s.q=river&s.ps=5"]`, true},
+		{`[]`, false},
+		{`{"s.q": "forest"}`, false},
+		{`["s.q=%zz"]`, false},
+		{`["a","b","c","d","e","f","g","h","i","j","k"]`, false},
+	} {
+		batch, err := readBatch(strings.NewReader(c.body))
+		if (err == nil) != c.ok {
+			t.Errorf("Reading %v returned %v, %v", c.body, batch, err)
+		}
+	}
+	batch, _ := readBatch(strings.NewReader(`["?s.q=river"]`))
+	if batch[0] != "s.q=river" {
+		t.Errorf("The leading ? wasn't removed, %v", batch[0])
+	}
+}
+
+// Each query is sent to the search endpoint, and the results are in the order of the queries.
+func TestBatchHandler(t *testing.T) {
+	oldBatchMax := *batchMax
+	*batchMax = 10
+	defer func() { *batchMax = oldBatchMax }()
+
+	var sent int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&sent, 1)
+		if r.URL.Path != "/2.0.0/search" {
+			t.Errorf("A query was sent to %v", r.URL.Path)
+		}
+		if r.URL.Query().Get("s.q") == "broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"query": r.URL.Query().Get("s.q")})
+	}))
+	defer ts.Close()
+	oldAPIURL := *apiURL
+	*apiURL = ts.URL
+	defer func() { *apiURL = oldAPIURL }()
+
+	handler := withBatch(http.HandlerFunc(proxyHandler))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/batch", strings.NewReader(`["s.q=one","s.q=two","s.q=broken"]`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Batch got %v %v", w.Code, w.Body.String())
+	}
+	var results []batchResult
+	if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil || len(results) != 3 {
+		t.Fatalf("Batch results were %v, %v", w.Body.String(), err)
+	}
+	if results[0].Status != http.StatusOK || string(results[0].Body) != `{"query":"one"}` ||
+		string(results[1].Body) != `{"query":"two"}` {
+		t.Errorf("Batch results were %v", w.Body.String())
+	}
+	if results[2].Status != http.StatusBadGateway && results[2].Status != http.StatusInternalServerError {
+		t.Errorf("A failed query had status %v", results[2].Status)
+	}
+	if sent != 3 {
+		t.Errorf("%v queries were sent to the Summon API", sent)
+	}
+
+	// Other requests are passed on.
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/2.0.0/search?s.q=one", nil))
+	if w.Body.String() != "{\"query\":\"one\"}\n" {
+		t.Errorf("A search got %v", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/batch", nil))
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "POST, OPTIONS" {
+		t.Errorf("A GET request to the batch endpoint got %v", w.Code)
+	}
+}
+
+// Each query in a batch is charged against the client's quota, and has its own requestInfo.
+func TestBatchQuota(t *testing.T) {
+	oldBatchMax := *batchMax
+	*batchMax = 10
+	defer func() { *batchMax = oldBatchMax }()
+
+	tiers, err := parseTiers("trusted keys=abc rate=0 quota=2/1h; anonymous rate=0", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	infos := make(map[*requestInfo]bool)
+	handler := withBatch(newTierHandler(tiers, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		infos[getRequestInfo(r)] = true
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	})))
+
+	r := httptest.NewRequest("POST", "/batch", strings.NewReader(`["s.q=one","s.q=two","s.q=three"]`))
+	r.Header.Set(APIKeyHeader, "abc")
+	r, info := withRequestInfo(r)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	var results []batchResult
+	if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil || len(results) != 3 {
+		t.Fatalf("Batch results were %v, %v", w.Body.String(), err)
+	}
+	statuses := make(map[int]int)
+	for _, result := range results {
+		statuses[result.Status]++
+	}
+	if statuses[http.StatusOK] != 2 || statuses[http.StatusTooManyRequests] != 1 {
+		t.Errorf("With a quota of 2, the statuses of 3 queries were %v", statuses)
+	}
+	if len(infos) != 2 || infos[info] {
+		t.Error("The queries shared a requestInfo.")
+	}
+}
+
+func TestBatchPreflight(t *testing.T) {
+	oldAllowedOrigins, oldBatchMax := *allowedOrigins, *batchMax
+	*allowedOrigins, *batchMax = "https://library.example.com", 10
+	defer func() { *allowedOrigins, *batchMax = oldAllowedOrigins, oldBatchMax }()
+
+	for _, c := range []struct {
+		method, headers string
+		code            int
+	}{
+		{"POST", "content-type, x-summon-session-id", http.StatusOK},
+		{"GET", "", http.StatusBadRequest},
+		{"POST", "authorization", http.StatusBadRequest},
+		{"POST", "", http.StatusForbidden},
+	} {
+		r := httptest.NewRequest("OPTIONS", "/batch", nil)
+		r.Header.Set("Origin", "https://library.example.com")
+		if c.code == http.StatusForbidden {
+			r.Header.Set("Origin", "https://evil.example.com")
+		}
+		r.Header.Set("Access-Control-Request-Method", c.method)
+		if c.headers != "" {
+			r.Header.Set("Access-Control-Request-Headers", c.headers)
+		}
+		w := httptest.NewRecorder()
+		withBatch(http.HandlerFunc(proxyHandler)).ServeHTTP(w, r)
+		if w.Code != c.code {
+			t.Errorf("Preflight for %v %v got %v", c.method, c.headers, w.Code)
+		}
+		if c.code == http.StatusOK && (w.Header().Get("Access-Control-Allow-Methods") != "POST" ||
+			w.Header().Get("Access-Control-Allow-Origin") != "https://library.example.com") {
+			t.Errorf("Preflight headers were %v", w.Header())
+		}
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"github.com/didip/tollbooth/libstring"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSessionBindingTTL is the default time a session stays bound to a client after its last request.
+	DefaultSessionBindingTTL = time.Hour
+
+	// MaxSessionBindings is the most sessions which are bound at once, to bound memory use.
+	// When there are more, new sessions aren't bound.
+	MaxSessionBindings = 100000
+
+	// ErrorSessionInUse is the error code sent when a session ID is bound to another client.
+	ErrorSessionInUse = "session_in_use"
+)
+
+var (
+	bindSessions = flag.Bool("bindsessions", false, "Bind each x-summon-session-id to the IP address and "+
+		"User-Agent of the first client which uses it, and reject requests using it from anywhere else.")
+	sessionBindingTTL = flag.Duration("sessionbindingttl", DefaultSessionBindingTTL, "The time a session stays "+
+		"bound to a client after its last request.")
+
+	// sessions holds the session bindings.
+	sessions = newSessionBindings()
+)
+
+// sessionBinding is the client a session is bound to.
+type sessionBinding struct {
+	fingerprint string
+	expires     time.Time
+}
+
+// sessionBindings remembers which client each session belongs to.
+type sessionBindings struct {
+	sync.Mutex
+	bindings map[string]sessionBinding
+	now      func() time.Time
+}
+
+func newSessionBindings() *sessionBindings {
+	return &sessionBindings{
+		bindings: make(map[string]sessionBinding),
+		now:      time.Now,
+	}
+}
+
+// Check binds the session to the fingerprint if it isn't bound, and returns
+// false if the session is bound to a different fingerprint.
+func (s *sessionBindings) Check(session, fingerprint string, ttl time.Duration) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	now := s.now()
+	b, ok := s.bindings[session]
+	if ok && now.Before(b.expires) && b.fingerprint != fingerprint {
+		return false
+	}
+	if !ok && len(s.bindings) >= MaxSessionBindings {
+		for k, b := range s.bindings {
+			if !now.Before(b.expires) {
+				delete(s.bindings, k)
+			}
+		}
+		if len(s.bindings) >= MaxSessionBindings {
+			return true
+		}
+	}
+	s.bindings[session] = sessionBinding{fingerprint: fingerprint, expires: now.Add(ttl)}
+	return true
+}
+
+// clientIPLookups are where the client's IP address is found, in order.
+func clientIPLookups() []string {
+	if *checkProxyHeaders {
+		return []string{"X-Forwarded-For", "X-Real-IP", "RemoteAddr"}
+	}
+	return []string{"RemoteAddr"}
+}
+
+// clientFingerprint returns a hash of the client's IP address and User-Agent.
+func clientFingerprint(r *http.Request) string {
+	ip := libstring.RemoteIP(clientIPLookups(), 0, r)
+	return sessionHash(ip + "\n" + r.Header.Get("User-Agent"))
+}
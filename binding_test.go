@@ -0,0 +1,95 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// A session is bound to the first client, until the binding expires.
+func TestSessionBindingsCheck(t *testing.T) {
+	s := newSessionBindings()
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	if !s.Check("session", "alice", time.Hour) {
+		t.Error("A new session was rejected.")
+	}
+	if !s.Check("session", "alice", time.Hour) {
+		t.Error("A session was rejected for the client it is bound to.")
+	}
+	if s.Check("session", "mallory", time.Hour) {
+		t.Error("A session was accepted from another client.")
+	}
+	now = now.Add(2 * time.Hour)
+	if !s.Check("session", "mallory", time.Hour) {
+		t.Error("An expired binding was still enforced.")
+	}
+}
+
+// The client fingerprint depends on the IP address and User-Agent.
+func TestClientFingerprint(t *testing.T) {
+	req, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set("User-Agent", "Browser")
+	fingerprint := clientFingerprint(req)
+
+	req.RemoteAddr = "192.0.2.1:5678"
+	if clientFingerprint(req) != fingerprint {
+		t.Error("The client port changed the fingerprint.")
+	}
+	req.Header.Set("User-Agent", "Scraper")
+	if clientFingerprint(req) == fingerprint {
+		t.Error("The User-Agent didn't change the fingerprint.")
+	}
+}
+
+// Mock the Summon API, and test that a session ID replayed from another client is rejected.
+func TestProxyHanderBindSessions(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "")
+	}))
+	defer ts.Close()
+
+	oldAPIURL := *apiURL
+	*apiURL = ts.URL
+	defer func() { *apiURL = oldAPIURL }()
+
+	oldBindSessions := *bindSessions
+	*bindSessions = true
+	defer func() { *bindSessions = oldBindSessions }()
+
+	oldSessions := sessions
+	sessions = newSessionBindings()
+	defer func() { sessions = oldSessions }()
+
+	for _, test := range []struct {
+		remoteAddr string
+		status     int
+	}{
+		{"192.0.2.1:1234", http.StatusOK},
+		{"192.0.2.1:1235", http.StatusOK},
+		{"198.51.100.7:1234", http.StatusForbidden},
+	} {
+		req, err := http.NewRequest("GET", "/2.0.0/search?s.q=test", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.RemoteAddr = test.remoteAddr
+		req.Header.Set("x-summon-session-id", "leaked-session")
+		w := httptest.NewRecorder()
+		proxyHandler(w, req)
+		if w.Code != test.status {
+			t.Errorf("Expected %v from %v, got %v", test.status, test.remoteAddr, w.Code)
+		}
+	}
+}
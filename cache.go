@@ -0,0 +1,245 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"flag"
+	"github.com/cu-library/lorica/metrics"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultCacheTTL is the default time a response is kept in the cache.
+	DefaultCacheTTL = 5 * time.Minute
+
+	// DefaultCacheMaxSize is the default most bytes of response bodies kept in the cache.
+	DefaultCacheMaxSize = 64 << 20
+
+	// CacheStatusHeader is the response header which tells the client whether the cache was used.
+	CacheStatusHeader = "X-Lorica-Cache"
+)
+
+var (
+	cacheTTL = flag.Duration("cachettl", DefaultCacheTTL, "How long successful Summon API responses are "+
+		"kept in the cache, while the cache feature is enabled.")
+	cacheMaxSize = flag.Int("cachemaxsize", DefaultCacheMaxSize, "The most bytes of response bodies kept in "+
+		"the cache. When it is full, the least recently used responses are removed.")
+
+	// uncachedResponseHeaders are the Summon API response headers which
+	// belong to one client's request, so they aren't kept in the cache.
+	uncachedResponseHeaders = []string{"x-summon-session-id", "Set-Cookie", "Date"}
+
+	// cachedResponses holds the cached Summon API responses. It is replaced by
+	// the -cachebackend once the flags are parsed.
+	cachedResponses cacheBackend = newResponseCache()
+
+	cacheRequestsTotal = metrics.NewCounterVec("lorica_cache_requests_total",
+		"The number of requests looked up in the response cache, by whether they were found.", "result")
+	cacheEvictionsTotal = metrics.NewCounterVec("lorica_cache_evictions_total",
+		"The number of responses removed from the cache, by whether they expired, the cache was full, or "+
+			"memory was short.", "reason")
+
+	_ = metrics.NewCollectorFunc("response_cache", func(w io.Writer) {
+		memory, ok := cachedResponses.(*responseCache)
+		if !ok {
+			return
+		}
+		entries, size := memory.Len()
+		metrics.WriteGauge(w, "lorica_cache_entries", "The number of responses in the cache.", float64(entries))
+		metrics.WriteGauge(w, "lorica_cache_bytes", "The size of the response bodies in the cache, in bytes.",
+			float64(size))
+	})
+)
+
+// cachedResponse is a successful response from the Summon API.
+type cachedResponse struct {
+	key     string
+	header  http.Header
+	body    []byte
+	added   time.Time
+	expires time.Time
+}
+
+// response returns the cached response as if it had just been sent by the Summon API.
+func (c *cachedResponse) response() *http.Response {
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Header:        cloneHeader(c.header),
+		Body:          ioutil.NopCloser(bytes.NewReader(c.body)),
+		ContentLength: int64(len(c.body)),
+	}
+}
+
+// age returns the Age header value of the cached response, in whole seconds.
+func (c *cachedResponse) age(now time.Time) string {
+	age := now.Sub(c.added)
+	if age < 0 {
+		age = 0
+	}
+	return strconv.Itoa(int(age / time.Second))
+}
+
+// responseCache keeps recent Summon API responses, removing the least
+// recently used ones when it is full.
+type responseCache struct {
+	sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+	size    int
+	now     func() time.Time
+}
+
+func newResponseCache() *responseCache {
+	return &responseCache{
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+		now:     time.Now,
+	}
+}
+
+// Get returns the cached response for the key, if there is one which hasn't expired.
+func (rc *responseCache) Get(key string) (*cachedResponse, bool) {
+	rc.Lock()
+	defer rc.Unlock()
+	e, ok := rc.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c := e.Value.(*cachedResponse)
+	if !rc.now().Before(c.expires) {
+		rc.remove(e)
+		cacheEvictionsTotal.With("expired").Inc()
+		return nil, false
+	}
+	rc.order.MoveToFront(e)
+	return c, true
+}
+
+// Add keeps a response for the ttl. Responses larger than maxSize aren't kept,
+// and the least recently used responses are removed to make room.
+func (rc *responseCache) Add(key string, header http.Header, body []byte, ttl time.Duration, maxSize int) {
+	if ttl <= 0 || len(body) > maxSize {
+		return
+	}
+	header = cloneHeader(header)
+	for _, name := range uncachedResponseHeaders {
+		header.Del(name)
+	}
+	rc.Lock()
+	defer rc.Unlock()
+	if e, ok := rc.entries[key]; ok {
+		rc.remove(e)
+	}
+	for rc.size+len(body) > maxSize && rc.order.Len() > 0 {
+		rc.remove(rc.order.Back())
+		cacheEvictionsTotal.With("size").Inc()
+	}
+	now := rc.now()
+	c := &cachedResponse{key: key, header: header, body: body, added: now, expires: now.Add(ttl)}
+	rc.entries[key] = rc.order.PushFront(c)
+	rc.size += len(body)
+}
+
+// remove removes an entry. The lock must be held.
+func (rc *responseCache) remove(e *list.Element) {
+	c := rc.order.Remove(e).(*cachedResponse)
+	delete(rc.entries, c.key)
+	rc.size -= len(c.body)
+}
+
+// Trim removes the least recently used responses until their bodies take at
+// most size bytes, and returns how many were removed.
+func (rc *responseCache) Trim(size int) int {
+	rc.Lock()
+	defer rc.Unlock()
+	removed := 0
+	for rc.size > size && rc.order.Len() > 0 {
+		rc.remove(rc.order.Back())
+		cacheEvictionsTotal.With("memory").Inc()
+		removed++
+	}
+	return removed
+}
+
+// Purge removes the responses to requests whose path and sorted query start
+// with prefix, and returns how many were removed.
+func (rc *responseCache) Purge(prefix string) (int, error) {
+	rc.Lock()
+	defer rc.Unlock()
+	purged := 0
+	for key, e := range rc.entries {
+		if cacheKeyHasPrefix(key, prefix) {
+			rc.remove(e)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// Len returns the number of cached responses, and the size of their bodies.
+func (rc *responseCache) Len() (int, int) {
+	rc.Lock()
+	defer rc.Unlock()
+	return rc.order.Len(), rc.size
+}
+
+// responseCacheKey returns the cache key of a request to the Summon API. The
+// access ID identifies the profile. The query parameters are sorted, so the
+// same search sent with its parameters in another order is found.
+func responseCacheKey(accessID string, apiRequestURL *url.URL, accept, acceptLanguage string) string {
+	query := apiRequestURL.RawQuery
+	if values, err := url.ParseQuery(query); err == nil {
+		query = values.Encode()
+	}
+	return strings.Join([]string{
+		accessID,
+		apiRequestURL.Path + "?" + query,
+		strings.ToLower(strings.TrimSpace(accept)),
+		strings.ToLower(strings.TrimSpace(acceptLanguage)),
+	}, "\n")
+}
+
+// cacheKeyHasPrefix returns true if the path and sorted query in the cache key start with prefix.
+func cacheKeyHasPrefix(key, prefix string) bool {
+	parts := strings.SplitN(key, "\n", 3)
+	return len(parts) > 1 && strings.HasPrefix(parts[1], prefix)
+}
+
+// useResponseCache returns true if the response to the request can come from
+// the cache. Requests in a Summon session, which can have their own state,
+// and requests sent to another upstream, aren't cached.
+func useResponseCache(r *http.Request, sessionID string, overridden bool) bool {
+	if !features.Enabled(FeatureCache) || *cacheTTL <= 0 {
+		return false
+	}
+	return (r.Method == "GET" || r.Method == "HEAD") && sessionID == "" && !overridden
+}
+
+// clientWantsFreshResponse returns true if the client asked for a response
+// which isn't from a cache. The fresh response is still cached.
+func clientWantsFreshResponse(r *http.Request) bool {
+	cacheControl := strings.ToLower(r.Header.Get("Cache-Control"))
+	return strings.Contains(cacheControl, "no-cache") || strings.Contains(cacheControl, "max-age=0") ||
+		r.Header.Get("Pragma") == "no-cache"
+}
+
+// setCacheStatus tells the client whether the response came from the cache.
+// Browsers only let CORS requests read it if it is exposed.
+func setCacheStatus(w http.ResponseWriter, status string) {
+	w.Header().Set(CacheStatusHeader, status)
+	if w.Header().Get("Access-Control-Allow-Origin") != "" {
+		w.Header().Add("Access-Control-Expose-Headers", CacheStatusHeader)
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"github.com/cu-library/lorica/internal/summonmock"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// Responses expire after the ttl, and the least recently used are removed when the cache is full.
+func TestResponseCache(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	rc := newResponseCache()
+	rc.now = func() time.Time { return now }
+
+	rc.Add("a", http.Header{"X-Summon-Session-Id": {"secret"}, "Content-Type": {"application/json"}},
+		[]byte("aaaa"), time.Minute, 10)
+	rc.Add("b", nil, []byte("bbbb"), time.Minute, 10)
+	c, ok := rc.Get("a")
+	if !ok || string(c.body) != "aaaa" || c.header.Get("Content-Type") != "application/json" {
+		t.Fatalf("Cached response a was %+v, %v", c, ok)
+	}
+	if c.header.Get("X-Summon-Session-Id") != "" {
+		t.Error("The Summon session ID was cached.")
+	}
+
+	// a was used more recently, so b is removed to make room.
+	rc.Add("c", nil, []byte("cccc"), time.Minute, 10)
+	if _, ok := rc.Get("b"); ok {
+		t.Error("The least recently used response wasn't removed.")
+	}
+	if _, ok := rc.Get("a"); !ok {
+		t.Error("A recently used response was removed.")
+	}
+	if entries, size := rc.Len(); entries != 2 || size != 8 {
+		t.Errorf("The cache has %v entries and %v bytes.", entries, size)
+	}
+
+	rc.Add("large", nil, []byte("more than ten bytes"), time.Minute, 10)
+	if _, ok := rc.Get("large"); ok {
+		t.Error("A response larger than the cache was kept.")
+	}
+
+	now = now.Add(30 * time.Second)
+	if c, ok := rc.Get("c"); !ok || c.age(now) != "30" {
+		t.Error("A response was removed before it expired, or has the wrong age.")
+	}
+	now = now.Add(30 * time.Second)
+	if _, ok := rc.Get("c"); ok {
+		t.Error("An expired response was used.")
+	}
+}
+
+// Purges remove the responses to requests under the prefix, and the result counts with searches.
+func TestPurgeResponseCache(t *testing.T) {
+	oldCachedResponses, oldCounts, oldPurger := cachedResponses, counts, purger
+	defer func() { cachedResponses, counts, purger = oldCachedResponses, oldCounts, oldPurger }()
+	rc := newResponseCache()
+	cachedResponses, counts, purger = rc, newCountCache(), nil
+
+	add := func() {
+		for _, target := range []string{"/2.0.0/search?s.q=forest", "/2.0.0/search?s.q=lake", "/2.0.0/availability?s.q=forest"} {
+			u, _ := url.Parse(target)
+			rc.Add(responseCacheKey("access", u, "application/json", ""), nil, []byte("{}"), time.Minute, 1000)
+		}
+		counts.Add("\ns.ps=0&s.q=forest", 10, time.Minute)
+	}
+	purge := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		cachePurgeHandler(w, httptest.NewRequest("DELETE", target, nil))
+		return w
+	}
+
+	add()
+	if w := purge("/admin/cache?prefix=/2.0.0/availability"); w.Code != http.StatusOK ||
+		w.Body.String() != "{\"purged\":1,\"prefix\":\"/2.0.0/availability\"}\n" {
+		t.Errorf("Purging availability returned %v %v", w.Code, w.Body.String())
+	}
+	if entries, _ := rc.Len(); entries != 2 {
+		t.Errorf("%v responses were left after purging availability.", entries)
+	}
+	if _, ok := counts.Get("\ns.ps=0&s.q=forest"); !ok {
+		t.Error("The result counts were purged with availability.")
+	}
+
+	if w := purge("/admin/cache?prefix=/2.0.0/search?s.q=l"); w.Body.String() != "{\"purged\":2,\"prefix\":\"/2.0.0/search?s.q=l\"}\n" {
+		t.Errorf("Purging one search returned %v", w.Body.String())
+	}
+	if _, ok := counts.Get("\ns.ps=0&s.q=forest"); ok {
+		t.Error("The result counts weren't purged with searches.")
+	}
+
+	add()
+	if w := purge("/admin/cache"); w.Body.String() != "{\"purged\":4}\n" {
+		t.Errorf("Purging everything returned %v", w.Body.String())
+	}
+	if w := purge("/admin/cache?prefix=search"); w.Code != http.StatusBadRequest {
+		t.Errorf("A prefix which isn't a path returned %v", w.Code)
+	}
+}
+
+// The cache key doesn't depend on the order of the query parameters.
+func TestResponseCacheKey(t *testing.T) {
+	key := func(rawURL, accept string) string {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return responseCacheKey("id", u, accept, "")
+	}
+	if key("http://summon/2.0.0/search?s.q=forest&s.ps=10", "application/json") !=
+		key("http://other/2.0.0/search?s.ps=10&s.q=forest", " Application/JSON") {
+		t.Error("The same search has different keys.")
+	}
+	if key("http://summon/2.0.0/search?s.q=forest", "application/json") ==
+		key("http://summon/2.0.0/search?s.q=forest", "application/xml") {
+		t.Error("Different Accept headers have the same key.")
+	}
+	if key("http://summon/2.0.0/search?s.fvf=a&s.fvf=b", "") == key("http://summon/2.0.0/search?s.fvf=b&s.fvf=a", "") {
+		t.Error("Repeated parameters in a different order have the same key.")
+	}
+}
+
+// Identical searches are only sent to the Summon API once while the cache feature is enabled.
+func TestProxyResponseCache(t *testing.T) {
+	s, stop := startSummonMock()
+	defer stop()
+	s.Script(summonmock.Response{Status: http.StatusOK,
+		Header: http.Header{"Content-Type": {"application/json"}, "X-Summon-Session-Id": {"summon-session"}},
+		Body:   `{"recordCount":1}`})
+	oldCachedResponses := cachedResponses
+	defer func() {
+		cachedResponses = oldCachedResponses
+		features.Set("")
+	}()
+	cachedResponses = newResponseCache()
+
+	search := func(header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/2.0.0/search?s.q=forest", nil)
+		for name, values := range header {
+			r.Header[name] = values
+		}
+		r, _ = withRequestInfo(r)
+		w := httptest.NewRecorder()
+		proxyHandler(w, r)
+		if w.Code != http.StatusOK || w.Body.String() != `{"recordCount":1}` {
+			t.Fatalf("Search got %v %v", w.Code, w.Body.String())
+		}
+		return w
+	}
+
+	search(nil)
+	search(nil)
+	if n := len(s.Requests()); n != 2 {
+		t.Errorf("Without the cache feature, %v requests were sent to the Summon API.", n)
+	}
+
+	features.Set(FeatureCache)
+	if w := search(nil); w.Header().Get(CacheStatusHeader) != CacheMiss {
+		t.Errorf("The first search was a %v.", w.Header().Get(CacheStatusHeader))
+	}
+	w := search(nil)
+	if w.Header().Get(CacheStatusHeader) != CacheHit || w.Header().Get("Age") == "" {
+		t.Errorf("The second search had headers %v", w.Header())
+	}
+	if w.Header().Get("X-Summon-Session-Id") != "" {
+		t.Error("A cached response had another client's Summon session ID.")
+	}
+	if n := len(s.Requests()); n != 3 {
+		t.Errorf("With the cache feature, %v requests were sent to the Summon API.", n)
+	}
+
+	if w := search(http.Header{"X-Summon-Session-Id": {"mine"}}); w.Header().Get(CacheStatusHeader) != "" {
+		t.Error("A search in a session was cached.")
+	}
+	if w := search(http.Header{"Cache-Control": {"no-cache"}}); w.Header().Get(CacheStatusHeader) != CacheMiss {
+		t.Error("A search asking for a fresh response used the cache.")
+	}
+	if w := search(http.Header{"Accept": {"application/xml"}}); w.Header().Get(CacheStatusHeader) != CacheMiss {
+		t.Error("A search with another Accept header used the cache.")
+	}
+	if n := len(s.Requests()); n != 6 {
+		t.Errorf("%v requests were sent to the Summon API, not 6.", n)
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	l "github.com/cu-library/lorica/loglevel"
+	"github.com/cu-library/lorica/metrics"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// The cache backends.
+	CacheBackendMemory = "memory"
+	CacheBackendRedis  = "redis"
+
+	// DefaultCacheTimeout is the default time allowed for each request to an external cache.
+	DefaultCacheTimeout = 250 * time.Millisecond
+
+	// RedisCacheKeyPrefix is the prefix of the keys of cached responses in Redis.
+	RedisCacheKeyPrefix = "lorica:response:"
+)
+
+var (
+	cacheBackendName = flag.String("cachebackend", CacheBackendMemory, "Where cached responses are kept: "+
+		"memory, or redis, so several instances of Lorica share one cache which outlives restarts.")
+	cacheURL = flag.String("cacheurl", "", "The URL of the external cache, like redis://:password@host:6379/0. "+
+		"rediss:// URLs connect with TLS.")
+	cacheTimeout = flag.Duration("cachetimeout", DefaultCacheTimeout, "The time allowed for each request to "+
+		"the external cache. When it fails or is slow, the request is sent to the Summon API.")
+
+	cacheErrorsTotal = metrics.NewCounterVec("lorica_cache_errors_total",
+		"The number of failed requests to the external cache, by operation.", "operation")
+)
+
+// cacheBackend keeps responses from the Summon API.
+type cacheBackend interface {
+	// Get returns the cached response for the key, if there is one which hasn't expired.
+	Get(key string) (*cachedResponse, bool)
+
+	// Add keeps a response for the ttl. Responses larger than maxSize aren't kept.
+	Add(key string, header http.Header, body []byte, ttl time.Duration, maxSize int)
+
+	// Purge removes the responses to requests whose path and sorted query
+	// start with prefix, and returns how many were removed.
+	Purge(prefix string) (int, error)
+}
+
+// newCacheBackend returns the named cache backend.
+func newCacheBackend(name, rawURL string, timeout time.Duration) (cacheBackend, error) {
+	switch name {
+	case CacheBackendMemory:
+		return newResponseCache(), nil
+	case CacheBackendRedis:
+		if rawURL == "" {
+			return nil, fmt.Errorf("the %v cache backend needs -cacheurl", name)
+		}
+		client, err := newRedisClient(rawURL, timeout)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse -cacheurl: %v", err)
+		}
+		return &redisCache{client: client, now: time.Now}, nil
+	}
+	return nil, fmt.Errorf("the cache backend %v should be %v or %v", name, CacheBackendMemory, CacheBackendRedis)
+}
+
+// redisCache keeps responses in Redis. Redis removes them when they expire,
+// and should be set up to remove the least recently used keys when it is full.
+type redisCache struct {
+	sync.Mutex
+	client  *redisClient
+	failing bool
+	now     func() time.Time
+}
+
+// redisCachedResponse is how a response is stored in Redis.
+type redisCachedResponse struct {
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+	Added  time.Time   `json:"added"`
+}
+
+// Get returns the cached response for the key. Responses which can't be read
+// from Redis are treated as missing.
+func (rc *redisCache) Get(key string) (*cachedResponse, bool) {
+	reply, err := rc.client.Do("GET", RedisCacheKeyPrefix+key)
+	if err == errRedisNil {
+		rc.succeeded()
+		return nil, false
+	}
+	data, ok := reply.([]byte)
+	if err == nil && !ok {
+		err = fmt.Errorf("unexpected reply %v", reply)
+	}
+	var stored redisCachedResponse
+	if err == nil {
+		err = json.Unmarshal(data, &stored)
+	}
+	if err != nil {
+		rc.failed("get", err)
+		return nil, false
+	}
+	rc.succeeded()
+	return &cachedResponse{key: key, header: stored.Header, body: stored.Body, added: stored.Added}, true
+}
+
+// Add keeps a response in Redis for the ttl.
+func (rc *redisCache) Add(key string, header http.Header, body []byte, ttl time.Duration, maxSize int) {
+	if ttl < time.Millisecond || len(body) > maxSize {
+		return
+	}
+	header = cloneHeader(header)
+	for _, name := range uncachedResponseHeaders {
+		header.Del(name)
+	}
+	data, err := json.Marshal(redisCachedResponse{Header: header, Body: body, Added: rc.now()})
+	if err == nil {
+		_, err = rc.client.Do("SET", RedisCacheKeyPrefix+key, string(data),
+			"PX", strconv.FormatInt(int64(ttl/time.Millisecond), 10))
+	}
+	if err != nil {
+		rc.failed("add", err)
+		return
+	}
+	rc.succeeded()
+}
+
+// Purge removes the responses to requests whose path and sorted query start
+// with prefix from Redis. The keys are scanned in batches, so Redis isn't
+// blocked while a large cache is purged.
+func (rc *redisCache) Purge(prefix string) (int, error) {
+	purged := 0
+	cursor := "0"
+	for {
+		reply, err := rc.client.Do("SCAN", cursor, "MATCH", RedisCacheKeyPrefix+"*", "COUNT", "1000")
+		items, ok := reply.([]interface{})
+		if err == nil && (!ok || len(items) != 2) {
+			err = fmt.Errorf("unexpected reply %v", reply)
+		}
+		if err != nil {
+			rc.failed("purge", err)
+			return purged, err
+		}
+		next, _ := items[0].([]byte)
+		keys, _ := items[1].([]interface{})
+		del := []string{"DEL"}
+		for _, key := range keys {
+			if b, ok := key.([]byte); ok && cacheKeyHasPrefix(strings.TrimPrefix(string(b), RedisCacheKeyPrefix), prefix) {
+				del = append(del, string(b))
+			}
+		}
+		if len(del) > 1 {
+			reply, err := rc.client.Do(del...)
+			if err != nil {
+				rc.failed("purge", err)
+				return purged, err
+			}
+			if n, ok := reply.(int64); ok {
+				purged += int(n)
+			}
+		}
+		cursor = string(next)
+		if cursor == "0" || cursor == "" {
+			break
+		}
+	}
+	rc.succeeded()
+	return purged, nil
+}
+
+// Ping checks Redis can be reached.
+func (rc *redisCache) Ping() error {
+	_, err := rc.client.Do("PING")
+	return err
+}
+
+// failed counts a failed request to Redis, and logs it if the previous one succeeded.
+func (rc *redisCache) failed(operation string, err error) {
+	cacheErrorsTotal.With(operation).Inc()
+	rc.Lock()
+	defer rc.Unlock()
+	if !rc.failing {
+		l.Logf(l.WarnMessage, "Unable to use the Redis cache, sending requests to the Summon API: %v.", err)
+	}
+	rc.failing = true
+}
+
+// succeeded logs that Redis is working again, if the previous request failed.
+func (rc *redisCache) succeeded() {
+	rc.Lock()
+	defer rc.Unlock()
+	if rc.failing {
+		l.Log(l.InfoMessage, "The Redis cache is working again.")
+	}
+	rc.failing = false
+}
@@ -0,0 +1,103 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"github.com/cu-library/lorica/metrics"
+	"github.com/didip/tollbooth/libstring"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// CampusRole is the s.role sent to Summon for clients on a campus network.
+const CampusRole = "authenticated"
+
+var (
+	campusNetworkList = flag.String("campusnetworks", "", "A list of campus networks, like 192.0.2.0/24, "+
+		"delimited by the ; character. Searches from clients on a campus network are sent to Summon with "+
+		"s.role=authenticated, and s.role is removed from every other search. The client's IP address is read "+
+		"from the proxy headers when -checkproxyheaders is set. If empty, s.role is passed on as it is.")
+
+	// campusNetworks are the parsed campus networks.
+	campusNetworks []*net.IPNet
+
+	campusRequestsTotal = metrics.NewCounterVec("lorica_campus_requests_total",
+		"Requests to the Summon API, by whether the client was on a campus network.", "campus")
+)
+
+// parseNetwork parses a network like 192.0.2.0/24. A single address, like
+// 192.0.2.1, is a network of one.
+func parseNetwork(network string) (*net.IPNet, error) {
+	if !strings.Contains(network, "/") {
+		if strings.Contains(network, ":") {
+			network += "/128"
+		} else {
+			network += "/32"
+		}
+	}
+	_, parsed, err := net.ParseCIDR(network)
+	return parsed, err
+}
+
+// parseCampusNetworks parses a list of networks, delimited by the ; character.
+func parseCampusNetworks(list string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, network := range splitList(list) {
+		parsed, err := parseNetwork(network)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, parsed)
+	}
+	return networks, nil
+}
+
+// onCampus returns true if the client's IP address is on a campus network.
+func onCampus(r *http.Request) bool {
+	ip := net.ParseIP(libstring.RemoteIP(clientIPLookups(), 0, r))
+	if ip == nil {
+		return false
+	}
+	for _, network := range campusNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// campusRole returns the s.role the client's searches are sent with, which
+// is empty for clients off campus. ok is false if there are no campus
+// networks, so the client's s.role is passed on.
+func campusRole(r *http.Request) (role string, ok bool) {
+	if len(campusNetworks) == 0 {
+		return "", false
+	}
+	if onCampus(r) {
+		return CampusRole, true
+	}
+	return "", true
+}
+
+// injectCampusRole sets s.role in the query for clients on campus, and
+// removes it for every other client, so clients can't choose their own role.
+func injectCampusRole(apiRequestURL *url.URL, r *http.Request) {
+	role, ok := campusRole(r)
+	if !ok {
+		return
+	}
+	query := apiRequestURL.Query()
+	query.Del("s.role")
+	if role != "" {
+		query.Set("s.role", role)
+		campusRequestsTotal.With("on").Inc()
+	} else {
+		campusRequestsTotal.With("off").Inc()
+	}
+	apiRequestURL.RawQuery = query.Encode()
+}
@@ -0,0 +1,86 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestParseCampusNetworks(t *testing.T) {
+	networks, err := parseCampusNetworks("192.0.2.0/24; 198.51.100.7;2001:db8::/32")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(networks) != 3 || networks[1].String() != "198.51.100.7/32" {
+		t.Errorf("The networks were %v", networks)
+	}
+	if _, err := parseCampusNetworks("192.0.2.0/33"); err == nil {
+		t.Error("No error for a bad network.")
+	}
+}
+
+// Clients on campus get the authenticated role, and nobody else can ask for it.
+func TestInjectCampusRole(t *testing.T) {
+	oldCampusNetworks, oldCheckProxyHeaders := campusNetworks, *checkProxyHeaders
+	defer func() { campusNetworks, *checkProxyHeaders = oldCampusNetworks, oldCheckProxyHeaders }()
+
+	tests := []struct {
+		networks, remoteAddr, forwardedFor string
+		checkProxyHeaders                  bool
+		query, expected                    string
+	}{
+		{"", "203.0.113.5:1234", "", false, "s.q=forest&s.role=authenticated", "s.q=forest&s.role=authenticated"},
+		{"192.0.2.0/24", "192.0.2.10:1234", "", false, "s.q=forest", "s.q=forest&s.role=authenticated"},
+		{"192.0.2.0/24", "203.0.113.5:1234", "", false, "s.q=forest&s.role=authenticated", "s.q=forest"},
+		{"192.0.2.0/24", "10.0.0.1:1234", "192.0.2.10", true, "s.q=forest", "s.q=forest&s.role=authenticated"},
+		{"192.0.2.0/24", "192.0.2.10:1234", "203.0.113.5", true, "s.q=forest", "s.q=forest"},
+		{"192.0.2.0/24", "203.0.113.5:1234", "192.0.2.10", false, "s.q=forest", "s.q=forest"},
+	}
+	for _, test := range tests {
+		campusNetworks, _ = parseCampusNetworks(test.networks)
+		*checkProxyHeaders = test.checkProxyHeaders
+		r := httptest.NewRequest("GET", "/2.0.0/search", nil)
+		r.RemoteAddr = test.remoteAddr
+		if test.forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", test.forwardedFor)
+		}
+		apiRequestURL := &url.URL{Path: "/2.0.0/search", RawQuery: test.query}
+		injectCampusRole(apiRequestURL, r)
+		if apiRequestURL.RawQuery != test.expected {
+			t.Errorf("%+v got the query %v", test, apiRequestURL.RawQuery)
+		}
+	}
+}
+
+// Searches from campus are sent with the role, and can't be shared by a CDN.
+func TestProxyHandlerCampusRole(t *testing.T) {
+	var role string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		role = r.URL.Query().Get("s.role")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"recordCount":1,"documents":[]}`))
+	}))
+	defer ts.Close()
+	oldAPIURL, oldCampusNetworks, oldSearchCacheControl := *apiURL, campusNetworks, *searchCacheControl
+	*apiURL, *searchCacheControl = ts.URL, "public, max-age=60"
+	campusNetworks, _ = parseCampusNetworks("192.0.2.0/24")
+	defer func() {
+		*apiURL, campusNetworks, *searchCacheControl = oldAPIURL, oldCampusNetworks, oldSearchCacheControl
+	}()
+
+	r := httptest.NewRequest("GET", "/2.0.0/search?s.q=forest", nil)
+	r.RemoteAddr = "192.0.2.10:1234"
+	w := httptest.NewRecorder()
+	proxyHandler(w, r)
+	if w.Code != http.StatusOK || role != CampusRole {
+		t.Errorf("The search got %v, and was sent with the role %q.", w.Code, role)
+	}
+	if cacheControl := w.Header().Get("Cache-Control"); cacheControl != "private" {
+		t.Errorf("The Cache-Control header was %q", cacheControl)
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+const (
+	// CapabilitiesPath is the path of the capability discovery endpoint.
+	CapabilitiesPath = "/capabilities"
+
+	// DefaultAPIVersion is the Summon API version Lorica's own searches use.
+	DefaultAPIVersion = "2.0.0"
+
+	// MaxSummonPageSize is the largest page size, s.ps, the Summon API returns.
+	MaxSummonPageSize = 50
+)
+
+// capabilities is what this instance of Lorica supports, so client
+// applications can check instead of assuming.
+type capabilities struct {
+	Version        string   `json:"version"`
+	APIVersion     string   `json:"apiVersion"`
+	VersionPinned  bool     `json:"versionPinned"`
+	Methods        []string `json:"methods"`
+	Endpoints      []string `json:"endpoints"`
+	MaxPageSize    int      `json:"maxPageSize"`
+	MaxQueryLength int      `json:"maxQueryLength,omitempty"`
+	Cache          bool     `json:"cache"`
+	Features       []string `json:"features"`
+}
+
+// apiVersion returns the Summon API version requests are sent to: the pinned
+// version, or the default.
+func apiVersion() string {
+	if *pinnedVersion != "" {
+		return *pinnedVersion
+	}
+	return DefaultAPIVersion
+}
+
+// currentCapabilities returns what Lorica supports with the current options
+// and enabled features.
+func currentCapabilities() capabilities {
+	c := capabilities{
+		Version:       version,
+		APIVersion:    apiVersion(),
+		VersionPinned: *pinnedVersion != "",
+		Methods:       proxiedMethods(),
+		MaxPageSize:   MaxSummonPageSize,
+		Cache:         features.Enabled(FeatureCache),
+		Features:      []string{},
+	}
+	for _, endpoint := range knownEndpoints {
+		if path := "/" + c.APIVersion + "/" + endpoint; pathAllowed(path) {
+			c.Endpoints = append(c.Endpoints, path)
+		}
+	}
+	if *batchMax > 0 {
+		c.Endpoints = append(c.Endpoints, BatchPath)
+	}
+	c.Endpoints = append(c.Endpoints, DidYouMeanPath, CountPath)
+	if *validateQueries {
+		c.MaxQueryLength = *maxSearchLength
+	}
+	for _, name := range knownFeatures {
+		if features.Enabled(name) {
+			c.Features = append(c.Features, name)
+		}
+	}
+	return c
+}
+
+// capabilitiesHandler sends what Lorica supports as JSON. It can be read
+// from any allowed origin, like the search results.
+func capabilitiesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Origin") != "" {
+		setACAOHeader(w, r)
+	}
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		sendJSONError(w, http.StatusMethodNotAllowed,
+			"Only GET and HEAD requests are accepted by "+CapabilitiesPath+".", nil)
+		return
+	}
+	body, err := json.Marshal(currentCapabilities())
+	if err != nil {
+		sendJSONError(w, http.StatusInternalServerError, "Unable to list capabilities.", nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	// The enabled features can change on SIGHUP, so the answer is only cached briefly.
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	w.Header().Set("Vary", "Origin")
+	w.Write(append(body, '\n'))
+}
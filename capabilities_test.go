@@ -0,0 +1,69 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// The capabilities follow the options and enabled features.
+func TestCapabilities(t *testing.T) {
+	oldPinnedVersion, oldBatchMax, oldAllowedOrigins := *pinnedVersion, *batchMax, *allowedOrigins
+	defer func() {
+		*pinnedVersion, *batchMax, *allowedOrigins = oldPinnedVersion, oldBatchMax, oldAllowedOrigins
+		features.Set("")
+	}()
+	*allowedOrigins = "http://good.example"
+
+	get := func() (*httptest.ResponseRecorder, capabilities) {
+		mux := http.NewServeMux()
+		registerPageHandlers(mux)
+		req := httptest.NewRequest("GET", CapabilitiesPath, nil)
+		req.Header.Set("Origin", "http://good.example")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		var c capabilities
+		if err := json.Unmarshal(w.Body.Bytes(), &c); err != nil {
+			t.Fatalf("Capabilities weren't JSON: %v %v", err, w.Body.String())
+		}
+		return w, c
+	}
+
+	*pinnedVersion, *batchMax = "", 0
+	features.Set("")
+	w, c := get()
+	if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "http://good.example" {
+		t.Errorf("Capabilities served incorrectly, got %v with headers %v", w.Code, w.Header())
+	}
+	wantEndpoints := []string{"/2.0.0/search", "/2.0.0/availability", "/2.0.0/suggest", DidYouMeanPath, CountPath}
+	if c.APIVersion != DefaultAPIVersion || c.VersionPinned || c.Cache || len(c.Features) != 0 ||
+		!reflect.DeepEqual(c.Methods, []string{"GET"}) || !reflect.DeepEqual(c.Endpoints, wantEndpoints) ||
+		c.MaxPageSize != MaxSummonPageSize {
+		t.Errorf("Default capabilities were %+v", c)
+	}
+
+	*pinnedVersion, *batchMax = "2.1.0", 10
+	features.Set(FeatureCache + ";" + FeaturePost)
+	_, c = get()
+	wantEndpoints = []string{"/2.1.0/search", "/2.1.0/availability", "/2.1.0/suggest", BatchPath, DidYouMeanPath, CountPath}
+	if c.APIVersion != "2.1.0" || !c.VersionPinned || !c.Cache ||
+		!reflect.DeepEqual(c.Features, []string{FeatureCache, FeaturePost}) ||
+		!reflect.DeepEqual(c.Methods, []string{"GET", "POST"}) || !reflect.DeepEqual(c.Endpoints, wantEndpoints) {
+		t.Errorf("Capabilities with options were %+v", c)
+	}
+}
+
+// Only GET and HEAD requests are accepted.
+func TestCapabilitiesMethods(t *testing.T) {
+	w := httptest.NewRecorder()
+	capabilitiesHandler(w, httptest.NewRequest("POST", CapabilitiesPath, nil))
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "GET, HEAD" {
+		t.Errorf("POST got %v with headers %v", w.Code, w.Header())
+	}
+}
@@ -0,0 +1,231 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	l "github.com/cu-library/lorica/loglevel"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultCaptureDuration is how long body capture runs for if no duration is given.
+	DefaultCaptureDuration = 10 * time.Minute
+
+	// MaxCaptureDuration is the longest body capture can run for.
+	MaxCaptureDuration = time.Hour
+
+	// CaptureRetention is how long captures are kept after body capture stops.
+	CaptureRetention = time.Hour
+
+	// MaxCaptureBodySize is the most of each request and response body which is captured.
+	MaxCaptureBodySize = 64 << 10
+
+	// MaxCaptures is the most captures kept at once. The oldest are dropped first.
+	MaxCaptures = 100
+)
+
+// bodyCapture is a request and response captured for debugging.
+type bodyCapture struct {
+	Time              time.Time   `json:"time"`
+	RequestID         string      `json:"request_id"`
+	Method            string      `json:"method"`
+	URL               string      `json:"url"`
+	RequestHeader     http.Header `json:"request_header"`
+	RequestBody       string      `json:"request_body,omitempty"`
+	RequestTruncated  bool        `json:"request_truncated,omitempty"`
+	Status            int         `json:"status"`
+	ResponseHeader    http.Header `json:"response_header"`
+	ResponseBody      string      `json:"response_body"`
+	ResponseTruncated bool        `json:"response_truncated,omitempty"`
+}
+
+// bodyCapturer decides which requests to capture, and holds the captures.
+type bodyCapturer struct {
+	sync.Mutex
+	until    time.Time
+	sample   float64
+	ids      map[string]bool
+	captures []bodyCapture
+	now      func() time.Time
+	random   func() float64
+}
+
+// capturer holds the body capture settings and captures.
+var capturer = &bodyCapturer{now: time.Now, random: rand.Float64}
+
+// Start captures requests until the duration is over. A fraction of requests
+// given by sample are captured, as well as requests with one of the IDs.
+func (c *bodyCapturer) Start(duration time.Duration, sample float64, ids []string) {
+	c.Lock()
+	defer c.Unlock()
+	c.until = c.now().Add(duration)
+	c.sample = sample
+	c.ids = make(map[string]bool)
+	for _, id := range ids {
+		c.ids[id] = true
+	}
+}
+
+// Stop stops capturing requests. The captures are kept until they expire.
+func (c *bodyCapturer) Stop() {
+	c.Lock()
+	defer c.Unlock()
+	c.until = c.now()
+}
+
+// Active returns true if requests are being captured.
+func (c *bodyCapturer) Active() bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.now().Before(c.until)
+}
+
+// Wants returns true if the request with the ID should be captured.
+func (c *bodyCapturer) Wants(id string) bool {
+	c.Lock()
+	defer c.Unlock()
+	if !c.now().Before(c.until) {
+		return false
+	}
+	return c.ids[id] || (c.sample > 0 && c.random() < c.sample)
+}
+
+// Add keeps a capture, dropping the oldest if there are too many.
+func (c *bodyCapturer) Add(capture bodyCapture) {
+	c.Lock()
+	defer c.Unlock()
+	c.captures = append(c.captures, capture)
+	if len(c.captures) > MaxCaptures {
+		c.captures = c.captures[len(c.captures)-MaxCaptures:]
+	}
+}
+
+// Captures returns the captures, after removing those which expired.
+func (c *bodyCapturer) Captures() []bodyCapture {
+	c.Lock()
+	defer c.Unlock()
+	if !c.now().Before(c.until.Add(CaptureRetention)) {
+		c.captures = nil
+	}
+	captures := make([]bodyCapture, len(c.captures))
+	copy(captures, c.captures)
+	return captures
+}
+
+// captureRecorder is a http.ResponseWriter which keeps a copy of the
+// start of the response body.
+type captureRecorder struct {
+	statusRecorder
+	body      bytes.Buffer
+	truncated bool
+}
+
+func (rec *captureRecorder) Write(b []byte) (int, error) {
+	if room := MaxCaptureBodySize - rec.body.Len(); room < len(b) {
+		rec.body.Write(b[:room])
+		rec.truncated = true
+	} else {
+		rec.body.Write(b)
+	}
+	return rec.statusRecorder.Write(b)
+}
+
+// captureBodies is a middleware which captures the requests and responses
+// the capturer wants.
+func captureBodies(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := getRequestInfo(r).id
+		if !capturer.Wants(id) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		capture := bodyCapture{
+			Time:          capturer.now(),
+			RequestID:     id,
+			Method:        r.Method,
+			URL:           r.URL.String(),
+			RequestHeader: redactedHeader(r.Header),
+		}
+		if r.Body != nil {
+			requestBody, err := ioutil.ReadAll(io.LimitReader(r.Body, MaxCaptureBodySize+1))
+			if err != nil {
+				l.Logf(l.DebugMessage, "Error capturing request body for %v: %v", id, err)
+			}
+			if len(requestBody) > MaxCaptureBodySize {
+				capture.RequestTruncated = true
+				capture.RequestBody = string(requestBody[:MaxCaptureBodySize])
+			} else {
+				capture.RequestBody = string(requestBody)
+			}
+			r.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(requestBody), r.Body))
+		}
+
+		rec := &captureRecorder{statusRecorder: statusRecorder{ResponseWriter: w}}
+		next.ServeHTTP(rec, r)
+
+		capture.Status = rec.Status()
+		capture.ResponseHeader = redactedHeader(w.Header())
+		capture.ResponseBody = rec.body.String()
+		capture.ResponseTruncated = rec.truncated
+		capturer.Add(capture)
+	})
+}
+
+// captureHandler lists the captures on a GET, starts capturing on a POST,
+// and stops capturing on a DELETE.
+func captureHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(capturer.Captures())
+	case "POST":
+		duration := DefaultCaptureDuration
+		if d := r.FormValue("duration"); d != "" {
+			var err error
+			duration, err = time.ParseDuration(d)
+			if err != nil || duration <= 0 || duration > MaxCaptureDuration {
+				sendJSONError(w, http.StatusBadRequest,
+					fmt.Sprintf("The duration must be more than 0 and at most %v.", MaxCaptureDuration), nil)
+				return
+			}
+		}
+		var sample float64
+		if s := r.FormValue("sample"); s != "" {
+			var err error
+			sample, err = strconv.ParseFloat(s, 64)
+			if err != nil || sample < 0 || sample > 1 {
+				sendJSONError(w, http.StatusBadRequest, "The sample must be between 0 and 1.", nil)
+				return
+			}
+		}
+		ids := splitList(strings.Replace(r.FormValue("ids"), ",", ";", -1))
+		if sample == 0 && len(ids) == 0 {
+			sendJSONError(w, http.StatusBadRequest, "Either sample or ids is required.", nil)
+			return
+		}
+		capturer.Start(duration, sample, ids)
+		l.Logf(l.InfoMessage, "Capturing bodies for %v: sample %v, %v request IDs.", duration, sample, len(ids))
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintf(w, "Capturing bodies for %v.\n", duration)
+	case "DELETE":
+		capturer.Stop()
+		l.Log(l.InfoMessage, "Stopped capturing bodies.")
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, "Stopped capturing bodies.")
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		sendJSONError(w, http.StatusMethodNotAllowed, "Only GET, POST, and DELETE requests accepted.", nil)
+	}
+}
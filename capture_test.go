@@ -0,0 +1,111 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Requests with a matching ID are captured, with bodies truncated and secrets masked.
+func TestCaptureBodies(t *testing.T) {
+	oldCapturer := capturer
+	capturer = &bodyCapturer{now: time.Now, random: func() float64 { return 1 }}
+	defer func() { capturer = oldCapturer }()
+	capturer.Start(time.Minute, 0, []string{"wanted"})
+
+	handler := recordResponses(captureBodies(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte(strings.Repeat("x", MaxCaptureBodySize+10)))
+	})))
+	for _, id := range []string{"wanted", "unwanted"} {
+		req := httptest.NewRequest("GET", "/2.0.0/search?s.q=test", nil)
+		req.Header.Set("X-Request-ID", id)
+		req.Header.Set(APIKeyHeader, "secretkey")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Body.Len() != MaxCaptureBodySize+10 {
+			t.Errorf("Response changed by capture, got %v bytes", w.Body.Len())
+		}
+	}
+
+	captures := capturer.Captures()
+	if len(captures) != 1 {
+		t.Fatalf("Expected 1 capture, got %v", len(captures))
+	}
+	c := captures[0]
+	if c.RequestID != "wanted" || c.Status != http.StatusTeapot || c.URL != "/2.0.0/search?s.q=test" {
+		t.Errorf("Bad capture, got %#v", c)
+	}
+	if len(c.ResponseBody) != MaxCaptureBodySize || !c.ResponseTruncated {
+		t.Errorf("Response body not truncated, got %v bytes", len(c.ResponseBody))
+	}
+	if c.RequestHeader.Get(APIKeyHeader) != MaskedValue {
+		t.Errorf("API key not masked, got %v", c.RequestHeader.Get(APIKeyHeader))
+	}
+}
+
+// Capturing stops after the duration, and the captures expire later.
+func TestBodyCapturerExpiry(t *testing.T) {
+	now := time.Date(2016, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := &bodyCapturer{now: func() time.Time { return now }, random: func() float64 { return 0 }}
+	c.Start(time.Minute, 0.5, nil)
+	if !c.Wants("any") {
+		t.Error("Sampled request not wanted.")
+	}
+	c.Add(bodyCapture{RequestID: "any"})
+
+	now = now.Add(2 * time.Minute)
+	if c.Active() || c.Wants("any") {
+		t.Error("Still capturing after the duration.")
+	}
+	if len(c.Captures()) != 1 {
+		t.Error("Capture expired too soon.")
+	}
+	now = now.Add(CaptureRetention)
+	if len(c.Captures()) != 0 {
+		t.Error("Capture did not expire.")
+	}
+}
+
+// The admin endpoint starts and stops capturing, and lists the captures.
+func TestCaptureHandler(t *testing.T) {
+	oldCapturer := capturer
+	capturer = &bodyCapturer{now: time.Now, random: func() float64 { return 1 }}
+	defer func() { capturer = oldCapturer }()
+
+	mux := newAdminMux()
+	for _, query := range []string{"", "sample=2", "ids=a&duration=2h"} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("POST", "/admin/capture?"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Bad capture settings %#v not rejected, got %v", query, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("POST", "/admin/capture?ids=a,b&duration=5m", nil))
+	if w.Code != http.StatusOK || !capturer.Active() || !capturer.Wants("b") {
+		t.Errorf("Capture not started, got %v: %v", w.Code, w.Body.String())
+	}
+
+	capturer.Add(bodyCapture{RequestID: "a"})
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/admin/capture", nil))
+	var captures []bodyCapture
+	if err := json.Unmarshal(w.Body.Bytes(), &captures); err != nil || len(captures) != 1 {
+		t.Errorf("Captures not listed, got %v", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("DELETE", "/admin/capture", nil))
+	if w.Code != http.StatusOK || capturer.Active() {
+		t.Errorf("Capture not stopped, got %v", w.Code)
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// SurrogateKeyHashLength is the number of hex characters kept from the hash in a query's surrogate key.
+const SurrogateKeyHashLength = 16
+
+var (
+	searchCacheControl = flag.String("searchcachecontrol", "", "The Cache-Control header of successful search "+
+		"responses, like public, max-age=60, so a CDN in front of Lorica can cache popular queries. Responses "+
+		"with a session ID are always private. If empty, the header isn't set.")
+	searchSurrogateControl = flag.String("searchsurrogatecontrol", "", "The Surrogate-Control header of "+
+		"successful search responses, like max-age=3600. CDNs like Fastly use it instead of Cache-Control, "+
+		"and remove it before the response reaches the client. If empty, the header isn't set.")
+	surrogateKeyHeader = flag.String("surrogatekeyheader", "", "The header which lists the surrogate keys of "+
+		"cacheable search responses, so a CDN can purge them by key: Surrogate-Key for Fastly, or Cache-Tag "+
+		"for Cloudflare and Akamai. If empty, the keys aren't sent.")
+)
+
+// cdnCachingEnabled returns true if search responses get any CDN caching headers.
+func cdnCachingEnabled() bool {
+	return *searchCacheControl != "" || *searchSurrogateControl != "" || *surrogateKeyHeader != ""
+}
+
+// profileSurrogateKey returns the surrogate key of every response signed with
+// a profile's credentials, like profile-default or profile-sandbox for /sandbox.
+func profileSurrogateKey(prefix string) string {
+	if prefix == "" {
+		return "profile-default"
+	}
+	return "profile-" + strings.Replace(strings.Trim(prefix, "/"), "/", "-", -1)
+}
+
+// querySurrogateKey returns the surrogate key of the responses to searches
+// for the queries. The queries are normalized first, so the key doesn't
+// depend on case, spacing, or order.
+func querySurrogateKey(queries []string) string {
+	normalized := make([]string, len(queries))
+	for i, q := range queries {
+		normalized[i] = strings.ToLower(strings.Join(strings.Fields(q), " "))
+	}
+	sort.Strings(normalized)
+	sum := sha256.Sum256([]byte(strings.Join(normalized, "\n")))
+	return "query-" + hex.EncodeToString(sum[:])[:SurrogateKeyHashLength]
+}
+
+// surrogateKeys returns the surrogate keys of a search response: lorica for
+// every response, the endpoint, the profile, and the query, if there is one.
+func surrogateKeys(r *http.Request) []string {
+	prefix := ""
+	for _, p := range credentialPrefixes {
+		if r.URL.Path == p.prefix || strings.HasPrefix(r.URL.Path, p.prefix+"/") {
+			prefix = p.prefix
+			break
+		}
+	}
+	keys := []string{"lorica", endpointLabel(r.URL.Path), profileSurrogateKey(prefix)}
+	if queries := r.URL.Query()["s.q"]; len(queries) > 0 {
+		keys = append(keys, querySurrogateKey(queries))
+	}
+	return keys
+}
+
+// setSearchCacheHeaders adds the CDN caching headers to a successful search
+// response. Responses to other endpoints and to POST requests aren't changed.
+// Responses with a session ID belong to one patron, and responses which
+// depend on whether the client is on campus can't be shared, so they are made private.
+func setSearchCacheHeaders(w http.ResponseWriter, r *http.Request) {
+	if !cdnCachingEnabled() || endpointLabel(r.URL.Path) != "search" || (r.Method != "GET" && r.Method != "HEAD") {
+		return
+	}
+	h := w.Header()
+	if h.Get("x-summon-session-id") != "" || r.Header.Get("x-summon-session-id") != "" || len(campusNetworks) > 0 {
+		h.Set("Cache-Control", "private")
+		return
+	}
+	if *searchCacheControl != "" {
+		h.Set("Cache-Control", *searchCacheControl)
+	}
+	if *searchSurrogateControl != "" {
+		h.Set("Surrogate-Control", *searchSurrogateControl)
+	}
+	if *surrogateKeyHeader != "" {
+		separator := ", "
+		if strings.EqualFold(*surrogateKeyHeader, "Surrogate-Key") {
+			separator = " "
+		}
+		h.Set(*surrogateKeyHeader, strings.Join(surrogateKeys(r), separator))
+	}
+
+	// The response depends on these request headers, so caches must keep
+	// a copy for each of their values.
+	h.Add("Vary", "Accept")
+	if acao := h.Get("Access-Control-Allow-Origin"); acao != "" && acao != "*" {
+		h.Add("Vary", "Origin")
+	}
+	if *injectLanguages != "" {
+		h.Add("Vary", "Accept-Language")
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"github.com/cu-library/lorica/internal/summonmock"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// setCDNFlags sets the CDN caching flags, and returns a function which restores them.
+func setCDNFlags(cacheControl, surrogateControl, keyHeader string) func() {
+	oldCacheControl, oldSurrogateControl, oldKeyHeader := *searchCacheControl, *searchSurrogateControl, *surrogateKeyHeader
+	*searchCacheControl, *searchSurrogateControl, *surrogateKeyHeader = cacheControl, surrogateControl, keyHeader
+	return func() {
+		*searchCacheControl, *searchSurrogateControl, *surrogateKeyHeader = oldCacheControl, oldSurrogateControl, oldKeyHeader
+	}
+}
+
+// Successful searches get the CDN caching headers, and other responses don't.
+func TestSearchCacheHeaders(t *testing.T) {
+	s, stop := startSummonMock()
+	defer stop()
+	defer setCDNFlags("public, max-age=60", "max-age=3600", "Surrogate-Key")()
+
+	search := func(method, target string, header http.Header) http.Header {
+		r := httptest.NewRequest(method, target, nil)
+		for name, values := range header {
+			r.Header[name] = values
+		}
+		w := httptest.NewRecorder()
+		proxyHandler(w, r)
+		return w.Header()
+	}
+
+	h := search("GET", "/2.0.0/search?s.q=Forest++Fire", nil)
+	if h.Get("Cache-Control") != "public, max-age=60" || h.Get("Surrogate-Control") != "max-age=3600" {
+		t.Errorf("A search had headers %v", h)
+	}
+	expected := "lorica search profile-default " + querySurrogateKey([]string{"forest fire"})
+	if h.Get("Surrogate-Key") != expected {
+		t.Errorf("A search had surrogate keys %q, expected %q", h.Get("Surrogate-Key"), expected)
+	}
+	if !strings.Contains(strings.Join(h["Vary"], ", "), "Accept") {
+		t.Errorf("A search had Vary %v", h["Vary"])
+	}
+
+	h = search("GET", "/2.0.0/search?s.q=forest", http.Header{"X-Summon-Session-Id": {"abc"}})
+	if h.Get("Cache-Control") != "private" || h.Get("Surrogate-Control") != "" || h.Get("Surrogate-Key") != "" {
+		t.Errorf("A search in a session had headers %v", h)
+	}
+
+	h = search("GET", "/2.0.0/availability?s.q=forest", nil)
+	if h.Get("Cache-Control") != "" || h.Get("Surrogate-Key") != "" {
+		t.Errorf("An availability request had headers %v", h)
+	}
+
+	s.Script(summonmock.JSON(http.StatusInternalServerError, `{"errors":[]}`))
+	h = search("GET", "/2.0.0/search?s.q=forest", nil)
+	if h.Get("Cache-Control") != "" || h.Get("Surrogate-Key") != "" {
+		t.Errorf("A failed search had headers %v", h)
+	}
+}
+
+// Query keys don't depend on case, spacing, or order, and profiles are named by their prefix.
+func TestSurrogateKeys(t *testing.T) {
+	if querySurrogateKey([]string{"Forest  fire", "trees"}) != querySurrogateKey([]string{"trees", "forest fire"}) {
+		t.Error("Equivalent queries had different keys.")
+	}
+	if querySurrogateKey([]string{"forest"}) == querySurrogateKey([]string{"fire"}) {
+		t.Error("Different queries had the same key.")
+	}
+
+	oldCredentialPrefixes := credentialPrefixes
+	credentialPrefixes = []prefixCredentials{{prefix: "/sandbox", credentials: credentials{"SANDBOX", "key"}}}
+	defer func() { credentialPrefixes = oldCredentialPrefixes }()
+	keys := surrogateKeys(httptest.NewRequest("GET", "/sandbox/2.0.0/search", nil))
+	if strings.Join(keys, " ") != "lorica search profile-sandbox" {
+		t.Errorf("A search without a query had keys %v", keys)
+	}
+
+	defer setCDNFlags("", "", "Cache-Tag")()
+	w := httptest.NewRecorder()
+	setSearchCacheHeaders(w, httptest.NewRequest("GET", "/2.0.0/search", nil))
+	if w.Header().Get("Cache-Tag") != "lorica, search, profile-default" {
+		t.Errorf("The Cache-Tag header was %q", w.Header().Get("Cache-Tag"))
+	}
+}
@@ -0,0 +1,245 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	l "github.com/cu-library/lorica/loglevel"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The CDNs whose purge APIs Lorica can call.
+const (
+	CDNFastly     = "fastly"
+	CDNCloudFront = "cloudfront"
+)
+
+// The signing details of AWS requests to the CloudFront API, which is global.
+const (
+	awsRegion          = "us-east-1"
+	cloudFrontService  = "cloudfront"
+	cloudFrontAPIDate  = "2020-05-31"
+	awsAmzDateFormat   = "20060102T150405Z"
+	awsShortDateFormat = "20060102"
+)
+
+var (
+	cdnPurge = flag.String("cdnpurge", "", "The CDN to purge when the cache is purged with the admin API: "+
+		"fastly, which purges the surrogate keys, or cloudfront, which can't purge by key, so invalidates "+
+		"every path. If empty, the CDN isn't purged.")
+	cdnPurgeID    = flag.String("cdnpurgeid", "", "The Fastly service ID or the CloudFront distribution ID to purge.")
+	cdnPurgeToken = flag.String("cdnpurgetoken", "", "The Fastly API token, or the AWS access key ID and "+
+		"secret access key for CloudFront, like ID:SECRET.")
+
+	// The CDN APIs. Tests replace them.
+	fastlyAPIURL     = "https://api.fastly.com"
+	cloudFrontAPIURL = "https://cloudfront.amazonaws.com"
+
+	// purger purges the CDN. It is nil if -cdnpurge isn't set.
+	purger cdnPurger
+)
+
+// cdnPurger purges cached responses from a CDN.
+type cdnPurger interface {
+	// Purge removes the responses with any of the surrogate keys.
+	Purge(keys []string) error
+	// Name returns the name of the CDN.
+	Name() string
+}
+
+// newCDNPurgerFromFlags builds a purger from the command line flags.
+// It returns nil if -cdnpurge isn't set.
+func newCDNPurgerFromFlags() (cdnPurger, error) {
+	if *cdnPurge == "" {
+		return nil, nil
+	}
+	if *cdnPurgeID == "" || *cdnPurgeToken == "" {
+		return nil, errors.New("-cdnpurgeid and -cdnpurgetoken must be set")
+	}
+	client := &http.Client{Timeout: *timeout}
+	switch *cdnPurge {
+	case CDNFastly:
+		return &fastlyPurger{serviceID: *cdnPurgeID, token: *cdnPurgeToken, client: client}, nil
+	case CDNCloudFront:
+		parts := strings.SplitN(*cdnPurgeToken, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, errors.New("the CloudFront token should be the access key ID and secret access key, like ID:SECRET")
+		}
+		return &cloudFrontPurger{distributionID: *cdnPurgeID, accessKeyID: parts[0], secretAccessKey: parts[1],
+			client: client, now: time.Now}, nil
+	}
+	return nil, fmt.Errorf("unknown CDN %#v, it should be %v or %v", *cdnPurge, CDNFastly, CDNCloudFront)
+}
+
+// fastlyPurger purges a Fastly service by surrogate key.
+type fastlyPurger struct {
+	serviceID string
+	token     string
+	client    *http.Client
+}
+
+func (f *fastlyPurger) Name() string {
+	return CDNFastly
+}
+
+// Purge purges the keys with one request to Fastly's bulk purge API.
+func (f *fastlyPurger) Purge(keys []string) error {
+	req, err := http.NewRequest("POST", fastlyAPIURL+"/service/"+url.PathEscape(f.serviceID)+"/purge", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Fastly-Key", f.token)
+	req.Header.Set("Surrogate-Key", strings.Join(keys, " "))
+	req.Header.Set("Accept", "application/json")
+	return sendPurge(f.client, req)
+}
+
+// cloudFrontPurger invalidates the paths of a CloudFront distribution.
+type cloudFrontPurger struct {
+	distributionID  string
+	accessKeyID     string
+	secretAccessKey string
+	client          *http.Client
+	now             func() time.Time
+}
+
+func (c *cloudFrontPurger) Name() string {
+	return CDNCloudFront
+}
+
+// Purge invalidates every path, since CloudFront can't invalidate by key.
+func (c *cloudFrontPurger) Purge(keys []string) error {
+	now := c.now().UTC()
+	body := `<?xml version="1.0" encoding="UTF-8"?>` +
+		`<InvalidationBatch xmlns="http://cloudfront.amazonaws.com/doc/` + cloudFrontAPIDate + `/">` +
+		`<Paths><Quantity>1</Quantity><Items><Path>/*</Path></Items></Paths>` +
+		`<CallerReference>lorica-` + strconv.FormatInt(now.UnixNano(), 10) + `</CallerReference>` +
+		`</InvalidationBatch>`
+	req, err := http.NewRequest("POST", cloudFrontAPIURL+"/"+cloudFrontAPIDate+"/distribution/"+
+		url.PathEscape(c.distributionID)+"/invalidation", strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/xml")
+	signAWSRequest(req, []byte(body), c.accessKeyID, c.secretAccessKey, awsRegion, cloudFrontService, now)
+	return sendPurge(c.client, req)
+}
+
+// signAWSRequest signs the request with AWS Signature Version 4. The host and
+// x-amz-date headers are signed.
+func signAWSRequest(req *http.Request, body []byte, accessKeyID, secretAccessKey, region, service string, now time.Time) {
+	amzDate := now.Format(awsAmzDateFormat)
+	shortDate := now.Format(awsShortDateFormat)
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	payloadHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host + "\n" + "x-amz-date:" + amzDate + "\n",
+		"host;x-amz-date",
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	scope := shortDate + "/" + region + "/" + service + "/aws4_request"
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	key := []byte("AWS4" + secretAccessKey)
+	for _, part := range []string{shortDate, region, service, "aws4_request", stringToSign} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKeyID+"/"+scope+
+		", SignedHeaders=host;x-amz-date, Signature="+hex.EncodeToString(key))
+}
+
+// sendPurge sends a request to a CDN's purge API, and returns an error
+// if it wasn't successful.
+func sendPurge(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, SchemaSampleSize))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("the purge API responded with %v: %q", resp.Status, body)
+	}
+	return nil
+}
+
+// purgeKeys returns the surrogate keys to purge for a purge request: the key
+// parameters, and the key of a search for the q parameters. Without any,
+// everything is purged.
+func purgeKeys(r *http.Request) []string {
+	keys := r.URL.Query()["key"]
+	if queries := r.URL.Query()["q"]; len(queries) > 0 {
+		keys = append(keys, querySurrogateKey(queries))
+	}
+	if len(keys) == 0 {
+		keys = []string{"lorica"}
+	}
+	return keys
+}
+
+// cachePurgeHandler purges cached responses on a DELETE. Lorica's own cache
+// is purged first, so the CDN isn't refilled from it, and then the CDN, if
+// there is one. The prefix parameter limits Lorica's purge to the requests
+// whose path and sorted query start with it.
+func cachePurgeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		w.Header().Set("Allow", "DELETE")
+		sendJSONError(w, http.StatusMethodNotAllowed, "Only DELETE requests accepted.", nil)
+		return
+	}
+	prefix := r.URL.Query().Get("prefix")
+	if prefix != "" && !strings.HasPrefix(prefix, "/") {
+		sendJSONError(w, http.StatusBadRequest, "The prefix parameter must be the start of a path.",
+			[]string{"Send a prefix like /2.0.0/search or /2.0.0/availability."})
+		return
+	}
+	purged, err := cachedResponses.Purge(prefix)
+	if err != nil {
+		sendJSONError(w, http.StatusBadGateway, fmt.Sprintf("Unable to purge the response cache: %v.", err), nil)
+		return
+	}
+	// Result counts are taken from searches.
+	if strings.HasPrefix(SearchPath, prefix) || strings.HasPrefix(prefix, SearchPath) {
+		purged += counts.Purge()
+	}
+	l.Logf(l.InfoMessage, "Purged %v cached responses with the prefix %q.", purged, prefix)
+	result := struct {
+		Purged int      `json:"purged"`
+		Prefix string   `json:"prefix,omitempty"`
+		CDN    string   `json:"cdn,omitempty"`
+		Keys   []string `json:"keys,omitempty"`
+	}{Purged: purged, Prefix: prefix}
+
+	if purger != nil {
+		keys := purgeKeys(r)
+		if err := purger.Purge(keys); err != nil {
+			sendJSONError(w, http.StatusBadGateway, fmt.Sprintf("Unable to purge %v: %v.", purger.Name(), err), nil)
+			return
+		}
+		l.Logf(l.InfoMessage, "Purged %v keys %v.", purger.Name(), strings.Join(keys, " "))
+		result.CDN, result.Keys = purger.Name(), keys
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,115 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The signature matches the get-vanilla example from AWS's Signature Version 4 test suite.
+func TestSignAWSRequest(t *testing.T) {
+	req, _ := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	signAWSRequest(req, nil, "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "us-east-1", "service",
+		time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if req.Header.Get("Authorization") != expected {
+		t.Errorf("Got %v, expected %v", req.Header.Get("Authorization"), expected)
+	}
+	if req.Header.Get("X-Amz-Date") != "20150830T123600Z" {
+		t.Errorf("Got date %v", req.Header.Get("X-Amz-Date"))
+	}
+}
+
+// Purges are sent to the configured CDN's API.
+func TestCachePurgeHandler(t *testing.T) {
+	var received *http.Request
+	var receivedBody string
+	status := http.StatusOK
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received, receivedBody = r, string(body)
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+	oldFastlyAPIURL, oldCloudFrontAPIURL, oldPurger := fastlyAPIURL, cloudFrontAPIURL, purger
+	fastlyAPIURL, cloudFrontAPIURL = ts.URL, ts.URL
+	defer func() { fastlyAPIURL, cloudFrontAPIURL, purger = oldFastlyAPIURL, oldCloudFrontAPIURL, oldPurger }()
+
+	purge := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		cachePurgeHandler(w, httptest.NewRequest("DELETE", target, nil))
+		return w
+	}
+
+	purger = nil
+	if w := purge("/admin/cache"); w.Code != http.StatusOK || strings.Contains(w.Body.String(), "cdn") {
+		t.Errorf("A purge without a CDN returned %v: %v", w.Code, w.Body.String())
+	}
+
+	purger = &fastlyPurger{serviceID: "SERVICE", token: "TOKEN", client: http.DefaultClient}
+	if w := purge("/admin/cache?key=profile-sandbox&q=Forest"); w.Code != http.StatusOK {
+		t.Fatalf("A Fastly purge returned %v: %v", w.Code, w.Body.String())
+	}
+	if received.URL.Path != "/service/SERVICE/purge" || received.Header.Get("Fastly-Key") != "TOKEN" ||
+		received.Header.Get("Surrogate-Key") != "profile-sandbox "+querySurrogateKey([]string{"forest"}) {
+		t.Errorf("Fastly was sent %v %v", received.URL, received.Header)
+	}
+	purge("/admin/cache")
+	if received.Header.Get("Surrogate-Key") != "lorica" {
+		t.Errorf("Purging everything sent keys %v", received.Header.Get("Surrogate-Key"))
+	}
+
+	purger = &cloudFrontPurger{distributionID: "DIST", accessKeyID: "ID", secretAccessKey: "SECRET",
+		client: http.DefaultClient, now: time.Now}
+	if w := purge("/admin/cache?q=forest"); w.Code != http.StatusOK {
+		t.Fatalf("A CloudFront purge returned %v: %v", w.Code, w.Body.String())
+	}
+	if received.URL.Path != "/2020-05-31/distribution/DIST/invalidation" ||
+		!strings.HasPrefix(received.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=ID/") ||
+		!strings.Contains(receivedBody, "<Path>/*</Path>") {
+		t.Errorf("CloudFront was sent %v %v %v", received.URL, received.Header, receivedBody)
+	}
+
+	status = http.StatusForbidden
+	if w := purge("/admin/cache"); w.Code != http.StatusBadGateway || !strings.Contains(w.Body.String(), "403") {
+		t.Errorf("A failed purge returned %v: %v", w.Code, w.Body.String())
+	}
+
+	w := httptest.NewRecorder()
+	cachePurgeHandler(w, httptest.NewRequest("GET", "/admin/cache", nil))
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "DELETE" {
+		t.Errorf("A GET returned %v", w.Code)
+	}
+}
+
+// The purger is checked at startup.
+func TestNewCDNPurgerFromFlags(t *testing.T) {
+	oldPurge, oldID, oldToken := *cdnPurge, *cdnPurgeID, *cdnPurgeToken
+	defer func() { *cdnPurge, *cdnPurgeID, *cdnPurgeToken = oldPurge, oldID, oldToken }()
+
+	for _, c := range []struct {
+		cdn, id, token string
+		ok             bool
+	}{
+		{"", "", "", true},
+		{"fastly", "SERVICE", "TOKEN", true},
+		{"fastly", "", "TOKEN", false},
+		{"cloudfront", "DIST", "ID:SECRET", true},
+		{"cloudfront", "DIST", "TOKEN", false},
+		{"akamai", "ID", "TOKEN", false},
+	} {
+		*cdnPurge, *cdnPurgeID, *cdnPurgeToken = c.cdn, c.id, c.token
+		_, err := newCDNPurgerFromFlags()
+		if (err == nil) != c.ok {
+			t.Errorf("%v %v %v gave error %v", c.cdn, c.id, c.token, err)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ChallengeTokenHeader is the request header which carries a challenge token.
+	ChallengeTokenHeader = "X-Lorica-Challenge-Token"
+
+	// ChallengeURLHeader is the response header which tells the front end where to get a challenge token.
+	ChallengeURLHeader = "X-Lorica-Challenge"
+
+	// MaxChallengeTokenLifetime is the longest a challenge token can be valid for.
+	MaxChallengeTokenLifetime = time.Hour
+
+	// ErrorChallengeRequired is the error code sent when a request needs a challenge token.
+	ErrorChallengeRequired = "challenge_required"
+)
+
+// The conditions which make a request suspect.
+const (
+	ChallengeNoSession   = "nosession"
+	ChallengeNoOrigin    = "noorigin"
+	ChallengeNoUserAgent = "nouseragent"
+)
+
+var (
+	challengeSecret = flag.String("challengesecret", "", "A secret shared with the website which issues challenge "+
+		"tokens. If set, suspect requests must carry a one-time token in the "+ChallengeTokenHeader+" header.")
+	challengeURL = flag.String("challengeurl", "", "Where the front end can get a challenge token. "+
+		"It is sent to challenged clients in the "+ChallengeURLHeader+" header.")
+	challengeConditions = flag.String("challengeconditions", ChallengeNoSession, "A list of the conditions which "+
+		"make a request suspect, delimited by the ; character. The conditions are nosession, noorigin, and nouseragent.")
+
+	// challengers are asked in order whether each request should be challenged
+	// before it is proxied. Other bot mitigation can be added here.
+	challengers []challenger
+)
+
+// challenge is the response sent instead of proxying a suspect request.
+type challenge struct {
+	status  int
+	message string
+	url     string
+}
+
+// challenger decides whether a request has to pass a challenge before it is proxied.
+type challenger interface {
+	// Challenge returns nil if the request can be proxied, or the challenge to send instead.
+	Challenge(r *http.Request) *challenge
+}
+
+// tokenChallenger challenges suspect requests which don't carry a valid
+// one-time token. A token looks like nonce.expiry.signature, where expiry is
+// a Unix time and signature is the hex HMAC-SHA256 of nonce.expiry using the
+// shared secret.
+type tokenChallenger struct {
+	sync.Mutex
+	secret     []byte
+	url        string
+	conditions map[string]bool
+	used       map[string]time.Time
+	now        func() time.Time
+}
+
+// newTokenChallenger returns a tokenChallenger, checking the conditions are known.
+func newTokenChallenger(secret, url, conditions string) (*tokenChallenger, error) {
+	c := &tokenChallenger{
+		secret:     []byte(secret),
+		url:        url,
+		conditions: make(map[string]bool),
+		used:       make(map[string]time.Time),
+		now:        time.Now,
+	}
+	for _, condition := range splitList(conditions) {
+		condition = strings.ToLower(condition)
+		switch condition {
+		case ChallengeNoSession, ChallengeNoOrigin, ChallengeNoUserAgent:
+			c.conditions[condition] = true
+		default:
+			return nil, fmt.Errorf("unknown challenge condition %v", condition)
+		}
+	}
+	return c, nil
+}
+
+// suspect returns true if the request meets any of the conditions.
+func (c *tokenChallenger) suspect(r *http.Request) bool {
+	return (c.conditions[ChallengeNoSession] && r.Header.Get("x-summon-session-id") == "") ||
+		(c.conditions[ChallengeNoOrigin] && r.Header.Get("Origin") == "" && r.Header.Get("Referer") == "") ||
+		(c.conditions[ChallengeNoUserAgent] && r.Header.Get("User-Agent") == "")
+}
+
+// Challenge returns a challenge if the request is suspect and doesn't have a valid token.
+func (c *tokenChallenger) Challenge(r *http.Request) *challenge {
+	if !c.suspect(r) {
+		return nil
+	}
+	if token := r.Header.Get(ChallengeTokenHeader); token != "" {
+		attempt := startAuthAttempt()
+		if c.redeem(token) {
+			return nil
+		}
+		attempt.Failed()
+	}
+	return &challenge{
+		status:  http.StatusForbidden,
+		message: "This request needs a challenge token.",
+		url:     c.url,
+	}
+}
+
+// redeem returns true if the token is valid and hasn't been used before.
+func (c *tokenChallenger) redeem(token string) bool {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] == "" {
+		return false
+	}
+	expiry, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	signature, err := hex.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, mac.Sum(nil)) {
+		return false
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	now := c.now()
+	expires := time.Unix(expiry, 0)
+	if !now.Before(expires) || expires.Sub(now) > MaxChallengeTokenLifetime {
+		return false
+	}
+	if _, ok := c.used[parts[0]]; ok {
+		return false
+	}
+	for nonce, expires := range c.used {
+		if !now.Before(expires) {
+			delete(c.used, nonce)
+		}
+	}
+	c.used[parts[0]] = expires
+	return true
+}
+
+// challengeRequest asks the challengers about the request, and returns
+// the first challenge, or nil if the request can be proxied.
+func challengeRequest(r *http.Request) *challenge {
+	for _, c := range challengers {
+		if ch := c.Challenge(r); ch != nil {
+			return ch
+		}
+	}
+	return nil
+}
+
+// sendChallenge sends the challenge to the client.
+func sendChallenge(w http.ResponseWriter, ch *challenge) {
+	var hints []string
+	if ch.url != "" {
+		w.Header().Set(ChallengeURLHeader, ch.url)
+		if w.Header().Get("Access-Control-Allow-Origin") != "" {
+			w.Header().Set("Access-Control-Expose-Headers", ChallengeURLHeader)
+		}
+		hints = append(hints, "Get a token from "+ch.url+" and send it in the "+ChallengeTokenHeader+" header.")
+	}
+	sendJSONErrorCode(w, ch.status, ErrorChallengeRequired, ch.message, hints)
+}
+
+// allowedCORSRequestHeaders are the headers a CORS request can send. With
+// client tiers, the API key and bearer token headers identify the client.
+func allowedCORSRequestHeaders() []string {
+	headers := []string{"x-summon-session-id"}
+	if len(challengers) > 0 {
+		headers = append(headers, strings.ToLower(ChallengeTokenHeader))
+	}
+	if len(clientTiers) > 0 {
+		headers = append(headers, strings.ToLower(APIKeyHeader), "authorization")
+	}
+	return headers
+}
+
+// requestedCORSHeaders returns the headers a CORS preflight request asks to
+// send, from every Access-Control-Request-Headers header, delimited by commas.
+func requestedCORSHeaders(r *http.Request) string {
+	return strings.Join(r.Header.Values("Access-Control-Request-Headers"), ",")
+}
+
+// corsRequestHeadersAllowed returns true if every header in the list can be sent.
+func corsRequestHeadersAllowed(list string) bool {
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		allowed := false
+		for _, ok := range allowedCORSRequestHeaders() {
+			if name == ok {
+				allowed = true
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
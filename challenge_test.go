@@ -0,0 +1,113 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// signChallengeToken makes a token the way the website which issues them does.
+func signChallengeToken(secret, nonce string, expires time.Time) string {
+	payload := nonce + "." + strconv.FormatInt(expires.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return payload + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Unknown conditions are errors.
+func TestNewTokenChallengerBadCondition(t *testing.T) {
+	if _, err := newTokenChallenger("secret", "", "nosession;nocookies"); err == nil {
+		t.Error("No error for an unknown challenge condition.")
+	}
+}
+
+// Suspect requests need a valid token, which can only be used once.
+func TestTokenChallenger(t *testing.T) {
+	c, err := newTokenChallenger("secret", "https://library.example/token", "nosession")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	req, err := http.NewRequest("GET", "/2.0.0/search?s.q=test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("x-summon-session-id", "session")
+	if c.Challenge(req) != nil {
+		t.Error("A request which isn't suspect was challenged.")
+	}
+
+	req.Header.Del("x-summon-session-id")
+	ch := c.Challenge(req)
+	if ch == nil || ch.url != "https://library.example/token" {
+		t.Fatalf("A suspect request wasn't challenged, got %+v", ch)
+	}
+
+	for _, token := range []string{
+		"garbage",
+		signChallengeToken("wrong secret", "nonce1", now.Add(time.Minute)),
+		signChallengeToken("secret", "nonce1", now.Add(-time.Minute)),
+		signChallengeToken("secret", "nonce1", now.Add(2*MaxChallengeTokenLifetime)),
+	} {
+		req.Header.Set(ChallengeTokenHeader, token)
+		if c.Challenge(req) == nil {
+			t.Errorf("Bad token %v was accepted.", token)
+		}
+	}
+
+	req.Header.Set(ChallengeTokenHeader, signChallengeToken("secret", "nonce1", now.Add(time.Minute)))
+	if c.Challenge(req) != nil {
+		t.Error("A valid token was rejected.")
+	}
+	if c.Challenge(req) == nil {
+		t.Error("A token was accepted twice.")
+	}
+}
+
+// A challenged request isn't proxied, and the preflight allows the token header.
+func TestProxyHanderChallenge(t *testing.T) {
+	c, err := newTokenChallenger("secret", "https://library.example/token", "nosession")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldChallengers := challengers
+	challengers = []challenger{c}
+	defer func() { challengers = oldChallengers }()
+	oldAllowedOrigins := *allowedOrigins
+	*allowedOrigins = "http://test.com"
+	defer func() { *allowedOrigins = oldAllowedOrigins }()
+
+	req, err := http.NewRequest("GET", "/2.0.0/search?s.q=test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	proxyHandler(w, req)
+	if w.Code != http.StatusForbidden || w.Header().Get(ChallengeURLHeader) != "https://library.example/token" {
+		t.Errorf("Request wasn't challenged, got %v", w.Code)
+	}
+
+	req, err = http.NewRequest("OPTIONS", "/2.0.0/search", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Origin", "http://test.com")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	req.Header.Set("Access-Control-Request-Headers", "x-summon-session-id, x-lorica-challenge-token")
+	w = httptest.NewRecorder()
+	proxyHandler(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Preflight with the challenge token header failed, got %v: %v", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	l "github.com/cu-library/lorica/loglevel"
+	"github.com/cu-library/lorica/metrics"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxClockSkew is the default for the most the local clock can differ
+	// from the Summon API's before requests risk being refused for a bad x-summon-date.
+	DefaultMaxClockSkew = time.Minute
+
+	// ClockSkewWarningInterval is the least time between warnings about clock skew.
+	ClockSkewWarningInterval = 10 * time.Minute
+
+	// ClockSkewSmoothing is the weight given to each new measurement of the skew.
+	// The Date header only has one second resolution, so measurements are averaged.
+	ClockSkewSmoothing = 0.1
+)
+
+var (
+	maxClockSkew = flag.Duration("maxclockskew", DefaultMaxClockSkew, "The most the local clock can differ from "+
+		"the Date header sent by the Summon API before Lorica warns about it.")
+	correctClockSkew = flag.Bool("correctclockskew", false, "When the local clock differs from the Summon API's "+
+		"by more than -maxclockskew, adjust the timestamp used to sign requests to match Summon's clock.")
+
+	// skew tracks how far the local clock is from the Summon API's.
+	skew = &clockSkew{now: time.Now}
+
+	_ = metrics.NewCollectorFunc("clock_skew", func(w io.Writer) {
+		metrics.WriteGauge(w, "lorica_clock_skew_seconds", "How far the Summon API's clock is ahead of the local clock.",
+			skew.Skew().Seconds())
+	})
+)
+
+// clockSkew is a running average of the difference between the Summon API's
+// clock and the local clock.
+type clockSkew struct {
+	sync.Mutex
+	skew     time.Duration
+	measured bool
+	warned   time.Time
+	now      func() time.Time
+}
+
+// Observe measures the skew from a Date header on a response to a request
+// sent at sent and received at received.
+func (c *clockSkew) Observe(date string, sent, received time.Time) {
+	summonTime, err := http.ParseTime(date)
+	if err != nil {
+		return
+	}
+	// The Date header is truncated to the second, so add half a second.
+	measured := summonTime.Add(time.Second / 2).Sub(sent.Add(received.Sub(sent) / 2))
+
+	c.Lock()
+	defer c.Unlock()
+	if c.measured {
+		c.skew += time.Duration(ClockSkewSmoothing * float64(measured-c.skew))
+	} else {
+		c.skew, c.measured = measured, true
+	}
+	if c.exceeded() && c.now().Sub(c.warned) >= ClockSkewWarningInterval {
+		c.warned = c.now()
+		action := "Synchronize the clock with NTP, or set -correctclockskew."
+		if *correctClockSkew {
+			action = "Correcting the timestamps used to sign requests."
+		}
+		l.Logf(l.WarnMessage, "The Summon API's clock is %v ahead of the local clock, which can cause "+
+			"signature failures. %v",
+			c.skew.Round(time.Second), action)
+	}
+}
+
+// exceeded returns true if the skew is more than the maximum. The caller must hold the lock.
+func (c *clockSkew) exceeded() bool {
+	return c.measured && (c.skew > *maxClockSkew || c.skew < -*maxClockSkew)
+}
+
+// Skew returns how far the Summon API's clock is ahead of the local clock.
+func (c *clockSkew) Skew() time.Duration {
+	c.Lock()
+	defer c.Unlock()
+	return c.skew
+}
+
+// SigningTime returns the time used to sign requests. If correcting clock skew
+// is enabled, and the skew is more than the maximum, it is Summon's time.
+func (c *clockSkew) SigningTime() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	now := c.now()
+	if *correctClockSkew && c.exceeded() {
+		return now.Add(c.skew)
+	}
+	return now
+}
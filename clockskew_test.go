@@ -0,0 +1,54 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// The skew is measured from Date headers, and averaged.
+func TestClockSkewObserve(t *testing.T) {
+	now := time.Date(2016, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := &clockSkew{now: func() time.Time { return now }}
+
+	c.Observe("not a date", now, now)
+	if c.Skew() != 0 {
+		t.Errorf("Skew measured from a bad Date header, got %v", c.Skew())
+	}
+	summon := now.Add(5 * time.Minute).Format(http.TimeFormat)
+	c.Observe(summon, now, now)
+	if c.Skew() != 5*time.Minute+time.Second/2 {
+		t.Errorf("Expected a skew of 5m0.5s, got %v", c.Skew())
+	}
+	c.Observe(now.Format(http.TimeFormat), now, now)
+	if skew := c.Skew(); skew <= 4*time.Minute || skew >= 5*time.Minute {
+		t.Errorf("Skew not averaged, got %v", skew)
+	}
+}
+
+// Signing timestamps are only corrected when enabled and the skew is too large.
+func TestClockSkewSigningTime(t *testing.T) {
+	now := time.Date(2016, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := &clockSkew{now: func() time.Time { return now }}
+	c.Observe(now.Add(-5*time.Minute).Format(http.TimeFormat), now, now)
+
+	if !c.SigningTime().Equal(now) {
+		t.Error("Signing time corrected while correction was disabled.")
+	}
+	oldCorrect := *correctClockSkew
+	*correctClockSkew = true
+	defer func() { *correctClockSkew = oldCorrect }()
+	if expected := now.Add(c.Skew()); !c.SigningTime().Equal(expected) {
+		t.Errorf("Expected signing time %v, got %v", expected, c.SigningTime())
+	}
+
+	c = &clockSkew{now: func() time.Time { return now }}
+	c.Observe(now.Add(10*time.Second).Format(http.TimeFormat), now, now)
+	if !c.SigningTime().Equal(now) {
+		t.Error("Signing time corrected for a small skew.")
+	}
+}
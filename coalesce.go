@@ -0,0 +1,134 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"github.com/cu-library/lorica/metrics"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	coalesceRequests = flag.Bool("coalesce", true, "Send identical searches which arrive while the same "+
+		"search is being sent to the Summon API only once, and send its response to all of them.")
+
+	// coalescer collapses identical requests to the Summon API.
+	coalescer = newRequestCoalescer()
+
+	coalescedRequestsTotal = metrics.NewCounterVec("lorica_coalesced_requests_total",
+		"The number of requests which shared the response to an identical request, instead of "+
+			"being sent to the Summon API.", "endpoint")
+)
+
+// sharedResponse is a response from the Summon API, read in full so it can be
+// sent to every request which was waiting for it.
+type sharedResponse struct {
+	status     string
+	statusCode int
+	header     http.Header
+	body       []byte
+	err        error
+}
+
+// response returns a copy of the shared response.
+func (s *sharedResponse) response() (*http.Response, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &http.Response{
+		Status:        s.status,
+		StatusCode:    s.statusCode,
+		Header:        cloneHeader(s.header),
+		Body:          ioutil.NopCloser(bytes.NewReader(s.body)),
+		ContentLength: int64(len(s.body)),
+	}, nil
+}
+
+// coalescedCall is a request to the Summon API which others are waiting for.
+type coalescedCall struct {
+	done    chan struct{}
+	waiting int
+	result  sharedResponse
+}
+
+// requestCoalescer sends identical requests to the Summon API once.
+type requestCoalescer struct {
+	sync.Mutex
+	calls map[string]*coalescedCall
+}
+
+func newRequestCoalescer() *requestCoalescer {
+	return &requestCoalescer{calls: make(map[string]*coalescedCall)}
+}
+
+// Waiting returns the number of requests waiting for the response to the request with the key.
+func (rc *requestCoalescer) Waiting(key string) int {
+	rc.Lock()
+	defer rc.Unlock()
+	if c, ok := rc.calls[key]; ok {
+		return c.waiting
+	}
+	return 0
+}
+
+// Do sends the request to the Summon API, unless an identical request is
+// already being sent, in which case it waits for that request's response
+// instead. The second result is true if the response was shared. The request
+// is sent with its own timeout, so it isn't given up when the first client
+// goes away. A request which is waiting gives up when ctx is done.
+func (rc *requestCoalescer) Do(ctx context.Context, key string, apiRequest *http.Request, timeout time.Duration) (*http.Response, bool, error) {
+	rc.Lock()
+	if c, ok := rc.calls[key]; ok {
+		c.waiting++
+		rc.Unlock()
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			return nil, true, ctx.Err()
+		}
+		resp, err := c.result.response()
+		return resp, true, err
+	}
+	c := &coalescedCall{done: make(chan struct{})}
+	rc.calls[key] = c
+	rc.Unlock()
+
+	sendCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	resp, err := upstreamClient.Do(apiRequest.WithContext(sendCtx))
+	if err == nil {
+		c.result = sharedResponse{status: resp.Status, statusCode: resp.StatusCode, header: resp.Header}
+		c.result.body, err = readUpstreamBody(resp)
+	}
+	cancel()
+	c.result.err = err
+
+	// The requests which share the response don't get the headers which
+	// belong to this request, like its Summon session, as with the cache.
+	own := c.result
+	c.result.header = cloneHeader(c.result.header)
+	for _, name := range uncachedResponseHeaders {
+		c.result.header.Del(name)
+	}
+
+	rc.Lock()
+	delete(rc.calls, key)
+	rc.Unlock()
+	close(c.done)
+
+	resp, err = own.response()
+	return resp, false, err
+}
+
+// coalescable returns true if identical requests can share the response to
+// the request. Like cached responses, responses in a Summon session, and to
+// requests sent to another upstream, aren't shared.
+func coalescable(r *http.Request, sessionID string, overridden bool) bool {
+	return *coalesceRequests && (r.Method == "GET" || r.Method == "HEAD") && sessionID == "" && !overridden
+}
@@ -0,0 +1,142 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Requests which arrive while an identical request is being sent share its response.
+func TestRequestCoalescer(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	sent := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		sent++
+		mu.Unlock()
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("x-summon-session-id", "sent-session")
+		w.Write([]byte(`{"recordCount":3}`))
+	}))
+	defer ts.Close()
+
+	rc := newRequestCoalescer()
+	type result struct {
+		body    string
+		session string
+		shared  bool
+		err     error
+	}
+	results := make(chan result, 3)
+	do := func(ctx context.Context) {
+		req, _ := http.NewRequest("GET", ts.URL, nil)
+		resp, shared, err := rc.Do(ctx, "key", req, time.Second)
+		if err != nil {
+			results <- result{shared: shared, err: err}
+			return
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		results <- result{string(body), resp.Header.Get("x-summon-session-id"), shared, nil}
+	}
+
+	go do(context.Background())
+	waitFor(t, func() bool { mu.Lock(); defer mu.Unlock(); return sent == 1 })
+	go do(context.Background())
+	go do(context.Background())
+	waitFor(t, func() bool { return rc.Waiting("key") == 2 })
+
+	// A waiting request gives up when its client goes away.
+	ctx, cancel := context.WithCancel(context.Background())
+	go do(ctx)
+	waitFor(t, func() bool { return rc.Waiting("key") == 3 })
+	cancel()
+	if r := <-results; r.err != context.Canceled || !r.shared {
+		t.Errorf("A cancelled request got %+v", r)
+	}
+
+	close(release)
+	shared := 0
+	for i := 0; i < 3; i++ {
+		r := <-results
+		if r.err != nil || r.body != `{"recordCount":3}` {
+			t.Errorf("A coalesced request got %+v", r)
+		}
+		if r.shared {
+			shared++
+		}
+		// Only the request which was sent gets its Summon session.
+		if r.shared == (r.session != "") {
+			t.Errorf("A request which shared the response: %v, got the session %q", r.shared, r.session)
+		}
+	}
+	if shared != 2 || sent != 1 {
+		t.Errorf("%v requests were sent, and %v shared the response", sent, shared)
+	}
+	if rc.Waiting("key") != 0 || len(rc.calls) != 0 {
+		t.Error("A finished request was kept.")
+	}
+}
+
+// Identical searches sent through the proxy at once are sent to the Summon API
+// once, but searches in a Summon session aren't shared.
+func TestCoalescedSearches(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	sent := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		sent++
+		mu.Unlock()
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"recordCount":3,"documents":[]}`))
+	}))
+	defer ts.Close()
+	oldAPIURL, oldCoalescer := *apiURL, coalescer
+	*apiURL, coalescer = ts.URL, newRequestCoalescer()
+	defer func() { *apiURL, coalescer = oldAPIURL, oldCoalescer }()
+
+	codes := make(chan int, 3)
+	search := func(sessionID string) {
+		r := httptest.NewRequest("GET", "/2.0.0/search?s.q=forest", nil)
+		if sessionID != "" {
+			r.Header.Set("x-summon-session-id", sessionID)
+		}
+		w := httptest.NewRecorder()
+		proxyHandler(w, r)
+		codes <- w.Code
+	}
+	go search("")
+	waitFor(t, func() bool { mu.Lock(); defer mu.Unlock(); return sent == 1 })
+	go search("")
+	waitFor(t, func() bool {
+		coalescer.Lock()
+		defer coalescer.Unlock()
+		for _, c := range coalescer.calls {
+			return c.waiting == 1
+		}
+		return false
+	})
+	go search("abc")
+	waitFor(t, func() bool { mu.Lock(); defer mu.Unlock(); return sent == 2 })
+
+	close(release)
+	for i := 0; i < 3; i++ {
+		if code := <-codes; code != http.StatusOK {
+			t.Errorf("A search got %v", code)
+		}
+	}
+	if sent != 2 {
+		t.Errorf("%v requests were sent to the Summon API", sent)
+	}
+}
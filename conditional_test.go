@@ -0,0 +1,64 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// The same body should always have the same entity tag.
+func TestEtagFor(t *testing.T) {
+	if etagFor([]byte("{}")) != etagFor([]byte("{}")) {
+		t.Error("The same body had different entity tags.")
+	}
+	if etagFor([]byte("{}")) == etagFor([]byte("[]")) {
+		t.Error("Different bodies had the same entity tag.")
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	etag := etagFor([]byte("{}"))
+	lastModified := time.Date(2016, 1, 1, 12, 0, 0, 500, time.UTC)
+
+	tests := []struct {
+		header   string
+		value    string
+		expected bool
+	}{
+		{"If-None-Match", etag, true},
+		{"If-None-Match", "W/" + etag, true},
+		{"If-None-Match", "\"other\", " + etag, true},
+		{"If-None-Match", "*", true},
+		{"If-None-Match", "\"other\"", false},
+		{"If-Modified-Since", lastModified.Format(http.TimeFormat), true},
+		{"If-Modified-Since", lastModified.Add(time.Hour).Format(http.TimeFormat), true},
+		{"If-Modified-Since", lastModified.Add(-time.Hour).Format(http.TimeFormat), false},
+		{"If-Modified-Since", "yesterday", false},
+	}
+
+	for _, test := range tests {
+		r, err := http.NewRequest("GET", "/2.0.0/search", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set(test.header, test.value)
+		if notModified(r, etag, lastModified) != test.expected {
+			t.Errorf("notModified with %v: %v should be %v.", test.header, test.value, test.expected)
+		}
+	}
+
+	// If-None-Match takes precedence over If-Modified-Since.
+	r, err := http.NewRequest("GET", "/2.0.0/search", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("If-None-Match", "\"other\"")
+	r.Header.Set("If-Modified-Since", lastModified.Format(http.TimeFormat))
+	if notModified(r, etag, lastModified) {
+		t.Error("If-Modified-Since was used when If-None-Match was present.")
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+const (
+	// SourceDefault is the source of options which were not set.
+	SourceDefault = "default"
+
+	// SourceFlag is the source of options set on the command line.
+	SourceFlag = "flag"
+
+	// SourceEnvironment is the source of options set by an environment variable.
+	SourceEnvironment = "environment"
+
+	// SourceFile is the source of options set in the configuration file.
+	SourceFile = "file"
+
+	// MaskedValue replaces the value of secret options when the configuration is printed.
+	MaskedValue = "********"
+)
+
+var (
+	configFile = flag.String("config", "", "A configuration file, with one name = value option per line, using the "+
+		"option names above. Options which are lists can be repeated, one item per line. Lines starting with # are ignored. "+
+		"Options set by flags or environment variables take precedence over the file.")
+
+	// secretOptions are the options whose values are never printed.
+	secretOptions = map[string]bool{
+		"secretkey":          true,
+		"admintoken":         true,
+		"admintokens":        true,
+		"credentialprefixes": true,
+		"credentialversions": true,
+		"extracredentials":   true,
+		"sessionsalt":        true,
+		"challengesecret":    true,
+		"cdnpurgetoken":      true,
+		"cacheurl":           true,
+		"jwtsecret":          true,
+		"tiers":              true,
+		"alertwebhook":       true,
+		"originauthurl":      true,
+	}
+
+	// listOptions are the options which are lists delimited by the ; character.
+	// They can be repeated in a configuration file, one item per line.
+	listOptions = map[string]bool{
+		"admintokens":         true,
+		"allowedorigins":      true,
+		"allowedpaths":        true,
+		"alertrules":          true,
+		"alertemail":          true,
+		"backgroundtiers":     true,
+		"campusnetworks":      true,
+		"challengeconditions": true,
+		"credentialprefixes":  true,
+		"credentialversions":  true,
+		"facetpresets":        true,
+		"features":            true,
+		"forwardheaders":      true,
+		"proxiedheaders":      true,
+		"regionalapis":        true,
+		"reportonly":          true,
+		"schedule":            true,
+		"tiers":               true,
+	}
+
+	// configSources records where each option which is not a default was set.
+	// The precedence is flag, then environment variable, then configuration file.
+	configSources = make(map[string]string)
+
+	configSourcesMutex sync.Mutex
+)
+
+// setConfigSource records where an option was set.
+func setConfigSource(name, source string) {
+	configSourcesMutex.Lock()
+	defer configSourcesMutex.Unlock()
+	configSources[name] = source
+}
+
+// configSource returns where an option was set.
+func configSource(name string) string {
+	configSourcesMutex.Lock()
+	defer configSourcesMutex.Unlock()
+	if source, ok := configSources[name]; ok {
+		return source
+	}
+	return SourceDefault
+}
+
+// recordFlagSources marks every option set on the command line.
+func recordFlagSources() {
+	flag.Visit(func(f *flag.Flag) { setConfigSource(f.Name, SourceFlag) })
+}
+
+// overrideUnsetFlagsFromConfigFile sets the options in the configuration file
+// which were not set by a flag or an environment variable.
+func overrideUnsetFlagsFromConfigFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	values, err := parseConfig(file)
+	if err != nil {
+		return fmt.Errorf("%v: %v", path, err)
+	}
+	for name, value := range values {
+		if configSource(name) != SourceDefault {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("%v: unable to set %v to \"%v\": %v", path, name, value, err)
+		}
+		setConfigSource(name, SourceFile)
+	}
+	return nil
+}
+
+// parseConfig reads name = value lines, and checks the names are options
+// which can be set in a configuration file.
+func parseConfig(r io.Reader) (map[string]string, error) {
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("line %v should look like name = value", lineNumber)
+		}
+		name := strings.ToLower(strings.TrimSpace(parts[0]))
+		if flag.Lookup(name) == nil {
+			return nil, fmt.Errorf("line %v has an unknown option %v", lineNumber, name)
+		}
+		if name == "config" || name == "envprefix" {
+			return nil, fmt.Errorf("line %v: %v can't be set in a configuration file", lineNumber, name)
+		}
+		value := strings.TrimSpace(parts[1])
+		if previous, ok := values[name]; ok {
+			if !listOptions[name] {
+				return nil, fmt.Errorf("line %v sets %v again, but it isn't a list", lineNumber, name)
+			}
+			value = previous + ";" + value
+		}
+		values[name] = value
+	}
+	return values, scanner.Err()
+}
+
+// configValue returns the effective value of an option, masked if it is secret.
+func configValue(f *flag.Flag) string {
+	value := f.Value.String()
+	if secretOptions[f.Name] && value != "" {
+		return MaskedValue
+	}
+	return value
+}
+
+// dumpConfig writes the effective value and source of every option.
+// Secret values are masked.
+func dumpConfig(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "OPTION\tVALUE\tSOURCE")
+	flag.VisitAll(func(f *flag.Flag) {
+		fmt.Fprintf(tw, "%v\t%v\t%v\n", f.Name, configValue(f), configSource(f.Name))
+	})
+	return tw.Flush()
+}
+
+// runCommand runs a command given after the options, like config dump.
+func runCommand(args []string) error {
+	if len(args) == 2 && args[0] == "config" && args[1] == "dump" {
+		return dumpConfig(os.Stdout)
+	}
+	if len(args) > 0 && args[0] == "diff" {
+		return diffCommand(args[1:])
+	}
+	if len(args) == 1 && args[0] == "doctor" {
+		return doctorCommand()
+	}
+	return fmt.Errorf("unknown command \"%v\", the commands are \"config dump\", \"diff\", and \"doctor\"",
+		strings.Join(args, " "))
+}
@@ -0,0 +1,122 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	l "github.com/cu-library/lorica/loglevel"
+	"github.com/cu-library/lorica/metrics"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ConfigWatchInterval is how often the watched files are checked for changes.
+const ConfigWatchInterval = 5 * time.Second
+
+var (
+	watchConfig = flag.Bool("watchconfig", false, "Watch the configuration file and the TLS certificate and key "+
+		"for changes, and reload them like a SIGHUP does, for platforms where signalling the process is awkward. "+
+		"Changes which aren't valid are rolled back, and the running configuration is kept.")
+
+	configReloadsTotal = metrics.NewCounterVec("lorica_config_reloads_total",
+		"The number of times a watched file changed and was reloaded, by file and whether the reload succeeded.",
+		"file", "result")
+)
+
+// watchedFile is a group of files which are reloaded together when any of them changes.
+type watchedFile struct {
+	name   string
+	paths  []string
+	reload func() error
+	stamps map[string]string
+}
+
+// configWatcher polls files for changes. Polling, unlike file system events,
+// sees the symlink swaps Kubernetes uses to update mounted ConfigMaps and
+// Secrets, and works the same everywhere.
+type configWatcher struct {
+	sync.Mutex
+	files []*watchedFile
+}
+
+// fileStamp returns a string which changes when the file is modified.
+func fileStamp(path string) string {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "missing"
+	}
+	return fmt.Sprintf("%v %v", info.ModTime().UnixNano(), info.Size())
+}
+
+// Watch adds files which are reloaded together when any of them changes.
+func (cw *configWatcher) Watch(name string, reload func() error, paths ...string) {
+	f := &watchedFile{name: name, paths: paths, reload: reload, stamps: make(map[string]string)}
+	for _, path := range paths {
+		f.stamps[path] = fileStamp(path)
+	}
+	cw.Lock()
+	defer cw.Unlock()
+	cw.files = append(cw.files, f)
+}
+
+// Check reloads the files which changed since the last check. A file which
+// couldn't be reloaded is tried again after it changes again.
+func (cw *configWatcher) Check() error {
+	cw.Lock()
+	defer cw.Unlock()
+	var failed []string
+	for _, f := range cw.files {
+		changed := false
+		for _, path := range f.paths {
+			if stamp := fileStamp(path); stamp != f.stamps[path] {
+				f.stamps[path] = stamp
+				changed = true
+			}
+		}
+		if !changed {
+			continue
+		}
+		if err := f.reload(); err != nil {
+			configReloadsTotal.With(f.name, "failed").Inc()
+			failed = append(failed, fmt.Sprintf("%v: %v", f.name, err))
+			continue
+		}
+		configReloadsTotal.With(f.name, "succeeded").Inc()
+		l.Logf(l.InfoMessage, "Reloaded the %v from %v.", f.name, strings.Join(f.paths, " and "))
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("keeping the running configuration: %v", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// reloadConfig reloads the options which can change without a restart, the
+// features and the schedule, from the configuration file. Options set by a
+// flag or an environment variable are left alone. If any option is invalid,
+// the ones already reloaded are rolled back, so the file is applied whole or
+// not at all.
+func reloadConfig(path string, limiters []string, tiersEnabled bool) error {
+	reloadable := func(name string) bool {
+		source := configSource(name)
+		return source == SourceFile || source == SourceDefault
+	}
+	oldFeatures, oldFeaturesSource := features.List(), configSource("features")
+	if reloadable("features") {
+		if err := reloadFeatures(path); err != nil {
+			return err
+		}
+	}
+	if reloadable("schedule") {
+		if err := reloadSchedule(path, limiters, tiersEnabled); err != nil {
+			features.Set(oldFeatures)
+			setConfigSource("features", oldFeaturesSource)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// Files are reloaded once when they change, and failed reloads are reported.
+func TestConfigWatcher(t *testing.T) {
+	file, err := ioutil.TempFile("", "lorica")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("features = post\n")
+	file.Close()
+
+	reloads := 0
+	var reloadErr error
+	cw := &configWatcher{}
+	cw.Watch("configuration", func() error { reloads++; return reloadErr }, file.Name())
+
+	if err := cw.Check(); err != nil || reloads != 0 {
+		t.Errorf("An unchanged file was reloaded %v times, %v", reloads, err)
+	}
+	if err := ioutil.WriteFile(file.Name(), []byte("features = post;cache\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cw.Check(); err != nil || reloads != 1 {
+		t.Errorf("A changed file was reloaded %v times, %v", reloads, err)
+	}
+	cw.Check()
+	if reloads != 1 {
+		t.Errorf("A file was reloaded %v times after one change.", reloads)
+	}
+
+	reloadErr = errors.New("invalid")
+	os.Remove(file.Name())
+	if err := cw.Check(); err == nil || reloads != 2 {
+		t.Errorf("A failed reload returned %v after %v reloads", err, reloads)
+	}
+	if err := cw.Check(); err != nil || reloads != 2 {
+		t.Errorf("A failed reload was retried before the file changed again, %v", err)
+	}
+}
+
+// When one option in the file is invalid, the others are rolled back.
+func TestReloadConfigRollback(t *testing.T) {
+	file, err := ioutil.TempFile("", "lorica")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+
+	oldFeatureList, oldSchedule := *featureList, schedule
+	schedule = &policySchedule{now: time.Now}
+	defer func() {
+		*featureList, schedule = oldFeatureList, oldSchedule
+		features.Set(oldFeatureList)
+		delete(configSources, "features")
+		delete(configSources, "schedule")
+	}()
+	features.Set("")
+	*featureList = ""
+
+	ioutil.WriteFile(file.Name(), []byte("features = post\nschedule = overnight 00:00-07:00 rate.default=1\n"), 0644)
+	if err := reloadConfig(file.Name(), []string{LimiterDefault}, false); err != nil {
+		t.Fatal(err)
+	}
+	if !features.Enabled(FeaturePost) || len(schedule.windows) != 1 {
+		t.Errorf("The configuration wasn't reloaded, got %v and %v", features.String(), schedule.String())
+	}
+
+	ioutil.WriteFile(file.Name(), []byte("features = cache\nschedule = overnight 25:00-07:00\n"), 0644)
+	if err := reloadConfig(file.Name(), []string{LimiterDefault}, false); err == nil {
+		t.Error("An invalid schedule was accepted.")
+	}
+	if !features.Enabled(FeaturePost) || features.Enabled(FeatureCache) || features.List() != "post" {
+		t.Errorf("The features weren't rolled back, got %v", features.String())
+	}
+	if len(schedule.windows) != 1 || configSource("features") != SourceFile {
+		t.Errorf("The running configuration changed, got %v", schedule.String())
+	}
+
+	// Options set by a flag are left alone.
+	setConfigSource("features", SourceFlag)
+	ioutil.WriteFile(file.Name(), []byte("features = cache\n"), 0644)
+	if err := reloadConfig(file.Name(), []string{LimiterDefault}, false); err != nil || features.Enabled(FeatureCache) {
+		t.Errorf("Features set by a flag were reloaded, %v", err)
+	}
+}
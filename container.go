@@ -0,0 +1,151 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+const (
+	// SourceContainer is the source of options sized from the container's limits.
+	SourceContainer = "container"
+
+	// CgroupRoot is where the cgroup filesystem is mounted.
+	CgroupRoot = "/sys/fs/cgroup"
+
+	// ContainerMemoryFraction is the fraction of the container's memory used for -memorylimit.
+	ContainerMemoryFraction = 0.8
+
+	// ContainerCacheFraction is the fraction of the container's memory the cache can use, up to
+	// DefaultCacheMaxSize.
+	ContainerCacheFraction = 0.125
+
+	// ContainerConcurrencyPerCPU is the number of prefetches and batch queries allowed at once for
+	// each CPU, up to their defaults.
+	ContainerConcurrencyPerCPU = 2
+
+	// unlimitedCgroupV1Memory is the smallest memory limit cgroup v1 reports
+	// when there isn't one. It is the largest page-aligned 64-bit number.
+	unlimitedCgroupV1Memory = 1 << 62
+)
+
+var (
+	containerDefaults = flag.Bool("containerdefaults", true, "Size GOMAXPROCS, -memorylimit, -cachemaxsize, "+
+		"-prefetchmaxconcurrent, and -batchconcurrency from the CPU and memory limits of the container Lorica runs in, "+
+		"like a Kubernetes pod. Options which are set take precedence.")
+
+	// containerLimitsRead are the limits the options were sized from.
+	containerLimitsRead containerLimits
+)
+
+// containerLimits are the CPU and memory limits of a container. Zero means there isn't one.
+type containerLimits struct {
+	cpus   float64
+	memory int64
+}
+
+// readContainerLimits reads the CPU and memory limits from the cgroup
+// filesystem at root, trying cgroup v2 first and then cgroup v1.
+func readContainerLimits(root string) containerLimits {
+	var limits containerLimits
+
+	// cgroup v2: cpu.max is "quota period", or "max period" without a limit.
+	if fields := strings.Fields(readCgroupFile(root, "cpu.max")); len(fields) == 2 {
+		limits.cpus = cpuQuota(fields[0], fields[1])
+	} else {
+		limits.cpus = cpuQuota(readCgroupFile(root, "cpu", "cpu.cfs_quota_us"),
+			readCgroupFile(root, "cpu", "cpu.cfs_period_us"))
+	}
+
+	// cgroup v2: memory.max is a number of bytes, or "max" without a limit.
+	memory := readCgroupFile(root, "memory.max")
+	if memory == "" {
+		memory = readCgroupFile(root, "memory", "memory.limit_in_bytes")
+	}
+	if n, err := strconv.ParseInt(memory, 10, 64); err == nil && n > 0 && n < unlimitedCgroupV1Memory {
+		limits.memory = n
+	}
+	return limits
+}
+
+// readCgroupFile returns the trimmed contents of a file in the cgroup
+// filesystem, or an empty string if it can't be read.
+func readCgroupFile(root string, elem ...string) string {
+	contents, err := ioutil.ReadFile(filepath.Join(append([]string{root}, elem...)...))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(contents))
+}
+
+// cpuQuota returns the number of CPUs a quota and period allow, or 0 if there isn't a quota.
+func cpuQuota(quota, period string) float64 {
+	q, err := strconv.ParseFloat(quota, 64)
+	if err != nil || q <= 0 {
+		return 0
+	}
+	p, err := strconv.ParseFloat(period, 64)
+	if err != nil || p <= 0 {
+		return 0
+	}
+	return q / p
+}
+
+// applyContainerDefaults sizes the options which weren't set from the
+// container's limits, and returns a description of each change. GOMAXPROCS
+// is only changed if the environment variable isn't set.
+func applyContainerDefaults(limits containerLimits) ([]string, error) {
+	var changes []string
+	setDefault := func(name string, value int) error {
+		if configSource(name) != SourceDefault {
+			return nil
+		}
+		if err := flag.Set(name, strconv.Itoa(value)); err != nil {
+			return fmt.Errorf("unable to set %v to %v: %v", name, value, err)
+		}
+		setConfigSource(name, SourceContainer)
+		changes = append(changes, fmt.Sprintf("-%v=%v", name, value))
+		return nil
+	}
+
+	if limits.cpus > 0 {
+		cpus := int(math.Ceil(limits.cpus))
+		if os.Getenv("GOMAXPROCS") == "" && cpus < runtime.GOMAXPROCS(0) {
+			runtime.GOMAXPROCS(cpus)
+			changes = append(changes, fmt.Sprintf("GOMAXPROCS=%v", cpus))
+		}
+		concurrency := cpus * ContainerConcurrencyPerCPU
+		if concurrency < *prefetchMaxConcurrent {
+			if err := setDefault("prefetchmaxconcurrent", concurrency); err != nil {
+				return changes, err
+			}
+		}
+		if concurrency < *batchConcurrency {
+			if err := setDefault("batchconcurrency", concurrency); err != nil {
+				return changes, err
+			}
+		}
+	}
+
+	if limits.memory > 0 {
+		if err := setDefault("memorylimit", int(float64(limits.memory)*ContainerMemoryFraction)); err != nil {
+			return changes, err
+		}
+		if size := int(float64(limits.memory) * ContainerCacheFraction); size < *cacheMaxSize {
+			if err := setDefault("cachemaxsize", size); err != nil {
+				return changes, err
+			}
+		}
+	}
+	return changes, nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+// The limits are read from cgroup v2 or cgroup v1 files, and missing or unlimited ones are zero.
+func TestReadContainerLimits(t *testing.T) {
+	write := func(root string, files map[string]string) {
+		for name, contents := range files {
+			path := filepath.Join(root, name)
+			os.MkdirAll(filepath.Dir(path), 0755)
+			if err := ioutil.WriteFile(path, []byte(contents+"\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for _, c := range []struct {
+		name  string
+		files map[string]string
+		want  containerLimits
+	}{
+		{"v2", map[string]string{"cpu.max": "150000 100000", "memory.max": "536870912"},
+			containerLimits{cpus: 1.5, memory: 536870912}},
+		{"v2 unlimited", map[string]string{"cpu.max": "max 100000", "memory.max": "max"}, containerLimits{}},
+		{"v1", map[string]string{"cpu/cpu.cfs_quota_us": "50000", "cpu/cpu.cfs_period_us": "100000",
+			"memory/memory.limit_in_bytes": "268435456"}, containerLimits{cpus: 0.5, memory: 268435456}},
+		{"v1 unlimited", map[string]string{"cpu/cpu.cfs_quota_us": "-1", "cpu/cpu.cfs_period_us": "100000",
+			"memory/memory.limit_in_bytes": "9223372036854771712"}, containerLimits{}},
+		{"none", nil, containerLimits{}},
+	} {
+		root, err := ioutil.TempDir("", "lorica")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(root)
+		write(root, c.files)
+		if got := readContainerLimits(root); got != c.want {
+			t.Errorf("The %v limits were %+v", c.name, got)
+		}
+	}
+}
+
+// Options which weren't set are sized from the limits, and options which were set are kept.
+func TestApplyContainerDefaults(t *testing.T) {
+	t.Setenv("GOMAXPROCS", strconv.Itoa(runtime.GOMAXPROCS(0)))
+	oldMemoryLimit, oldCacheMaxSize := *memoryLimit, *cacheMaxSize
+	oldPrefetch, oldBatch := *prefetchMaxConcurrent, *batchConcurrency
+	defer func() {
+		*memoryLimit, *cacheMaxSize = oldMemoryLimit, oldCacheMaxSize
+		*prefetchMaxConcurrent, *batchConcurrency = oldPrefetch, oldBatch
+		for _, name := range []string{"memorylimit", "cachemaxsize", "prefetchmaxconcurrent", "batchconcurrency"} {
+			delete(configSources, name)
+		}
+	}()
+	setConfigSource("batchconcurrency", SourceFlag)
+	*batchConcurrency = 3
+
+	changes, err := applyContainerDefaults(containerLimits{cpus: 0.5, memory: 256 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *memoryLimit != 214748364 || configSource("memorylimit") != SourceContainer {
+		t.Errorf("The memory limit was %v from %v", *memoryLimit, configSource("memorylimit"))
+	}
+	if *cacheMaxSize != 32<<20 || *prefetchMaxConcurrent != 2 {
+		t.Errorf("The cache size was %v and the prefetch concurrency was %v", *cacheMaxSize, *prefetchMaxConcurrent)
+	}
+	if *batchConcurrency != 3 || configSource("batchconcurrency") != SourceFlag {
+		t.Errorf("A flag was overridden, the batch concurrency was %v", *batchConcurrency)
+	}
+	if len(changes) != 3 {
+		t.Errorf("The changes were %v", changes)
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"github.com/cu-library/lorica/metrics"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// CountPath is the path of the result count endpoint. Like the batch
+	// endpoint, it can follow a credential prefix.
+	CountPath = "/count"
+
+	// DefaultCountTTL is the default time result counts are cached.
+	DefaultCountTTL = time.Hour
+
+	// MaxCountEntries is the most queries whose result counts are cached.
+	MaxCountEntries = 10000
+)
+
+var (
+	countTTL = flag.Duration("countttl", DefaultCountTTL, "How long the result counts served by /count are "+
+		"cached. They are cached separately from search results. 0 means they aren't cached.")
+
+	// uncountedParameters are the search parameters which don't change the result count, or only add to the
+	// response, so they are left out of the search for a count.
+	uncountedParameters = []string{"s.pn", "s.ps", "s.ff", "s.hl", "s.dym", "s.light"}
+
+	// counts holds the cached result counts.
+	counts = newCountCache()
+
+	countRequestsTotal = metrics.NewCounterVec("lorica_count_requests_total",
+		"The number of requests for result counts, by whether they were cached.", "cache")
+)
+
+// resultCount is the response of the result count endpoint.
+type resultCount struct {
+	Query       string `json:"query"`
+	RecordCount int    `json:"recordCount"`
+}
+
+// countEntry is the cached result count for one query.
+type countEntry struct {
+	count   int
+	expires time.Time
+}
+
+// countCache keeps the result counts for recent queries.
+type countCache struct {
+	sync.Mutex
+	entries map[string]countEntry
+	now     func() time.Time
+}
+
+func newCountCache() *countCache {
+	return &countCache{entries: make(map[string]countEntry), now: time.Now}
+}
+
+// Get returns the cached result count for the key, if there is one.
+func (cc *countCache) Get(key string) (int, bool) {
+	cc.Lock()
+	defer cc.Unlock()
+	entry, ok := cc.entries[key]
+	if !ok || !cc.now().Before(entry.expires) {
+		return 0, false
+	}
+	return entry.count, true
+}
+
+// Add caches the result count for the key for the ttl. When the cache is
+// full, expired entries are dropped, and if none have expired, the count
+// isn't cached.
+func (cc *countCache) Add(key string, count int, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	cc.Lock()
+	defer cc.Unlock()
+	now := cc.now()
+	if len(cc.entries) >= MaxCountEntries {
+		for k, entry := range cc.entries {
+			if !now.Before(entry.expires) {
+				delete(cc.entries, k)
+			}
+		}
+	}
+	if len(cc.entries) < MaxCountEntries {
+		cc.entries[key] = countEntry{count: count, expires: now.Add(ttl)}
+	}
+}
+
+// Purge removes every cached result count, and returns how many were removed.
+func (cc *countCache) Purge() int {
+	cc.Lock()
+	defer cc.Unlock()
+	purged := len(cc.entries)
+	cc.entries = make(map[string]countEntry)
+	return purged
+}
+
+// countQuery returns the query string of the search for a result count,
+// which asks for no records. The parameters are sorted, so the same search
+// sent with its parameters in another order shares a cache entry.
+func countQuery(rawQuery string) (string, bool) {
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "", false
+	}
+	for _, name := range uncountedParameters {
+		values.Del(name)
+	}
+	if len(values) == 0 {
+		return "", false
+	}
+	values.Set("s.ps", "0")
+	return values.Encode(), true
+}
+
+// withCount is a middleware which answers requests to the result count
+// endpoint, and passes every other request to next. The search for the count
+// is handled by next, like any other search.
+func withCount(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Preflight requests are answered like they are for searches.
+		if !strings.HasSuffix(r.URL.Path, CountPath) || r.Method == "OPTIONS" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		countHandler(w, r, next)
+	})
+}
+
+// countHandler sends the number of results Summon has for the search in the
+// query parameters, without the records, so a page can show a badge like
+// "1,234 articles".
+func countHandler(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	if r.Header.Get("Origin") != "" {
+		setACAOHeader(w, r)
+		auditOriginMismatch(w, r)
+	}
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD, OPTIONS")
+		sendJSONError(w, http.StatusMethodNotAllowed, "Only GET and HEAD requests are accepted by "+CountPath+".", nil)
+		return
+	}
+	query, ok := countQuery(r.URL.RawQuery)
+	if !ok {
+		sendJSONError(w, http.StatusBadRequest, "There is no search to count.",
+			[]string{"Send Summon search parameters, like " + CountPath + "?s.q=forest&s.fvf=ContentType,Journal+Article."})
+		return
+	}
+
+	// The count depends on the profile, so the credential prefix is part of the key.
+	prefix := strings.TrimSuffix(r.URL.Path, CountPath)
+	key := prefix + "\n" + query
+	// So does whether the client is on campus.
+	if role, ok := campusRole(r); ok {
+		key += "\n" + role
+	}
+	info := getRequestInfo(r)
+	count, ok := counts.Get(key)
+	if ok {
+		info.cache = CacheHit
+	} else {
+		info.cache = CacheMiss
+		rec := &responseBuffer{header: make(http.Header)}
+		next.ServeHTTP(rec, searchRequest(r, r.Header, prefix+SearchPath, query))
+		copyHeaders(w.Header(), rec.header, []string{"x-summon-session-id"})
+		if rec.status != 0 && rec.status != http.StatusOK {
+			// Errors are passed on as they are, and aren't cached.
+			copyHeaders(w.Header(), rec.header, []string{"Content-Type", "Retry-After", "X-Content-Type-Options"})
+			w.WriteHeader(rec.status)
+			w.Write(rec.body.Bytes())
+			return
+		}
+		var summon struct {
+			RecordCount *int `json:"recordCount"`
+		}
+		if err := json.Unmarshal(rec.body.Bytes(), &summon); err != nil || summon.RecordCount == nil {
+			sendJSONError(w, http.StatusBadGateway, "The Summon API sent a response without a record count.", nil)
+			return
+		}
+		count = *summon.RecordCount
+		counts.Add(key, count, *countTTL)
+	}
+	countRequestsTotal.With(info.cache).Inc()
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if *countTTL > 0 && w.Header().Get("x-summon-session-id") == "" && len(campusNetworks) == 0 {
+		w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(countTTL.Seconds())))
+		w.Header().Add("Vary", "Origin")
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(resultCount{Query: query, RecordCount: count})
+}
@@ -0,0 +1,121 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCountCache(t *testing.T) {
+	now := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	cc := newCountCache()
+	cc.now = func() time.Time { return now }
+
+	cc.Add("a", 12, time.Minute)
+	cc.Add("b", 34, 0)
+	if n, ok := cc.Get("a"); !ok || n != 12 {
+		t.Errorf("Cached count was %v, %v", n, ok)
+	}
+	if _, ok := cc.Get("b"); ok {
+		t.Error("A count was cached with no TTL.")
+	}
+	now = now.Add(time.Minute)
+	if _, ok := cc.Get("a"); ok {
+		t.Error("An expired count was returned.")
+	}
+}
+
+// The search for a count asks for no records, and its parameters are sorted.
+func TestCountQuery(t *testing.T) {
+	for _, c := range []struct {
+		rawQuery, want string
+		ok             bool
+	}{
+		{"s.q=forest", "s.ps=0&s.q=forest", true},
+		{"s.q=forest&s.pn=3&s.ps=50&s.ff=ContentType,or,1,15", "s.ps=0&s.q=forest", true},
+		{"s.fvf=ContentType,Book&s.q=forest", "s.fvf=ContentType%2CBook&s.ps=0&s.q=forest", true},
+		{"s.ps=10", "", false},
+		{"", "", false},
+		{"s.q=%zz", "", false},
+	} {
+		got, ok := countQuery(c.rawQuery)
+		if got != c.want || ok != c.ok {
+			t.Errorf("The count query for %q was %q, %v", c.rawQuery, got, ok)
+		}
+	}
+}
+
+// Result counts are taken from a search without records, and cached.
+func TestCountHandler(t *testing.T) {
+	sent := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent++
+		if r.URL.Query().Get("s.ps") != "0" {
+			t.Errorf("The search for a count was %v", r.URL.RawQuery)
+		}
+		switch r.URL.Query().Get("s.q") {
+		case "broken":
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		case "nocount":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"recordCount":1234,"documents":[]}`))
+	}))
+	defer ts.Close()
+	oldAPIURL, oldCounts := *apiURL, counts
+	*apiURL, counts = ts.URL, newCountCache()
+	defer func() { *apiURL, counts = oldAPIURL, oldCounts }()
+
+	handler := withCount(http.HandlerFunc(proxyHandler))
+	for _, target := range []string{"/count?s.q=forest&s.ps=20", "/count?s.ps=50&s.q=forest"} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		if w.Code != http.StatusOK || w.Body.String() != "{\"query\":\"s.ps=0&s.q=forest\",\"recordCount\":1234}\n" {
+			t.Errorf("The count was %v %v", w.Code, w.Body.String())
+		}
+		if w.Header().Get("Cache-Control") != "public, max-age=3600" {
+			t.Errorf("The count had Cache-Control %q", w.Header().Get("Cache-Control"))
+		}
+	}
+	if sent != 1 {
+		t.Errorf("%v searches were sent for the same count", sent)
+	}
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/count?s.q=broken", nil))
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("A failed search got %v", w.Code)
+		}
+	}
+	if sent != 3 {
+		t.Errorf("Failed searches were cached, %v searches were sent", sent)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/count?s.q=nocount", nil))
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("A response without a count got %v", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/count", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("A request without a search got %v", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/count?s.q=forest", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("A POST request got %v", w.Code)
+	}
+}
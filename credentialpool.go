@@ -0,0 +1,148 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/cu-library/lorica/metrics"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The strategies for choosing between credentials for the same profile.
+const (
+	CredentialStrategyFirst      = "first"
+	CredentialStrategyRoundRobin = "roundrobin"
+	CredentialStrategyLeastUsed  = "leastused"
+)
+
+// CredentialProfileDefault names the profile of -accessid and -secretkey in -extracredentials.
+const CredentialProfileDefault = "default"
+
+var (
+	extraCredentials = flag.String("extracredentials", "", "A list of additional credentials for the same "+
+		"Summon profile, delimited by the ; character, used to spread requests across API keys. Each entry "+
+		"looks like default=ACCESSID:SECRETKEY or /sandbox=ACCESSID:SECRETKEY, where /sandbox is one of "+
+		"the credential prefixes. See -credentialstrategy.")
+	credentialStrategy = flag.String("credentialstrategy", CredentialStrategyFirst, "How requests are spread "+
+		"across the credentials for a profile: first only uses the first, roundrobin takes turns, and "+
+		"leastused uses the credentials which have signed the fewest requests in the current minute.")
+
+	// credentialPools are the credentials for each profile with extra credentials,
+	// by the access ID of the profile's first credentials.
+	credentialPools = make(map[string]*credentialPool)
+
+	credentialRequestsTotal = metrics.NewCounterVec("lorica_credential_requests_total",
+		"The number of requests to the Summon API signed with each access ID.", "access_id")
+)
+
+// credentialPool is the credentials for one Summon profile.
+type credentialPool struct {
+	sync.Mutex
+	sets     []credentials
+	strategy string
+	next     int
+	used     []int
+	window   time.Time
+	now      func() time.Time
+}
+
+func newCredentialPool(sets []credentials, strategy string) *credentialPool {
+	return &credentialPool{sets: sets, strategy: strategy, used: make([]int, len(sets)), now: time.Now}
+}
+
+// Pick returns the credentials which should sign the next request.
+func (cp *credentialPool) Pick() credentials {
+	cp.Lock()
+	defer cp.Unlock()
+	i := 0
+	switch cp.strategy {
+	case CredentialStrategyRoundRobin:
+		i = cp.next
+		cp.next = (cp.next + 1) % len(cp.sets)
+	case CredentialStrategyLeastUsed:
+		if window := cp.now().Truncate(time.Minute); !window.Equal(cp.window) {
+			cp.window = window
+			cp.used = make([]int, len(cp.sets))
+		}
+		for j := range cp.sets {
+			if cp.used[j] < cp.used[i] {
+				i = j
+			}
+		}
+	}
+	cp.used[i]++
+	return cp.sets[i]
+}
+
+// pickCredentials returns the credentials which should sign a request made
+// with creds, spreading requests across the profile's extra credentials,
+// and counts the request.
+func pickCredentials(creds credentials) credentials {
+	if pool, ok := credentialPools[creds.accessID]; ok {
+		creds = pool.Pick()
+	}
+	credentialRequestsTotal.With(creds.accessID).Inc()
+	return creds
+}
+
+// parseExtraCredentials parses a list like default=ID:KEY;/sandbox=ID:KEY,
+// and returns the credentials for each profile.
+func parseExtraCredentials(list string) (map[string][]credentials, error) {
+	extra := make(map[string][]credentials)
+	for i, entry := range splitList(list) {
+		parts := strings.SplitN(entry, "=", 2)
+		profile := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || profile == "" {
+			// Without an =, the entry might be the secret key, so it isn't echoed.
+			return nil, fmt.Errorf("extra credentials entry %v should look like default=ACCESSID:SECRETKEY", i+1)
+		}
+		keys := strings.SplitN(strings.TrimSpace(parts[1]), ":", 2)
+		if len(keys) != 2 || keys[0] == "" || keys[1] == "" {
+			return nil, fmt.Errorf("extra credentials for %v should have an access ID and secret key, like ACCESSID:SECRETKEY", profile)
+		}
+		extra[profile] = append(extra[profile], credentials{accessID: keys[0], secretKey: keys[1]})
+	}
+	return extra, nil
+}
+
+// setupCredentialPools checks the credential strategy, and pools the extra
+// credentials with the credentials of their profiles. It must be called
+// after the credential prefixes are parsed.
+func setupCredentialPools() error {
+	switch *credentialStrategy {
+	case CredentialStrategyFirst, CredentialStrategyRoundRobin, CredentialStrategyLeastUsed:
+	default:
+		return fmt.Errorf("unknown credential strategy %v, the strategies are %v, %v, and %v", *credentialStrategy,
+			CredentialStrategyFirst, CredentialStrategyRoundRobin, CredentialStrategyLeastUsed)
+	}
+	extra, err := parseExtraCredentials(*extraCredentials)
+	if err != nil {
+		return err
+	}
+	credentialPools = make(map[string]*credentialPool)
+	for profile, sets := range extra {
+		var first credentials
+		if profile == CredentialProfileDefault {
+			if credentialVersions.Len() > 0 {
+				return fmt.Errorf("extra credentials for %v can't be used with -credentialversions", profile)
+			}
+			first = flagCredentials()
+		} else {
+			for _, p := range credentialPrefixes {
+				if p.prefix == strings.TrimRight(profile, "/") {
+					first = p.credentials
+				}
+			}
+		}
+		if first.accessID == "" {
+			return fmt.Errorf("extra credentials for %v don't belong to a profile, use default or one of the credential prefixes", profile)
+		}
+		credentialPools[first.accessID] = newCredentialPool(append([]credentials{first}, sets...), *credentialStrategy)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCredentialPoolPick(t *testing.T) {
+	sets := []credentials{{"a", "1"}, {"b", "2"}, {"c", "3"}}
+
+	first := newCredentialPool(sets, CredentialStrategyFirst)
+	for i := 0; i < 3; i++ {
+		if first.Pick().accessID != "a" {
+			t.Error("The first strategy used other credentials.")
+		}
+	}
+
+	roundRobin := newCredentialPool(sets, CredentialStrategyRoundRobin)
+	picked := ""
+	for i := 0; i < 4; i++ {
+		picked += roundRobin.Pick().accessID
+	}
+	if picked != "abca" {
+		t.Errorf("Round robin picked %v, expected abca", picked)
+	}
+
+	leastUsed := newCredentialPool(sets, CredentialStrategyLeastUsed)
+	now := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	leastUsed.now = func() time.Time { return now }
+	picked = ""
+	for i := 0; i < 4; i++ {
+		picked += leastUsed.Pick().accessID
+	}
+	if picked != "abca" {
+		t.Errorf("Least used picked %v, expected abca", picked)
+	}
+	now = now.Add(time.Minute)
+	if leastUsed.Pick().accessID != "a" {
+		t.Error("Least used counts weren't reset for the next minute.")
+	}
+}
+
+func TestSetupCredentialPools(t *testing.T) {
+	oldAccessID, oldSecretKey, oldPrefixes := *accessID, *secretKey, credentialPrefixes
+	oldExtra, oldStrategy, oldPools := *extraCredentials, *credentialStrategy, credentialPools
+	defer func() {
+		*accessID, *secretKey, credentialPrefixes = oldAccessID, oldSecretKey, oldPrefixes
+		*extraCredentials, *credentialStrategy, credentialPools = oldExtra, oldStrategy, oldPools
+	}()
+	*accessID, *secretKey = "main", "mainkey"
+	credentialPrefixes, _ = parseCredentialPrefixes("/sandbox=sand:sandkey")
+	*extraCredentials = "default=main2:key2; default=main3:key3; /sandbox=sand2:key4"
+	*credentialStrategy = CredentialStrategyRoundRobin
+
+	if err := setupCredentialPools(); err != nil {
+		t.Fatal(err)
+	}
+	if len(credentialPools) != 2 || len(credentialPools["main"].sets) != 3 || len(credentialPools["sand"].sets) != 2 {
+		t.Fatalf("Pools were %v", credentialPools)
+	}
+	main := credentials{accessID: "main", secretKey: "mainkey"}
+	if pickCredentials(main).accessID != "main" || pickCredentials(main).accessID != "main2" {
+		t.Error("Requests weren't spread across the default profile's credentials.")
+	}
+	if pickCredentials(credentials{accessID: "other"}).accessID != "other" {
+		t.Error("Credentials without a pool were changed.")
+	}
+
+	for _, bad := range []struct{ extra, strategy string }{
+		{"/unknown=id:key", CredentialStrategyFirst},
+		{"default=id", CredentialStrategyFirst},
+		{"=id:key", CredentialStrategyFirst},
+		{"default=id:key", "random"},
+	} {
+		*extraCredentials, *credentialStrategy = bad.extra, bad.strategy
+		if err := setupCredentialPools(); err == nil {
+			t.Errorf("Extra credentials %v with strategy %v didn't return an error.", bad.extra, bad.strategy)
+		}
+	}
+	_, err := parseExtraCredentials("default=id:key; id:hunter2")
+	if err == nil || strings.Contains(err.Error(), "hunter2") || !strings.Contains(err.Error(), "entry 2 ") {
+		t.Errorf("A malformed extra credentials entry returned %v", err)
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SourceCredentialFile is the source of credentials read from -accessidfile or -secretkeyfile.
+const SourceCredentialFile = "credentialfile"
+
+var (
+	accessIDFile = flag.String("accessidfile", "", "A file with the access ID, like a Docker or Kubernetes "+
+		"secret, or a systemd credential. Surrounding whitespace is trimmed. Use it instead of -accessid.")
+	secretKeyFile = flag.String("secretkeyfile", "", "A file with the secret key, like a Docker or Kubernetes "+
+		"secret, or a systemd credential, so the key isn't in the process's arguments or environment. "+
+		"Surrounding whitespace is trimmed. Use it instead of -secretkey.")
+
+	credentialPrefixList = flag.String("credentialprefixes", "", "A list of path prefixes which use other Summon "+
+		"credentials, delimited by the ; character. Each entry looks like /sandbox=ACCESSID:SECRETKEY. "+
+		"The prefix is removed before the request is sent to Summon, so /sandbox/2.0.0/search is sent as /2.0.0/search. "+
+		"Paths without a prefix use -accessid and -secretkey.")
+
+	// credentialPrefixes are the parsed credential prefixes, longest prefix first.
+	credentialPrefixes []prefixCredentials
+
+	// fileCredentials holds the credentials reloaded from -accessidfile and -secretkeyfile.
+	fileCredentials = &reloadedCredentials{}
+)
+
+// credentials are a Summon API access ID and secret key.
+type credentials struct {
+	accessID  string
+	secretKey string
+}
+
+// prefixCredentials are the credentials used for paths starting with a prefix.
+type prefixCredentials struct {
+	prefix string
+	credentials
+}
+
+// defaultCredentials returns the credentials set by -accessid and -secretkey,
+// the active credential version, or the credentials switched to with the admin API.
+func defaultCredentials() credentials {
+	if creds := upstreams.Credentials(); creds != nil {
+		return *creds
+	}
+	if v, ok := credentialVersions.Active(); ok {
+		return v.credentials
+	}
+	return flagCredentials()
+}
+
+// flagCredentials returns the credentials set by -accessid and -secretkey,
+// or reloaded from -accessidfile and -secretkeyfile.
+func flagCredentials() credentials {
+	if creds, ok := fileCredentials.Credentials(); ok {
+		return creds
+	}
+	return credentials{accessID: *accessID, secretKey: *secretKey}
+}
+
+// reloadedCredentials holds the credentials reloaded from the credential
+// files. The flags are only set at startup, since requests read them.
+type reloadedCredentials struct {
+	sync.RWMutex
+	creds *credentials
+}
+
+// Credentials returns the reloaded credentials. ok is false if the files
+// haven't been reloaded.
+func (rc *reloadedCredentials) Credentials() (creds credentials, ok bool) {
+	rc.RLock()
+	defer rc.RUnlock()
+	if rc.creds == nil {
+		return credentials{}, false
+	}
+	return *rc.creds, true
+}
+
+// Set replaces the reloaded credentials.
+func (rc *reloadedCredentials) Set(creds credentials) {
+	rc.Lock()
+	defer rc.Unlock()
+	rc.creds = &creds
+}
+
+// readCredentialFile returns the trimmed contents of a credential file.
+func readCredentialFile(path string) (string, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	value := strings.TrimSpace(string(contents))
+	if value == "" {
+		return "", fmt.Errorf("%v is empty", path)
+	}
+	return value, nil
+}
+
+// reloadCredentialFiles reads -accessidfile and -secretkeyfile again, so a
+// key rotated on disk is used without a restart. If a file can't be read, or
+// is empty, the credentials loaded before are kept. Credentials pooled with
+// -extracredentials aren't reloaded, since the pool keeps its own copy.
+func reloadCredentialFiles() error {
+	creds := flagCredentials()
+	if _, ok := credentialPools[creds.accessID]; ok {
+		return errors.New("the credentials are pooled with -extracredentials, so they can't be reloaded without a restart")
+	}
+	for _, c := range []struct {
+		path  string
+		value *string
+	}{
+		{*accessIDFile, &creds.accessID},
+		{*secretKeyFile, &creds.secretKey},
+	} {
+		if c.path == "" {
+			continue
+		}
+		value, err := readCredentialFile(c.path)
+		if err != nil {
+			return err
+		}
+		*c.value = value
+	}
+	fileCredentials.Set(creds)
+	return nil
+}
+
+// readCredentialFiles sets -accessid and -secretkey from -accessidfile and
+// -secretkeyfile. An option can't be set both ways.
+func readCredentialFiles() error {
+	for _, c := range []struct {
+		name, path string
+		value      *string
+	}{
+		{"accessid", *accessIDFile, accessID},
+		{"secretkey", *secretKeyFile, secretKey},
+	} {
+		if c.path == "" {
+			continue
+		}
+		if source := configSource(c.name); source != SourceDefault {
+			return fmt.Errorf("-%v was set by %v, and -%vfile is set too", c.name, source, c.name)
+		}
+		value, err := readCredentialFile(c.path)
+		if err != nil {
+			return err
+		}
+		*c.value = value
+		setConfigSource(c.name, SourceCredentialFile)
+	}
+	return nil
+}
+
+// parseCredentialPrefixes parses a list like /prod=ID:KEY;/sandbox=ID:KEY.
+func parseCredentialPrefixes(list string) ([]prefixCredentials, error) {
+	var prefixes []prefixCredentials
+	seen := make(map[string]bool)
+	for i, entry := range splitList(list) {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			// Without an =, the entry might be the secret key, so it isn't echoed.
+			return nil, fmt.Errorf("credential prefix entry %v should look like /prefix=ACCESSID:SECRETKEY", i+1)
+		}
+		prefix := strings.TrimRight(strings.TrimSpace(parts[0]), "/")
+		if !strings.HasPrefix(prefix, "/") || strings.Count(prefix, "/") != 1 {
+			return nil, fmt.Errorf("credential prefix %v should be a single path segment, like /sandbox", prefix)
+		}
+		if seen[prefix] {
+			return nil, fmt.Errorf("credential prefix %v is listed more than once", prefix)
+		}
+		seen[prefix] = true
+		keys := strings.SplitN(strings.TrimSpace(parts[1]), ":", 2)
+		if len(keys) != 2 || keys[0] == "" || keys[1] == "" {
+			return nil, fmt.Errorf("credential prefix %v should have an access ID and secret key, like ACCESSID:SECRETKEY", prefix)
+		}
+		prefixes = append(prefixes, prefixCredentials{
+			prefix:      prefix,
+			credentials: credentials{accessID: keys[0], secretKey: keys[1]},
+		})
+	}
+	sort.Slice(prefixes, func(i, j int) bool { return len(prefixes[i].prefix) > len(prefixes[j].prefix) })
+	return prefixes, nil
+}
+
+// credentialsFor returns the credentials for a request path, and the path
+// with any credential prefix removed. If the path has no credential prefix,
+// but there are credential prefixes and no default credentials, ok is false.
+func credentialsFor(path string) (creds credentials, rest string, ok bool) {
+	for _, p := range credentialPrefixes {
+		if path == p.prefix || strings.HasPrefix(path, p.prefix+"/") {
+			return p.credentials, strings.TrimPrefix(path, p.prefix), true
+		}
+	}
+	creds = defaultCredentials()
+	return creds, path, creds.accessID != "" || len(credentialPrefixes) == 0
+}
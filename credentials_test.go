@@ -0,0 +1,176 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Parse a list of credential prefixes, longest first.
+func TestParseCredentialPrefixes(t *testing.T) {
+	prefixes, err := parseCredentialPrefixes("/prod=PROD:prodkey; /sandbox/=SANDBOX:sand:boxkey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prefixes) != 2 {
+		t.Fatalf("Expected 2 credential prefixes, got %v", len(prefixes))
+	}
+	if prefixes[0].prefix != "/sandbox" || prefixes[0].accessID != "SANDBOX" || prefixes[0].secretKey != "sand:boxkey" {
+		t.Errorf("Sandbox credential prefix parsed incorrectly, got %+v", prefixes[0])
+	}
+	if prefixes[1].prefix != "/prod" || prefixes[1].accessID != "PROD" || prefixes[1].secretKey != "prodkey" {
+		t.Errorf("Prod credential prefix parsed incorrectly, got %+v", prefixes[1])
+	}
+}
+
+// Bad credential prefixes are errors.
+func TestParseCredentialPrefixesErrors(t *testing.T) {
+	for _, list := range []string{"/prod", "prod=ID:KEY", "/a/b=ID:KEY", "/prod=ID", "/prod=:KEY", "/p=A:B;/p=C:D"} {
+		_, err := parseCredentialPrefixes(list)
+		if err == nil {
+			t.Errorf("No error parsing credential prefixes %#v", list)
+		}
+	}
+	_, err := parseCredentialPrefixes("/prod=ID:KEY; /sandbox ID:hunter2")
+	if err == nil || strings.Contains(err.Error(), "hunter2") || !strings.Contains(err.Error(), "entry 2 ") {
+		t.Errorf("A malformed credential prefix returned %v", err)
+	}
+}
+
+// Paths with a prefix use its credentials, and others use the defaults.
+func TestCredentialsFor(t *testing.T) {
+	oldCredentialPrefixes := credentialPrefixes
+	credentialPrefixes = []prefixCredentials{{prefix: "/sandbox", credentials: credentials{"SANDBOX", "key"}}}
+	defer func() { credentialPrefixes = oldCredentialPrefixes }()
+
+	oldAccessID := *accessID
+	*accessID = "DEFAULT"
+	defer func() { *accessID = oldAccessID }()
+
+	creds, rest, ok := credentialsFor("/sandbox/2.0.0/search")
+	if !ok || creds.accessID != "SANDBOX" || rest != "/2.0.0/search" {
+		t.Errorf("Wrong credentials for a prefixed path, got %v %v %v", creds.accessID, rest, ok)
+	}
+	creds, rest, ok = credentialsFor("/sandboxes/2.0.0/search")
+	if !ok || creds.accessID != "DEFAULT" || rest != "/sandboxes/2.0.0/search" {
+		t.Errorf("Wrong credentials for an unprefixed path, got %v %v %v", creds.accessID, rest, ok)
+	}
+
+	*accessID = ""
+	if _, _, ok = credentialsFor("/2.0.0/search"); ok {
+		t.Error("An unprefixed path was allowed without default credentials.")
+	}
+}
+
+// Mock the Summon API, and test that a prefixed path is signed with its credentials.
+func TestProxyHanderCredentialPrefix(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/2.0.0/search" {
+			t.Errorf("Summon API got the wrong path, %v", r.URL.Path)
+		}
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "Summon SANDBOX;") {
+			t.Errorf("Summon API got the wrong credentials, %v", r.Header.Get("Authorization"))
+		}
+		fmt.Fprintln(w, "")
+	}))
+	defer ts.Close()
+
+	oldAPIURL := *apiURL
+	*apiURL = ts.URL
+	defer func() { *apiURL = oldAPIURL }()
+
+	oldCredentialPrefixes := credentialPrefixes
+	credentialPrefixes = []prefixCredentials{{prefix: "/sandbox", credentials: credentials{"SANDBOX", "key"}}}
+	defer func() { credentialPrefixes = oldCredentialPrefixes }()
+
+	req, err := http.NewRequest("GET", "/sandbox/2.0.0/search?s.q=test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	proxyHandler(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %v", w.Code)
+	}
+}
+
+// Credentials are read from files with the whitespace trimmed, and can't also be set another way.
+func TestReadCredentialFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lorica")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "accessid"), []byte("FILEID\n"), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "secretkey"), []byte("  filekey \n"), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "empty"), []byte("\n"), 0600)
+
+	oldAccessID, oldSecretKey, oldAccessIDFile, oldSecretKeyFile := *accessID, *secretKey, *accessIDFile, *secretKeyFile
+	defer func() {
+		*accessID, *secretKey, *accessIDFile, *secretKeyFile = oldAccessID, oldSecretKey, oldAccessIDFile, oldSecretKeyFile
+		delete(configSources, "accessid")
+		delete(configSources, "secretkey")
+	}()
+
+	*accessIDFile, *secretKeyFile = filepath.Join(dir, "accessid"), filepath.Join(dir, "secretkey")
+	if err := readCredentialFiles(); err != nil {
+		t.Fatal(err)
+	}
+	if *accessID != "FILEID" || *secretKey != "filekey" || configSource("secretkey") != SourceCredentialFile {
+		t.Errorf("The credentials were %q and %q from %v", *accessID, *secretKey, configSource("secretkey"))
+	}
+
+	delete(configSources, "accessid")
+	setConfigSource("secretkey", SourceEnvironment)
+	if err := readCredentialFiles(); err == nil || !strings.Contains(err.Error(), "-secretkeyfile") {
+		t.Errorf("A secret key set twice returned %v", err)
+	}
+	delete(configSources, "accessid")
+	delete(configSources, "secretkey")
+	for _, path := range []string{filepath.Join(dir, "empty"), filepath.Join(dir, "missing")} {
+		*secretKeyFile = path
+		if err := readCredentialFiles(); err == nil {
+			t.Errorf("%v was accepted.", path)
+		}
+		delete(configSources, "accessid")
+	}
+}
+
+// A key rotated on disk is used without writing the flags, and a file which
+// can't be read keeps the credentials loaded before.
+func TestReloadCredentialFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lorica")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "secretkey"), []byte("newkey\n"), 0600)
+
+	oldAccessID, oldSecretKey, oldSecretKeyFile, oldFileCredentials := *accessID, *secretKey, *secretKeyFile, fileCredentials
+	defer func() {
+		*accessID, *secretKey, *secretKeyFile, fileCredentials = oldAccessID, oldSecretKey, oldSecretKeyFile, oldFileCredentials
+	}()
+	*accessID, *secretKey, *secretKeyFile = "FILEID", "oldkey", filepath.Join(dir, "secretkey")
+	fileCredentials = &reloadedCredentials{}
+
+	if err := reloadCredentialFiles(); err != nil {
+		t.Fatal(err)
+	}
+	if creds := flagCredentials(); creds.accessID != "FILEID" || creds.secretKey != "newkey" || *secretKey != "oldkey" {
+		t.Errorf("The credentials were %v, and the flag %v", creds, *secretKey)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "secretkey"), []byte("\n"), 0600)
+	if err := reloadCredentialFiles(); err == nil || flagCredentials().secretKey != "newkey" {
+		t.Errorf("An empty secret key file returned %v", err)
+	}
+}
@@ -0,0 +1,258 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	l "github.com/cu-library/lorica/loglevel"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ActiveCredentialMarker marks the credential version which signs requests.
+const ActiveCredentialMarker = "*"
+
+var (
+	credentialVersionList = flag.String("credentialversions", "", "Versions of the Summon credentials, "+
+		"delimited by the ; character, used instead of -accessid and -secretkey so they can be rotated without "+
+		"a restart. Each looks like NAME=ACCESSID:SECRETKEY, and the version which signs requests is marked "+
+		"with a *, like *2016b=ACCESSID:SECRETKEY. The active version can be switched at /admin/credentials.")
+	credentialVersionsFile = flag.String("credentialversionsfile", "", "A file of credential versions like "+
+		"-credentialversions, one per line, which is reloaded when it changes. Lines starting with # are ignored.")
+
+	// credentialVersions holds the credential versions. It is set once the flags are parsed.
+	credentialVersions = &credentialVersionSet{}
+)
+
+// credentialVersion is one named version of the default credentials.
+type credentialVersion struct {
+	name string
+	credentials
+}
+
+// credentialVersionStatus is how a credential version is shown by /admin/credentials.
+// The secret key is never shown.
+type credentialVersionStatus struct {
+	Name     string `json:"name"`
+	AccessID string `json:"access_id"`
+	Active   bool   `json:"active"`
+}
+
+// credentialVersionSet holds the versions of the default credentials, and
+// which of them signs requests. Switching versions is atomic, so every
+// request is signed with one whole version.
+type credentialVersionSet struct {
+	sync.RWMutex
+	versions []credentialVersion
+	active   int
+	marked   string
+}
+
+// Set replaces the versions, and makes the one at index active active.
+func (cv *credentialVersionSet) Set(versions []credentialVersion, active int) {
+	cv.Lock()
+	defer cv.Unlock()
+	cv.versions, cv.active, cv.marked = versions, active, ""
+	if len(versions) > 0 {
+		cv.marked = versions[active].name
+	}
+}
+
+// Reload replaces the versions, where the one at index marked is marked
+// active. If the marker hasn't moved since the last load, the active version
+// is kept while it is still listed, so a switch made at /admin/credentials
+// isn't undone by an unrelated edit to the file. Moving the marker switches
+// to the marked version.
+func (cv *credentialVersionSet) Reload(versions []credentialVersion, marked int) {
+	cv.Lock()
+	defer cv.Unlock()
+	active := marked
+	if len(cv.versions) > 0 && len(versions) > 0 && versions[marked].name == cv.marked {
+		current := cv.versions[cv.active].name
+		for i, v := range versions {
+			if v.name == current {
+				active = i
+			}
+		}
+	}
+	cv.versions, cv.active, cv.marked = versions, active, ""
+	if len(versions) > 0 {
+		cv.marked = versions[marked].name
+	}
+}
+
+// Len returns the number of versions.
+func (cv *credentialVersionSet) Len() int {
+	cv.RLock()
+	defer cv.RUnlock()
+	return len(cv.versions)
+}
+
+// Active returns the active version, or false if there are no versions.
+func (cv *credentialVersionSet) Active() (credentialVersion, bool) {
+	cv.RLock()
+	defer cv.RUnlock()
+	if len(cv.versions) == 0 {
+		return credentialVersion{}, false
+	}
+	return cv.versions[cv.active], true
+}
+
+// Activate makes the version with the name sign requests.
+func (cv *credentialVersionSet) Activate(name string) error {
+	cv.Lock()
+	defer cv.Unlock()
+	for i, v := range cv.versions {
+		if v.name == name {
+			cv.active = i
+			return nil
+		}
+	}
+	return fmt.Errorf("there is no credential version %v", name)
+}
+
+// Status returns the versions, in the order they were listed.
+func (cv *credentialVersionSet) Status() []credentialVersionStatus {
+	cv.RLock()
+	defer cv.RUnlock()
+	statuses := []credentialVersionStatus{}
+	for i, v := range cv.versions {
+		statuses = append(statuses, credentialVersionStatus{Name: v.name, AccessID: v.accessID, Active: i == cv.active})
+	}
+	return statuses
+}
+
+// parseCredentialVersions parses entries like *NAME=ACCESSID:SECRETKEY, and
+// returns the versions and the index of the one marked active. A single
+// version doesn't need to be marked.
+func parseCredentialVersions(entries []string) ([]credentialVersion, int, error) {
+	var versions []credentialVersion
+	active := -1
+	seen := make(map[string]bool)
+	for i, entry := range entries {
+		parts := strings.SplitN(entry, "=", 2)
+		name := strings.TrimSpace(parts[0])
+		marked := strings.HasPrefix(name, ActiveCredentialMarker)
+		name = strings.TrimSpace(strings.TrimPrefix(name, ActiveCredentialMarker))
+		if len(parts) != 2 || name == "" {
+			// Without an =, the entry might be the secret key, so it isn't echoed.
+			return nil, 0, fmt.Errorf("credential version %v should look like NAME=ACCESSID:SECRETKEY", i+1)
+		}
+		if seen[name] {
+			return nil, 0, fmt.Errorf("credential version %v is listed more than once", name)
+		}
+		seen[name] = true
+		keys := strings.SplitN(strings.TrimSpace(parts[1]), ":", 2)
+		if len(keys) != 2 || keys[0] == "" || keys[1] == "" {
+			return nil, 0, fmt.Errorf("credential version %v should have an access ID and secret key, like ACCESSID:SECRETKEY", name)
+		}
+		if marked {
+			if active >= 0 {
+				return nil, 0, fmt.Errorf("credential versions %v and %v are both marked active", versions[active].name, name)
+			}
+			active = len(versions)
+		}
+		versions = append(versions, credentialVersion{name: name, credentials: credentials{accessID: keys[0], secretKey: keys[1]}})
+	}
+	if len(versions) == 1 {
+		active = 0
+	}
+	if len(versions) > 0 && active < 0 {
+		return nil, 0, fmt.Errorf("none of the credential versions is marked active with %v", ActiveCredentialMarker)
+	}
+	return versions, active, nil
+}
+
+// readListFile returns the lines of a file which aren't empty or comments starting with #.
+func readListFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var entries []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			entries = append(entries, line)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// reloadCredentialVersions reloads the credential versions from
+// -credentialversions and -credentialversionsfile. The marked version is
+// made active if the marker moved, or the active version is no longer
+// listed. If any are malformed, the versions loaded before are kept.
+func reloadCredentialVersions() error {
+	entries := splitList(*credentialVersionList)
+	if *credentialVersionsFile != "" {
+		lines, err := readListFile(*credentialVersionsFile)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, lines...)
+	}
+	versions, active, err := parseCredentialVersions(entries)
+	if err != nil {
+		return err
+	}
+	if len(versions) == 0 && credentialVersions.Len() > 0 {
+		return errors.New("there are no credential versions left, keeping the ones loaded before")
+	}
+	credentialVersions.Reload(versions, active)
+	return nil
+}
+
+// credentialsHandler lists the credential versions, and switches the active
+// version to the one in the version parameter on a POST.
+func credentialsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET", "HEAD":
+	case "POST":
+		name := r.FormValue("version")
+		if name == "" {
+			sendJSONError(w, http.StatusBadRequest, "The version parameter is required.", nil)
+			return
+		}
+		if err := credentialVersions.Activate(name); err != nil {
+			sendJSONError(w, http.StatusNotFound, "Unable to switch: "+err.Error()+".", nil)
+			return
+		}
+		l.Logf(l.InfoMessage, "Signing requests with credential version %v.", name)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		sendJSONError(w, http.StatusMethodNotAllowed, "Only GET, HEAD, and POST requests accepted.", nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(credentialVersions.Status())
+}
+
+// credentialsReloadHandler reloads the credential versions, like a change to
+// -credentialversionsfile does.
+func credentialsReloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		sendJSONError(w, http.StatusMethodNotAllowed, "Only POST requests accepted.", nil)
+		return
+	}
+	if err := reloadCredentialVersions(); err != nil {
+		sendJSONError(w, http.StatusBadRequest, "Unable to reload the credential versions: "+err.Error()+".", nil)
+		return
+	}
+	if v, ok := credentialVersions.Active(); ok {
+		l.Logf(l.InfoMessage, "Reloaded the credential versions, signing requests with %v.", v.name)
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(credentialVersions.Status())
+}
@@ -0,0 +1,195 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The version marked with a * is active, and a single version doesn't need the marker.
+func TestParseCredentialVersions(t *testing.T) {
+	versions, active, err := parseCredentialVersions([]string{"2016a=OLD:oldkey", "*2016b=NEW:new:key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || active != 1 {
+		t.Fatalf("Parsed %+v, active %v", versions, active)
+	}
+	if versions[1].name != "2016b" || versions[1].accessID != "NEW" || versions[1].secretKey != "new:key" {
+		t.Errorf("The active version was parsed as %+v", versions[1])
+	}
+
+	versions, active, err = parseCredentialVersions([]string{"only=ID:KEY"})
+	if err != nil || len(versions) != 1 || active != 0 {
+		t.Errorf("A single version was parsed as %+v, active %v, %v", versions, active, err)
+	}
+
+	for _, entries := range [][]string{
+		{"a=ID:KEY", "b=ID:KEY"},
+		{"*a=ID:KEY", "*b=ID:KEY"},
+		{"*a=ID:KEY", "a=ID:KEY"},
+		{"*a=ID"},
+		{"*=ID:KEY"},
+		{"*a"},
+	} {
+		if _, _, err := parseCredentialVersions(entries); err == nil {
+			t.Errorf("No error parsing credential versions %v", entries)
+		}
+	}
+	_, _, err = parseCredentialVersions([]string{"a=ID:KEY", "ID:hunter2"})
+	if err == nil || strings.Contains(err.Error(), "hunter2") || !strings.Contains(err.Error(), "version 2 ") {
+		t.Errorf("A malformed credential version returned %v", err)
+	}
+}
+
+// The active version is the default credentials, and switching is seen by the next request.
+func TestCredentialVersionsDefault(t *testing.T) {
+	oldVersions := credentialVersions
+	defer func() { credentialVersions = oldVersions }()
+	credentialVersions = &credentialVersionSet{}
+
+	oldAccessID := *accessID
+	*accessID = "FLAG"
+	defer func() { *accessID = oldAccessID }()
+
+	if creds := defaultCredentials(); creds.accessID != "FLAG" {
+		t.Errorf("Without versions, the default access ID was %v", creds.accessID)
+	}
+	credentialVersions.Set([]credentialVersion{
+		{"a", credentials{"OLD", "oldkey"}},
+		{"b", credentials{"NEW", "newkey"}},
+	}, 0)
+	if creds := defaultCredentials(); creds.accessID != "OLD" || creds.secretKey != "oldkey" {
+		t.Errorf("The default credentials were %+v", creds)
+	}
+	if err := credentialVersions.Activate("b"); err != nil {
+		t.Fatal(err)
+	}
+	if creds := defaultCredentials(); creds.accessID != "NEW" || creds.secretKey != "newkey" {
+		t.Errorf("After switching, the default credentials were %+v", creds)
+	}
+	if err := credentialVersions.Activate("c"); err == nil {
+		t.Error("No error switching to a version which doesn't exist.")
+	}
+}
+
+// The versions file is reloaded, and malformed versions are ignored.
+func TestReloadCredentialVersions(t *testing.T) {
+	oldVersions := credentialVersions
+	defer func() { credentialVersions = oldVersions }()
+	credentialVersions = &credentialVersionSet{}
+
+	dir, err := ioutil.TempDir("", "lorica")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "credentials")
+	if err := ioutil.WriteFile(path, []byte("# Rotated 2016-10-01\na=OLD:oldkey\n*b=NEW:newkey\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	oldFile := *credentialVersionsFile
+	*credentialVersionsFile = path
+	defer func() { *credentialVersionsFile = oldFile }()
+
+	if err := reloadCredentialVersions(); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := credentialVersions.Active(); !ok || v.name != "b" {
+		t.Errorf("The active version was %+v", v)
+	}
+
+	for _, contents := range []string{"*a=OLD:oldkey\n*b=NEW:newkey\n", "# Nothing left\n"} {
+		if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := reloadCredentialVersions(); err == nil {
+			t.Errorf("No error reloading %q", contents)
+		}
+		if v, _ := credentialVersions.Active(); v.name != "b" || credentialVersions.Len() != 2 {
+			t.Errorf("After reloading %q, the active version was %+v", contents, v)
+		}
+	}
+
+	// A switch made with the admin API is kept until the marker moves, or
+	// the version is removed.
+	for _, test := range []struct {
+		switchTo string
+		contents string
+		active   string
+	}{
+		{"a", "a=OLD:oldkey\n*b=NEW:newkey\nc=NEWER:newerkey\n", "a"},
+		{"", "a=OLD:oldkey\nb=NEW:newkey\n*c=NEWER:newerkey\n", "c"},
+		{"a", "a=OLD:oldkey\nb=NEW:newkey\n*c=NEWER:newerkey\n", "a"},
+		{"", "b=NEW:newkey\n*c=NEWER:newerkey\n", "c"},
+	} {
+		if test.switchTo != "" {
+			if err := credentialVersions.Activate(test.switchTo); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := ioutil.WriteFile(path, []byte(test.contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := reloadCredentialVersions(); err != nil {
+			t.Fatal(err)
+		}
+		if v, _ := credentialVersions.Active(); v.name != test.active {
+			t.Errorf("After reloading %q, the active version was %+v, expected %v", test.contents, v, test.active)
+		}
+	}
+}
+
+func TestCredentialsHandler(t *testing.T) {
+	oldVersions := credentialVersions
+	defer func() { credentialVersions = oldVersions }()
+	credentialVersions = &credentialVersionSet{}
+	credentialVersions.Set([]credentialVersion{
+		{"a", credentials{"OLD", "oldkey"}},
+		{"b", credentials{"NEW", "newkey"}},
+	}, 0)
+
+	w := httptest.NewRecorder()
+	credentialsHandler(w, httptest.NewRequest("GET", "/admin/credentials", nil))
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "key") {
+		t.Fatalf("Listing the versions got %v %v", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	credentialsHandler(w, httptest.NewRequest("POST", "/admin/credentials?version=b", nil))
+	var statuses []credentialVersionStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &statuses); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("Switching got %v %v", w.Code, w.Body.String())
+	}
+	if len(statuses) != 2 || statuses[0].Active || !statuses[1].Active || statuses[1].AccessID != "NEW" {
+		t.Errorf("After switching, the versions were %+v", statuses)
+	}
+
+	tests := []struct {
+		method, query string
+		status        int
+	}{
+		{"POST", "", http.StatusBadRequest},
+		{"POST", "?version=c", http.StatusNotFound},
+		{"DELETE", "", http.StatusMethodNotAllowed},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		credentialsHandler(w, httptest.NewRequest(test.method, "/admin/credentials"+test.query, nil))
+		if w.Code != test.status {
+			t.Errorf("%v %v got %v, expected %v", test.method, test.query, w.Code, test.status)
+		}
+	}
+	if v, _ := credentialVersions.Active(); v.name != "b" {
+		t.Errorf("Bad requests changed the active version to %v", v.name)
+	}
+}
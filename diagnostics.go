@@ -0,0 +1,108 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	l "github.com/cu-library/lorica/loglevel"
+	"github.com/cu-library/lorica/metrics"
+	"io"
+	"log"
+	"os"
+	"runtime/pprof"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	diagnosticsFile = flag.String("diagnosticsfile", "", "The file diagnostic dumps are appended to when Lorica "+
+		"receives a SIGUSR1. If not set, they are written to the log.")
+
+	// clientTiers are the client tiers in use, if any.
+	clientTiers []*tier
+)
+
+// writeDiagnostics writes a snapshot of the configuration, rate limiter
+// state, metrics, and goroutine stacks.
+func writeDiagnostics(w io.Writer, now time.Time) {
+	fmt.Fprintf(w, "Lorica diagnostic dump at %v\n", now.Format(time.RFC3339))
+
+	fmt.Fprint(w, "\n== Configuration\n")
+	if err := dumpConfig(w); err != nil {
+		fmt.Fprintf(w, "Unable to dump configuration: %v\n", err)
+	}
+
+	fmt.Fprint(w, "\n== Rate limiting\n")
+	switch {
+	case len(clientTiers) > 0:
+		for _, t := range clientTiers {
+			fmt.Fprintf(w, "Tier %v: %v request(s) per second", t.name, t.rate)
+			if t.usage != nil {
+				fmt.Fprintf(w, ", quota %v per %v, %v client(s) counted", t.quota, t.quotaPeriod, t.usage.Len())
+			}
+			fmt.Fprintln(w)
+		}
+	case *rateLimit:
+		fmt.Fprintf(w, "%v request(s) per second per client\n", *maxRequests)
+	default:
+		fmt.Fprintln(w, "Disabled")
+	}
+	if window := schedule.Active(); window != nil {
+		fmt.Fprintf(w, "Schedule window %v is active\n", window.name)
+	}
+	for _, s := range allLimiterStats() {
+		tracked, limited := s.Counts()
+		fmt.Fprintf(w, "Limiter %v: %v client(s) tracked, %v limited in the last minute\n", s.name, tracked, limited)
+		for _, c := range s.Top(DefaultTopLimitedClients) {
+			fmt.Fprintf(w, "  %v: %v allowed, %v rejected, %.2f tokens\n", c.IP, c.Allowed, c.Rejected, c.Tokens)
+		}
+	}
+	waiting := throttles.Waiting()
+	keys := make([]string, 0, len(waiting))
+	for key := range waiting {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "Backing off from the Summon API with %v for %v\n", key, waiting[key])
+	}
+	blocked := abuse.Blocked()
+	ips := make([]string, len(blocked))
+	for i, b := range blocked {
+		ips[i] = b.IP + " (" + b.Reason + ")"
+	}
+	fmt.Fprintf(w, "%v blocked client(s): %v\n", len(blocked), strings.Join(ips, ", "))
+	fmt.Fprintf(w, "%v response(s) held in the tarpit\n", len(tarpitSlots))
+
+	fmt.Fprint(w, "\n== Metrics\n")
+	metrics.Default.Write(w)
+
+	fmt.Fprint(w, "\n== Goroutines\n")
+	pprof.Lookup("goroutine").WriteTo(w, 2)
+}
+
+// dumpDiagnostics writes a diagnostic dump to the diagnostics file, or the log.
+func dumpDiagnostics() {
+	if *diagnosticsFile == "" {
+		var b strings.Builder
+		writeDiagnostics(&b, time.Now())
+		log.Print(b.String())
+		return
+	}
+	f, err := os.OpenFile(*diagnosticsFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		l.Logf(l.ErrorMessage, "Unable to open diagnostics file: %v", err)
+		return
+	}
+	writeDiagnostics(f, time.Now())
+	fmt.Fprintln(f)
+	if err := f.Close(); err != nil {
+		l.Logf(l.ErrorMessage, "Unable to write diagnostics file: %v", err)
+		return
+	}
+	l.Log(l.InfoMessage, "Wrote diagnostic dump to "+*diagnosticsFile)
+}
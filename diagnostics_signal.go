@@ -0,0 +1,23 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// dumpDiagnosticsOnSignal writes a diagnostic dump each time Lorica receives a SIGUSR1.
+func dumpDiagnosticsOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	for range signals {
+		dumpDiagnostics()
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The dump has each section, and doesn't include secrets.
+func TestWriteDiagnostics(t *testing.T) {
+	oldSecretKey := *secretKey
+	*secretKey = "verysecret"
+	oldTiers := clientTiers
+	clientTiers = []*tier{{name: "staff", rate: 10, quota: 100, quotaPeriod: time.Hour, usage: newQuotaUsage()}}
+	defer func() {
+		*secretKey = oldSecretKey
+		clientTiers = oldTiers
+	}()
+
+	var b bytes.Buffer
+	writeDiagnostics(&b, time.Now())
+	dump := b.String()
+	for _, expected := range []string{"== Configuration", "Tier staff: 10 request(s) per second, quota 100 per 1h0m0s",
+		"== Metrics", "lorica_requests_total", "== Goroutines", "TestWriteDiagnostics"} {
+		if !strings.Contains(dump, expected) {
+			t.Errorf("Dump is missing %#v", expected)
+		}
+	}
+	if strings.Contains(dump, "verysecret") {
+		t.Error("Dump includes the secret key.")
+	}
+}
+
+// Dumps are appended to the diagnostics file.
+func TestDumpDiagnosticsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lorica")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	oldFile := *diagnosticsFile
+	*diagnosticsFile = filepath.Join(dir, "dump.txt")
+	defer func() { *diagnosticsFile = oldFile }()
+
+	dumpDiagnostics()
+	dumpDiagnostics()
+	contents, err := ioutil.ReadFile(*diagnosticsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(contents), "Lorica diagnostic dump at") != 2 {
+		t.Error("Dumps not appended to the diagnostics file.")
+	}
+}
@@ -0,0 +1,8 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+// dumpDiagnosticsOnSignal does nothing, since Windows doesn't have SIGUSR1.
+func dumpDiagnosticsOnSignal() {}
@@ -0,0 +1,171 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"github.com/cu-library/lorica/metrics"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DidYouMeanPath is the path of the spelling suggestion endpoint. Like the
+	// batch endpoint, it can follow a credential prefix.
+	DidYouMeanPath = "/didyoumean"
+
+	// MaxDidYouMeanEntries is the most queries whose spelling suggestions are cached.
+	MaxDidYouMeanEntries = 10000
+)
+
+var (
+	didYouMeanTTL = flag.Duration("didyoumeanttl", time.Hour, "How long the spelling suggestions served by "+
+		"/didyoumean are cached. They are cached separately from search results. 0 means they aren't cached.")
+
+	// suggestions holds the cached spelling suggestions.
+	suggestions = newSuggestionCache()
+
+	didYouMeanRequestsTotal = metrics.NewCounterVec("lorica_didyoumean_requests_total",
+		"The number of requests for spelling suggestions, by whether they were cached.", "cache")
+)
+
+// didYouMean is the response of the spelling suggestion endpoint.
+type didYouMean struct {
+	Query       string   `json:"query"`
+	Suggestions []string `json:"suggestions"`
+}
+
+// summonSuggestions is the part of a Summon search response with the spelling suggestions.
+type summonSuggestions struct {
+	DidYouMeanSuggestions []struct {
+		SuggestedQuery string `json:"suggestedQuery"`
+	} `json:"didYouMeanSuggestions"`
+}
+
+// suggestionEntry is the cached spelling suggestions for one query.
+type suggestionEntry struct {
+	suggestions []string
+	expires     time.Time
+}
+
+// suggestionCache keeps the spelling suggestions for recent queries.
+type suggestionCache struct {
+	sync.Mutex
+	entries map[string]suggestionEntry
+	now     func() time.Time
+}
+
+func newSuggestionCache() *suggestionCache {
+	return &suggestionCache{entries: make(map[string]suggestionEntry), now: time.Now}
+}
+
+// Get returns the cached spelling suggestions for the key, if there are any.
+func (sc *suggestionCache) Get(key string) ([]string, bool) {
+	sc.Lock()
+	defer sc.Unlock()
+	entry, ok := sc.entries[key]
+	if !ok || !sc.now().Before(entry.expires) {
+		return nil, false
+	}
+	return entry.suggestions, true
+}
+
+// Add caches the spelling suggestions for the key for the ttl. When the
+// cache is full, expired entries are dropped, and if none have expired,
+// the suggestions aren't cached.
+func (sc *suggestionCache) Add(key string, suggestions []string, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	sc.Lock()
+	defer sc.Unlock()
+	now := sc.now()
+	if len(sc.entries) >= MaxDidYouMeanEntries {
+		for k, entry := range sc.entries {
+			if !now.Before(entry.expires) {
+				delete(sc.entries, k)
+			}
+		}
+	}
+	if len(sc.entries) < MaxDidYouMeanEntries {
+		sc.entries[key] = suggestionEntry{suggestions: suggestions, expires: now.Add(ttl)}
+	}
+}
+
+// withDidYouMean is a middleware which answers requests to the spelling
+// suggestion endpoint, and passes every other request to next. The search
+// for the suggestions is handled by next, like any other search.
+func withDidYouMean(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Preflight requests are answered like they are for searches.
+		if !strings.HasSuffix(r.URL.Path, DidYouMeanPath) || r.Method == "OPTIONS" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		didYouMeanHandler(w, r, next)
+	})
+}
+
+// didYouMeanHandler sends the spelling suggestions Summon has for the q
+// parameter, so a search box can offer them while the user types.
+func didYouMeanHandler(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	if r.Header.Get("Origin") != "" {
+		setACAOHeader(w, r)
+		auditOriginMismatch(w, r)
+	}
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET, OPTIONS")
+		sendJSONError(w, http.StatusMethodNotAllowed, "Only GET requests are accepted by "+DidYouMeanPath+".", nil)
+		return
+	}
+	q := strings.TrimSpace(r.FormValue("q"))
+	if q == "" {
+		sendJSONError(w, http.StatusBadRequest, "The q parameter is missing.",
+			[]string{"Send the search terms in the q parameter, like " + DidYouMeanPath + "?q=forrest."})
+		return
+	}
+
+	// The suggestions depend on the profile, so the credential prefix is part of the key.
+	prefix := strings.TrimSuffix(r.URL.Path, DidYouMeanPath)
+	key := prefix + "\n" + q
+	info := getRequestInfo(r)
+	result, ok := suggestions.Get(key)
+	if ok {
+		info.cache = CacheHit
+	} else {
+		info.cache = CacheMiss
+		query := url.Values{"s.q": {q}, "s.dym": {"true"}, "s.ps": {"0"}}.Encode()
+		rec := &responseBuffer{header: make(http.Header)}
+		next.ServeHTTP(rec, searchRequest(r, r.Header, prefix+SearchPath, query))
+		copyHeaders(w.Header(), rec.header, []string{"x-summon-session-id"})
+		if rec.status != 0 && rec.status != http.StatusOK {
+			// Errors are passed on as they are, and aren't cached.
+			copyHeaders(w.Header(), rec.header, []string{"Content-Type", "Retry-After", "X-Content-Type-Options"})
+			w.WriteHeader(rec.status)
+			w.Write(rec.body.Bytes())
+			return
+		}
+		var summon summonSuggestions
+		if err := json.Unmarshal(rec.body.Bytes(), &summon); err != nil {
+			sendJSONError(w, http.StatusBadGateway, "The Summon API sent a response which isn't JSON.", nil)
+			return
+		}
+		result = []string{}
+		for _, s := range summon.DidYouMeanSuggestions {
+			if s.SuggestedQuery != "" {
+				result = append(result, s.SuggestedQuery)
+			}
+		}
+		suggestions.Add(key, result, *didYouMeanTTL)
+	}
+	didYouMeanRequestsTotal.With(info.cache).Inc()
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(didYouMean{Query: q, Suggestions: result})
+}
@@ -0,0 +1,81 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSuggestionCache(t *testing.T) {
+	now := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	sc := newSuggestionCache()
+	sc.now = func() time.Time { return now }
+
+	sc.Add("a", []string{"b"}, time.Minute)
+	sc.Add("c", []string{"d"}, 0)
+	if s, ok := sc.Get("a"); !ok || s[0] != "b" {
+		t.Errorf("Cached suggestions were %v, %v", s, ok)
+	}
+	if _, ok := sc.Get("c"); ok {
+		t.Error("Suggestions were cached with no TTL.")
+	}
+	now = now.Add(time.Minute)
+	if _, ok := sc.Get("a"); ok {
+		t.Error("Expired suggestions were returned.")
+	}
+}
+
+// Spelling suggestions are taken from a search, and cached.
+func TestDidYouMeanHandler(t *testing.T) {
+	sent := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent++
+		if r.URL.Query().Get("s.dym") != "true" || r.URL.Query().Get("s.ps") != "0" {
+			t.Errorf("The search for suggestions was %v", r.URL.RawQuery)
+		}
+		if r.URL.Query().Get("s.q") == "broken" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"recordCount":0,"didYouMeanSuggestions":[{"originalQuery":"forrest","suggestedQuery":"forest"}]}`))
+	}))
+	defer ts.Close()
+	oldAPIURL, oldSuggestions := *apiURL, suggestions
+	*apiURL, suggestions = ts.URL, newSuggestionCache()
+	defer func() { *apiURL, suggestions = oldAPIURL, oldSuggestions }()
+
+	handler := withDidYouMean(http.HandlerFunc(proxyHandler))
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/didyoumean?q=forrest", nil))
+		if w.Code != http.StatusOK || w.Body.String() != "{\"query\":\"forrest\",\"suggestions\":[\"forest\"]}\n" {
+			t.Errorf("Suggestions were %v %v", w.Code, w.Body.String())
+		}
+	}
+	if sent != 1 {
+		t.Errorf("%v searches were sent for the same suggestions", sent)
+	}
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/didyoumean?q=broken", nil))
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("A failed search got %v", w.Code)
+		}
+	}
+	if sent != 3 {
+		t.Errorf("Failed searches were cached, %v searches were sent", sent)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/didyoumean", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("A request without q got %v", w.Code)
+	}
+}
@@ -0,0 +1,253 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// DiffDefaultBackend is the backend which uses the default credentials.
+	DiffDefaultBackend = "default"
+
+	// MaxDiffsPerQuery is the most differences reported for each query.
+	MaxDiffsPerQuery = 50
+)
+
+// diffIgnoredFields change between any two responses, so they aren't compared.
+var diffIgnoredFields = map[string]bool{
+	"elapsedQueryTime": true,
+	"totalRequestTime": true,
+	"sessionId":        true,
+}
+
+// diffBackend is a Summon API URL and the credentials used to sign requests to it.
+type diffBackend struct {
+	name  string
+	url   string
+	creds credentials
+}
+
+// parseDiffBackend parses a backend like /sandbox or default@https://example.com.
+// The name is a credential prefix, or default for -accessid and -secretkey.
+// The URL is -summonapi if it isn't given.
+func parseDiffBackend(spec string) (diffBackend, error) {
+	b := diffBackend{name: spec, url: *apiURL}
+	if i := strings.Index(spec, "@"); i >= 0 {
+		b.name, b.url = spec[:i], spec[i+1:]
+		parsed, err := url.Parse(b.url)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return b, fmt.Errorf("%v isn't a http or https URL", b.url)
+		}
+	}
+	if b.name == DiffDefaultBackend {
+		b.creds = defaultCredentials()
+		return b, nil
+	}
+	for _, p := range credentialPrefixes {
+		if p.prefix == b.name {
+			b.creds = p.credentials
+			return b, nil
+		}
+	}
+	return b, fmt.Errorf("%v isn't %v or one of the credential prefixes", b.name, DiffDefaultBackend)
+}
+
+// fetch sends the query to the backend and decodes the JSON response.
+func (b diffBackend) fetch(client *http.Client, query string) (interface{}, error) {
+	apiRequestURL, err := url.Parse(b.url)
+	if err != nil {
+		return nil, err
+	}
+	pathAndQuery, err := url.Parse(query)
+	if err != nil {
+		return nil, err
+	}
+	apiRequestURL.Path = pathAndQuery.Path
+	apiRequestURL.RawQuery = pathAndQuery.RawQuery
+
+	apiRequest, err := http.NewRequest("GET", apiRequestURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	accept := "application/json"
+	timestampRFC2616 := time.Now().UTC().Format(http.TimeFormat)
+	apiRequest.Header.Add("Accept", accept)
+	apiRequest.Header.Add("x-summon-date", timestampRFC2616)
+	apiRequest.Header.Add("Authorization", buildHeaderWithCredentials(b.creds, apiRequestURL, accept, timestampRFC2616))
+
+	resp, err := client.Do(apiRequest)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%v responded with %v", b.name, resp.Status)
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		return nil, fmt.Errorf("%v responded with invalid JSON: %v", b.name, err)
+	}
+	return decoded, nil
+}
+
+// diffJSON appends the differences between two decoded JSON values to diffs.
+// Each difference is the path to the field and the two values.
+func diffJSON(path string, a, b interface{}, diffs []string) []string {
+	switch a := a.(type) {
+	case map[string]interface{}:
+		if b, ok := b.(map[string]interface{}); ok {
+			keys := make(map[string]bool)
+			for k := range a {
+				keys[k] = true
+			}
+			for k := range b {
+				keys[k] = true
+			}
+			sorted := make([]string, 0, len(keys))
+			for k := range keys {
+				if !diffIgnoredFields[k] {
+					sorted = append(sorted, k)
+				}
+			}
+			sort.Strings(sorted)
+			for _, k := range sorted {
+				field := k
+				if path != "" {
+					field = path + "." + k
+				}
+				diffs = diffJSON(field, a[k], b[k], diffs)
+			}
+			return diffs
+		}
+	case []interface{}:
+		if b, ok := b.([]interface{}); ok {
+			for i := 0; i < len(a) || i < len(b); i++ {
+				var ai, bi interface{}
+				if i < len(a) {
+					ai = a[i]
+				}
+				if i < len(b) {
+					bi = b[i]
+				}
+				diffs = diffJSON(fmt.Sprintf("%v[%v]", path, i), ai, bi, diffs)
+			}
+			return diffs
+		}
+	}
+	if !reflect.DeepEqual(a, b) {
+		diffs = append(diffs, fmt.Sprintf("%v: %v != %v", path, diffValue(a), diffValue(b)))
+	}
+	return diffs
+}
+
+// diffValue formats a decoded JSON value for a difference.
+func diffValue(v interface{}) string {
+	if v == nil {
+		return "(missing)"
+	}
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(encoded)
+}
+
+// readDiffQueries reads one request path and query per line, skipping blank lines and comments.
+func readDiffQueries(r io.Reader) ([]string, error) {
+	var queries []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		queries = append(queries, line)
+	}
+	return queries, scanner.Err()
+}
+
+// runDiff sends each query to both backends and writes the differences in their responses.
+// It returns the number of queries whose responses differed or failed.
+func runDiff(w io.Writer, client *http.Client, a, b diffBackend, queries []string) int {
+	differing := 0
+	for _, query := range queries {
+		fmt.Fprintf(w, "== %v\n", query)
+		aResp, err := a.fetch(client, query)
+		if err == nil {
+			var bResp interface{}
+			bResp, err = b.fetch(client, query)
+			if err == nil {
+				diffs := diffJSON("", aResp, bResp, nil)
+				if len(diffs) == 0 {
+					fmt.Fprintln(w, "   same")
+					continue
+				}
+				differing++
+				for i, d := range diffs {
+					if i == MaxDiffsPerQuery {
+						fmt.Fprintf(w, "   ... and %v more\n", len(diffs)-MaxDiffsPerQuery)
+						break
+					}
+					fmt.Fprintf(w, "   %v\n", d)
+				}
+				continue
+			}
+		}
+		differing++
+		fmt.Fprintf(w, "   error: %v\n", err)
+	}
+	fmt.Fprintf(w, "%v of %v queries differ between %v and %v.\n", differing, len(queries), a.name, b.name)
+	return differing
+}
+
+// diffCommand runs lorica diff BACKEND BACKEND QUERYFILE.
+func diffCommand(args []string) error {
+	if len(args) != 3 {
+		return fmt.Errorf("usage: lorica diff BACKEND BACKEND QUERYFILE, where BACKEND is %v or a "+
+			"credential prefix, optionally followed by @URL", DiffDefaultBackend)
+	}
+	var err error
+	credentialPrefixes, err = parseCredentialPrefixes(*credentialPrefixList)
+	if err != nil {
+		return err
+	}
+	a, err := parseDiffBackend(args[0])
+	if err != nil {
+		return err
+	}
+	b, err := parseDiffBackend(args[1])
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(args[2])
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	queries, err := readDiffQueries(file)
+	if err != nil {
+		return err
+	}
+	if runDiff(os.Stdout, &http.Client{Timeout: *timeout}, a, b, queries) > 0 {
+		return fmt.Errorf("responses differ")
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Differences are reported by field path, and ignored fields aren't compared.
+func TestDiffJSON(t *testing.T) {
+	var a, b interface{}
+	json.Unmarshal([]byte(`{"recordCount": 10, "elapsedQueryTime": 5, "documents": [{"Title": ["A"]}]}`), &a)
+	json.Unmarshal([]byte(`{"recordCount": 12, "elapsedQueryTime": 9, "documents": [{"Title": ["A"]}, {"Title": ["B"]}]}`), &b)
+	diffs := diffJSON("", a, b, nil)
+	expected := []string{`documents[1]: (missing) != {"Title":["B"]}`, "recordCount: 10 != 12"}
+	if strings.Join(diffs, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected differences %v, got %v", expected, diffs)
+	}
+}
+
+// A backend is default or a credential prefix, optionally with a URL.
+func TestParseDiffBackend(t *testing.T) {
+	oldPrefixes := credentialPrefixes
+	credentialPrefixes = []prefixCredentials{{"/sandbox", credentials{"sandboxid", "sandboxkey"}}}
+	defer func() { credentialPrefixes = oldPrefixes }()
+
+	b, err := parseDiffBackend("/sandbox@https://staging.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.url != "https://staging.example.com" || b.creds.accessID != "sandboxid" {
+		t.Errorf("Backend parsed incorrectly, got %#v", b)
+	}
+	b, err = parseDiffBackend(DiffDefaultBackend)
+	if err != nil || b.url != *apiURL {
+		t.Errorf("Default backend parsed incorrectly, got %#v, %v", b, err)
+	}
+	for _, spec := range []string{"/unknown", "default@notaurl"} {
+		if _, err := parseDiffBackend(spec); err == nil {
+			t.Errorf("No error parsing backend %v", spec)
+		}
+	}
+}
+
+// Each query is sent to both backends, and queries which differ are counted.
+func TestRunDiff(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.SplitN(strings.TrimPrefix(r.Header.Get("Authorization"), "Summon "), ";", 2)[0]
+		count := 10
+		if id == "b" && r.URL.Query().Get("s.q") == "changed" {
+			count = 11
+		}
+		fmt.Fprintf(w, `{"recordCount": %v}`, count)
+	}))
+	defer ts.Close()
+
+	a := diffBackend{name: "a", url: ts.URL, creds: credentials{accessID: "a"}}
+	b := diffBackend{name: "b", url: ts.URL, creds: credentials{accessID: "b"}}
+	queries, err := readDiffQueries(strings.NewReader("# Queries\n/2.0.0/search?s.q=same\n\n/2.0.0/search?s.q=changed\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if differing := runDiff(&out, ts.Client(), a, b, queries); differing != 1 {
+		t.Errorf("Expected 1 query to differ, got %v:\n%v", differing, out.String())
+	}
+	if !strings.Contains(out.String(), "recordCount: 10 != 11") {
+		t.Errorf("Difference not reported:\n%v", out.String())
+	}
+}
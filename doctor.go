@@ -0,0 +1,219 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// The results of a doctor check.
+const (
+	DoctorPass = "PASS"
+	DoctorFail = "FAIL"
+	DoctorSkip = "SKIP"
+)
+
+// errSkipped is returned by a check which doesn't apply to the configuration.
+var errSkipped = errors.New("skipped")
+
+// doctorCheck is one of the checks run by lorica doctor. The check returns
+// what it found, or an error which says how to fix the problem.
+type doctorCheck struct {
+	name  string
+	check func() (string, error)
+}
+
+// doctorChecks returns the checks for the current configuration.
+func doctorChecks() ([]doctorCheck, error) {
+	apiRequestURL, err := url.Parse(*apiURL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse API URL: %v", err)
+	}
+	credentialPrefixes, err = parseCredentialPrefixes(*credentialPrefixList)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: *timeout}
+
+	checks := []doctorCheck{
+		{"DNS", func() (string, error) { return checkDNS(apiRequestURL.Hostname()) }},
+		{"TLS", func() (string, error) { return checkTLS(apiRequestURL) }},
+		{"Clock", func() (string, error) { return checkClockSkew(client, apiRequestURL.String(), time.Now) }},
+		{"Signing", checkSigning},
+	}
+	if defaultCredentials().accessID != "" {
+		checks = append(checks, doctorCheck{"Credentials", func() (string, error) {
+			return checkCredentials(client, apiRequestURL.String(), defaultCredentials())
+		}})
+	}
+	for _, p := range credentialPrefixes {
+		creds := p.credentials
+		checks = append(checks, doctorCheck{"Credentials " + p.prefix, func() (string, error) {
+			return checkCredentials(client, apiRequestURL.String(), creds)
+		}})
+	}
+	checks = append(checks, doctorCheck{"Listen", func() (string, error) { return checkListen(*address) }})
+	if *adminAddress != "" {
+		checks = append(checks, doctorCheck{"Listen admin", func() (string, error) { return checkListen(*adminAddress) }})
+	}
+	return checks, nil
+}
+
+// checkDNS resolves the Summon API host.
+func checkDNS(host string) (string, error) {
+	addrs, err := net.LookupHost(host)
+	if err != nil {
+		return "", fmt.Errorf("unable to resolve %v: %v. Check the host's DNS servers, or -summonapi", host, err)
+	}
+	return fmt.Sprintf("%v resolves to %v", host, strings.Join(addrs, ", ")), nil
+}
+
+// checkTLS makes a TLS connection to the Summon API, verifying its certificate.
+func checkTLS(apiRequestURL *url.URL) (string, error) {
+	if apiRequestURL.Scheme != "https" {
+		return "", errSkipped
+	}
+	host := apiRequestURL.Host
+	if apiRequestURL.Port() == "" {
+		host = net.JoinHostPort(apiRequestURL.Hostname(), "443")
+	}
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: *timeout}, "tcp", host, nil)
+	if err != nil {
+		return "", fmt.Errorf("unable to make a TLS connection to %v: %v. Check outbound firewall rules, "+
+			"proxies which intercept TLS, and the system's CA certificates", host, err)
+	}
+	defer conn.Close()
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return "", fmt.Errorf("%v sent no certificate", host)
+	}
+	return fmt.Sprintf("connected to %v, certificate valid until %v", host, certs[0].NotAfter.Format("2006-01-02")), nil
+}
+
+// checkClockSkew compares the local clock with the Date header from the Summon API.
+func checkClockSkew(client *http.Client, apiURLString string, now func() time.Time) (string, error) {
+	before := now()
+	resp, err := client.Head(apiURLString)
+	if err != nil {
+		return "", fmt.Errorf("unable to reach %v: %v", apiURLString, err)
+	}
+	resp.Body.Close()
+	after := now()
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return "", fmt.Errorf("%v didn't send a Date header to compare with", apiURLString)
+	}
+	skew := date.Sub(before.Add(after.Sub(before) / 2)).Round(time.Second)
+	if skew > *maxClockSkew || skew < -*maxClockSkew {
+		return "", fmt.Errorf("the local clock is %v off from the Summon API's, so requests may be refused. "+
+			"Synchronize the clock with NTP", skew)
+	}
+	return fmt.Sprintf("the local clock is within %v of the Summon API's", *maxClockSkew), nil
+}
+
+// checkSigning signs the example request from Summon's documentation.
+func checkSigning() (string, error) {
+	if err := selfTestSigning(); err != nil {
+		return "", err
+	}
+	return "the documented example request was signed correctly", nil
+}
+
+// credentialsRefusedError is returned by checkCredentials when the Summon
+// API refuses the credentials, rather than failing for another reason.
+type credentialsRefusedError struct {
+	accessID string
+	status   string
+}
+
+func (e *credentialsRefusedError) Error() string {
+	return fmt.Sprintf("the Summon API refused access ID %v with %v. Check the access ID and secret key, "+
+		"and the clock", e.accessID, e.status)
+}
+
+// checkCredentials sends a small signed search to the Summon API.
+func checkCredentials(client *http.Client, apiURLString string, creds credentials) (string, error) {
+	apiRequestURL, err := url.Parse(apiURLString)
+	if err != nil {
+		return "", err
+	}
+	apiRequestURL.Path = "/2.0.0/search"
+	apiRequestURL.RawQuery = "s.ps=1&s.q=lorica"
+	apiRequest, err := http.NewRequest("GET", apiRequestURL.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	accept := "application/json"
+	timestampRFC2616 := time.Now().UTC().Format(http.TimeFormat)
+	apiRequest.Header.Add("Accept", accept)
+	apiRequest.Header.Add("x-summon-date", timestampRFC2616)
+	apiRequest.Header.Add("Authorization", buildHeaderWithCredentials(creds, apiRequestURL, accept, timestampRFC2616))
+	resp, err := client.Do(apiRequest)
+	if err != nil {
+		return "", fmt.Errorf("unable to reach %v: %v", apiRequestURL.Host, err)
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return fmt.Sprintf("the Summon API accepted access ID %v", creds.accessID), nil
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return "", &credentialsRefusedError{accessID: creds.accessID, status: resp.Status}
+	default:
+		return "", fmt.Errorf("the Summon API responded to a test search with %v", resp.Status)
+	}
+}
+
+// checkListen makes sure Lorica can listen on the address.
+func checkListen(addr string) (string, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", fmt.Errorf("unable to listen on %v: %v. Check another process isn't using the port, "+
+			"and that Lorica is allowed to bind to it", addr, err)
+	}
+	ln.Close()
+	return fmt.Sprintf("able to listen on %v", addr), nil
+}
+
+// runDoctor runs the checks, writing a line for each result, and
+// returns the number which failed.
+func runDoctor(w io.Writer, checks []doctorCheck) int {
+	failed := 0
+	for _, c := range checks {
+		found, err := c.check()
+		switch {
+		case err == errSkipped:
+			fmt.Fprintf(w, "%v  %v\n", DoctorSkip, c.name)
+		case err != nil:
+			failed++
+			fmt.Fprintf(w, "%v  %v: %v.\n", DoctorFail, c.name, err)
+		default:
+			fmt.Fprintf(w, "%v  %v: %v.\n", DoctorPass, c.name, found)
+		}
+	}
+	return failed
+}
+
+// doctorCommand runs lorica doctor.
+func doctorCommand() error {
+	checks, err := doctorChecks()
+	if err != nil {
+		return err
+	}
+	if failed := runDoctor(os.Stdout, checks); failed > 0 {
+		return fmt.Errorf("%v of %v checks failed", failed, len(checks))
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Each check gets a line, and failures are counted.
+func TestRunDoctor(t *testing.T) {
+	checks := []doctorCheck{
+		{"Good", func() (string, error) { return "all fine", nil }},
+		{"Bad", func() (string, error) { return "", errors.New("broken. Fix it") }},
+		{"Other", func() (string, error) { return "", errSkipped }},
+	}
+	var b bytes.Buffer
+	if failed := runDoctor(&b, checks); failed != 1 {
+		t.Errorf("Expected 1 failure, got %v", failed)
+	}
+	expected := "PASS  Good: all fine.\nFAIL  Bad: broken. Fix it.\nSKIP  Other\n"
+	if b.String() != expected {
+		t.Errorf("Expected\n%vgot\n%v", expected, b.String())
+	}
+}
+
+// A clock which is too far from the Summon API's fails.
+func TestCheckClockSkew(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	if _, err := checkClockSkew(ts.Client(), ts.URL, time.Now); err != nil {
+		t.Errorf("Clock skew check failed with the same clock: %v", err)
+	}
+	skewed := func() time.Time { return time.Now().Add(10 * time.Minute) }
+	if _, err := checkClockSkew(ts.Client(), ts.URL, skewed); err == nil || !strings.Contains(err.Error(), "-10m") {
+		t.Errorf("Clock skew not detected, got %v", err)
+	}
+}
+
+// Credentials refused by the Summon API fail.
+func TestCheckCredentials(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "Summon good;") {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer ts.Close()
+
+	if _, err := checkCredentials(ts.Client(), ts.URL, credentials{"good", "key"}); err != nil {
+		t.Errorf("Good credentials failed: %v", err)
+	}
+	if _, err := checkCredentials(ts.Client(), ts.URL, credentials{"bad", "key"}); err == nil {
+		t.Error("Bad credentials passed.")
+	}
+}
+
+// An address which is in use fails.
+func TestCheckListen(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if _, err := checkListen(ln.Addr().String()); err == nil {
+		t.Error("Listening on an address in use passed.")
+	}
+	if _, err := checkListen("127.0.0.1:0"); err != nil {
+		t.Errorf("Listening on a free address failed: %v", err)
+	}
+}
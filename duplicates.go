@@ -0,0 +1,165 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"strconv"
+	"strings"
+)
+
+// The fields added to a record which other records were collapsed into.
+const (
+	// DuplicateCountField is the number of records collapsed into the survivor.
+	DuplicateCountField = "loricaDuplicateCount"
+
+	// MergedAvailabilityField lists the availability of the survivor and each record collapsed into it.
+	MergedAvailabilityField = "loricaMergedAvailability"
+)
+
+var (
+	collapseDuplicates = flag.Bool("collapseduplicates", false, "Collapse records with the same DOI or ISBN "+
+		"in search results into the first of them, which is annotated with the availability of each. "+
+		"Only applied while the transform feature is enabled.")
+
+	// availabilityFields are copied from each duplicate into the survivor's merged availability.
+	availabilityFields = []string{"ID", "link", "hasFullText", "isFullTextHit", "inHoldings", "DBID", "Database"}
+)
+
+// duplicateCollapser collapses records which share a DOI or ISBN.
+type duplicateCollapser struct{}
+
+// Transform collapses duplicates in the response's documents, keeping the first
+// of each set, which carries the ranking Summon gave it. The response's
+// recordCount is lowered by the number of records collapsed.
+func (duplicateCollapser) Transform(response map[string]interface{}) bool {
+	documents, ok := response["documents"].([]interface{})
+	if !ok {
+		return false
+	}
+	survivors := make([]interface{}, 0, len(documents))
+	byIdentifier := make(map[string]map[string]interface{})
+	for _, d := range documents {
+		document, ok := d.(map[string]interface{})
+		if !ok {
+			survivors = append(survivors, d)
+			continue
+		}
+		identifiers := recordIdentifiers(document)
+		var survivor map[string]interface{}
+		for _, id := range identifiers {
+			if s, ok := byIdentifier[id]; ok {
+				survivor = s
+				break
+			}
+		}
+		if survivor == nil {
+			for _, id := range identifiers {
+				byIdentifier[id] = document
+			}
+			survivors = append(survivors, document)
+			continue
+		}
+		mergeDuplicate(survivor, document)
+		for _, id := range identifiers {
+			if _, ok := byIdentifier[id]; !ok {
+				byIdentifier[id] = survivor
+			}
+		}
+	}
+	collapsed := len(documents) - len(survivors)
+	if collapsed == 0 {
+		return false
+	}
+	response["documents"] = survivors
+	response["recordCount"] = lowerRecordCount(response["recordCount"], collapsed)
+	return true
+}
+
+// lowerRecordCount returns the record count less the collapsed records. A
+// count which can't be read is returned as it is.
+func lowerRecordCount(count interface{}, collapsed int) interface{} {
+	switch count := count.(type) {
+	case json.Number:
+		n, err := strconv.ParseInt(string(count), 10, 64)
+		if err != nil || n < int64(collapsed) {
+			return count
+		}
+		return json.Number(strconv.FormatInt(n-int64(collapsed), 10))
+	case float64:
+		if count < float64(collapsed) {
+			return count
+		}
+		return count - float64(collapsed)
+	}
+	return count
+}
+
+// recordIdentifiers returns the normalized DOIs and ISBNs of a record.
+func recordIdentifiers(document map[string]interface{}) []string {
+	var identifiers []string
+	for _, doi := range stringValues(document["DOI"]) {
+		identifiers = append(identifiers, "doi:"+strings.ToLower(strings.TrimSpace(doi)))
+	}
+	for _, field := range []string{"ISBN", "EISBN"} {
+		for _, isbn := range stringValues(document[field]) {
+			normalized := strings.Map(func(r rune) rune {
+				if (r >= '0' && r <= '9') || r == 'X' || r == 'x' {
+					return r
+				}
+				return -1
+			}, isbn)
+			if normalized != "" {
+				identifiers = append(identifiers, "isbn:"+strings.ToUpper(normalized))
+			}
+		}
+	}
+	return identifiers
+}
+
+// stringValues returns the strings in a Summon field, which is usually a list of strings.
+func stringValues(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var values []string
+		for _, item := range v {
+			if s, ok := item.(string); ok && s != "" {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// mergeDuplicate annotates the survivor with the duplicate's availability.
+func mergeDuplicate(survivor, duplicate map[string]interface{}) {
+	merged, ok := survivor[MergedAvailabilityField].([]interface{})
+	if !ok {
+		merged = []interface{}{availabilityOf(survivor)}
+	}
+	survivor[MergedAvailabilityField] = append(merged, availabilityOf(duplicate))
+	count, _ := survivor[DuplicateCountField].(int)
+	survivor[DuplicateCountField] = count + 1
+	for _, field := range []string{"hasFullText", "inHoldings"} {
+		if available, _ := duplicate[field].(bool); available {
+			survivor[field] = true
+		}
+	}
+}
+
+// availabilityOf returns the availability fields of a record.
+func availabilityOf(document map[string]interface{}) map[string]interface{} {
+	availability := make(map[string]interface{})
+	for _, field := range availabilityFields {
+		if v, ok := document[field]; ok {
+			availability[field] = v
+		}
+	}
+	return availability
+}
@@ -0,0 +1,55 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// Records with the same DOI or ISBN are collapsed into the first, which gets their availability.
+func TestDuplicateCollapser(t *testing.T) {
+	var response map[string]interface{}
+	err := json.Unmarshal([]byte(`{"recordCount": 20, "documents": [
+		{"ID": ["a"], "DOI": ["10.1000/ABC"], "hasFullText": false},
+		{"ID": ["b"], "ISBN": ["978-0-00-000000-2"]},
+		{"ID": ["c"], "DOI": ["10.1000/abc"], "hasFullText": true, "link": "http://c.example.com"},
+		{"ID": ["d"], "EISBN": ["9780000000002"]},
+		{"ID": ["e"]}
+	]}`), &response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !(duplicateCollapser{}).Transform(response) {
+		t.Error("Transform didn't report collapsing duplicates.")
+	}
+
+	documents := response["documents"].([]interface{})
+	if len(documents) != 3 {
+		t.Fatalf("Expected 3 records after collapsing, got %v", len(documents))
+	}
+	first := documents[0].(map[string]interface{})
+	if first[DuplicateCountField] != 1 || first["hasFullText"] != true {
+		t.Errorf("Survivor not annotated, got %v", first)
+	}
+	merged := first[MergedAvailabilityField].([]interface{})
+	if len(merged) != 2 || merged[1].(map[string]interface{})["link"] != "http://c.example.com" {
+		t.Errorf("Availability not merged, got %v", merged)
+	}
+	if documents[1].(map[string]interface{})[DuplicateCountField] != 1 {
+		t.Errorf("Records with the same ISBN not collapsed, got %v", documents[1])
+	}
+	if response["recordCount"] != float64(18) {
+		t.Errorf("Expected recordCount of 18 after collapsing, got %v", response["recordCount"])
+	}
+
+	response = map[string]interface{}{"recordCount": float64(2), "documents": []interface{}{
+		map[string]interface{}{"DOI": []interface{}{"10.1000/a"}},
+		map[string]interface{}{"DOI": []interface{}{"10.1000/b"}},
+	}}
+	if (duplicateCollapser{}).Transform(response) || response["recordCount"] != float64(2) {
+		t.Errorf("Response without duplicates changed, got %v", response)
+	}
+}
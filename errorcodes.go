@@ -0,0 +1,100 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ErrorsPath is the path of the error code registry.
+const ErrorsPath = "/errors"
+
+// The codes of the errors sent when a request to the proxy can't be sent to
+// Summon. The codes of other errors are declared next to the code which sends them.
+const (
+	ErrorMethodNotAllowed = "method_not_allowed"
+	ErrorQueryTooLong     = "query_too_long"
+	ErrorBodyTooLarge     = "body_too_large"
+	ErrorInternal         = "internal_error"
+	ErrorSignatureError   = "signature_error"
+)
+
+// errorCode is an error code Lorica sends in the code field of its JSON
+// errors. Codes are never renamed or reused, so client applications can
+// rely on them. A status of 0 means the status varies.
+type errorCode struct {
+	Code        string `json:"code"`
+	Status      int    `json:"status,omitempty"`
+	Retryable   bool   `json:"retryable"`
+	Description string `json:"description"`
+}
+
+// errorCodes is the registry of every error code. Every error sent by the
+// proxy and /batch has a code. Errors from the admin endpoints and Lorica's
+// other endpoints, like /count, don't.
+var errorCodes = []errorCode{
+	{ErrorMethodNotAllowed, http.StatusMethodNotAllowed, false, "The method isn't one Lorica proxies. The Allow header lists them."},
+	{ErrorBadPreflight, http.StatusBadRequest, false, "The CORS preflight request asked for a method or header cross-origin requests can't use."},
+	{ErrorOriginRejected, http.StatusForbidden, false, "The origin isn't one of the allowed origins, or the origin authorizer refused it."},
+	{ErrorQueryTooLong, http.StatusRequestURITooLong, false, "The query string is longer than Lorica accepts."},
+	{ErrorBodyTooLarge, http.StatusRequestEntityTooLarge, false, "The request body is larger than Lorica accepts."},
+	{ErrorInvalidBatch, http.StatusBadRequest, false, "The batch isn't a JSON array of query strings, or has too many queries."},
+	{ErrorPathNotFound, http.StatusNotFound, false, "The path isn't a Summon API path."},
+	{ErrorPathNotAllowed, http.StatusForbidden, false, "The Summon API path isn't one Lorica proxies."},
+	{ErrorInvalidQuery, http.StatusBadRequest, false, "A query parameter was rejected before it was sent to Summon."},
+	{ErrorUnknownPreset, http.StatusBadRequest, false, "The request asked for a facet preset which isn't defined."},
+	{ErrorAmbiguousRequest, 0, false, "The request could be read differently by Lorica and a proxy in front of it."},
+	{ErrorClientUnidentified, http.StatusUnauthorized, false, "The client's API key or bearer token wasn't accepted."},
+	{ErrorEndpointNotAllowed, http.StatusForbidden, false, "The client's tier can't use the endpoint."},
+	{ErrorQuotaExceeded, http.StatusTooManyRequests, true, "The client's quota is used up. Retry-After says when it resets."},
+	{ErrorRateLimited, http.StatusTooManyRequests, true, "The client sent too many requests. Slow down."},
+	{ErrorBlocked, http.StatusForbidden, false, "The client's requests look like a scraper's, and are blocked for now."},
+	{ErrorChallengeRequired, 0, false, "The request needs a challenge token, from the URL in the X-Lorica-Challenge header."},
+	{ErrorSessionInUse, http.StatusForbidden, false, "The session ID belongs to another client. Start a new session."},
+	{ErrorOverloaded, http.StatusServiceUnavailable, true, "Lorica is busy, and shed a background request."},
+	{ErrorUpstreamTimeout, http.StatusGatewayTimeout, true, "The Summon API didn't respond in time."},
+	{ErrorUpstreamUnreachable, http.StatusBadGateway, true, "Lorica couldn't reach the Summon API."},
+	{ErrorInternal, http.StatusInternalServerError, false, "Lorica couldn't build the request to the Summon API."},
+	{ErrorSignatureError, http.StatusInternalServerError, false, "Lorica couldn't sign the request to the Summon API."},
+	{ErrorSummonAuth, 0, false, "The Summon API refused Lorica's credentials or signature. The library needs to fix it."},
+	{ErrorSummonBadQuery, http.StatusBadRequest, false, "The Summon API couldn't run the query."},
+	{ErrorSummonNotFound, http.StatusNotFound, false, "The Summon API doesn't have the endpoint or record."},
+	{ErrorSummonRateLimited, http.StatusTooManyRequests, true, "The Summon API is rate limiting Lorica."},
+	{ErrorSummonUnavailable, 0, true, "The Summon API is unavailable."},
+	{ErrorSummonOther, 0, false, "The Summon API responded with another error."},
+	{ErrorSummonBadResponse, http.StatusBadGateway, true, "The Summon API sent a response which isn't what Lorica expects, or is cut off or too large."},
+}
+
+// errorCodeNames returns the registered error codes.
+func errorCodeNames() []string {
+	var names []string
+	for _, c := range errorCodes {
+		names = append(names, c.Code)
+	}
+	return names
+}
+
+// errorsHandler sends the error code registry as JSON. Like the
+// capabilities, it can be read from any allowed origin.
+func errorsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Origin") != "" {
+		setACAOHeader(w, r)
+	}
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		sendJSONError(w, http.StatusMethodNotAllowed, "Only GET and HEAD requests are accepted by "+ErrorsPath+".", nil)
+		return
+	}
+	body, err := json.Marshal(errorCodes)
+	if err != nil {
+		sendJSONError(w, http.StatusInternalServerError, "Unable to list the error codes.", nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Header().Set("Vary", "Origin")
+	w.Write(append(body, '\n'))
+}
@@ -0,0 +1,89 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// errorCodePattern matches the values of error codes, like upstream_timeout.
+var errorCodePattern = regexp.MustCompile(`^[a-z]+(_[a-z]+)*$`)
+
+// Every error code constant, like ErrorUpstreamTimeout, is in the registry, once.
+func TestErrorCodesRegistered(t *testing.T) {
+	registered := make(map[string]bool)
+	for _, c := range errorCodes {
+		if registered[c.Code] {
+			t.Errorf("The error code %v is registered twice.", c.Code)
+		}
+		registered[c.Code] = true
+		if c.Description == "" {
+			t.Errorf("The error code %v has no description.", c.Code)
+		}
+	}
+
+	fset := token.NewFileSet()
+	packages, err := parser.ParseDir(fset, ".", func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range packages["main"].Files {
+		for _, decl := range file.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.CONST {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				value := spec.(*ast.ValueSpec)
+				for i, name := range value.Names {
+					if !strings.HasPrefix(name.Name, "Error") || i >= len(value.Values) {
+						continue
+					}
+					literal, ok := value.Values[i].(*ast.BasicLit)
+					if !ok || literal.Kind != token.STRING {
+						continue
+					}
+					code, _ := strconv.Unquote(literal.Value)
+					if errorCodePattern.MatchString(code) && !registered[code] {
+						t.Errorf("The error code %v (%v) isn't in the registry.", code, name.Name)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestErrorsHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	errorsHandler(w, httptest.NewRequest("GET", ErrorsPath, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("The status was %v", w.Code)
+	}
+	var codes []errorCode
+	if err := json.Unmarshal(w.Body.Bytes(), &codes); err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != len(errorCodes) {
+		t.Errorf("%v error codes were sent, not %v", len(codes), len(errorCodes))
+	}
+
+	w = httptest.NewRecorder()
+	errorsHandler(w, httptest.NewRequest("POST", ErrorsPath, nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("A POST got %v", w.Code)
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	l "github.com/cu-library/lorica/loglevel"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultEvidenceMaxAge is the default age after which evidence of Summon API errors is purged.
+const DefaultEvidenceMaxAge = 14 * 24 * time.Hour
+
+var (
+	evidenceLogPath = flag.String("evidencelog", "", "A file which a JSON line is appended to for every request "+
+		"the Summon API failed, with the request Lorica sent, the headers Summon responded with, and how long it "+
+		"took, to attach to support tickets with ProQuest. Entries are found by request ID at /admin/evidence. "+
+		"If not set, the evidence isn't stored.")
+	evidenceMaxAge = flag.Duration("evidencemaxage", DefaultEvidenceMaxAge, "Evidence of Summon API errors "+
+		"older than this is purged. It holds what patrons searched for, so it is kept for less time than "+
+		"other records. The size is limited by -retentionmaxsize.")
+
+	// evidence is the evidence log. If it is nil, nothing is recorded.
+	evidence *evidenceLog
+)
+
+// evidenceEntry is one line of the evidence log, a request the Summon API failed.
+type evidenceEntry struct {
+	Time            time.Time   `json:"time"`
+	RequestID       string      `json:"request_id"`
+	Method          string      `json:"method"`
+	URL             string      `json:"url"`
+	RequestHeader   http.Header `json:"request_header"`
+	Status          int         `json:"status,omitempty"`
+	Error           string      `json:"error,omitempty"`
+	ResponseHeader  http.Header `json:"response_header,omitempty"`
+	ResponseBody    string      `json:"response_body,omitempty"`
+	DurationSeconds float64     `json:"duration_seconds"`
+}
+
+// evidenceLog appends the Summon API's failures to a file as JSON lines.
+type evidenceLog struct {
+	sync.Mutex
+	w    io.Writer
+	file *os.File
+	path string
+	now  func() time.Time
+}
+
+// openEvidenceLog opens the file for appending, creating it if needed.
+func openEvidenceLog(path string) (*evidenceLog, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &evidenceLog{w: file, file: file, path: path, now: time.Now}, nil
+}
+
+// summonFailed returns true if the Summon API, not the client, is to blame for the response.
+func summonFailed(apiResp *http.Response, err error) bool {
+	return err != nil || apiResp.StatusCode >= 500
+}
+
+// Record appends an entry if the Summon API failed the request Lorica sent.
+// The response's body is read, up to MaxUpstreamErrorBody, and replaced, so
+// it can still be sent to the client. It is safe to call on a nil evidenceLog.
+func (e *evidenceLog) Record(r *http.Request, apiRequest *http.Request, apiResp *http.Response, err error, took time.Duration) {
+	if e == nil || !summonFailed(apiResp, err) {
+		return
+	}
+	entry := evidenceEntry{
+		RequestID:       getRequestInfo(r).id,
+		Method:          apiRequest.Method,
+		URL:             apiRequest.URL.String(),
+		RequestHeader:   redactedHeader(apiRequest.Header),
+		DurationSeconds: took.Seconds(),
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	} else {
+		body, readErr := ioutil.ReadAll(io.LimitReader(apiResp.Body, MaxUpstreamErrorBody))
+		apiResp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), apiResp.Body), apiResp.Body}
+		if readErr != nil {
+			entry.Error = fmt.Sprintf("reading the body: %v", readErr)
+		}
+		entry.Status = apiResp.StatusCode
+		entry.ResponseHeader = cloneHeader(apiResp.Header)
+		entry.ResponseBody = string(body)
+	}
+
+	e.Lock()
+	defer e.Unlock()
+	entry.Time = e.now().UTC()
+	line, err := json.Marshal(entry)
+	if err != nil {
+		l.Logf(l.ErrorMessage, "Unable to build evidence log entry: %v", err)
+		return
+	}
+	if _, err := e.w.Write(append(line, '\n')); err != nil {
+		l.Logf(l.ErrorMessage, "Unable to write to evidence log: %v", err)
+	}
+}
+
+// Purge removes the entries which the retention policy says should be purged.
+func (e *evidenceLog) Purge(policy retentionPolicy) (int, error) {
+	if e == nil || e.file == nil {
+		return 0, nil
+	}
+	e.Lock()
+	defer e.Unlock()
+
+	// Close the file while it is replaced, then open the new one.
+	e.file.Close()
+	purged, purgeErr := purgeJSONLines(e.path, policy, e.now())
+	file, err := os.OpenFile(e.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		e.w = ioutil.Discard
+		return purged, err
+	}
+	e.w, e.file = file, file
+	return purged, purgeErr
+}
+
+// purgeEvidenceLog purges the evidence older than -evidencemaxage, or over -retentionmaxsize.
+func purgeEvidenceLog(e *evidenceLog) error {
+	policy := retentionPolicyFromFlags()
+	policy.maxAge = *evidenceMaxAge
+	purged, err := e.Purge(policy)
+	if err != nil {
+		return fmt.Errorf("unable to purge evidence log: %v", err)
+	}
+	if purged > 0 {
+		l.Logf(l.InfoMessage, "Purged %v evidence log entries.", purged)
+	}
+	return nil
+}
+
+// findEvidence returns the entries for the request ID.
+func findEvidence(entries io.Reader, id string) ([]evidenceEntry, error) {
+	found := []evidenceEntry{}
+	scanner := bufio.NewScanner(entries)
+	scanner.Buffer(nil, 4*MaxUpstreamErrorBody)
+	for scanner.Scan() {
+		var entry evidenceEntry
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil || entry.RequestID != id {
+			continue
+		}
+		found = append(found, entry)
+	}
+	return found, scanner.Err()
+}
+
+// evidenceHandler sends the evidence stored for the request with the id parameter.
+func evidenceHandler(w http.ResponseWriter, r *http.Request) {
+	if evidence == nil {
+		sendJSONError(w, http.StatusNotFound, "The evidence log isn't enabled.",
+			[]string{"Set -evidencelog to store the Summon API's failures."})
+		return
+	}
+	id := r.FormValue("id")
+	if id == "" {
+		sendJSONError(w, http.StatusBadRequest, "The id parameter is required.",
+			[]string{"Use the X-Request-ID of the failed request."})
+		return
+	}
+
+	evidence.Lock()
+	file, err := os.Open(evidence.path)
+	evidence.Unlock()
+	if err != nil {
+		sendJSONError(w, http.StatusInternalServerError, "Unable to read the evidence log.", nil)
+		return
+	}
+	defer file.Close()
+	found, err := findEvidence(file, id)
+	if err != nil {
+		l.Logf(l.ErrorMessage, "Unable to read evidence log: %v", err)
+	}
+	if len(found) == 0 {
+		sendJSONError(w, http.StatusNotFound, fmt.Sprintf("There is no evidence for request %v.", id),
+			[]string{fmt.Sprintf("Only requests the Summon API failed are stored, for %v.", *evidenceMaxAge)})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(found)
+}
@@ -0,0 +1,162 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Failures of the Summon API are recorded with its headers, and the body can still be read.
+func TestEvidenceLogRecord(t *testing.T) {
+	buf := new(bytes.Buffer)
+	e := &evidenceLog{w: buf, now: func() time.Time { return time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC) }}
+
+	r, info := withRequestInfo(httptest.NewRequest("GET", "/2.0.0/search?s.q=cats", nil))
+	apiRequest, _ := http.NewRequest("GET", "https://api.summon.serialssolutions.com/2.0.0/search?s.q=cats", nil)
+	apiRequest.Header.Set("Authorization", "Summon lorica;secret")
+	apiRequest.Header.Set("x-summon-date", "Sat, 02 Jan 2016 03:04:05 GMT")
+
+	ok := &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader("{}"))}
+	e.Record(r, apiRequest, ok, nil, time.Second)
+	badQuery := &http.Response{StatusCode: http.StatusBadRequest, Body: ioutil.NopCloser(strings.NewReader("{}"))}
+	e.Record(r, apiRequest, badQuery, nil, time.Second)
+	if buf.Len() != 0 {
+		t.Fatalf("Responses Summon isn't to blame for were recorded: %v", buf.String())
+	}
+
+	failed := &http.Response{
+		StatusCode: http.StatusServiceUnavailable,
+		Header:     http.Header{"X-Summon-Trace": {"abc123"}},
+		Body:       ioutil.NopCloser(strings.NewReader(`{"errors":[{"message":"down"}]}`)),
+	}
+	e.Record(r, apiRequest, failed, nil, 1500*time.Millisecond)
+	body, _ := ioutil.ReadAll(failed.Body)
+	if string(body) != `{"errors":[{"message":"down"}]}` {
+		t.Errorf("The body left to read was %q", body)
+	}
+	e.Record(r, apiRequest, nil, errors.New("connection reset"), 2*time.Second)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Evidence log was %v", buf.String())
+	}
+	var entry evidenceEntry
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.RequestID != info.id || entry.Status != http.StatusServiceUnavailable || entry.DurationSeconds != 1.5 {
+		t.Errorf("Entry was %+v", entry)
+	}
+	if entry.ResponseHeader.Get("X-Summon-Trace") != "abc123" || !strings.Contains(entry.ResponseBody, "down") {
+		t.Errorf("Entry didn't have the response: %+v", entry)
+	}
+	if entry.RequestHeader.Get("Authorization") != MaskedValue || entry.RequestHeader.Get("x-summon-date") == "" {
+		t.Errorf("Entry's request headers were %v", entry.RequestHeader)
+	}
+	if !strings.Contains(lines[1], `"error":"connection reset"`) {
+		t.Errorf("Entry for an error was %v", lines[1])
+	}
+
+	var nilLog *evidenceLog
+	nilLog.Record(r, apiRequest, failed, nil, time.Second)
+}
+
+func TestEvidenceHandler(t *testing.T) {
+	oldEvidence := evidence
+	defer func() { evidence = oldEvidence }()
+
+	evidence = nil
+	w := httptest.NewRecorder()
+	evidenceHandler(w, httptest.NewRequest("GET", "/admin/evidence?id=a", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Without an evidence log, the status was %v", w.Code)
+	}
+
+	dir, err := ioutil.TempDir("", "lorica")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "evidence.log")
+	entries := `{"time":"2016-01-01T10:00:00Z","request_id":"a","method":"GET","url":"/one","status":502}
+not json
+{"time":"2016-01-01T10:01:00Z","request_id":"b","method":"GET","url":"/two","status":503}
+{"time":"2016-01-01T10:02:00Z","request_id":"a","method":"GET","url":"/one","error":"timeout"}
+`
+	if err := ioutil.WriteFile(path, []byte(entries), 0600); err != nil {
+		t.Fatal(err)
+	}
+	evidence, err = openEvidenceLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer evidence.file.Close()
+
+	tests := []struct {
+		query   string
+		status  int
+		entries int
+	}{
+		{"", http.StatusBadRequest, 0},
+		{"?id=c", http.StatusNotFound, 0},
+		{"?id=a", http.StatusOK, 2},
+		{"?id=b", http.StatusOK, 1},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		evidenceHandler(w, httptest.NewRequest("GET", "/admin/evidence"+test.query, nil))
+		if w.Code != test.status {
+			t.Errorf("The status for %q was %v, expected %v", test.query, w.Code, test.status)
+			continue
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+		var found []evidenceEntry
+		if err := json.Unmarshal(w.Body.Bytes(), &found); err != nil || len(found) != test.entries {
+			t.Errorf("The entries for %q were %v, %v", test.query, w.Body.String(), err)
+		}
+	}
+}
+
+// Evidence is purged after -evidencemaxage, not -retentionmaxage.
+func TestPurgeEvidenceLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lorica")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "evidence.log")
+	entries := `{"time":"2016-01-01T00:00:00Z","request_id":"old"}
+{"time":"2016-01-20T00:00:00Z","request_id":"new"}
+`
+	if err := ioutil.WriteFile(path, []byte(entries), 0600); err != nil {
+		t.Fatal(err)
+	}
+	e, err := openEvidenceLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.file.Close()
+	e.now = func() time.Time { return time.Date(2016, 1, 21, 0, 0, 0, 0, time.UTC) }
+
+	if err := purgeEvidenceLog(e); err != nil {
+		t.Fatal(err)
+	}
+	contents, _ := ioutil.ReadFile(path)
+	if strings.Contains(string(contents), "old") || !strings.Contains(string(contents), "new") {
+		t.Errorf("After purging, the evidence log was %v", string(contents))
+	}
+}
@@ -0,0 +1,31 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"io/ioutil"
+	"syscall"
+)
+
+// openFileDescriptors returns the number of file descriptors this process has open.
+func openFileDescriptors() (int, bool) {
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		return 0, false
+	}
+	return len(fds), true
+}
+
+// maxFileDescriptors returns the soft limit on the number of open file descriptors.
+func maxFileDescriptors() (uint64, bool) {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		return 0, false
+	}
+	return uint64(limit.Cur), true
+}
@@ -0,0 +1,18 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package main
+
+// openFileDescriptors isn't supported on this platform.
+func openFileDescriptors() (int, bool) {
+	return 0, false
+}
+
+// maxFileDescriptors isn't supported on this platform.
+func maxFileDescriptors() (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,160 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	l "github.com/cu-library/lorica/loglevel"
+	"github.com/cu-library/lorica/metrics"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+const (
+	// FeatureCache enables caching responses from the Summon API.
+	FeatureCache = "cache"
+
+	// FeaturePost enables proxying POST requests.
+	FeaturePost = "post"
+
+	// FeatureTransform enables transforming responses from the Summon API.
+	FeatureTransform = "transform"
+)
+
+var (
+	featureList = flag.String("features", "", "A list of experimental features to enable, delimited by the ; character. "+
+		"The features are cache, post, and transform. When set in the configuration file, "+
+		"the list is reloaded when Lorica receives a SIGHUP.")
+
+	// knownFeatures are the features which can be enabled.
+	knownFeatures = []string{FeatureCache, FeaturePost, FeatureTransform}
+
+	// features are the currently enabled features.
+	features = &featureSet{enabled: make(map[string]bool)}
+
+	featureEnabledGauge = metrics.NewGaugeVec("lorica_feature_enabled",
+		"Whether an experimental feature is enabled.", "feature")
+)
+
+// featureSet holds the enabled features. It can be changed while serving
+// requests, and by the SIGHUP and configuration file reloads.
+type featureSet struct {
+	sync.RWMutex
+	list    string
+	enabled map[string]bool
+}
+
+// Enabled returns true if the feature is enabled.
+func (fs *featureSet) Enabled(name string) bool {
+	fs.RLock()
+	defer fs.RUnlock()
+	return fs.enabled[name]
+}
+
+// Set replaces the enabled features with those in the list.
+func (fs *featureSet) Set(list string) error {
+	enabled, err := parseFeatures(list)
+	if err != nil {
+		return err
+	}
+	fs.Lock()
+	fs.list = list
+	fs.enabled = enabled
+	fs.Unlock()
+	for _, name := range knownFeatures {
+		if enabled[name] {
+			featureEnabledGauge.With(name).Set(1)
+		} else {
+			featureEnabledGauge.With(name).Set(0)
+		}
+	}
+	return nil
+}
+
+// String returns the enabled features, delimited by the ; character.
+func (fs *featureSet) String() string {
+	fs.RLock()
+	defer fs.RUnlock()
+	var names []string
+	for _, name := range knownFeatures {
+		if fs.enabled[name] {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ";")
+}
+
+// List returns the list the features were set from.
+func (fs *featureSet) List() string {
+	fs.RLock()
+	defer fs.RUnlock()
+	return fs.list
+}
+
+// parseFeatures parses a list of features, delimited by the ; character.
+func parseFeatures(list string) (map[string]bool, error) {
+	enabled := make(map[string]bool)
+	for _, name := range splitList(list) {
+		name = strings.ToLower(name)
+		known := false
+		for _, k := range knownFeatures {
+			if name == k {
+				known = true
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown feature %v, the features are %v", name, strings.Join(knownFeatures, ", "))
+		}
+		enabled[name] = true
+	}
+	return enabled, nil
+}
+
+// reloadFeatures sets the enabled features from the configuration file,
+// unless they were set by a flag or an environment variable. The reloaded
+// list is kept by the feature set, under its lock, not in the flag.
+func reloadFeatures(path string) error {
+	source := configSource("features")
+	if source != SourceFile && source != SourceDefault {
+		return fmt.Errorf("features were set by %v, not the configuration file", source)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	values, err := parseConfig(file)
+	if err != nil {
+		return fmt.Errorf("%v: %v", path, err)
+	}
+	list, ok := values["features"]
+	if err := features.Set(list); err != nil {
+		return err
+	}
+	if ok {
+		setConfigSource("features", SourceFile)
+	} else {
+		setConfigSource("features", SourceDefault)
+	}
+	return nil
+}
+
+// reloadFeaturesOnHangup reloads the features from the configuration file
+// every time the process receives a SIGHUP.
+func reloadFeaturesOnHangup(path string) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	for range hangups {
+		if err := reloadFeatures(path); err != nil {
+			l.Logf(l.ErrorMessage, "Unable to reload features: %v", err)
+			continue
+		}
+		l.Log(l.InfoMessage, "Reloaded Features: "+features.String())
+	}
+}
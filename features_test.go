@@ -0,0 +1,63 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// Known features can be enabled, and unknown features are errors.
+func TestFeatureSet(t *testing.T) {
+	fs := &featureSet{enabled: make(map[string]bool)}
+	if err := fs.Set("Cache; transform"); err != nil {
+		t.Fatal(err)
+	}
+	if !fs.Enabled(FeatureCache) || !fs.Enabled(FeatureTransform) || fs.Enabled(FeaturePost) {
+		t.Errorf("Wrong features enabled, got %v", fs.String())
+	}
+	if fs.String() != "cache;transform" {
+		t.Errorf("Features listed incorrectly, got %v", fs.String())
+	}
+	if err := fs.Set("cache;teleport"); err == nil {
+		t.Error("No error enabling an unknown feature.")
+	}
+	if !fs.Enabled(FeatureCache) {
+		t.Error("A bad list of features changed the enabled features.")
+	}
+}
+
+// Features are reloaded from the configuration file, but not if a flag set them.
+func TestReloadFeatures(t *testing.T) {
+	file, err := ioutil.TempFile("", "lorica")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("features = post\n")
+	file.Close()
+
+	oldFeatureList := features.List()
+	defer func() {
+		features.Set(oldFeatureList)
+		delete(configSources, "features")
+	}()
+
+	if err := reloadFeatures(file.Name()); err != nil {
+		t.Fatal(err)
+	}
+	if !features.Enabled(FeaturePost) || features.List() != "post" {
+		t.Errorf("Features not reloaded, got %v", features.String())
+	}
+	if *featureList != "" {
+		t.Errorf("The flag was written by the reload, got %v", *featureList)
+	}
+
+	setConfigSource("features", SourceFlag)
+	if err := reloadFeatures(file.Name()); err == nil {
+		t.Error("No error reloading features which were set by a flag.")
+	}
+}
@@ -1,9 +1,14 @@
 module github.com/cu-library/lorica
 
-go 1.12
+go 1.18
 
 require (
 	github.com/didip/tollbooth v4.0.0+incompatible
-	github.com/patrickmn/go-cache v2.1.0+incompatible
+	golang.org/x/net v0.35.0
+)
+
+require (
+	github.com/patrickmn/go-cache v2.1.0+incompatible // indirect
+	golang.org/x/text v0.22.0 // indirect
 	golang.org/x/time v0.0.0-20181108054448-85acf8d2951c // indirect
 )
@@ -0,0 +1,221 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	viaHeader = flag.Bool("via", true, "Add Lorica to the Via header of requests sent to the Summon API, "+
+		"after any proxies the client's request went through.")
+	forwardedHeader = flag.Bool("forwarded", false, "Add an RFC 7239 Forwarded element, with the client's IP address, "+
+		"to requests sent to the Summon API, after the client's Forwarded header.")
+	forwardHeaders = flag.String("forwardheaders", "", "A list of additional client request headers to forward "+
+		"to the Summon API, delimited by the ; character. Accept, Accept-Language, and x-summon-session-id "+
+		"are always forwarded.")
+	proxiedHeaders = flag.String("proxiedheaders", "Content-Type", "A list of Summon API response headers to "+
+		"send to the client, delimited by the ; character.")
+	serverHeader = flag.Bool("serverheader", true, "Add a Server header with Lorica's version, "+
+		"like lorica/1.0.0, to responses.")
+	hidePoweredBy = flag.Bool("hidepoweredby", true, "Never send an X-Powered-By header to clients, "+
+		"even if it is listed in -proxiedheaders.")
+)
+
+// reservedRequestHeaders are set by Lorica on requests to the Summon API,
+// and can't be forwarded from the client.
+var reservedRequestHeaders = []string{
+	"Accept",
+	"Accept-Language",
+	"Authorization",
+	"Forwarded",
+	"Host",
+	"Via",
+	"X-Lorica-Admin-Token",
+	"X-Lorica-Challenge-Token",
+	"X-Lorica-Key",
+	"X-Lorica-Upstream",
+	"X-Summon-Date",
+	"X-Summon-Session-Id",
+}
+
+// hopByHopHeaders only apply to a single connection, and must not be
+// forwarded by proxies. See RFC 7230, section 6.1.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// cloneHeader returns a deep copy of the header.
+func cloneHeader(h http.Header) http.Header {
+	clone := make(http.Header, len(h))
+	for key, values := range h {
+		clone[key] = append([]string(nil), values...)
+	}
+	return clone
+}
+
+// removeHopByHopHeaders deletes the hop-by-hop headers from h, including
+// any headers named in the Connection header.
+func removeHopByHopHeaders(h http.Header) {
+	for _, value := range h["Connection"] {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		h.Del(name)
+	}
+}
+
+// viaValue returns the element Lorica adds to the Via header for a request.
+func viaValue(r *http.Request) string {
+	protocol := strconv.Itoa(r.ProtoMajor)
+	if r.ProtoMajor < 2 {
+		protocol += "." + strconv.Itoa(r.ProtoMinor)
+	}
+	return protocol + " lorica"
+}
+
+// forwardedValue returns the element Lorica adds to the Forwarded header for a request.
+func forwardedValue(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	// IPv6 addresses must be bracketed.
+	if strings.Contains(ip, ":") {
+		ip = "[" + ip + "]"
+	}
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+	element := "for=" + forwardedParameter(ip) + ";proto=" + proto
+	if r.Host != "" {
+		element += ";host=" + forwardedParameter(r.Host)
+	}
+	return element
+}
+
+// forwardedParameter returns the value as an RFC 7239 parameter value: a
+// token if it can be one, otherwise a quoted string with quotes and
+// backslashes escaped.
+func forwardedParameter(value string) string {
+	token := value != ""
+	for _, c := range value {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("!#$%&'*+-.^_`|~", c)) {
+			token = false
+		}
+	}
+	if token {
+		return value
+	}
+	return "\"" + strings.NewReplacer("\\", "\\\\", "\"", "\\\"").Replace(value) + "\""
+}
+
+// appendToChain sets the header on the request to Summon to the client's
+// values followed by Lorica's element, so the proxies the request went
+// through are kept in order.
+func appendToChain(dst, client http.Header, name, element string) {
+	name = http.CanonicalHeaderKey(name)
+	dst[name] = append(append([]string(nil), client[name]...), element)
+}
+
+// splitList splits a list delimited by the ; character, trimming
+// whitespace and dropping empty items.
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ";") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// forwardableHeaders returns the extra client headers which should be
+// forwarded to the Summon API, leaving out any which Lorica sets itself.
+func forwardableHeaders() []string {
+	var names []string
+	for _, name := range splitList(*forwardHeaders) {
+		name = http.CanonicalHeaderKey(name)
+		reserved := false
+		for _, reservedName := range reservedRequestHeaders {
+			if name == reservedName {
+				reserved = true
+			}
+		}
+		if !reserved {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// proxiableHeaders returns the Summon API response headers which should be
+// sent to the client. Hop-by-hop headers are never sent, and Content-Length,
+// Date, and Server are managed by Lorica.
+func proxiableHeaders() []string {
+	var names []string
+	for _, name := range splitList(*proxiedHeaders) {
+		name = http.CanonicalHeaderKey(name)
+		if name == "Content-Length" || name == "Date" ||
+			(name == "Server" && *serverHeader) || (name == "X-Powered-By" && *hidePoweredBy) {
+			continue
+		}
+		hopByHop := false
+		for _, hopByHopName := range hopByHopHeaders {
+			if name == hopByHopName {
+				hopByHop = true
+			}
+		}
+		if !hopByHop {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// copyHeaders adds the values of the named headers in src to dst.
+func copyHeaders(dst, src http.Header, names []string) {
+	for _, name := range names {
+		for _, value := range src[http.CanonicalHeaderKey(name)] {
+			dst.Add(name, value)
+		}
+	}
+}
+
+// serverValue returns the value of the Server header Lorica adds to responses.
+func serverValue() string {
+	return "lorica/" + version
+}
+
+// identifyResponses is a middleware which adds the Date header, and the
+// Server header if it is enabled, to every response, including the ones
+// Lorica makes itself.
+func identifyResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", time.Now().UTC().Format(http.TimeFormat))
+		if *serverHeader {
+			w.Header().Set("Server", serverValue())
+		}
+		next.ServeHTTP(w, r)
+	})
+}
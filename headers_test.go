@@ -0,0 +1,180 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Hop-by-hop headers, and headers named in Connection, should be removed.
+func TestRemoveHopByHopHeaders(t *testing.T) {
+	h := http.Header{}
+	h.Set("Connection", "close, X-Private")
+	h.Set("Keep-Alive", "timeout=5")
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("Te", "trailers")
+	h.Set("Upgrade", "h2c")
+	h.Set("X-Private", "secret")
+	h.Set("Content-Type", "application/json")
+	h.Set("Accept", "application/json")
+
+	removeHopByHopHeaders(h)
+
+	for _, name := range []string{"Connection", "Keep-Alive", "Transfer-Encoding", "Te", "Upgrade", "X-Private"} {
+		if h.Get(name) != "" {
+			t.Errorf("Header %v should have been removed.", name)
+		}
+	}
+	for _, name := range []string{"Content-Type", "Accept"} {
+		if h.Get(name) == "" {
+			t.Errorf("Header %v should not have been removed.", name)
+		}
+	}
+}
+
+// Changing a cloned header shouldn't change the original.
+func TestCloneHeader(t *testing.T) {
+	h := http.Header{}
+	h.Add("Accept", "application/json")
+	clone := cloneHeader(h)
+	clone.Add("Accept", "text/html")
+	clone.Set("Connection", "close")
+
+	if len(h["Accept"]) != 1 || h.Get("Connection") != "" {
+		t.Errorf("Changing the clone changed the original: %v", h)
+	}
+}
+
+// The Via header should have the client's protocol version.
+func TestViaValue(t *testing.T) {
+	r, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := viaValue(r); v != "1.1 lorica" {
+		t.Errorf("Via header had %v, expected 1.1 lorica.", v)
+	}
+	r.ProtoMajor, r.ProtoMinor = 2, 0
+	if v := viaValue(r); v != "2 lorica" {
+		t.Errorf("Via header had %v, expected 2 lorica.", v)
+	}
+}
+
+// The Forwarded header should have the client's address, protocol, and host,
+// quoted when they aren't tokens.
+func TestForwardedValue(t *testing.T) {
+	r, err := http.NewRequest("GET", "http://lorica.example.org/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.RemoteAddr = "192.0.2.60:4711"
+
+	expected := "for=192.0.2.60;proto=http;host=lorica.example.org"
+	if v := forwardedValue(r); v != expected {
+		t.Errorf("Forwarded header had %v, expected %v.", v, expected)
+	}
+
+	r.RemoteAddr = "[2001:db8:cafe::17]:4711"
+	r.Host = "lorica.example.org:8877"
+	expected = "for=\"[2001:db8:cafe::17]\";proto=http;host=\"lorica.example.org:8877\""
+	if v := forwardedValue(r); v != expected {
+		t.Errorf("Forwarded header had %v, expected %v.", v, expected)
+	}
+
+	r.Host = `lorica"example\org`
+	expected = `for="[2001:db8:cafe::17]";proto=http;host="lorica\"example\\org"`
+	if v := forwardedValue(r); v != expected {
+		t.Errorf("Forwarded header had %v, expected %v.", v, expected)
+	}
+}
+
+// Lorica's Via and Forwarded elements are appended to the client's.
+func TestAppendToChain(t *testing.T) {
+	client := http.Header{}
+	client.Add("Via", "1.1 campus-proxy")
+	client.Add("Forwarded", "for=198.51.100.17")
+	client.Add("Forwarded", "for=192.0.2.43")
+	dst := http.Header{}
+	appendToChain(dst, client, "Via", "1.1 lorica")
+	appendToChain(dst, client, "forwarded", "for=192.0.2.60")
+	if v := strings.Join(dst["Via"], ", "); v != "1.1 campus-proxy, 1.1 lorica" {
+		t.Errorf("Via header had %v.", v)
+	}
+	if v := strings.Join(dst["Forwarded"], ", "); v != "for=198.51.100.17, for=192.0.2.43, for=192.0.2.60" {
+		t.Errorf("Forwarded header had %v.", v)
+	}
+	if len(client["Forwarded"]) != 2 {
+		t.Errorf("The client's header was changed: %v", client)
+	}
+}
+
+// Lists should be split on the ; character, dropping empty items.
+func TestSplitList(t *testing.T) {
+	items := splitList(" a; b ;;c;")
+	if len(items) != 3 || items[0] != "a" || items[1] != "b" || items[2] != "c" {
+		t.Errorf("List split incorrectly: %#v", items)
+	}
+	if items := splitList(""); len(items) != 0 {
+		t.Errorf("Empty list split incorrectly: %#v", items)
+	}
+}
+
+// Headers Lorica sets itself shouldn't be forwardable.
+func TestForwardableHeaders(t *testing.T) {
+	oldForwardHeaders := *forwardHeaders
+	*forwardHeaders = "x-campus;Authorization;X-Consortium;x-summon-date;Accept-Language"
+	defer func() { *forwardHeaders = oldForwardHeaders }()
+
+	names := forwardableHeaders()
+	if len(names) != 2 || names[0] != "X-Campus" || names[1] != "X-Consortium" {
+		t.Errorf("Wrong forwardable headers: %#v", names)
+	}
+}
+
+// Hop-by-hop headers and Content-Length shouldn't be proxiable.
+func TestProxiableHeaders(t *testing.T) {
+	oldProxiedHeaders := *proxiedHeaders
+	*proxiedHeaders = "content-type;Content-Language;Connection;Content-Length;X-Summon-Diagnostic;Date;Server;X-Powered-By"
+	defer func() { *proxiedHeaders = oldProxiedHeaders }()
+
+	names := proxiableHeaders()
+	expected := []string{"Content-Type", "Content-Language", "X-Summon-Diagnostic"}
+	if len(names) != len(expected) {
+		t.Fatalf("Wrong proxiable headers: %#v", names)
+	}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Errorf("Wrong proxiable headers: %#v", names)
+		}
+	}
+}
+
+// Every response gets a Date header, and a Server header unless it is disabled.
+func TestIdentifyResponses(t *testing.T) {
+	oldServerHeader := *serverHeader
+	defer func() { *serverHeader = oldServerHeader }()
+	handler := identifyResponses(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sendError(w, http.StatusNotFound, "Not found.")
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if _, err := http.ParseTime(w.Header().Get("Date")); err != nil {
+		t.Errorf("Bad Date header %q", w.Header().Get("Date"))
+	}
+	if w.Header().Get("Server") != "lorica/"+version {
+		t.Errorf("Server header was %q", w.Header().Get("Server"))
+	}
+
+	*serverHeader = false
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Header().Get("Server") != "" {
+		t.Errorf("Server header was sent when disabled, got %q", w.Header().Get("Server"))
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	l "github.com/cu-library/lorica/loglevel"
+	"github.com/cu-library/lorica/metrics"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	healthCheckInterval = flag.Duration("healthcheckinterval", 0, "How often to send a small signed search to "+
+		"the Summon API, to check it is up. The result is reported by /readyz and the lorica_upstream_up metric. "+
+		"0 disables the checks, and /readyz only reports that Lorica is serving requests.")
+
+	// upstreamHealth holds the result of the last check of the Summon API.
+	upstreamHealth = &healthChecker{check: checkUpstream, now: time.Now}
+
+	_ = metrics.NewCollectorFunc("upstream_health", func(w io.Writer) {
+		checked, err := upstreamHealth.Status()
+		if checked.IsZero() {
+			return
+		}
+		up := 1.0
+		if err != nil {
+			up = 0
+		}
+		metrics.WriteGauge(w, "lorica_upstream_up", "Whether the last check of the Summon API succeeded.", up)
+		metrics.WriteGauge(w, "lorica_upstream_last_check_timestamp_seconds",
+			"When the Summon API was last checked, in seconds since the epoch.", float64(checked.Unix()))
+	})
+)
+
+// checkUpstream sends a small signed search to the active Summon API URL,
+// with the default credentials.
+func checkUpstream() error {
+	_, err := checkCredentials(upstreamClientWithTimeout(*timeout), upstreams.URL(), defaultCredentials())
+	return err
+}
+
+// healthChecker periodically checks the Summon API, and keeps the result.
+type healthChecker struct {
+	sync.Mutex
+	checked time.Time
+	err     error
+	check   func() error
+	now     func() time.Time
+}
+
+// Check checks the Summon API and records the result. Changes are logged.
+func (h *healthChecker) Check() {
+	err := h.check()
+
+	h.Lock()
+	defer h.Unlock()
+	switch {
+	case err != nil && (h.err == nil || h.checked.IsZero()):
+		l.Logf(l.WarnMessage, "The Summon API health check failed: %v.", err)
+	case err == nil && h.err != nil:
+		l.Log(l.InfoMessage, "The Summon API health check is passing again.")
+	}
+	h.checked, h.err = h.now(), err
+}
+
+// Status returns when the Summon API was last checked, and the error if the
+// check failed. The time is zero if it hasn't been checked.
+func (h *healthChecker) Status() (time.Time, error) {
+	h.Lock()
+	defer h.Unlock()
+	return h.checked, h.err
+}
+
+// readyzHandler reports whether Lorica is ready to serve requests. It is
+// ready unless the last check of the Summon API failed.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	checked, err := upstreamHealth.Status()
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "The Summon API health check at %v failed: %v.\n", checked.UTC().Format(time.RFC3339), err)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
@@ -0,0 +1,60 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"github.com/cu-library/lorica/internal/summonmock"
+	"github.com/cu-library/lorica/metrics"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The readiness endpoint and metrics follow the last check of the Summon API.
+func TestUpstreamHealth(t *testing.T) {
+	mock, stop := startSummonMock()
+	defer stop()
+
+	oldUpstreamHealth := upstreamHealth
+	upstreamHealth = &healthChecker{check: checkUpstream, now: time.Now}
+	defer func() { upstreamHealth = oldUpstreamHealth }()
+
+	ready := func() (int, string) {
+		w := httptest.NewRecorder()
+		readyzHandler(w, httptest.NewRequest("GET", "/readyz", nil))
+		return w.Code, w.Body.String()
+	}
+	upMetric := func() string {
+		w := httptest.NewRecorder()
+		metrics.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+		for _, line := range strings.Split(w.Body.String(), "\n") {
+			if strings.HasPrefix(line, "lorica_upstream_up ") {
+				return line
+			}
+		}
+		return ""
+	}
+
+	if code, _ := ready(); code != http.StatusOK || upMetric() != "" {
+		t.Errorf("Before a check, /readyz returned %v and the metric was %q.", code, upMetric())
+	}
+
+	upstreamHealth.Check()
+	if code, _ := ready(); code != http.StatusOK || upMetric() != "lorica_upstream_up 1" {
+		t.Errorf("After a passing check, /readyz returned %v and the metric was %q.", code, upMetric())
+	}
+	if r := mock.LastRequest(); !r.SignatureOK || r.Query.Get("s.ps") != "1" {
+		t.Errorf("The check sent %v", r)
+	}
+
+	mock.Script(summonmock.JSON(http.StatusServiceUnavailable, `{"errors":[]}`))
+	upstreamHealth.Check()
+	if code, body := ready(); code != http.StatusServiceUnavailable || !strings.Contains(body, "503") ||
+		upMetric() != "lorica_upstream_up 0" {
+		t.Errorf("After a failing check, /readyz returned %v %q and the metric was %q.", code, body, upMetric())
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"github.com/cu-library/lorica/internal/summonmock"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startSummonMock starts a fake Summon API, and points Lorica at it with its credentials.
+// The returned function stops it and restores the options.
+func startSummonMock() (*summonmock.Server, func()) {
+	s := summonmock.New("integration", "integrationkey")
+	oldAPIURL, oldAccessID, oldSecretKey := *apiURL, *accessID, *secretKey
+	*apiURL, *accessID, *secretKey = s.URL, s.AccessID, s.SecretKey
+	return s, func() {
+		*apiURL, *accessID, *secretKey = oldAPIURL, oldAccessID, oldSecretKey
+		s.Close()
+	}
+}
+
+// Requests are signed the way Summon checks them, however the client encoded the query.
+func TestIntegrationSignatures(t *testing.T) {
+	s, stop := startSummonMock()
+	defer stop()
+
+	for _, rawQuery := range []string{
+		"s.q=forest",
+		"s.q=forest+fire",
+		"s.q=forest%20fire",
+		"s.q=%22forest+fire%22",
+		"s.q=caf%C3%A9&s.q=na%C3%AFve",
+		"s.fvf=ContentType,Book&s.fvf=ContentType,Audio",
+		"s.fvf=ContentType%2CBook&s.q=a%26b%3Dc",
+		"s.q=forest&s.light",
+		"s.q=forest&s.pn=",
+		"s.cmd=addFacetValueFilters(ContentType,Journal+Article)",
+		"S.Q=case&s.q=Case",
+		"",
+	} {
+		w := httptest.NewRecorder()
+		proxyHandler(w, httptest.NewRequest("GET", "/2.0.0/search?"+rawQuery, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("Query %q got %v %v", rawQuery, w.Code, w.Body.String())
+		}
+		if !s.LastRequest().SignatureOK {
+			t.Errorf("Query %q had a bad signature.", rawQuery)
+		}
+	}
+
+	// The queries in a batch are signed too.
+	oldBatchMax := *batchMax
+	*batchMax = 10
+	defer func() { *batchMax = oldBatchMax }()
+	sent := len(s.Requests())
+	w := httptest.NewRecorder()
+	withBatch(http.HandlerFunc(proxyHandler)).ServeHTTP(w,
+		httptest.NewRequest("POST", "/batch", strings.NewReader(`["s.q=one+two", "s.q=caf%C3%A9"]`)))
+	if w.Code != http.StatusOK || len(s.Requests()) != sent+2 {
+		t.Fatalf("The batch got %v, and sent %v queries to Summon.", w.Code, len(s.Requests())-sent)
+	}
+	for _, r := range s.Requests()[len(s.Requests())-2:] {
+		if !r.SignatureOK {
+			t.Errorf("Batch query %v had a bad signature.", r.Query)
+		}
+	}
+}
+
+// Client headers are forwarded to Summon, and the configured Summon headers are sent back.
+func TestIntegrationHeaders(t *testing.T) {
+	s, stop := startSummonMock()
+	defer stop()
+	oldProxiedHeaders := *proxiedHeaders
+	*proxiedHeaders = "Content-Type;X-Summon-Diagnostic"
+	defer func() { *proxiedHeaders = oldProxiedHeaders }()
+
+	s.Script(summonmock.Response{
+		Status: http.StatusOK,
+		Header: http.Header{
+			"Content-Type":        {"application/json"},
+			"X-Summon-Diagnostic": {"node-3"},
+			"X-Summon-Internal":   {"secret"},
+		},
+		Body: `{"recordCount":0}`,
+	})
+
+	r := httptest.NewRequest("GET", "/2.0.0/search?s.q=forest", nil)
+	r.Header.Set("Accept", "application/json")
+	r.Header.Set("Accept-Language", "fr-CA")
+	r.Header.Set("x-summon-session-id", "session-1")
+	r.Header.Set("Connection", "X-Private")
+	r.Header.Set("X-Private", "private")
+	w := httptest.NewRecorder()
+	proxyHandler(w, r)
+
+	sent := s.LastRequest()
+	if !sent.SignatureOK {
+		t.Error("The request had a bad signature.")
+	}
+	for name, value := range map[string]string{
+		"Accept":              "application/json",
+		"Accept-Language":     "fr-CA",
+		"X-Summon-Session-Id": "session-1",
+		"X-Private":           "",
+	} {
+		if sent.Header.Get(name) != value {
+			t.Errorf("Summon got %v header %q, expected %q", name, sent.Header.Get(name), value)
+		}
+	}
+	if sent.Header.Get("x-summon-date") == "" {
+		t.Error("Summon didn't get an x-summon-date header.")
+	}
+
+	for name, value := range map[string]string{
+		"Content-Type":        "application/json",
+		"X-Summon-Diagnostic": "node-3",
+		"X-Summon-Internal":   "",
+	} {
+		if w.Header().Get(name) != value {
+			t.Errorf("The client got %v header %q, expected %q", name, w.Header().Get(name), value)
+		}
+	}
+}
+
+// Summon's errors are sent to the client as Lorica's structured errors.
+func TestIntegrationErrorTranslation(t *testing.T) {
+	s, stop := startSummonMock()
+	defer stop()
+
+	for _, c := range []struct {
+		response summonmock.Response
+		code     string
+		message  string
+	}{
+		{summonmock.JSON(http.StatusBadRequest, `{"errors":[{"code":"parse","message":"Unbalanced parentheses."}]}`),
+			ErrorSummonBadQuery, "Summon said: Unbalanced parentheses."},
+		{summonmock.JSON(http.StatusNotFound, `{"message":"No such record."}`),
+			ErrorSummonNotFound, "Summon said: No such record."},
+		{summonmock.Response{Status: http.StatusServiceUnavailable, Body: "<html>down</html>"},
+			ErrorSummonUnavailable, "The Summon API is unavailable."},
+		{summonmock.JSON(http.StatusOK, `[]`),
+			ErrorSummonBadResponse, "couldn't be understood"},
+	} {
+		s.Script(c.response)
+		w := httptest.NewRecorder()
+		proxyHandler(w, httptest.NewRequest("GET", "/2.0.0/search?s.q=forest", nil))
+		var body jsonError
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("The error for a %v wasn't JSON: %v", c.response.Status, w.Body.String())
+		}
+		if body.Code != c.code || !strings.Contains(body.Message, c.message) {
+			t.Errorf("A %v from Summon got %v %+v", c.response.Status, w.Code, body)
+		}
+	}
+
+	// Summon's message about bad credentials isn't sent to the client.
+	*secretKey = "wrongkey"
+	w := httptest.NewRecorder()
+	proxyHandler(w, httptest.NewRequest("GET", "/2.0.0/search?s.q=forest", nil))
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), ErrorSummonAuth) ||
+		strings.Contains(w.Body.String(), "signature") {
+		t.Errorf("Bad credentials got %v %v", w.Code, w.Body.String())
+	}
+}
+
+// Slow responses from Summon are abandoned after the timeout.
+func TestIntegrationTimeout(t *testing.T) {
+	s, stop := startSummonMock()
+	defer stop()
+	oldTimeout := *timeout
+	*timeout = 50 * time.Millisecond
+	defer func() { *timeout = oldTimeout }()
+
+	s.Script(summonmock.Response{Status: http.StatusOK, Body: `{}`, Delay: 5 * time.Second})
+	start := time.Now()
+	w := httptest.NewRecorder()
+	proxyHandler(w, httptest.NewRequest("GET", "/2.0.0/search?s.q=forest", nil))
+	if w.Code != http.StatusGatewayTimeout || !strings.Contains(w.Body.String(), ErrorUpstreamTimeout) {
+		t.Errorf("A slow response got %v %v", w.Code, w.Body.String())
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("The request took %v, longer than the timeout.", elapsed)
+	}
+}
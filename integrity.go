@@ -0,0 +1,84 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	l "github.com/cu-library/lorica/loglevel"
+	"github.com/cu-library/lorica/metrics"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// DefaultMaxResponseSize is the default most bytes of a Summon API response body which is read in full.
+const DefaultMaxResponseSize = 16 << 20
+
+var (
+	maxResponseSize = flag.Int("maxresponsesize", DefaultMaxResponseSize, "The most bytes of a Summon API "+
+		"response body which Lorica reads in full, like a successful search. Larger responses get a 502 response.")
+	sendDigest = flag.Bool("digest", false, "Add a Digest header with the SHA-256 of the body to proxied responses, "+
+		"so caches and archivers can detect truncated responses. Bodies which are streamed get it as a trailer.")
+
+	truncatedResponsesTotal = metrics.NewCounterVec("lorica_truncated_responses_total",
+		"The number of Summon API responses whose body was shorter or longer than their Content-Length, "+
+			"or ended with an error.", "endpoint")
+)
+
+// bodyDigest returns the value of the Digest header for the body, like SHA-256=base64.
+func bodyDigest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "SHA-256=" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// readUpstreamBody reads a Summon API response body in full, and closes it.
+// Bodies larger than -maxresponsesize are an error.
+func readUpstreamBody(resp *http.Response) ([]byte, error) {
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, int64(*maxResponseSize)+1))
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > *maxResponseSize {
+		return nil, fmt.Errorf("the body is larger than the maximum of %v bytes", *maxResponseSize)
+	}
+	return body, nil
+}
+
+// checkBodyLength returns an error if the body isn't as long as the Content-Length.
+// A negative Content-Length means it isn't known.
+func checkBodyLength(length int, contentLength int64) error {
+	if contentLength >= 0 && int64(length) != contentLength {
+		return fmt.Errorf("the body was %v bytes, but the Content-Length was %v", length, contentLength)
+	}
+	return nil
+}
+
+// streamBody copies the Summon API's response body to the client. If the
+// digest is enabled, it is sent as a trailer, unless the body was truncated.
+// The status code and headers must not have been written yet.
+func streamBody(w http.ResponseWriter, r *http.Request, apiResp *http.Response) {
+	hash := sha256.New()
+	if *sendDigest {
+		w.Header().Set("Trailer", "Digest")
+	}
+	w.WriteHeader(apiResp.StatusCode)
+	n, err := io.Copy(io.MultiWriter(w, hash), apiResp.Body)
+	apiResp.Body.Close()
+	if err == nil {
+		err = checkBodyLength(int(n), apiResp.ContentLength)
+	}
+	if err != nil {
+		truncatedResponsesTotal.With(endpointLabel(r.URL.Path)).Inc()
+		l.Logf(l.ErrorMessage, "Truncated response from Summon API for request %v: %v", getRequestInfo(r).id, err)
+		return
+	}
+	if *sendDigest {
+		w.Header().Set("Digest", "SHA-256="+base64.StdEncoding.EncodeToString(hash.Sum(nil)))
+	}
+}
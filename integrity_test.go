@@ -0,0 +1,87 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBodyDigest(t *testing.T) {
+	if digest := bodyDigest([]byte("abc")); digest != "SHA-256=ungWv48Bz+pBQUDeXa4iI7ADYaOWF3qctBD/YfIAFa0=" {
+		t.Errorf("Digest was %v", digest)
+	}
+	if checkBodyLength(3, 3) != nil || checkBodyLength(3, -1) != nil || checkBodyLength(2, 3) == nil {
+		t.Error("Body lengths weren't checked against the Content-Length.")
+	}
+}
+
+// Streamed bodies get the digest as a trailer, unless they were truncated.
+func TestStreamBody(t *testing.T) {
+	oldSendDigest := *sendDigest
+	*sendDigest = true
+	defer func() { *sendDigest = oldSendDigest }()
+
+	for _, c := range []struct {
+		contentLength int64
+		digest        string
+	}{
+		{3, "SHA-256=ungWv48Bz+pBQUDeXa4iI7ADYaOWF3qctBD/YfIAFa0="},
+		{-1, "SHA-256=ungWv48Bz+pBQUDeXa4iI7ADYaOWF3qctBD/YfIAFa0="},
+		{10, ""},
+	} {
+		apiResp := &http.Response{
+			StatusCode:    http.StatusCreated,
+			ContentLength: c.contentLength,
+			Body:          ioutil.NopCloser(strings.NewReader("abc")),
+		}
+		w := httptest.NewRecorder()
+		streamBody(w, httptest.NewRequest("GET", "/2.0.0/search", nil), apiResp)
+		result := w.Result()
+		if result.StatusCode != http.StatusCreated || w.Body.String() != "abc" {
+			t.Errorf("Response was %v %v", result.StatusCode, w.Body.String())
+		}
+		if digest := result.Trailer.Get("Digest"); digest != c.digest {
+			t.Errorf("Content-Length %v had digest trailer %q, expected %q.", c.contentLength, digest, c.digest)
+		}
+	}
+}
+
+// Successful responses get a Digest header.
+func TestProxyHandlerDigest(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("abc"))
+	}))
+	defer ts.Close()
+	oldAPIURL, oldSendDigest := *apiURL, *sendDigest
+	*apiURL, *sendDigest = ts.URL, true
+	defer func() { *apiURL, *sendDigest = oldAPIURL, oldSendDigest }()
+
+	w := httptest.NewRecorder()
+	proxyHandler(w, httptest.NewRequest("GET", "/2.0.0/search?s.q=test", nil))
+	if w.Header().Get("Digest") != bodyDigest([]byte("abc")) {
+		t.Errorf("Digest header was %q", w.Header().Get("Digest"))
+	}
+}
+
+// Successful responses larger than -maxresponsesize aren't read in full.
+func TestProxyHandlerMaxResponseSize(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("a", 100)))
+	}))
+	defer ts.Close()
+	oldAPIURL, oldMaxResponseSize := *apiURL, *maxResponseSize
+	*apiURL, *maxResponseSize = ts.URL, 10
+	defer func() { *apiURL, *maxResponseSize = oldAPIURL, oldMaxResponseSize }()
+
+	w := httptest.NewRecorder()
+	proxyHandler(w, httptest.NewRequest("GET", "/2.0.0/search?s.q=test", nil))
+	if w.Code != http.StatusBadGateway || strings.Contains(w.Body.String(), "aaaaaaaaaaa") {
+		t.Errorf("A response larger than the maximum got %v %v", w.Code, w.Body.String())
+	}
+}
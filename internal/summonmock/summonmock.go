@@ -0,0 +1,163 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+// Package summonmock provides a fake Summon API for tests. It checks the
+// signature of every request the way Summon does, records what it was sent,
+// and answers with a script of responses.
+package summonmock
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Response is a scripted response from the fake Summon API.
+type Response struct {
+	Status int
+	Header http.Header
+	Body   string
+
+	// Delay is how long the response is held before it is sent.
+	Delay time.Duration
+}
+
+// Request is a request the fake Summon API received.
+type Request struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Header http.Header
+
+	// SignatureOK is true if the Authorization header was signed with the
+	// server's credentials. Requests with a bad signature get a 401.
+	SignatureOK bool
+}
+
+// Server is a fake Summon API.
+type Server struct {
+	*httptest.Server
+	AccessID  string
+	SecretKey string
+
+	mu       sync.Mutex
+	script   []Response
+	requests []Request
+}
+
+// New starts a fake Summon API which accepts requests signed with the credentials.
+// Until responses are scripted, it answers every request with an empty search.
+func New(accessID, secretKey string) *Server {
+	s := &Server{AccessID: accessID, SecretKey: secretKey}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Script sets the responses to the next requests, in order. The last
+// response is repeated for any requests after it.
+func (s *Server) Script(responses ...Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.script = responses
+}
+
+// Requests returns the requests received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	requests := make([]Request, len(s.requests))
+	copy(requests, s.requests)
+	return requests
+}
+
+// LastRequest returns the most recent request received. It panics if there hasn't been one.
+func (s *Server) LastRequest() Request {
+	requests := s.Requests()
+	return requests[len(requests)-1]
+}
+
+// next returns the next scripted response.
+func (s *Server) next() Response {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.script) == 0 {
+		return JSON(http.StatusOK, `{"recordCount":0,"documents":[]}`)
+	}
+	response := s.script[0]
+	if len(s.script) > 1 {
+		s.script = s.script[1:]
+	}
+	return response
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	expected := Authorization(s.AccessID, s.SecretKey, r.Header.Get("Accept"),
+		r.Header.Get("x-summon-date"), r.Host, r.URL.Path, r.URL.Query())
+	request := Request{
+		Method:      r.Method,
+		Path:        r.URL.Path,
+		Query:       r.URL.Query(),
+		Header:      r.Header,
+		SignatureOK: r.Header.Get("Authorization") == expected,
+	}
+	s.mu.Lock()
+	s.requests = append(s.requests, request)
+	s.mu.Unlock()
+
+	if !request.SignatureOK {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"errors":[{"code":"unauthorized","message":"The signature doesn't match."}]}`)
+		return
+	}
+
+	response := s.next()
+	if response.Delay > 0 {
+		select {
+		case <-time.After(response.Delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	for name, values := range response.Header {
+		w.Header()[name] = values
+	}
+	if response.Status == 0 {
+		response.Status = http.StatusOK
+	}
+	w.WriteHeader(response.Status)
+	fmt.Fprint(w, response.Body)
+}
+
+// JSON returns a scripted JSON response.
+func JSON(status int, body string) Response {
+	return Response{Status: status, Header: http.Header{"Content-Type": {"application/json"}}, Body: body}
+}
+
+// Authorization returns the Authorization header Summon expects for a request,
+// following Summon's documentation: the Accept header, the x-summon-date header,
+// the host, the path, and the decoded query parameters, sorted and joined with &,
+// each followed by a newline, signed with HMAC-SHA1. It is written separately
+// from Lorica's signing code, so each can check the other.
+func Authorization(accessID, secretKey, accept, date, host, path string, query url.Values) string {
+	var params []string
+	for key, values := range query {
+		for _, value := range values {
+			params = append(params, key+"="+value)
+		}
+	}
+	sort.Strings(params)
+	id := accept + "\n" + date + "\n" + host + "\n" + path + "\n" + strings.Join(params, "&") + "\n"
+	mac := hmac.New(sha1.New, []byte(secretKey))
+	mac.Write([]byte(id))
+	return "Summon " + accessID + ";" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,71 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package summonmock
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestAuthorization(t *testing.T) {
+	// Repeated keys are sorted by value, and values are signed decoded.
+	// The expected header was computed separately, with Python's hmac module.
+	query := url.Values{"s.q": {"forest fire"}, "s.fvf": {"ContentType,Book", "ContentType,Audio"}}
+	header := Authorization("id", "key", "application/json", "Tue, 30 Jun 2009 12:10:24 GMT",
+		"api.summon.serialssolutions.com", "/2.0.0/search", query)
+	if header != "Summon id;53FeJ9wXCHqXQZc7WZM2Y3EBZas=" {
+		t.Errorf("Authorization was %v", header)
+	}
+}
+
+func get(t *testing.T, s *Server, path string, query url.Values, secretKey string) *http.Response {
+	u := s.URL + path + "?" + query.Encode()
+	r, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	date := "Tue, 30 Jun 2009 12:10:24 GMT"
+	r.Header.Set("Accept", "application/json")
+	r.Header.Set("x-summon-date", date)
+	r.Header.Set("Authorization", Authorization(s.AccessID, secretKey, "application/json", date, r.URL.Host, path, query))
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestServer(t *testing.T) {
+	s := New("id", "key")
+	defer s.Close()
+	s.Script(JSON(http.StatusOK, `{"recordCount":1}`), JSON(http.StatusBadRequest, `{"errors":[]}`))
+
+	for _, c := range []struct {
+		secretKey string
+		status    int
+		body      string
+	}{
+		{"key", http.StatusOK, `{"recordCount":1}`},
+		{"wrong", http.StatusUnauthorized, ""},
+		{"key", http.StatusBadRequest, `{"errors":[]}`},
+		{"key", http.StatusBadRequest, `{"errors":[]}`},
+	} {
+		resp := get(t, s, "/2.0.0/search", url.Values{"s.q": {"forest"}}, c.secretKey)
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != c.status || (c.body != "" && string(body) != c.body) {
+			t.Errorf("Got %v %s, expected %v %v", resp.StatusCode, body, c.status, c.body)
+		}
+	}
+	requests := s.Requests()
+	if len(requests) != 4 || !requests[0].SignatureOK || requests[1].SignatureOK {
+		t.Errorf("Requests were %v", requests)
+	}
+	if s.LastRequest().Query.Get("s.q") != "forest" {
+		t.Errorf("The last request was %v", s.LastRequest())
+	}
+}
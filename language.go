@@ -0,0 +1,83 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var injectLanguages = flag.String("injectlanguages", "", "A list of languages, delimited by the ; character, "+
+	"which can be requested from Summon with the s.l parameter. If the client doesn't set s.l, the most preferred "+
+	"language in the client's Accept-Language header which is in this list is used. If empty, s.l is never added.")
+
+// languagePreference is one language range from an Accept-Language header.
+type languagePreference struct {
+	tag     string
+	quality float64
+}
+
+// parseAcceptLanguage returns the language ranges in an Accept-Language header,
+// most preferred first. Ranges with a quality of 0 are dropped.
+func parseAcceptLanguage(header string) []languagePreference {
+	var prefs []languagePreference
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if tag == "" {
+			continue
+		}
+		quality := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+				if err != nil {
+					q = 0
+				}
+				quality = q
+			}
+		}
+		if quality > 0 {
+			prefs = append(prefs, languagePreference{tag: tag, quality: quality})
+		}
+	}
+	sort.SliceStable(prefs, func(i, j int) bool { return prefs[i].quality > prefs[j].quality })
+	return prefs
+}
+
+// preferredLanguage returns the client's most preferred language which is
+// supported, matching on the primary subtag, so fr-CA matches fr.
+func preferredLanguage(acceptLanguage string, supported []string) (string, bool) {
+	for _, pref := range parseAcceptLanguage(acceptLanguage) {
+		primary := strings.SplitN(pref.tag, "-", 2)[0]
+		for _, language := range supported {
+			if strings.ToLower(language) == primary {
+				return language, true
+			}
+		}
+	}
+	return "", false
+}
+
+// injectLanguage adds the s.l parameter to the query, based on the
+// Accept-Language header, unless the client already set it.
+func injectLanguage(apiRequestURL *url.URL, acceptLanguage string) {
+	supported := splitList(*injectLanguages)
+	if len(supported) == 0 || acceptLanguage == "" {
+		return
+	}
+	query := apiRequestURL.Query()
+	if query.Get("s.l") != "" {
+		return
+	}
+	if language, ok := preferredLanguage(acceptLanguage, supported); ok {
+		query.Set("s.l", language)
+		apiRequestURL.RawQuery = query.Encode()
+	}
+}
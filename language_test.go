@@ -0,0 +1,65 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+// Accept-Language headers should be sorted by quality.
+func TestParseAcceptLanguage(t *testing.T) {
+	prefs := parseAcceptLanguage("en-US;q=0.8, fr-CA, de;q=0, es;q=0.9")
+	expected := []string{"fr-ca", "es", "en-us"}
+	if len(prefs) != len(expected) {
+		t.Fatalf("Parsed Accept-Language incorrectly: %#v", prefs)
+	}
+	for i, tag := range expected {
+		if prefs[i].tag != tag {
+			t.Errorf("Expected %v at position %v, got %v.", tag, i, prefs[i].tag)
+		}
+	}
+}
+
+// The most preferred supported language should be chosen.
+func TestPreferredLanguage(t *testing.T) {
+	supported := []string{"en", "fr"}
+	for header, expected := range map[string]string{
+		"fr-CA,fr;q=0.9,en;q=0.8": "fr",
+		"de,en-GB;q=0.5":          "en",
+		"de":                      "",
+		"":                        "",
+	} {
+		language, _ := preferredLanguage(header, supported)
+		if language != expected {
+			t.Errorf("Preferred language for %v was %v, expected %v.", header, language, expected)
+		}
+	}
+}
+
+// s.l should only be added when it is configured, and the client didn't set it.
+func TestInjectLanguage(t *testing.T) {
+	oldInjectLanguages := *injectLanguages
+	defer func() { *injectLanguages = oldInjectLanguages }()
+
+	u, _ := url.Parse("http://api.summon.serialssolutions.com/2.0.0/search?s.q=forest")
+	*injectLanguages = ""
+	injectLanguage(u, "fr-CA")
+	if u.Query().Get("s.l") != "" {
+		t.Error("s.l was added without -injectlanguages set.")
+	}
+
+	*injectLanguages = "en;fr"
+	injectLanguage(u, "fr-CA")
+	if u.Query().Get("s.l") != "fr" || u.Query().Get("s.q") != "forest" {
+		t.Errorf("s.l wasn't added properly, got query %v.", u.RawQuery)
+	}
+
+	u, _ = url.Parse("http://api.summon.serialssolutions.com/2.0.0/search?s.q=forest&s.l=en")
+	injectLanguage(u, "fr-CA")
+	if u.Query().Get("s.l") != "en" {
+		t.Errorf("s.l set by the client was replaced, got query %v.", u.RawQuery)
+	}
+}
@@ -9,8 +9,11 @@ package loglevel
 import (
 	"fmt"
 	"log"
+	"path"
+	"runtime"
 	"strings"
 	"sync"
+	"time"
 )
 
 // LogLevel defines a hirarchy of levels for classifing
@@ -37,6 +40,23 @@ var logLevelToString = map[LogLevel]string{
 var logMessageLevel = ErrorMessage
 var logMessageLevelMutex = new(sync.RWMutex)
 
+// Record is a log message sent to subscribers.
+type Record struct {
+	Time      time.Time `json:"time"`
+	Level     LogLevel  `json:"-"`
+	Component string    `json:"component"`
+	Message   string    `json:"message"`
+}
+
+// subscriber receives the records at or below its level.
+type subscriber struct {
+	level   LogLevel
+	records chan Record
+}
+
+var subscribers = make(map[*subscriber]bool)
+var subscribersMutex = new(sync.RWMutex)
+
 // Set the package's message level.
 func Set(level LogLevel) {
 	logMessageLevelMutex.Lock()
@@ -56,6 +76,7 @@ func Log(messagelevel LogLevel, message interface{}) {
 	if messagelevel <= logMessageLevel {
 		log.Printf("%v: %v\n", messagelevel, message)
 	}
+	publish(messagelevel, message)
 }
 
 // Logf is a wrapper around Log(). It first formats the log message
@@ -64,6 +85,54 @@ func Logf(messagelevel LogLevel, format string, a ...interface{}) {
 	Log(messagelevel, fmt.Sprintf(format, a...))
 }
 
+// Subscribe returns a channel which receives every message at or below the
+// level, whatever the package's message level is. Messages are dropped if the
+// channel's buffer is full. The returned function unsubscribes.
+func Subscribe(level LogLevel, buffer int) (<-chan Record, func()) {
+	sub := &subscriber{level: level, records: make(chan Record, buffer)}
+	subscribersMutex.Lock()
+	subscribers[sub] = true
+	subscribersMutex.Unlock()
+	return sub.records, func() {
+		subscribersMutex.Lock()
+		delete(subscribers, sub)
+		subscribersMutex.Unlock()
+	}
+}
+
+// publish sends a message to the subscribers which want it.
+func publish(messagelevel LogLevel, message interface{}) {
+	subscribersMutex.RLock()
+	defer subscribersMutex.RUnlock()
+
+	if len(subscribers) == 0 {
+		return
+	}
+	record := Record{Time: time.Now(), Level: messagelevel, Component: component(), Message: fmt.Sprint(message)}
+	for sub := range subscribers {
+		if messagelevel <= sub.level {
+			select {
+			case sub.records <- record:
+			default:
+			}
+		}
+	}
+}
+
+// component returns the name of the source file which logged the message,
+// without the .go extension.
+func component() string {
+	for skip := 2; ; skip++ {
+		_, file, _, ok := runtime.Caller(skip)
+		if !ok {
+			return ""
+		}
+		if !strings.HasSuffix(file, "/loglevel/loglevel.go") {
+			return strings.TrimSuffix(path.Base(file), ".go")
+		}
+	}
+}
+
 // Return the string representation of the LogLevel.
 func (level LogLevel) String() string {
 	return logLevelToString[level]
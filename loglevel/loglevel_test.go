@@ -112,3 +112,32 @@ func TestLogLevel(t *testing.T) {
 		}
 	}
 }
+
+func TestSubscribe(t *testing.T) {
+	Set(ErrorMessage)
+	records, unsubscribe := Subscribe(DebugMessage, 10)
+
+	Log(DebugMessage, "debug")
+	Logf(TraceMessage, "%v", "trace")
+	select {
+	case record := <-records:
+		if record.Level != DebugMessage || record.Message != "debug" || record.Component != "loglevel_test" {
+			t.Errorf("Got the wrong record: %#v", record)
+		}
+	default:
+		t.Fatal("Subscriber did not receive a message below the package's level.")
+	}
+	select {
+	case record := <-records:
+		t.Errorf("Subscriber received a message above its level: %#v", record)
+	default:
+	}
+
+	unsubscribe()
+	Log(ErrorMessage, "error")
+	select {
+	case record := <-records:
+		t.Errorf("Unsubscribed subscriber received a message: %#v", record)
+	default:
+	}
+}
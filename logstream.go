@@ -0,0 +1,100 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	l "github.com/cu-library/lorica/loglevel"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// LogStreamBuffer is how many log records can wait to be sent to a stream.
+	// Records are dropped while the buffer is full.
+	LogStreamBuffer = 1000
+
+	// LogStreamHeartbeat is the time between comments sent to keep a quiet stream open.
+	LogStreamHeartbeat = 15 * time.Second
+)
+
+// streamedRecord is a log record sent to a stream.
+type streamedRecord struct {
+	Time      time.Time `json:"time"`
+	Level     string    `json:"level"`
+	Component string    `json:"component"`
+	Message   string    `json:"message"`
+}
+
+// logStreamHandler streams log records as server-sent events. The level
+// parameter is the most detailed level sent, INFO by default, and the
+// component parameter is a list of the source files to send records from,
+// like tiers,abuse. The stream closes before the write timeout, and
+// EventSource clients reconnect.
+func logStreamHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		sendJSONError(w, http.StatusInternalServerError, "Streaming is not supported.", nil)
+		return
+	}
+	level := l.InfoMessage
+	if name := r.FormValue("level"); name != "" {
+		var err error
+		level, err = l.ParseLogLevel(name)
+		if err != nil {
+			sendJSONError(w, http.StatusBadRequest, err.Error(), []string{"The levels are ERROR, WARN, INFO, DEBUG, and TRACE."})
+			return
+		}
+	}
+	components := make(map[string]bool)
+	for _, c := range splitList(strings.Replace(r.FormValue("component"), ",", ";", -1)) {
+		components[strings.ToLower(c)] = true
+	}
+
+	var deadline <-chan time.Time
+	if *writeTimeout > time.Second {
+		deadline = time.After(*writeTimeout - time.Second)
+	}
+	heartbeat := time.NewTicker(LogStreamHeartbeat)
+	defer heartbeat.Stop()
+
+	records, unsubscribe := l.Subscribe(level, LogStreamBuffer)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": streaming log records\n\n")
+	flusher.Flush()
+
+	for {
+		select {
+		case record := <-records:
+			if len(components) > 0 && !components[record.Component] {
+				continue
+			}
+			data, err := json.Marshal(streamedRecord{
+				Time:      record.Time,
+				Level:     record.Level.String(),
+				Component: record.Component,
+				Message:   record.Message,
+			})
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			flusher.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+		case <-deadline:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
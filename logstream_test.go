@@ -0,0 +1,67 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	l "github.com/cu-library/lorica/loglevel"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Records from the chosen components, at or below the chosen level, are streamed as events.
+func TestLogStreamHandler(t *testing.T) {
+	ts := httptest.NewServer(newAdminMux())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/admin/logs/stream?level=debug&component=logstream_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Bad Content-Type %v", resp.Header.Get("Content-Type"))
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(10 * time.Millisecond):
+				l.Log(l.TraceMessage, "too detailed")
+				l.Log(l.DebugMessage, "streamed")
+			}
+		}
+	}()
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		if !strings.Contains(line, `"level":"DEBUG"`) || !strings.Contains(line, `"message":"streamed"`) ||
+			!strings.Contains(line, `"component":"logstream_test"`) {
+			t.Errorf("Bad event %v", line)
+		}
+		return
+	}
+	t.Errorf("Stream ended without an event: %v", scanner.Err())
+}
+
+// Unknown levels are rejected.
+func TestLogStreamHandlerBadLevel(t *testing.T) {
+	w := httptest.NewRecorder()
+	newAdminMux().ServeHTTP(w, httptest.NewRequest("GET", "/admin/logs/stream?level=loud", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Bad level not rejected, got %v", w.Code)
+	}
+}
@@ -9,6 +9,7 @@ queries to the Summon API.
 package main
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha1"
 	"encoding/base64"
@@ -16,6 +17,7 @@ import (
 	"fmt"
 	l "github.com/cu-library/lorica/loglevel"
 	"github.com/didip/tollbooth"
+	"github.com/didip/tollbooth/limiter"
 	"io"
 	"log"
 	"net/http"
@@ -28,7 +30,7 @@ import (
 )
 
 const (
-	// EnvPrefix is the prefix for the environment variables.
+	// EnvPrefix is the default prefix for the environment variables.
 	EnvPrefix string = "LORICA_"
 
 	// DefaultAddress is the default address to serve from.
@@ -43,11 +45,17 @@ const (
 	// DefaultMaxAge is the default number of seconds for the Access-Control-Max-Age header.
 	DefaultMaxAge = "604800"
 
-	// DefaultSummonAPITimeout is the number of seconds this service will wait for a response from Summon.
-	DefaultSummonAPITimeout = 10
+	// DefaultSummonAPITimeout is the time this service will wait for a response from Summon.
+	DefaultSummonAPITimeout = 10 * time.Second
 
 	// DefaultMaxRequestsPerSecond is the maximum number of requests that will be processed from one IP in a second.
 	DefaultMaxRequestsPerSecond = 1
+
+	// DefaultMaxQueryLength is the default maximum length of a request's query string.
+	DefaultMaxQueryLength = 4096
+
+	// AllowedMethods is the value of the Allow header when only GET requests are proxied.
+	AllowedMethods = "GET, OPTIONS"
 )
 
 var (
@@ -60,15 +68,24 @@ var (
 	logLevel = flag.String("loglevel", "warn", "The maximum log level which will be logged. "+
 		"error < warn < info < debug < trace. "+
 		"For example, trace will log everything, info will log info, warn, and error.")
-	timeout     = flag.Int("timeout", DefaultSummonAPITimeout, "The number of seconds to wait for a response from Summon.")
+	timeout     = flag.Duration("timeout", DefaultSummonAPITimeout, "The time to wait for a response from Summon, like 10s or 500ms.")
 	rateLimit   = flag.Bool("ratelimit", true, "Enable and disable rate limiting.")
 	maxRequests = flag.Float64("maxrequests", DefaultMaxRequestsPerSecond, "The maximum number of requests accepted from "+
 		"one client per one second interval.")
 	checkProxyHeaders = flag.Bool("checkproxyheaders", false, "Have the rate limiter use the IP address from the "+
 		"X-Forwarded-For and X-Real-IP header first. You may need this if you are running Lorica behind a proxy.")
+	maxQueryLength = flag.Int("maxquerylength", DefaultMaxQueryLength, "The maximum length of a request's query string. "+
+		"Requests with longer query strings are rejected. 0 means no limit.")
+
+	envPrefix = flag.String("envprefix", defaultEnvPrefix, "The prefix for the environment variables. "+
+		"Useful for running several differently configured instances on one host. "+
+		"This option can't be set by an environment variable.")
 
 	// A version flag, which should be overwritten when building using ldflags.
 	version = "devel"
+
+	// The default environment variable prefix, which can be overwritten when building using ldflags.
+	defaultEnvPrefix = EnvPrefix
 )
 
 func init() {
@@ -79,7 +96,9 @@ func init() {
 
 		flag.VisitAll(func(f *flag.Flag) {
 			uppercaseName := strings.ToUpper(f.Name)
-			fmt.Fprintf(os.Stderr, "  %v%v\n", EnvPrefix, uppercaseName)
+			if f.Name != "envprefix" {
+				fmt.Fprintf(os.Stderr, "  %v%v\n", *envPrefix, uppercaseName)
+			}
 		})
 	}
 }
@@ -88,11 +107,49 @@ func main() {
 
 	// Process the flags.
 	flag.Parse()
+	recordFlagSources()
 
 	// If any flags have not been set, see if there are
 	// environment variables that set them.
 	overrideUnsetFlagsFromEnvironmentVariables()
 
+	// Then see if the configuration file sets them.
+	if *configFile != "" {
+		err := overrideUnsetFlagsFromConfigFile(*configFile)
+		if err != nil {
+			log.Fatalf("FATAL: Unable to read configuration file: %v", err)
+		}
+	}
+
+	// Read the credentials from files, like Docker or Kubernetes secrets.
+	if err := readCredentialFiles(); err != nil {
+		log.Fatalf("FATAL: Unable to read credentials: %v", err)
+	}
+
+	// Load the versions of the credentials, which are used instead of -accessid and -secretkey.
+	if err := reloadCredentialVersions(); err != nil {
+		log.Fatalf("FATAL: Unable to load credential versions: %v", err)
+	}
+	if credentialVersions.Len() > 0 && (*accessID != "" || *secretKey != "") {
+		log.Fatal("FATAL: -accessid and -secretkey can't be set with credential versions.")
+	}
+
+	// Size the options which weren't set from the container's CPU and memory limits.
+	if *containerDefaults {
+		containerLimitsRead = readContainerLimits(CgroupRoot)
+		if _, err := applyContainerDefaults(containerLimitsRead); err != nil {
+			log.Fatalf("FATAL: Unable to size options from the container's limits: %v", err)
+		}
+	}
+
+	// Run a command, like config dump, instead of the server.
+	if flag.NArg() > 0 {
+		if err := runCommand(flag.Args()); err != nil {
+			log.Fatalf("FATAL: %v", err)
+		}
+		return
+	}
+
 	// Set the loglevel in the loglevel subpackage
 	level, err := l.ParseLogLevel(*logLevel)
 	if err != nil {
@@ -106,44 +163,364 @@ func main() {
 		log.Fatalf("FATAL: Unable to parse Summon API URL.")
 	}
 
-	// Greet the user.
-	l.Log(l.InfoMessage, "Serving on address: "+*address)
-	l.Log(l.InfoMessage, "Using API URL: "+*apiURL)
-	l.Log(l.InfoMessage, "Allowed Origins for CORS: "+*allowedOrigins)
-	l.Log(l.InfoMessage, "Summon API Timeout: "+strconv.Itoa(*timeout)+" seconds")
+	// Warn if a response from Summon could take longer than the server is allowed to write it.
+	if *writeTimeout != 0 && *writeTimeout <= *timeout {
+		l.Log(l.WarnMessage, "The write timeout is not longer than the Summon API timeout, slow responses will be cut off.")
+	}
+
+	// Pool the connections to the Summon API.
+	upstreamClient = newUpstreamClient()
+
+	// Parse the credentials used for particular path prefixes.
+	credentialPrefixes, err = parseCredentialPrefixes(*credentialPrefixList)
+	if err != nil {
+		log.Fatalf("FATAL: Unable to parse credential prefixes: %v", err)
+	}
+
+	// Pool the extra credentials with the credentials of their profiles.
+	if err := setupCredentialPools(); err != nil {
+		log.Fatalf("FATAL: Unable to set up extra credentials: %v", err)
+	}
+
+	// Make sure requests are signed the way Summon checks them, and optionally
+	// that Summon accepts the credentials, rather than serving 401s all day.
+	if err := selfTestSigning(); err != nil {
+		log.Fatalf("FATAL: Signing self-test failed, Summon would refuse every request: %v", err)
+	}
+	if *verifyCredentials {
+		if err := verifyAllCredentials(upstreamClientWithTimeout(*timeout), *apiURL); err != nil {
+			log.Fatalf("FATAL: Unable to verify credentials: %v", err)
+		}
+	}
+
+	// Parse the upstreams staff can send requests to instead.
+	upstreamOverrides, err = parseUpstreamOverrides(*upstreamOverrideList)
+	if err != nil {
+		log.Fatalf("FATAL: Unable to parse upstream overrides: %v", err)
+	}
+	if len(upstreamOverrides) > 0 {
+		if *adminToken == "" {
+			log.Fatalf("FATAL: Upstream overrides need an admin token, set -admintoken.")
+		}
+	}
+
+	// Protect the admin endpoints with the admin tokens, if there are any.
+	if err := reloadAdminTokens(); err != nil {
+		log.Fatalf("FATAL: Unable to load admin tokens: %v", err)
+	}
+
+	// Parse the facet presets clients can ask for.
+	facetPresets, err = parseFacetPresets(*facetPresetList)
+	if err != nil {
+		log.Fatalf("FATAL: Unable to parse facet presets: %v", err)
+	}
+
+	// Set up the A/B test, if there is an alternate version or profile.
+	if err := setupABTest(); err != nil {
+		log.Fatalf("FATAL: Unable to set up A/B test: %v", err)
+	}
+
+	// Check the pinned version.
+	if err := checkPinnedVersion(); err != nil {
+		log.Fatalf("FATAL: Unable to pin Summon API version: %v", err)
+	}
+
+	// Only the allowed Summon API paths are proxied, if they are listed.
+	if err := checkAllowedPaths(*allowedPaths); err != nil {
+		log.Fatalf("FATAL: Unable to parse allowed paths: %v", err)
+	}
 
 	// If any of the required flags are not set, exit.
-	if *accessID == "" {
+	// The default credentials are optional if there are credential prefixes.
+	defaultCreds := defaultCredentials()
+	if defaultCreds.accessID == "" && len(credentialPrefixes) == 0 {
 		log.Fatal("FATAL: An access ID for the Summon API is required.")
-	} else if *secretKey == "" {
+	} else if defaultCreds.accessID != "" && defaultCreds.secretKey == "" {
 		log.Fatal("FATAL: An secret key for the Summon API is required.")
+	} else if defaultCreds.accessID == "" {
+		l.Log(l.WarnMessage, "No default access ID, only paths with a credential prefix will be proxied.")
+	}
+
+	// Warn about forwarded headers which are ignored because Lorica sets them.
+	if len(forwardableHeaders()) != len(splitList(*forwardHeaders)) {
+		l.Log(l.WarnMessage, "Some headers in -forwardheaders are set by Lorica, and will not be forwarded.")
+	}
+
+	// The periodic jobs are spread out by up to this fraction of their interval.
+	if *jobJitter < 0 || *jobJitter > 1 {
+		log.Fatalf("FATAL: The job jitter %v should be between 0 and 1.", *jobJitter)
+	}
+
+	// Load the allowed origins file, and reload it when it changes.
+	if *allowedOriginsFile != "" {
+		if _, err := fileOrigins.Load(*allowedOriginsFile); err != nil {
+			log.Fatalf("FATAL: Unable to read allowed origins file: %v", err)
+		}
+		jobs.Schedule("origins-file", OriginsFileCheckInterval, false, func() error { return reloadOriginsFile(*allowedOriginsFile) })
 	}
 
-	// Warn if the allowedOrigins flag is empty.
-	if *allowedOrigins == "" {
+	// Group origins into tenants for usage analytics.
+	tenants, err = parseTenants(*tenantList)
+	if err != nil {
+		log.Fatalf("FATAL: Unable to parse tenants: %v", err)
+	}
+
+	// Ask the origin authorization endpoint about origins which aren't listed.
+	if *originAuthURL != "" {
+		originAuth, err = newOriginAuthorizer(*originAuthURL, *originAuthTTL, *timeout)
+		if err != nil {
+			log.Fatalf("FATAL: Unable to parse origin authorization URL: %v", err)
+		}
+	}
+
+	// Warn if there are no allowed origins.
+	if *allowedOrigins == "" && *allowedOriginsFile == "" && *originAuthURL == "" {
 		l.Log(l.WarnMessage, "No Allowed Origins for CORS! No CORS requests will be processed.")
 	}
 
+	// Open the audit log, if there is one.
+	if *auditLogPath != "" {
+		audit, err = openAuditLog(*auditLogPath)
+		if err != nil {
+			log.Fatalf("FATAL: Unable to open audit log: %v", err)
+		}
+		jobs.Schedule("audit-log-purge", RetentionCheckInterval, true, func() error { return purgeAuditLog(audit) })
+	}
+
+	// Open the query log, if there is one.
+	if *queryLogPath != "" {
+		queries, err = openQueryLog(*queryLogPath)
+		if err != nil {
+			log.Fatalf("FATAL: Unable to open query log: %v", err)
+		}
+		jobs.Schedule("query-log-purge", RetentionCheckInterval, true, func() error { return purgeQueryLog(queries) })
+	}
+
+	// Open the evidence log, if there is one.
+	if *evidenceLogPath != "" {
+		evidence, err = openEvidenceLog(*evidenceLogPath)
+		if err != nil {
+			log.Fatalf("FATAL: Unable to open evidence log: %v", err)
+		}
+		jobs.Schedule("evidence-log-purge", RetentionCheckInterval, true, func() error { return purgeEvidenceLog(evidence) })
+	}
+
+	// Start checking the alert rules, if there are any.
+	alerts, err := newAlerterFromFlags()
+	if err != nil {
+		log.Fatalf("FATAL: Unable to parse alert rules: %v", err)
+	}
+	if alerts != nil {
+		jobs.Schedule("alert-rules", AlertCheckInterval, false, func() error { alerts.Check(); return nil })
+	}
+
+	// Purge the CDN in front of Lorica when the cache is purged.
+	purger, err = newCDNPurgerFromFlags()
+	if err != nil {
+		log.Fatalf("FATAL: Unable to set up CDN purges: %v", err)
+	}
+
+	// Measure the regional Summon API endpoints, and send requests to the fastest.
+	if *regionalAPIURLs != "" {
+		// Without a positive interval, the endpoints would be probed in a busy loop.
+		if *regionCheckInterval <= 0 {
+			log.Fatalf("FATAL: The region check interval %v should be more than 0.", *regionCheckInterval)
+		}
+		urls, err := parseRegionalAPIURLs(*regionalAPIURLs)
+		if err != nil {
+			log.Fatalf("FATAL: Unable to parse regional Summon API URLs: %v", err)
+		}
+		regions = newRegionSelector(urls, checkRegion)
+		jobs.Schedule("region-check", *regionCheckInterval, true, func() error { regions.Check(); return nil })
+	}
+
+	// Switch back from a Summon API URL or credentials switched to with the
+	// admin API if errors spike after the switch.
+	jobs.Schedule("upstream-rollback", RollbackCheckInterval, false, func() error {
+		upstreams.checkRollback(*rollbackWindow)
+		return nil
+	})
+
+	// Check the Summon API is up in the background.
+	if *healthCheckInterval > 0 {
+		jobs.Schedule("health-check", *healthCheckInterval, true, func() error { upstreamHealth.Check(); return nil })
+	}
+
+	// Clients on campus networks get the authenticated role.
+	if campusNetworks, err = parseCampusNetworks(*campusNetworkList); err != nil {
+		log.Fatalf("FATAL: Unable to parse campus networks: %v", err)
+	}
+
+	// Some policies might only be reported, to measure their impact before they are enforced.
+	if reportOnly, err = parseReportOnly(*reportOnlyList); err != nil {
+		log.Fatalf("FATAL: Unable to parse report-only policies: %v", err)
+	}
+
+	// Enable the experimental features, and reload them from the configuration file on SIGHUP.
+	if err := features.Set(*featureList); err != nil {
+		log.Fatalf("FATAL: Unable to parse features: %v", err)
+	}
+	if *configFile != "" {
+		go reloadFeaturesOnHangup(*configFile)
+	}
+
+	// Keep cached responses where -cachebackend says, while the cache feature is enabled.
+	cachedResponses, err = newCacheBackend(*cacheBackendName, *cacheURL, *cacheTimeout)
+	if err != nil {
+		log.Fatalf("FATAL: Unable to set up the response cache: %v", err)
+	}
+	if redis, ok := cachedResponses.(*redisCache); ok {
+		if err := redis.Ping(); err != nil {
+			l.Logf(l.WarnMessage, "Unable to reach the Redis cache at %v: %v.", redis.client.address, err)
+		}
+	}
+	if *prefetch {
+		prefetchSlots = make(chan struct{}, *prefetchMaxConcurrent)
+	}
+
+	// Shrink the cache and shed background requests when memory is short.
+	if *memoryLimit > 0 {
+		memoryWatch.limit = *memoryLimit
+		jobs.Schedule("memory-check", MemoryCheckInterval, true, func() error { memoryWatch.Check(); return nil })
+	}
+
 	// HTTP handler. All requests are proxied to the Summon API.
-	if *rateLimit {
-		l.Log(l.InfoMessage, "Rate Limiting Enabled: Max "+strconv.FormatFloat(*maxRequests, 'f', -1, 64)+" request(s) per second.")
-		if *checkProxyHeaders {
-			l.Log(l.InfoMessage, "Using client IP from headers.")
+	if *tarpitDelay > 0 {
+		tarpitSlots = make(chan struct{}, *tarpitMaxConcurrent)
+	}
+
+	// Challenge suspect requests, if there is a shared secret for challenge tokens.
+	if *challengeSecret != "" {
+		c, err := newTokenChallenger(*challengeSecret, *challengeURL, *challengeConditions)
+		if err != nil {
+			log.Fatalf("FATAL: Unable to set up challenges: %v", err)
+		}
+		challengers = append(challengers, c)
+	}
+
+	// Transform successful responses while the transform feature is enabled.
+	if *collapseDuplicates {
+		responseTransforms = append(responseTransforms, duplicateCollapser{})
+	}
+
+	// Bound the requests sent to the Summon API at once, and shed background requests first.
+	if *maxInFlight > 0 {
+		if *backgroundShare < 0 || *backgroundShare > 1 {
+			log.Fatalf("FATAL: The background share %v should be between 0 and 1.", *backgroundShare)
+		}
+		inFlight = newPriorityLimiter(*maxInFlight, *backgroundShare)
+	}
+
+	var handler http.Handler = withDidYouMean(withCount(withPriority(http.HandlerFunc(proxyHandler))))
+	if *abuseDetection {
+		handler = detectAbuse(handler)
+	}
+	if *tierList != "" {
+		// Each client tier has its own rate limit.
+		defaultRate := *maxRequests
+		if !*rateLimit {
+			defaultRate = 0
+		}
+		tiers, err := parseTiers(*tierList, defaultRate)
+		if err != nil {
+			log.Fatalf("FATAL: Unable to parse client tiers: %v", err)
+		}
+		clientTiers = tiers
+		handler = newTierHandler(tiers, handler)
+	} else if *rateLimit {
+		handler = newScheduledHandler(LimiterDefault, limitHandler(LimiterDefault, *maxRequests, handler), handler)
+	} else {
+		handler = newScheduledHandler(LimiterDefault, handler, handler)
+	}
+	// Each query in a batch goes through the rate limits and quotas, like any other search.
+	handler = withBatch(handler)
+
+	// Change the rate limits and quotas during the scheduled time windows,
+	// and reload the schedule from the configuration file on SIGHUP.
+	limiters := []string{LimiterDefault}
+	if len(clientTiers) > 0 {
+		limiters = nil
+		for _, t := range clientTiers {
+			limiters = append(limiters, t.name)
+		}
+	}
+	if err := schedule.Set(*scheduleList, limiters, len(clientTiers) > 0); err != nil {
+		log.Fatalf("FATAL: Unable to parse schedule: %v", err)
+	}
+	if *configFile != "" {
+		go reloadScheduleOnHangup(*configFile, limiters, len(clientTiers) > 0)
+	}
+	// Write a diagnostic dump when Lorica receives a SIGUSR1.
+	go dumpDiagnosticsOnSignal()
+
+	// Reload the configuration file and the TLS certificate when they change, if asked.
+	watcher := &configWatcher{}
+	if *watchConfig && *configFile != "" {
+		tiersEnabled := len(clientTiers) > 0
+		watcher.Watch("configuration", func() error { return reloadConfig(*configFile, limiters, tiersEnabled) }, *configFile)
+	}
+
+	// Serve HTTPS, if there is a TLS certificate.
+	if *tlsCert != "" || *tlsKey != "" {
+		certificates, err := newCertificateStore(*tlsCert, *tlsKey)
+		if err != nil {
+			log.Fatalf("FATAL: %v", err)
+		}
+		serverTLSConfig, err = newTLSConfig(certificates, *tlsMinVersion)
+		if err != nil {
+			log.Fatalf("FATAL: Unable to set up TLS: %v", err)
+		}
+		go reloadCertificatesOnHangup(certificates)
+		if *watchConfig {
+			watcher.Watch("TLS certificate", certificates.Load, *tlsCert, *tlsKey)
 		}
-		limiter := tollbooth.NewLimiter(*maxRequests, nil)
-		if *checkProxyHeaders {
-			limiter.SetIPLookups([]string{"X-Forwarded-For", "X-Real-IP", "RemoteAddr"})
+	}
+	if *adminTokensFile != "" {
+		// Tokens are rotated by editing the file, so it is always watched.
+		watcher.Watch("admin tokens", reloadAdminTokens, *adminTokensFile)
+	}
+	if *credentialVersionsFile != "" {
+		// Credentials are rotated by editing the file too.
+		watcher.Watch("credential versions", reloadCredentialVersions, *credentialVersionsFile)
+	}
+	if *accessIDFile != "" || *secretKeyFile != "" {
+		// So are the credential files, like a Kubernetes Secret mounted as files.
+		var paths []string
+		for _, path := range []string{*accessIDFile, *secretKeyFile} {
+			if path != "" {
+				paths = append(paths, path)
+			}
 		}
-		http.Handle("/", tollbooth.LimitFuncHandler(limiter, proxyHandler))
+		watcher.Watch("credential files", reloadCredentialFiles, paths...)
+	}
+	if len(watcher.files) > 0 {
+		jobs.Schedule("config-watch", ConfigWatchInterval, false, watcher.Check)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", recordResponses(captureBodies(handler)))
+	registerPageHandlers(mux)
+
+	// The metrics, health check, profiling, and admin endpoints are served on their
+	// own address, so they can be firewalled off. Without one, only the metrics
+	// and health check are served, alongside the proxy.
+	if *adminAddress != "" {
+		adminMux := newAdminMux()
+		go func() {
+			log.Fatalf("FATAL: %v", listenAndServe(newServer(*adminAddress, adminMux)))
+		}()
 	} else {
-		l.Log(l.InfoMessage, "Rate Limiting Disabled!")
-		http.HandleFunc("/", proxyHandler)
+		registerPublicAdminHandlers(mux)
 	}
 
+	// Summarize the configuration, addresses, and features in one line, which
+	// deployment automation can check.
+	logStartupSummary()
+
 	// Run the HTTP server. If ListenAndServe returns,
 	// then there was an error.
 	l.Log(l.TraceMessage, "Starting server.")
-	log.Fatalf("FATAL: %v", http.ListenAndServe(*address, nil))
+	log.Fatalf("FATAL: %v", listenAndServe(newServer(*address, mux)))
 }
 
 // proxyHandler is responsible for the duties of a CORS
@@ -157,32 +534,42 @@ func proxyHandler(w http.ResponseWriter, r *http.Request) {
 			// header isn't set, it isn't accepted.
 			preflightRequestMethod := r.Header.Get("Access-Control-Request-Method")
 			if preflightRequestMethod == "" {
-				sendError(w, http.StatusBadRequest,
+				audit.Record(r, AuditBadPreflight, "missing Access-Control-Request-Method")
+				sendJSONErrorCode(w, http.StatusBadRequest, ErrorBadPreflight,
 					"Access-Control-Request-Method header "+
-						"should be set for OPTIONS request.")
+						"should be set for OPTIONS request.", nil)
+				preflightRequestsTotal.With(PreflightRejected).Inc()
 				return
 			}
 			// Otherwise, this is a preflight request.
-			// The Access-Control-Request-Method must be GET.
-			if preflightRequestMethod != "GET" {
-				sendError(w, http.StatusBadRequest,
+			// The Access-Control-Request-Method must be one of the proxied methods.
+			if !methodProxied(preflightRequestMethod) {
+				audit.Record(r, AuditBadPreflight, "Access-Control-Request-Method "+preflightRequestMethod)
+				sendJSONErrorCode(w, http.StatusBadRequest, ErrorBadPreflight,
 					"Access-Control-Request-Method header "+
-						"should only be GET.")
+						"should only be "+proxiedMethodsText("or")+".", nil)
+				preflightRequestsTotal.With(PreflightRejected).Inc()
 				return
 			}
-			// The Access-Control-Request-Header should not be set or
-			// only contain x-summon-session-id
-			preflightRequestHeader := r.Header.Get("Access-Control-Request-Header")
-			if preflightRequestHeader != "" && preflightRequestHeader != "x-summon-session-id" {
-				sendError(w, http.StatusBadRequest,
-					"Access-Control-Request-Header header "+
-						"should only contain x-summon-session-id.")
+			// The Access-Control-Request-Headers should not be set or
+			// only contain x-summon-session-id, and the challenge token header if challenges are on.
+			preflightRequestHeaders := requestedCORSHeaders(r)
+			if preflightRequestHeaders != "" && !corsRequestHeadersAllowed(preflightRequestHeaders) {
+				audit.Record(r, AuditBadPreflight, "Access-Control-Request-Headers "+preflightRequestHeaders)
+				sendJSONErrorCode(w, http.StatusBadRequest, ErrorBadPreflight,
+					"Access-Control-Request-Headers header "+
+						"should only contain "+strings.Join(allowedCORSRequestHeaders(), ", ")+".", nil)
+				preflightRequestsTotal.With(PreflightRejected).Inc()
+				return
+			}
+			setPreflightHeaders(w, r)
+			auditOriginMismatch(w, r)
+			if w.Header().Get("Access-Control-Allow-Origin") == "" {
+				sendJSONErrorCode(w, http.StatusForbidden, ErrorOriginRejected,
+					"The origin "+r.Header.Get("Origin")+" isn't allowed.", nil)
+				preflightRequestsTotal.With(PreflightRejected).Inc()
 				return
 			}
-			w.Header().Set("Access-Control-Allow-Methods", "GET")
-			w.Header().Set("Access-Control-Allow-Headers", "x-summon-session-id")
-			w.Header().Set("Access-Control-Max-Age", DefaultMaxAge)
-			setACAOHeader(w, r)
 
 			l.Logf(l.TraceMessage, "Sending preflight response %#v.", w.Header())
 
@@ -191,95 +578,402 @@ func proxyHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// Not a preflight request, so it has to be a GET request.
-		if r.Method != "GET" {
-			sendError(w, http.StatusMethodNotAllowed,
-				"Only GET requests accepted.")
+		// Not a preflight request, so it has to be a proxied method.
+		if !methodProxied(r.Method) {
+			w.Header().Set("Allow", allowHeader())
+			sendJSONErrorCode(w, http.StatusMethodNotAllowed, ErrorMethodNotAllowed,
+				"Only "+proxiedMethodsText("and")+" requests accepted.", nil)
 			return
 		}
 
 		// Set the Access-Control-Allow-Origin header.
 		setACAOHeader(w, r)
+		auditOriginMismatch(w, r)
 
 	}
 
-	// Build the auth headers and send a request to the Summon API.
-	client := new(http.Client)
+	// A plain OPTIONS request asks which methods are supported.
+	if r.Method == "OPTIONS" {
+		w.Header().Set("Allow", allowHeader())
+		w.Header().Set("Content-Length", "0")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
 
-	// Add a timeout to the http client
-	client.Timeout = time.Duration(*timeout) * time.Second
+	// Only the proxied methods are accepted.
+	if !methodProxied(r.Method) {
+		w.Header().Set("Allow", allowHeader())
+		sendJSONErrorCode(w, http.StatusMethodNotAllowed, ErrorMethodNotAllowed,
+			"Only "+proxiedMethodsText("and")+" requests accepted.", nil)
+		return
+	}
+
+	// Choose the credentials for the path, and remove any credential prefix.
+	creds, summonPath, ok := credentialsFor(r.URL.Path)
+	if !ok {
+		sendPathNotFound(w, r)
+		return
+	}
+
+	// Send every request to the pinned version, if there is one.
+	summonPath = pinVersion(summonPath)
+
+	// Don't spend a signed request on paths which aren't part of the Summon API.
+	if *strictPaths && !validSummonPath(summonPath) {
+		sendPathNotFound(w, r)
+		return
+	}
+
+	// Only sign the paths which are meant to be public.
+	if !pathAllowed(summonPath) && enforcePolicy(r, PolicyPaths, summonPath) {
+		sendPathNotAllowed(w, r, summonPath)
+		return
+	}
+
+	// Suspect requests have to pass a challenge before they are proxied.
+	if ch := challengeRequest(r); ch != nil {
+		audit.Record(r, AuditChallenged, ch.message)
+		sendChallenge(w, ch)
+		return
+	}
+
+	// Reject very long queries before spending a signed request on them.
+	if *maxQueryLength > 0 && len(r.URL.RawQuery) > *maxQueryLength {
+		sendJSONErrorCode(w, http.StatusRequestURITooLong, ErrorQueryTooLong,
+			fmt.Sprintf("The query string is longer than the maximum of %v characters.", *maxQueryLength), nil)
+		return
+	}
+
+	// Expand the facet presets the client asked for.
+	rawQuery, unknownPreset, ok := expandPresets(r.URL.RawQuery)
+	if !ok {
+		sendUnknownPreset(w, unknownPreset)
+		return
+	}
+
+	// Reject bad search terms and facets before spending a signed request on them.
+	if *validateQueries {
+		if problem := validateQuery(rawQuery); problem != "" && enforcePolicy(r, PolicyQueries, problem) {
+			sendInvalidQuery(w, problem)
+			return
+		}
+	}
+
+	// Optionally send the request to an alternate version or profile, to compare them.
+	variant := chooseVariant(r)
+	summonPath, creds = applyVariant(variant, summonPath, creds)
+
+	// Staff can send a request to another upstream, to compare them.
+	override, err := upstreamOverrideFor(r)
+	if err != nil {
+		sendUpstreamOverrideError(w, r, err)
+		return
+	}
+	apiURLString := upstreams.URL()
+	if override != nil {
+		audit.Record(r, AuditUpstreamOverride, override.name+"@"+override.url)
+		apiURLString, creds = override.url, override.creds
+	}
+
+	// The profile's cached responses are shared by all of its credentials.
+	profileAccessID := creds.accessID
+
+	// Spread requests across the profile's credentials, if it has more than one.
+	creds = pickCredentials(creds)
+
+	// The request to the Summon API is given up after the timeout, or when the client goes away.
+	ctx, cancel := context.WithTimeout(r.Context(), *timeout)
+	defer cancel()
 
 	// Build the API Request.
-	apiRequestURL, err := url.Parse(*apiURL)
+	apiRequestURL, err := url.Parse(apiURLString)
 	if err != nil {
 		// This should never happen, since we already parsed in main.
-		sendError(w, http.StatusInternalServerError, "Unable to parse API URL.")
+		sendJSONErrorCode(w, http.StatusInternalServerError, ErrorInternal, "Unable to parse API URL.", nil)
 		return
 	}
-	apiRequestURL.Path = r.URL.Path
-	apiRequestURL.RawQuery = r.URL.RawQuery
+	apiRequestURL.Path = summonPath
+	apiRequestURL.RawQuery = escapeQuery(rawQuery)
+
+	// The hop-by-hop headers only apply to the client's connection to Lorica.
+	clientHeader := cloneHeader(r.Header)
+	removeHopByHopHeaders(clientHeader)
+
+	// Optionally use the client's language preferences to choose the language of the results.
+	acceptLanguage := clientHeader.Get("Accept-Language")
+	injectLanguage(apiRequestURL, acceptLanguage)
+
+	// Searches from campus networks get the authenticated role.
+	injectCampusRole(apiRequestURL, r)
 
 	// Create the request struct.
-	apiRequest, err := http.NewRequest("GET", apiRequestURL.String(), nil)
-	if err != nil {
-		sendError(w, http.StatusInternalServerError,
-			"Unable to build API Request.")
+	apiRequest, err := newAPIRequest(r, apiRequestURL.String())
+	if err == errBodyTooLarge {
+		sendJSONErrorCode(w, http.StatusRequestEntityTooLarge, ErrorBodyTooLarge,
+			fmt.Sprintf("The request body is larger than the maximum of %v bytes.", MaxPostBodySize), nil)
+		return
+	} else if err != nil {
+		sendJSONErrorCode(w, http.StatusInternalServerError, ErrorInternal,
+			"Unable to build API Request.", nil)
 		return
 	}
 
-	// Close the connection after sending the request.
-	apiRequest.Close = true
-
 	// Add the accept header from the client.
-	accept := r.Header.Get("Accept")
+	accept := clientHeader.Get("Accept")
 	apiRequest.Header.Add("Accept", accept)
 
+	// Add the language preferences from the client.
+	if acceptLanguage != "" {
+		apiRequest.Header.Add("Accept-Language", acceptLanguage)
+	}
+
 	// Add the timestamp
-	timestampRFC2616 := time.Now().UTC().Format(http.TimeFormat)
+	timestampRFC2616 := skew.SigningTime().UTC().Format(http.TimeFormat)
 	apiRequest.Header.Add("x-summon-date", timestampRFC2616)
 
 	// Add the session id from the client, if available.
-	sessionID := r.Header.Get("x-summon-session-id")
+	sessionID := clientHeader.Get("x-summon-session-id")
+	if sessionID == "" && *issueSessions {
+		sessionID, err = newSessionID()
+		if err != nil {
+			sendJSONErrorCode(w, http.StatusInternalServerError, ErrorInternal, "Unable to make a session ID.", nil)
+			return
+		}
+		w.Header().Set("x-summon-session-id", sessionID)
+		getRequestInfo(r).session = sessionHash(sessionID)
+	}
+	// Optionally reject a session ID which is being used by another client.
+	if sessionID != "" && *bindSessions && !sessions.Check(sessionHash(sessionID), clientFingerprint(r), *sessionBindingTTL) {
+		audit.Record(r, AuditSessionReuse, "")
+		sendJSONErrorCode(w, http.StatusForbidden, ErrorSessionInUse, "The session ID belongs to another client.",
+			[]string{"Leave out the x-summon-session-id header to start a new session."})
+		return
+	}
 	if sessionID != "" {
 		apiRequest.Header.Add("x-summon-session-id", sessionID)
 	}
 
+	// Add any other headers from the client which should be forwarded.
+	copyHeaders(apiRequest.Header, clientHeader, forwardableHeaders())
+
+	// Identify Lorica, and optionally the client, to the Summon API.
+	if *viaHeader {
+		appendToChain(apiRequest.Header, clientHeader, "Via", viaValue(r))
+	}
+	if *forwardedHeader {
+		appendToChain(apiRequest.Header, clientHeader, "Forwarded", forwardedValue(r))
+	}
+
 	// Call the helper function to build the accept header.
-	apiRequest.Header.Add("Authorization", buildHeader(apiRequestURL, accept, timestampRFC2616))
+	apiRequest.Header.Add("Authorization", buildHeaderWithCredentials(creds, apiRequestURL, accept, timestampRFC2616))
+
+	l.Logf(l.TraceMessage, "Sending request %v to Summon API: %v %v %v",
+		getRequestInfo(r).id, apiRequest.Method, apiRequest.URL, redactedHeader(apiRequest.Header))
+
+	// Use the cached response from the Summon API, if there is one.
+	cacheKey := ""
+	var cached *cachedResponse
+	if useResponseCache(r, sessionID, override != nil) {
+		info := getRequestInfo(r)
+		cacheKey = responseCacheKey(profileAccessID, apiRequestURL, accept, acceptLanguage)
+		info.cache = CacheMiss
+		if !clientWantsFreshResponse(r) {
+			if c, ok := cachedResponses.Get(cacheKey); ok {
+				cached, info.cache = c, CacheHit
+			}
+		}
+		cacheRequestsTotal.With(info.cache).Inc()
+	}
 
-	l.Logf(l.TraceMessage, "Sending request to Summon API %#v", apiRequest)
+	var apiResp *http.Response
+	throttle := throttles.For(creds.accessID, apiRequestURL.Host)
+	if cached != nil {
+		l.Logf(l.TraceMessage, "Using cached response for request %v.", getRequestInfo(r).id)
+		apiResp = cached.response()
+		w.Header().Set("Age", cached.age(time.Now()))
+	} else {
+		// Don't send the request while backing off after Summon rate limited
+		// Lorica, with these credentials at this upstream.
+		if wait := throttle.Wait(); wait > 0 {
+			sendThrottled(w, wait)
+			return
+		}
 
-	// Send the response to the Summon API.
-	apiResp, err := client.Do(apiRequest)
-	if err != nil {
-		sendError(w, http.StatusInternalServerError,
-			fmt.Sprintf("Error sending API Request: %v", err))
-		return
+		// Send the response to the Summon API. Identical requests which are
+		// already being sent share their response instead.
+		upstreamStart := time.Now()
+		shared := false
+		if coalescable(r, sessionID, override != nil) {
+			coalesceKey := responseCacheKey(profileAccessID, apiRequestURL, accept, acceptLanguage)
+			apiResp, shared, err = coalescer.Do(ctx, coalesceKey, apiRequest, *timeout)
+		} else {
+			apiResp, err = upstreamClient.Do(apiRequest.WithContext(ctx))
+		}
+		if !shared {
+			evidence.Record(r, apiRequest, apiResp, err, time.Since(upstreamStart))
+		}
+		if shared {
+			// Only the request which was sent is recorded as a Summon API response.
+			coalescedRequestsTotal.With(endpointLabel(r.URL.Path)).Inc()
+		} else if err != nil {
+			upstreamResponses.Record(http.StatusBadGateway)
+			if override == nil {
+				upstreams.Record(http.StatusBadGateway)
+			}
+			recordVariant(variant, http.StatusBadGateway, time.Since(upstreamStart))
+			recordUpstream(r, http.StatusBadGateway, time.Since(upstreamStart))
+		}
+		if err != nil {
+			sendUpstreamFailure(w, r, err)
+			return
+		}
+
+		l.Logf(l.TraceMessage, "Received response from Summon API: %#v", apiResp)
+		if !shared {
+			upstreamResponses.Record(apiResp.StatusCode)
+			if override == nil {
+				upstreams.Record(apiResp.StatusCode)
+			}
+			skew.Observe(apiResp.Header.Get("Date"), upstreamStart, time.Now())
+			recordVariant(variant, apiResp.StatusCode, time.Since(upstreamStart))
+			recordUpstream(r, apiResp.StatusCode, time.Since(upstreamStart))
+			recordUpstreamRateLimit(throttle, apiResp)
+			if apiResp.StatusCode == http.StatusUnauthorized || apiResp.StatusCode == http.StatusForbidden {
+				audit.Record(r, AuditSummonAuthFailed, apiResp.Status)
+			}
+		}
 	}
 
-	l.Logf(l.TraceMessage, "Received response from Summon API: %#v", apiResp)
+	// The hop-by-hop headers only apply to Lorica's connection to Summon.
+	apiHeader := cloneHeader(apiResp.Header)
+	removeHopByHopHeaders(apiHeader)
 
-	// Send the client important Summon API headers
-	proxiedHeaders := []string{
-		"Content-Type",
+	// Send the client the configured Summon API headers.
+	// Browsers only let CORS requests read them if they are exposed.
+	responseHeaders := proxiableHeaders()
+	copyHeaders(w.Header(), apiHeader, responseHeaders)
+	if w.Header().Get("x-summon-session-id") != "" {
+		responseHeaders = append(responseHeaders, http.CanonicalHeaderKey("x-summon-session-id"))
+	}
+	if w.Header().Get("Access-Control-Allow-Origin") != "" && len(responseHeaders) > 0 {
+		w.Header().Set("Access-Control-Expose-Headers", strings.Join(responseHeaders, ", "))
+	}
+	if cacheKey != "" {
+		setCacheStatus(w, getRequestInfo(r).cache)
 	}
 
-	for _, proxiedHeader := range proxiedHeaders {
-		if apiResp.Header.Get(proxiedHeader) != "" {
-			w.Header().Add(proxiedHeader, apiResp.Header.Get(proxiedHeader))
+	// Successful responses are read in full, so they can be given an entity tag,
+	// and the client's conditional request headers can be checked against it.
+	if apiResp.StatusCode == http.StatusOK {
+		body, err := readUpstreamBody(apiResp)
+		if err == nil {
+			err = checkBodyLength(len(body), apiResp.ContentLength)
+		}
+		if err != nil {
+			truncatedResponsesTotal.With(endpointLabel(r.URL.Path)).Inc()
+			sendJSONErrorCode(w, http.StatusBadGateway, ErrorSummonBadResponse,
+				fmt.Sprintf("Error reading API Response: %v", err), nil)
+			return
+		}
+		// Malformed responses are never transformed or sent to the client.
+		if *schemaGuard {
+			if err := checkSchema(endpointLabel(r.URL.Path), apiHeader.Get("Content-Type"), body); err != nil {
+				sendSchemaMismatch(w, r, body, err)
+				return
+			}
+		}
+		if cacheKey != "" && cached == nil {
+			cachedResponses.Add(cacheKey, apiHeader, body, *cacheTTL, *cacheMaxSize)
+		}
+		if cacheKey != "" && r.Method == "GET" {
+			prefetchNextPage(r, body)
+		}
+		body = transformResponse(body)
+		if *paginationLinkHeaders {
+			setPaginationLinks(w, r, body)
 		}
+		setSearchCacheHeaders(w, r)
+		if *sendDigest {
+			w.Header().Set("Digest", bodyDigest(body))
+		}
+		etag := etagFor(body)
+		w.Header().Set("ETag", etag)
+		lastModified, err := http.ParseTime(apiHeader.Get("Last-Modified"))
+		if err == nil {
+			w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+		}
+		if notModified(r, etag, lastModified) {
+			l.Logf(l.TraceMessage, "Sending not modified response to client with headers: %v", w.Header())
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+
+		l.Logf(l.TraceMessage, "Sending response to client with headers: %v", w.Header())
+
+		markProxied(r)
+		w.WriteHeader(apiResp.StatusCode)
+		w.Write(body)
+		return
+	}
+
+	// Errors from the Summon API are sent as Lorica's structured errors.
+	// While Lorica is backing off, the client is told when to try again.
+	if wait := throttle.Wait(); wait > 0 && apiResp.StatusCode == http.StatusTooManyRequests {
+		setRetryAfter(w, wait)
+	}
+	if apiResp.StatusCode >= 400 {
+		sendUpstreamError(w, r, apiResp)
+		return
+	}
+
+	// The body is copied unchanged, so its length is known if Summon sent it.
+	if apiResp.ContentLength >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(apiResp.ContentLength, 10))
 	}
 
 	l.Logf(l.TraceMessage, "Sending response to client with headers: %v", w.Header())
 
-	w.WriteHeader(apiResp.StatusCode)
-	io.Copy(w, apiResp.Body)
-	apiResp.Body.Close()
+	markProxied(r)
+	streamBody(w, r, apiResp)
+}
+
+// rateLimitReached audits a rate limited request, and holds it in the tarpit.
+// The rate limiter then sends the constant rejection.
+func rateLimitReached(w http.ResponseWriter, r *http.Request) {
+	audit.Record(r, AuditRateLimited, "")
+	tarpit(r)
+	setRejectionHeaders(w.Header())
+	w.Header().Set("Retry-After", "1")
+}
 
+// Build a rate limiter which allows max requests per second from each client.
+func newRateLimiter(max float64) *limiter.Limiter {
+	lmt := tollbooth.NewLimiter(max, nil)
+	lmt.SetMessage(string(rateLimitedBody))
+	lmt.SetMessageContentType("application/json; charset=utf-8")
+	lmt.SetOnLimitReached(rateLimitReached)
+	if *checkProxyHeaders {
+		lmt.SetIPLookups(clientIPLookups())
+	}
+	return lmt
 }
 
 // A helper function that uses a HMAC with SHA1 to build the Authorization header.
 func buildHeader(apiRequestURL *url.URL, accept, timestampRFC2616 string) string {
+	return buildHeaderWithCredentials(defaultCredentials(), apiRequestURL, accept, timestampRFC2616)
+}
+
+// escapeQuery escapes the characters in a client's query string which the
+// HTTP client wouldn't send as they are, so the query string Summon receives
+// is the one which was signed. A # would otherwise start a fragment, which
+// isn't sent.
+func escapeQuery(rawQuery string) string {
+	return strings.Replace(rawQuery, "#", "%23", -1)
+}
+
+// Build the Authorization header using a particular set of credentials.
+func buildHeaderWithCredentials(creds credentials, apiRequestURL *url.URL, accept, timestampRFC2616 string) string {
 
 	// The slice which holds the pieces of the identification string.
 	idComponents := make([]string, 5)
@@ -308,12 +1002,12 @@ func buildHeader(apiRequestURL *url.URL, accept, timestampRFC2616 string) string
 	idString := strings.Join(idComponents, "\n") + "\n"
 
 	// Hash using sha1, then base64 encode.
-	hmacsha1 := hmac.New(sha1.New, []byte(*secretKey))
+	hmacsha1 := hmac.New(sha1.New, []byte(creds.secretKey))
 	io.WriteString(hmacsha1, idString)
 	encodedHash := base64.StdEncoding.EncodeToString(hmacsha1.Sum(nil))
 
 	// Build the final auth header.
-	return fmt.Sprintf("Summon %v;%v", *accessID, encodedHash)
+	return fmt.Sprintf("Summon %v;%v", creds.accessID, encodedHash)
 }
 
 // Send an error to the client, and log the error.
@@ -347,9 +1041,14 @@ func overrideUnsetFlagsFromEnvironmentVariables() {
 	// We don't care about the values in our map, only the keys.
 	for k := range listOfUnsetFlags {
 
+		// The prefix can't come from an environment variable with the prefix.
+		if k.Name == "envprefix" {
+			continue
+		}
+
 		// Build the corresponding environment variable name for each flag.
 		uppercaseName := strings.ToUpper(k.Name)
-		environmentVariableName := fmt.Sprintf("%v%v", EnvPrefix, uppercaseName)
+		environmentVariableName := fmt.Sprintf("%v%v", *envPrefix, uppercaseName)
 
 		// Look for the environment variable name.
 		// If found, set the flag to that value.
@@ -363,6 +1062,7 @@ func overrideUnsetFlagsFromEnvironmentVariables() {
 					"which has a value of \"%v\"",
 					k.Name, environmentVariableName, environmentVariableValue)
 			}
+			setConfigSource(k.Name, SourceEnvironment)
 		}
 	}
 }
@@ -370,19 +1070,15 @@ func overrideUnsetFlagsFromEnvironmentVariables() {
 // Set the Access-Control-Allow-Origin header
 func setACAOHeader(w http.ResponseWriter, r *http.Request) {
 
-	if *allowedOrigins == "*" {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		return
-	}
-
-	if *allowedOrigins != "" {
-		possibleOrigins := strings.Split(*allowedOrigins, ";")
-		for _, okOrigin := range possibleOrigins {
-			okOrigin = strings.TrimSpace(okOrigin)
-			if (okOrigin != "") && (okOrigin == r.Header.Get("Origin")) {
-				w.Header().Set("Access-Control-Allow-Origin", okOrigin)
-				return
-			}
+	switch matchOrigin(r.Header.Get("Origin")) {
+	case "":
+		// In report-only mode, the origin is allowed anyway.
+		if origin := r.Header.Get("Origin"); origin != "" && !enforcePolicy(r, PolicyOrigins, origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
 		}
+	case "*":
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	default:
+		w.Header().Set("Access-Control-Allow-Origin", r.Header.Get("Origin"))
 	}
 }
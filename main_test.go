@@ -6,6 +6,7 @@ package main
 
 import (
 	"fmt"
+	"github.com/cu-library/lorica/internal/summonmock"
 	l "github.com/cu-library/lorica/loglevel"
 	"io/ioutil"
 	"log"
@@ -14,6 +15,7 @@ import (
 	"net/url"
 	"os"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -32,6 +34,7 @@ func TestProxyHanderPreflightCorrect(t *testing.T) {
 	}
 
 	req.Header.Add("Access-Control-Request-Method", "GET")
+	req.Header.Add("Access-Control-Request-Headers", "X-Summon-Session-Id")
 	req.Header.Add("Origin", "http://test.com")
 
 	// Override the command line flags
@@ -84,6 +87,9 @@ func TestProxyHanderPreflightNoMethodHeader(t *testing.T) {
 	if !strings.Contains(bodyString, "Access-Control-Request-Method header should be set for OPTIONS request.") {
 		t.Errorf("Didn't get the right message from bad preflight request, got %v.", bodyString)
 	}
+	if !strings.Contains(bodyString, `"code":"`+ErrorBadPreflight+`"`) {
+		t.Errorf("Didn't get the bad_preflight code from bad preflight request, got %v.", bodyString)
+	}
 
 }
 
@@ -120,17 +126,17 @@ func TestProxyHanderPreflightBadRequestHeader(t *testing.T) {
 	}
 
 	req.Header.Add("Access-Control-Request-Method", "GET")
-	req.Header.Add("Access-Control-Request-Header", "bad-news")
+	req.Header.Add("Access-Control-Request-Headers", "x-summon-session-id, bad-news")
 	req.Header.Add("Origin", "http://test.com")
 
 	w := httptest.NewRecorder()
 	proxyHandler(w, req)
 
 	if w.Code != http.StatusBadRequest {
-		t.Error("Preflight request with Access-Control-Request-Header set to bad-news should have failed.")
+		t.Error("Preflight request with Access-Control-Request-Headers set to bad-news should have failed.")
 	}
 	bodyString := w.Body.String()
-	if !strings.Contains(bodyString, "Access-Control-Request-Header header should only contain x-summon-session-id.") {
+	if !strings.Contains(bodyString, "Access-Control-Request-Headers header should only contain x-summon-session-id.") {
 		t.Errorf("Didn't get the right message from bad preflight request, got %v.", bodyString)
 	}
 
@@ -155,6 +161,47 @@ func TestProxyHanderBadCORSMethod(t *testing.T) {
 	if !strings.Contains(bodyString, "Only GET requests accepted.") {
 		t.Errorf("Didn't get the right message from bad CORS method request, got %v.", bodyString)
 	}
+	if !strings.Contains(bodyString, `"code":"`+ErrorMethodNotAllowed+`"`) {
+		t.Errorf("Didn't get the method_not_allowed code from bad CORS method request, got %v.", bodyString)
+	}
+
+}
+
+// Test that a plain OPTIONS request gets the supported methods.
+func TestProxyHanderPlainOptions(t *testing.T) {
+	req, err := http.NewRequest("OPTIONS", "/2.0.0/search", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	proxyHandler(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Plain OPTIONS request got %v, expected 200.", w.Code)
+	}
+	if w.Header().Get("Allow") != AllowedMethods {
+		t.Errorf("Allow header had %v, expected %v.", w.Header().Get("Allow"), AllowedMethods)
+	}
+
+}
+
+// Test that a request which isn't CORS still has to be a GET.
+func TestProxyHanderBadMethod(t *testing.T) {
+	req, err := http.NewRequest("DELETE", "/2.0.0/search", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	proxyHandler(w, req)
+
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("DELETE request got %v, expected 405.", w.Code)
+	}
+	if w.Header().Get("Allow") != AllowedMethods {
+		t.Errorf("Allow header had %v, expected %v.", w.Header().Get("Allow"), AllowedMethods)
+	}
 
 }
 
@@ -180,6 +227,10 @@ func TestProxyHanderAPICall(t *testing.T) {
 		if r.Header.Get("Authorization") == "" {
 			t.Error("Sierra API didn't receive Authorization header.")
 		}
+		// The request should have a Via header
+		if r.Header.Get("Via") != "1.1 lorica" {
+			t.Error("Sierra API didn't receive Via header.")
+		}
 
 		fmt.Fprintln(w, "")
 	}))
@@ -203,6 +254,224 @@ func TestProxyHanderAPICall(t *testing.T) {
 
 }
 
+// Mock the Summon API, and test that hop-by-hop headers aren't proxied.
+func TestProxyHanderHopByHopHeaders(t *testing.T) {
+
+	// The mock of the Summon API.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-summon-session-id") != "" {
+			t.Error("Summon API received a header the client named in Connection.")
+		}
+		w.Header().Set("Connection", "X-Upstream-Private")
+		w.Header().Set("X-Upstream-Private", "secret")
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, "{}")
+	}))
+	defer ts.Close()
+
+	// Override the command line flags
+	oldAPIURL := *apiURL
+	*apiURL = ts.URL
+	defer func() { *apiURL = oldAPIURL }()
+
+	req, err := http.NewRequest("GET", "/2.0.0/search?s.q=test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Connection", "x-summon-session-id")
+	req.Header.Set("x-summon-session-id", "1234")
+
+	w := httptest.NewRecorder()
+	proxyHandler(w, req)
+
+	if w.Header().Get("Connection") != "" || w.Header().Get("X-Upstream-Private") != "" {
+		t.Errorf("Hop-by-hop headers were sent to the client: %v", w.Header())
+	}
+	if w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Content-Type header had %v, expected application/json.", w.Header().Get("Content-Type"))
+	}
+	if w.Header().Get("Content-Length") != "2" {
+		t.Errorf("Content-Length header had %v, expected 2.", w.Header().Get("Content-Length"))
+	}
+
+}
+
+// Mock the Summon API, and test that configured headers are forwarded.
+func TestProxyHanderForwardHeaders(t *testing.T) {
+
+	// The mock of the Summon API.
+	var sent int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&sent, 1)
+		if r.Header.Get("X-Consortium") != "carleton" {
+			t.Error("Summon API didn't receive the forwarded X-Consortium header.")
+		}
+		if r.Header.Get("X-Not-Forwarded") != "" {
+			t.Error("Summon API received a header which wasn't configured to be forwarded.")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, "{}")
+	}))
+	defer ts.Close()
+
+	// Override the command line flags
+	oldAPIURL := *apiURL
+	*apiURL = ts.URL
+	defer func() { *apiURL = oldAPIURL }()
+
+	oldForwardHeaders := *forwardHeaders
+	*forwardHeaders = "X-Consortium"
+	defer func() { *forwardHeaders = oldForwardHeaders }()
+
+	req, err := http.NewRequest("GET", "/2.0.0/search?s.q=test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Consortium", "carleton")
+	req.Header.Set("X-Not-Forwarded", "true")
+
+	w := httptest.NewRecorder()
+	proxyHandler(w, req)
+
+	if n := atomic.LoadInt32(&sent); n != 1 {
+		t.Errorf("%v requests were sent to the Summon API, expected 1.", n)
+	}
+	if w.Code != http.StatusOK || w.Body.String() != "{}" {
+		t.Errorf("The client got %v %v, expected 200 {}.", w.Code, w.Body.String())
+	}
+
+}
+
+// Mock the Summon API, and test that configured response headers are proxied and exposed.
+func TestProxyHanderProxiedHeaders(t *testing.T) {
+
+	// The mock of the Summon API.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Language", "fr")
+		w.Header().Set("X-Not-Proxied", "true")
+		fmt.Fprint(w, "{}")
+	}))
+	defer ts.Close()
+
+	// Override the command line flags
+	oldAPIURL := *apiURL
+	*apiURL = ts.URL
+	defer func() { *apiURL = oldAPIURL }()
+
+	oldProxiedHeaders := *proxiedHeaders
+	*proxiedHeaders = "Content-Type;Content-Language"
+	defer func() { *proxiedHeaders = oldProxiedHeaders }()
+
+	oldAllowedOrigins := *allowedOrigins
+	*allowedOrigins = "http://test.com"
+	defer func() { *allowedOrigins = oldAllowedOrigins }()
+
+	req, err := http.NewRequest("GET", "/2.0.0/search?s.q=test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Origin", "http://test.com")
+
+	w := httptest.NewRecorder()
+	proxyHandler(w, req)
+
+	if w.Header().Get("Content-Language") != "fr" {
+		t.Errorf("Content-Language header had %v, expected fr.", w.Header().Get("Content-Language"))
+	}
+	if w.Header().Get("X-Not-Proxied") != "" {
+		t.Error("A header which wasn't configured to be proxied was sent to the client.")
+	}
+	if w.Header().Get("Access-Control-Expose-Headers") != "Content-Type, Content-Language" {
+		t.Errorf("Access-Control-Expose-Headers header had %v, expected Content-Type, Content-Language.",
+			w.Header().Get("Access-Control-Expose-Headers"))
+	}
+
+}
+
+// Mock the Summon API, and test that Accept-Language is forwarded and s.l is injected.
+func TestProxyHanderAcceptLanguage(t *testing.T) {
+
+	// The mock of the Summon API.
+	var sent int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&sent, 1)
+		if r.Header.Get("Accept-Language") != "fr-CA,fr;q=0.9" {
+			t.Errorf("Summon API received Accept-Language %v.", r.Header.Get("Accept-Language"))
+		}
+		if r.URL.Query().Get("s.l") != "fr" {
+			t.Errorf("Summon API received s.l %v, expected fr.", r.URL.Query().Get("s.l"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, "{}")
+	}))
+	defer ts.Close()
+
+	// Override the command line flags
+	oldAPIURL := *apiURL
+	*apiURL = ts.URL
+	defer func() { *apiURL = oldAPIURL }()
+
+	oldInjectLanguages := *injectLanguages
+	*injectLanguages = "en;fr"
+	defer func() { *injectLanguages = oldInjectLanguages }()
+
+	req, err := http.NewRequest("GET", "/2.0.0/search?s.q=test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept-Language", "fr-CA,fr;q=0.9")
+
+	w := httptest.NewRecorder()
+	proxyHandler(w, req)
+
+	if n := atomic.LoadInt32(&sent); n != 1 {
+		t.Errorf("%v requests were sent to the Summon API, expected 1.", n)
+	}
+	if w.Code != http.StatusOK || w.Body.String() != "{}" {
+		t.Errorf("The client got %v %v, expected 200 {}.", w.Code, w.Body.String())
+	}
+
+}
+
+// Mock the Summon API, and test that conditional requests get a not modified response.
+func TestProxyHanderConditionalRequest(t *testing.T) {
+
+	// The mock of the Summon API.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, "{}")
+	}))
+	defer ts.Close()
+
+	// Override the command line flags
+	oldAPIURL := *apiURL
+	*apiURL = ts.URL
+	defer func() { *apiURL = oldAPIURL }()
+
+	req, err := http.NewRequest("GET", "/2.0.0/search?s.q=test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	proxyHandler(w, req)
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" {
+		t.Fatalf("Expected a 200 response with an ETag, got %v with ETag %v.", w.Code, etag)
+	}
+
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	proxyHandler(w, req)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("Expected a 304 response for a matching If-None-Match, got %v.", w.Code)
+	}
+	if w.Body.Len() != 0 {
+		t.Error("A not modified response had a body.")
+	}
+
+}
+
 // Mock the Summon API, and test that the timeout works as expected.
 func TestProxyHanderTimeoutAPICall(t *testing.T) {
 
@@ -219,11 +488,11 @@ func TestProxyHanderTimeoutAPICall(t *testing.T) {
 	defer func() { *apiURL = oldAPIURL }()
 
 	oldTimeout := *timeout
-	*timeout = 1
+	*timeout = time.Second
 	defer func() { *timeout = oldTimeout }()
 
 	// The request from the client.
-	req, err := http.NewRequest("GET", "/", nil)
+	req, err := http.NewRequest("GET", "/2.0.0/search", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -233,12 +502,39 @@ func TestProxyHanderTimeoutAPICall(t *testing.T) {
 
 	proxyHandler(w, req)
 
-	if w.Code != 500 {
+	if w.Code != http.StatusGatewayTimeout {
 		t.Fatal("Request to slow backend did not time out.")
 	}
 
 }
 
+// Test that requests with long query strings are rejected.
+func TestProxyHanderLongQuery(t *testing.T) {
+	oldMaxQueryLength := *maxQueryLength
+	*maxQueryLength = 10
+	defer func() { *maxQueryLength = oldMaxQueryLength }()
+
+	req, err := http.NewRequest("GET", "/2.0.0/search?s.q=aaaaaaaaaa", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	proxyHandler(w, req)
+
+	if w.Code != http.StatusRequestURITooLong {
+		t.Errorf("Request with a long query got %v, expected 414.", w.Code)
+	}
+	bodyString := w.Body.String()
+	if !strings.Contains(bodyString, "The query string is longer than the maximum of 10 characters.") {
+		t.Errorf("Didn't get the right message from long query request, got %v.", bodyString)
+	}
+	if !strings.Contains(bodyString, `"code":"`+ErrorQueryTooLong+`"`) {
+		t.Errorf("Didn't get the query_too_long code from long query request, got %v.", bodyString)
+	}
+
+}
+
 // Test the build header with data from the Summon API
 func TestBuildHeader(t *testing.T) {
 
@@ -265,6 +561,59 @@ func TestBuildHeader(t *testing.T) {
 
 }
 
+// Fuzz the canonicalization of query strings when signing. The signature
+// Lorica computes must match the one Summon computes from the request it
+// receives, which is checked with the fake Summon API's separate signing code,
+// however the client encoded the query string.
+func FuzzBuildHeader(f *testing.F) {
+	for _, seed := range []string{
+		"s.q=forest&s.ff=ContentType,or,1,15",
+		"s.q=forest+fire&s.q=forest%20fire",
+		"s.fvf=ContentType,Book&s.fvf=ContentType,Audio&s.fvf=ContentType,Book",
+		"s.q=caf%C3%A9&s.q=%FF%FE",
+		"s.q=\xff\xfe",
+		"s.q=a%26b%3Dc&s.q=%2B",
+		"s.q&s.q=&=value&&",
+		"s.q=%zz&s.q=ok",
+		"s.q=a;s.ps=5",
+		"s.q=forest#fragment",
+		"s.q=%23fragment",
+		"S.Q=Case&s.q=case",
+	} {
+		f.Add(seed)
+	}
+	creds := credentials{accessID: "fuzz", secretKey: "fuzzkey"}
+	accept, timestamp := "application/json", "Tue, 30 Jun 2009 12:10:24 GMT"
+	f.Fuzz(func(t *testing.T, rawQuery string) {
+		// The query string has to be one Lorica's server could have received.
+		clientURL, err := url.ParseRequestURI("/2.0.0/search?" + rawQuery)
+		if err != nil {
+			t.Skip()
+		}
+		apiRequestURL, _ := url.Parse("https://api.summon.serialssolutions.com")
+		apiRequestURL.Path = clientURL.Path
+		apiRequestURL.RawQuery = escapeQuery(clientURL.RawQuery)
+		header := buildHeaderWithCredentials(creds, apiRequestURL, accept, timestamp)
+
+		// The request Lorica sends, as Summon receives it.
+		apiRequest, err := http.NewRequest("GET", apiRequestURL.String(), nil)
+		if err != nil {
+			// Lorica doesn't send requests it can't build.
+			t.Skip()
+		}
+		received, err := url.ParseRequestURI(apiRequest.URL.RequestURI())
+		if err != nil {
+			t.Fatalf("Summon couldn't parse the request URI %q: %v", apiRequest.URL.RequestURI(), err)
+		}
+		expected := summonmock.Authorization(creds.accessID, creds.secretKey, accept, timestamp,
+			apiRequest.URL.Host, received.Path, received.Query())
+		if header != expected {
+			t.Errorf("Query string %q was signed as %v, Summon expects %v for %q",
+				rawQuery, header, expected, received.RawQuery)
+		}
+	})
+}
+
 // sendError should return the right errors.
 func TestSendError(t *testing.T) {
 
@@ -414,3 +763,20 @@ func TestSetACAOHeaderWildcard(t *testing.T) {
 		t.Error("Access-Control-Allow-Origin not set properly.")
 	}
 }
+
+// See if a custom environment variable prefix is used.
+func TestEnvironmentVariableCustomPrefix(t *testing.T) {
+	oldEnvPrefix := *envPrefix
+	*envPrefix = "LORICA_SANDBOX_"
+	defer func() { *envPrefix = oldEnvPrefix }()
+
+	oldDemo := *demo
+	defer func() { *demo = oldDemo }()
+
+	os.Setenv("LORICA_SANDBOX_DEMO", "true")
+	defer os.Unsetenv("LORICA_SANDBOX_DEMO")
+	overrideUnsetFlagsFromEnvironmentVariables()
+	if !*demo {
+		t.Error("Setting an environment variable with a custom prefix did not override an unset flag.")
+	}
+}
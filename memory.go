@@ -0,0 +1,120 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	l "github.com/cu-library/lorica/loglevel"
+	"github.com/cu-library/lorica/metrics"
+	"io"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+const (
+	// MemoryCheckInterval is how often the memory in use is checked against -memorylimit.
+	MemoryCheckInterval = 5 * time.Second
+
+	// MemoryRecoveryFraction is the fraction of -memorylimit the memory in use
+	// has to fall below before Lorica stops shedding work, so it doesn't flap
+	// around the limit.
+	MemoryRecoveryFraction = 0.9
+)
+
+var (
+	memoryLimit = flag.Int("memorylimit", 0, "A soft limit on the memory Lorica uses, in bytes. When it is "+
+		"crossed, the response cache is shrunk, background requests are shed, and a warning is logged, so "+
+		"Lorica slows down instead of being killed for running out of memory. 0 means no limit.")
+
+	// memoryWatch tracks whether Lorica is over -memorylimit.
+	memoryWatch = &memoryWatcher{read: readMemoryUse, shrink: shrinkCaches}
+
+	_ = metrics.NewCollectorFunc("memory_pressure", func(w io.Writer) {
+		used, limit, pressure := memoryWatch.Status()
+		if limit == 0 {
+			return
+		}
+		under := 0.0
+		if pressure {
+			under = 1
+		}
+		metrics.WriteGauge(w, "lorica_memory_bytes", "The memory in use at the last check, in bytes.", float64(used))
+		metrics.WriteGauge(w, "lorica_memory_limit_bytes", "The soft memory limit, in bytes.", float64(limit))
+		metrics.WriteGauge(w, "lorica_memory_pressure", "Whether Lorica is shedding work to save memory.", under)
+	})
+)
+
+// memoryWatcher compares the memory in use with a soft limit. Over the limit,
+// it shrinks the caches each time it checks, until the memory in use falls
+// below MemoryRecoveryFraction of the limit.
+type memoryWatcher struct {
+	sync.Mutex
+	limit    int
+	used     uint64
+	pressure bool
+	read     func() uint64
+	shrink   func()
+}
+
+// readMemoryUse returns the memory the Go runtime holds from the operating
+// system, less what it has given back.
+func readMemoryUse() uint64 {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.Sys - m.HeapReleased
+}
+
+// shrinkCaches halves the in-memory response cache, and gives the freed
+// memory back to the operating system. An external cache doesn't use
+// Lorica's memory, so it is left alone.
+func shrinkCaches() {
+	if rc, ok := cachedResponses.(*responseCache); ok {
+		_, size := rc.Len()
+		if removed := rc.Trim(size / 2); removed > 0 {
+			l.Logf(l.InfoMessage, "Removed %v responses from the cache to save memory.", removed)
+		}
+	}
+	debug.FreeOSMemory()
+}
+
+// Check reads the memory in use, and starts or stops shedding work. Changes are logged.
+func (mw *memoryWatcher) Check() {
+	used := mw.read()
+
+	mw.Lock()
+	mw.used = used
+	over := mw.limit > 0 && used >= uint64(mw.limit)
+	switch {
+	case over && !mw.pressure:
+		l.Logf(l.WarnMessage, "Lorica is using %v bytes of memory, over the -memorylimit of %v. "+
+			"Shrinking the cache and shedding background requests.", used, mw.limit)
+		mw.pressure = true
+	case !over && mw.pressure && float64(used) < float64(mw.limit)*MemoryRecoveryFraction:
+		l.Logf(l.InfoMessage, "Lorica is using %v bytes of memory, and is no longer shedding background requests.", used)
+		mw.pressure = false
+	}
+	mw.Unlock()
+
+	if over {
+		mw.shrink()
+	}
+}
+
+// UnderPressure returns true if Lorica is shedding work to save memory.
+func (mw *memoryWatcher) UnderPressure() bool {
+	mw.Lock()
+	defer mw.Unlock()
+	return mw.pressure
+}
+
+// Status returns the memory in use at the last check, the limit, and whether
+// Lorica is shedding work.
+func (mw *memoryWatcher) Status() (uint64, int, bool) {
+	mw.Lock()
+	defer mw.Unlock()
+	return mw.used, mw.limit, mw.pressure
+}
@@ -0,0 +1,74 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Over the limit the caches are shrunk, and work is shed until memory falls well below it.
+func TestMemoryWatcher(t *testing.T) {
+	var used uint64
+	shrinks := 0
+	mw := &memoryWatcher{limit: 1000, read: func() uint64 { return used }, shrink: func() { shrinks++ }}
+
+	for _, c := range []struct {
+		used     uint64
+		pressure bool
+		shrinks  int
+	}{
+		{500, false, 0},
+		{1000, true, 1},
+		{1200, true, 2},
+		{950, true, 2},
+		{899, false, 2},
+		{950, false, 2},
+	} {
+		used = c.used
+		mw.Check()
+		if mw.UnderPressure() != c.pressure || shrinks != c.shrinks {
+			t.Errorf("At %v bytes, the pressure was %v after %v shrinks.", c.used, mw.UnderPressure(), shrinks)
+		}
+	}
+}
+
+// The least recently used responses are removed first.
+func TestResponseCacheTrim(t *testing.T) {
+	rc := newResponseCache()
+	for _, key := range []string{"a", "b", "c"} {
+		rc.Add(key, nil, []byte("1234"), time.Minute, 100)
+	}
+	rc.Get("a")
+	if removed := rc.Trim(6); removed != 2 {
+		t.Errorf("%v responses were removed.", removed)
+	}
+	if _, ok := rc.Get("a"); !ok {
+		t.Error("The most recently used response was removed.")
+	}
+}
+
+// Background requests are shed while memory is short, even without -maxinflight.
+func TestPriorityUnderMemoryPressure(t *testing.T) {
+	old := memoryWatch
+	defer func() { memoryWatch = old }()
+	memoryWatch = &memoryWatcher{pressure: true}
+
+	handler := withPriority(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	r, info := withRequestInfo(httptest.NewRequest("GET", "/2.0.0/search?s.q=forest", nil))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("An interactive request got %v", w.Code)
+	}
+	info.prefetch = true
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("A background request got %v", w.Code)
+	}
+}
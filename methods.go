@@ -0,0 +1,88 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// MaxPostBodySize is the largest POST body which is proxied.
+const MaxPostBodySize = 1 << 20
+
+var proxyHead = flag.Bool("proxyhead", false, "Proxy HEAD requests. They are sent to the Summon API as GET "+
+	"requests, and the body is left out of the response.")
+
+// proxiedMethods returns the methods which are proxied. GET always is, HEAD is
+// if -proxyhead is set, and POST is while the post feature is enabled.
+func proxiedMethods() []string {
+	methods := []string{"GET"}
+	if *proxyHead {
+		methods = append(methods, "HEAD")
+	}
+	if features.Enabled(FeaturePost) {
+		methods = append(methods, "POST")
+	}
+	return methods
+}
+
+// methodProxied returns true if requests with the method are proxied.
+func methodProxied(method string) bool {
+	for _, m := range proxiedMethods() {
+		if method == m {
+			return true
+		}
+	}
+	return false
+}
+
+// allowHeader returns the value of the Allow header.
+func allowHeader() string {
+	return strings.Join(append(proxiedMethods(), "OPTIONS"), ", ")
+}
+
+// proxiedMethodsText returns the proxied methods for a message, like "GET, HEAD, and POST".
+func proxiedMethodsText(conjunction string) string {
+	methods := proxiedMethods()
+	switch len(methods) {
+	case 1:
+		return methods[0]
+	case 2:
+		return methods[0] + " " + conjunction + " " + methods[1]
+	}
+	return strings.Join(methods[:len(methods)-1], ", ") + ", " + conjunction + " " + methods[len(methods)-1]
+}
+
+// errBodyTooLarge is returned when a POST body is larger than MaxPostBodySize.
+var errBodyTooLarge = errors.New("request body too large")
+
+// newAPIRequest builds the request sent to the Summon API for the client's
+// request. HEAD requests are sent as GET requests, and POST requests are sent
+// with the client's body and Content-Type.
+func newAPIRequest(r *http.Request, apiRequestURL string) (*http.Request, error) {
+	if r.Method != "POST" {
+		return http.NewRequest("GET", apiRequestURL, nil)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, MaxPostBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > MaxPostBodySize {
+		return nil, errBodyTooLarge
+	}
+	apiRequest, err := http.NewRequest("POST", apiRequestURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		apiRequest.Header.Set("Content-Type", contentType)
+	}
+	return apiRequest, nil
+}
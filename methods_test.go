@@ -0,0 +1,75 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// The preflight response and the Allow header list the enabled methods.
+func TestProxiedMethods(t *testing.T) {
+	oldProxyHead := *proxyHead
+	defer func() {
+		*proxyHead = oldProxyHead
+		features.Set("")
+	}()
+
+	if allowHeader() != AllowedMethods || proxiedMethodsText("or") != "GET" {
+		t.Errorf("Default methods were %v", allowHeader())
+	}
+
+	*proxyHead = true
+	features.Set(FeaturePost)
+	if allowHeader() != "GET, HEAD, POST, OPTIONS" {
+		t.Errorf("Allow header was %v", allowHeader())
+	}
+	if proxiedMethodsText("and") != "GET, HEAD, and POST" {
+		t.Errorf("Methods text was %v", proxiedMethodsText("and"))
+	}
+
+	oldAllowedOrigins := *allowedOrigins
+	*allowedOrigins = "http://test.com"
+	defer func() { *allowedOrigins = oldAllowedOrigins }()
+	req := httptest.NewRequest("OPTIONS", "/", nil)
+	req.Header.Add("Access-Control-Request-Method", "POST")
+	req.Header.Add("Origin", "http://test.com")
+	w := httptest.NewRecorder()
+	proxyHandler(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("POST preflight got %v", w.Code)
+	}
+	if w.Header().Get("Access-Control-Allow-Methods") != "GET, HEAD, POST" {
+		t.Errorf("Access-Control-Allow-Methods header had %v", w.Header().Get("Access-Control-Allow-Methods"))
+	}
+}
+
+// HEAD requests are sent as GET requests, and POST requests keep their body.
+func TestNewAPIRequest(t *testing.T) {
+	head, err := newAPIRequest(httptest.NewRequest("HEAD", "/", nil), "http://summon/2.0.0/search")
+	if err != nil || head.Method != "GET" {
+		t.Errorf("HEAD request built as %v, %v", head, err)
+	}
+
+	r := httptest.NewRequest("POST", "/", strings.NewReader("s.q=test"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	post, err := newAPIRequest(r, "http://summon/2.0.0/search")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(post.Body)
+	if post.Method != "POST" || string(body) != "s.q=test" ||
+		post.Header.Get("Content-Type") != "application/x-www-form-urlencoded" {
+		t.Errorf("POST request built as %v %q %v", post.Method, body, post.Header)
+	}
+
+	large := httptest.NewRequest("POST", "/", strings.NewReader(strings.Repeat("x", MaxPostBodySize+1)))
+	if _, err := newAPIRequest(large, "http://summon/2.0.0/search"); err != errBodyTooLarge {
+		t.Errorf("Large body got %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"github.com/cu-library/lorica/metrics"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// The labels used on request metrics. Every label has a small,
+// fixed set of values, so dashboards can slice on them freely.
+var requestLabels = []string{"status_class", "endpoint", "cache", "origin"}
+
+var (
+	requestsTotal = metrics.NewCounterVec("lorica_requests_total",
+		"The number of requests handled.", requestLabels...)
+	requestDuration = metrics.NewHistogramVec("lorica_request_duration_seconds",
+		"The time taken to handle requests, in seconds.", nil, requestLabels...)
+	upstreamDuration = metrics.NewHistogramVec("lorica_upstream_duration_seconds",
+		"The time taken by the Summon API to respond, in seconds. Requests which failed "+
+			"without a response count as 5xx.", nil, "endpoint", "status_class")
+)
+
+// The values of the cache label.
+const (
+	CacheNone = "none"
+)
+
+// knownEndpoints are the Summon API endpoints given their own endpoint label.
+var knownEndpoints = []string{"search", "availability", "suggest"}
+
+type contextKey int
+
+const (
+	requestInfoKey contextKey = iota
+	responseOriginKey
+)
+
+// requestInfo holds facts about a request which are learned while
+// handling it, and are needed afterwards for logging and metrics.
+type requestInfo struct {
+	id      string
+	session string
+	tier    string
+	cache   string
+
+	// prefetch is true for requests Lorica makes itself, to prefetch the next page of a search.
+	prefetch bool
+}
+
+// withRequestInfo returns a copy of the request which carries a new requestInfo.
+func withRequestInfo(r *http.Request) (*http.Request, *requestInfo) {
+	info := &requestInfo{
+		id:      requestID(r),
+		session: sessionHash(r.Header.Get("x-summon-session-id")),
+		cache:   CacheNone,
+	}
+	return r.WithContext(context.WithValue(r.Context(), requestInfoKey, info)), info
+}
+
+// getRequestInfo returns the request's requestInfo. If the request doesn't
+// have one, a throwaway requestInfo is returned so callers don't need to check.
+func getRequestInfo(r *http.Request) *requestInfo {
+	if info, ok := r.Context().Value(requestInfoKey).(*requestInfo); ok {
+		return info
+	}
+	return &requestInfo{cache: CacheNone}
+}
+
+// statusClassLabel returns the class of the status code, like 2xx.
+func statusClassLabel(statusCode int) string {
+	if statusCode < 100 || statusCode > 599 {
+		return "other"
+	}
+	return string('0'+byte(statusCode/100)) + "xx"
+}
+
+// endpointLabel returns the Summon API endpoint the path is for.
+// Paths look like /2.0.0/search/ping, the endpoint is the part after the version.
+func endpointLabel(path string) string {
+	_, path, _ = credentialsFor(path)
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) < 2 {
+		return "other"
+	}
+	for _, endpoint := range knownEndpoints {
+		if parts[1] == endpoint {
+			return endpoint
+		}
+	}
+	return "other"
+}
+
+// originLabel returns the label value for an Origin header. Only origins
+// listed in the allowed origins are used as values, to bound the cardinality.
+// Origins matched by a pattern are labelled with the pattern, and origins
+// allowed by the origin authorization endpoint are other.
+func originLabel(origin string) string {
+	if origin == "" {
+		return "none"
+	}
+	if okOrigin := matchListedOrigin(origin); okOrigin != "" && okOrigin != "*" {
+		return okOrigin
+	}
+	return "other"
+}
+
+// recordUpstream records how long the Summon API took to respond to the request.
+func recordUpstream(r *http.Request, statusCode int, duration time.Duration) {
+	upstreamDuration.With(endpointLabel(r.URL.Path), statusClassLabel(statusCode)).Observe(duration.Seconds())
+}
@@ -0,0 +1,443 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+// Package metrics provides counters, gauges, and histograms which
+// can be exported in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the default histogram buckets, in seconds.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// A Collector is a metric which can write itself out.
+type Collector interface {
+	// Name returns the metric name.
+	Name() string
+	// Write writes the metric in the Prometheus text format.
+	Write(w io.Writer)
+}
+
+// Registry is a set of collectors.
+type Registry struct {
+	sync.RWMutex
+	collectors map[string]Collector
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{collectors: make(map[string]Collector)}
+}
+
+// Default is the registry used by the package level constructors.
+var Default = NewRegistry()
+
+// Register adds a collector to the registry. It panics if
+// a collector with the same name was already registered.
+func (reg *Registry) Register(c Collector) {
+	reg.Lock()
+	defer reg.Unlock()
+
+	if _, ok := reg.collectors[c.Name()]; ok {
+		panic("metrics: duplicate metric " + c.Name())
+	}
+	reg.collectors[c.Name()] = c
+}
+
+// Write writes every registered collector, sorted by name.
+func (reg *Registry) Write(w io.Writer) {
+	reg.RLock()
+	names := make([]string, 0, len(reg.collectors))
+	for name := range reg.collectors {
+		names = append(names, name)
+	}
+	collectors := make([]Collector, 0, len(names))
+	sort.Strings(names)
+	for _, name := range names {
+		collectors = append(collectors, reg.collectors[name])
+	}
+	reg.RUnlock()
+
+	for _, c := range collectors {
+		c.Write(w)
+	}
+}
+
+// Handler returns a http.Handler which serves the registry's metrics.
+func (reg *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b := new(bytes.Buffer)
+		reg.Write(b)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(b.Bytes())
+	})
+}
+
+// Handler returns a http.Handler which serves the Default registry's metrics.
+func Handler() http.Handler {
+	return Default.Handler()
+}
+
+// vec holds the labelled children of a metric.
+type vec struct {
+	sync.RWMutex
+	name       string
+	help       string
+	labelNames []string
+	children   map[string]interface{}
+	labels     map[string][]string
+}
+
+func newVec(name, help string, labelNames []string) vec {
+	return vec{
+		name:       name,
+		help:       help,
+		labelNames: labelNames,
+		children:   make(map[string]interface{}),
+		labels:     make(map[string][]string),
+	}
+}
+
+// Name returns the metric name.
+func (v *vec) Name() string {
+	return v.name
+}
+
+// child returns the child with the label values, creating it if needed.
+func (v *vec) child(labelValues []string, create func() interface{}) interface{} {
+	if len(labelValues) != len(v.labelNames) {
+		panic(fmt.Sprintf("metrics: %v expects %v label values, got %v", v.name, len(v.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	v.RLock()
+	c, ok := v.children[key]
+	v.RUnlock()
+	if ok {
+		return c
+	}
+
+	v.Lock()
+	defer v.Unlock()
+	if c, ok := v.children[key]; ok {
+		return c
+	}
+	c = create()
+	v.children[key] = c
+	v.labels[key] = append([]string(nil), labelValues...)
+	return c
+}
+
+// sortedKeys returns the keys of the children, sorted.
+func (v *vec) sortedKeys() []string {
+	keys := make([]string, 0, len(v.children))
+	for key := range v.children {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// writeHeader writes the HELP and TYPE lines.
+func (v *vec) writeHeader(w io.Writer, metricType string) {
+	fmt.Fprintf(w, "# HELP %v %v\n", v.name, escapeHelp(v.help))
+	fmt.Fprintf(w, "# TYPE %v %v\n", v.name, metricType)
+}
+
+// formatLabels formats label names and values like {a="b",c="d"}.
+func formatLabels(names, values []string, extra ...string) string {
+	if len(names) == 0 && len(extra) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(names)+len(extra)/2)
+	for i, name := range names {
+		pairs = append(pairs, name+"=\""+escapeLabelValue(values[i])+"\"")
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+"=\""+escapeLabelValue(extra[i+1])+"\"")
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escapeHelp(s string) string {
+	s = strings.Replace(s, "\\", "\\\\", -1)
+	return strings.Replace(s, "\n", "\\n", -1)
+}
+
+func escapeLabelValue(s string) string {
+	s = strings.Replace(s, "\\", "\\\\", -1)
+	s = strings.Replace(s, "\"", "\\\"", -1)
+	return strings.Replace(s, "\n", "\\n", -1)
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// WriteCounter writes a single unlabelled counter in the Prometheus text format.
+// It is useful for collectors which calculate their values when written.
+func WriteCounter(w io.Writer, name, help string, f float64) {
+	writeSingle(w, name, help, "counter", f)
+}
+
+// WriteGauge writes a single unlabelled gauge in the Prometheus text format.
+// It is useful for collectors which calculate their values when written.
+func WriteGauge(w io.Writer, name, help string, f float64) {
+	writeSingle(w, name, help, "gauge", f)
+}
+
+func writeSingle(w io.Writer, name, help, metricType string, f float64) {
+	fmt.Fprintf(w, "# HELP %v %v\n", name, escapeHelp(help))
+	fmt.Fprintf(w, "# TYPE %v %v\n", name, metricType)
+	fmt.Fprintf(w, "%v %v\n", name, formatFloat(f))
+}
+
+// CollectorFunc is a Collector which calls a function to write its metrics.
+type CollectorFunc struct {
+	name  string
+	write func(w io.Writer)
+}
+
+// NewCollectorFunc creates a CollectorFunc and registers it in the Default registry.
+// The write function should use WriteCounter and WriteGauge.
+func NewCollectorFunc(name string, write func(w io.Writer)) *CollectorFunc {
+	c := &CollectorFunc{name: name, write: write}
+	Default.Register(c)
+	return c
+}
+
+// Name returns the collector's name.
+func (c *CollectorFunc) Name() string {
+	return c.name
+}
+
+// Write calls the collector's write function.
+func (c *CollectorFunc) Write(w io.Writer) {
+	c.write(w)
+}
+
+// value is a float64 protected by a mutex.
+type value struct {
+	sync.Mutex
+	v float64
+}
+
+func (val *value) add(f float64) {
+	val.Lock()
+	val.v += f
+	val.Unlock()
+}
+
+func (val *value) set(f float64) {
+	val.Lock()
+	val.v = f
+	val.Unlock()
+}
+
+func (val *value) get() float64 {
+	val.Lock()
+	defer val.Unlock()
+	return val.v
+}
+
+// Counter is a value which only goes up.
+type Counter struct {
+	val value
+}
+
+// Inc increments the counter by one.
+func (c *Counter) Inc() {
+	c.val.add(1)
+}
+
+// Add adds f to the counter. f must not be negative.
+func (c *Counter) Add(f float64) {
+	if f < 0 {
+		panic("metrics: counters cannot decrease")
+	}
+	c.val.add(f)
+}
+
+// Value returns the current value of the counter.
+func (c *Counter) Value() float64 {
+	return c.val.get()
+}
+
+// CounterVec is a set of counters with the same name, partitioned by labels.
+type CounterVec struct {
+	vec
+}
+
+// NewCounterVec creates a CounterVec and registers it in the Default registry.
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	c := &CounterVec{newVec(name, help, labelNames)}
+	Default.Register(c)
+	return c
+}
+
+// With returns the counter for the label values.
+func (c *CounterVec) With(labelValues ...string) *Counter {
+	return c.child(labelValues, func() interface{} { return new(Counter) }).(*Counter)
+}
+
+// Write writes the counters in the Prometheus text format.
+func (c *CounterVec) Write(w io.Writer) {
+	c.RLock()
+	defer c.RUnlock()
+
+	c.writeHeader(w, "counter")
+	for _, key := range c.sortedKeys() {
+		fmt.Fprintf(w, "%v%v %v\n", c.name, formatLabels(c.labelNames, c.labels[key]),
+			formatFloat(c.children[key].(*Counter).Value()))
+	}
+}
+
+// Gauge is a value which can go up and down.
+type Gauge struct {
+	val value
+}
+
+// Set sets the gauge to f.
+func (g *Gauge) Set(f float64) {
+	g.val.set(f)
+}
+
+// Add adds f, which may be negative, to the gauge.
+func (g *Gauge) Add(f float64) {
+	g.val.add(f)
+}
+
+// Inc increments the gauge by one.
+func (g *Gauge) Inc() {
+	g.val.add(1)
+}
+
+// Dec decrements the gauge by one.
+func (g *Gauge) Dec() {
+	g.val.add(-1)
+}
+
+// Value returns the current value of the gauge.
+func (g *Gauge) Value() float64 {
+	return g.val.get()
+}
+
+// GaugeVec is a set of gauges with the same name, partitioned by labels.
+type GaugeVec struct {
+	vec
+}
+
+// NewGaugeVec creates a GaugeVec and registers it in the Default registry.
+func NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	g := &GaugeVec{newVec(name, help, labelNames)}
+	Default.Register(g)
+	return g
+}
+
+// With returns the gauge for the label values.
+func (g *GaugeVec) With(labelValues ...string) *Gauge {
+	return g.child(labelValues, func() interface{} { return new(Gauge) }).(*Gauge)
+}
+
+// Write writes the gauges in the Prometheus text format.
+func (g *GaugeVec) Write(w io.Writer) {
+	g.RLock()
+	defer g.RUnlock()
+
+	g.writeHeader(w, "gauge")
+	for _, key := range g.sortedKeys() {
+		fmt.Fprintf(w, "%v%v %v\n", g.name, formatLabels(g.labelNames, g.labels[key]),
+			formatFloat(g.children[key].(*Gauge).Value()))
+	}
+}
+
+// Histogram counts observations in buckets.
+type Histogram struct {
+	sync.Mutex
+	upperBounds []float64
+	counts      []uint64
+	count       uint64
+	sum         float64
+}
+
+// Observe adds an observation to the histogram.
+func (h *Histogram) Observe(f float64) {
+	h.Lock()
+	defer h.Unlock()
+
+	i := sort.SearchFloat64s(h.upperBounds, f)
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += f
+}
+
+// HistogramVec is a set of histograms with the same name and buckets, partitioned by labels.
+type HistogramVec struct {
+	vec
+	buckets []float64
+}
+
+// NewHistogramVec creates a HistogramVec and registers it in the Default registry.
+// If buckets is nil, DefaultBuckets is used.
+func NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	h := &HistogramVec{vec: newVec(name, help, labelNames), buckets: buckets}
+	Default.Register(h)
+	return h
+}
+
+// With returns the histogram for the label values.
+func (h *HistogramVec) With(labelValues ...string) *Histogram {
+	return h.child(labelValues, func() interface{} {
+		return &Histogram{upperBounds: h.buckets, counts: make([]uint64, len(h.buckets))}
+	}).(*Histogram)
+}
+
+// Write writes the histograms in the Prometheus text format.
+func (h *HistogramVec) Write(w io.Writer) {
+	h.RLock()
+	defer h.RUnlock()
+
+	h.writeHeader(w, "histogram")
+	for _, key := range h.sortedKeys() {
+		child := h.children[key].(*Histogram)
+		labelValues := h.labels[key]
+
+		child.Lock()
+		var cumulative uint64
+		for i, upperBound := range child.upperBounds {
+			cumulative += child.counts[i]
+			fmt.Fprintf(w, "%v_bucket%v %v\n", h.name,
+				formatLabels(h.labelNames, labelValues, "le", formatFloat(upperBound)), cumulative)
+		}
+		fmt.Fprintf(w, "%v_bucket%v %v\n", h.name, formatLabels(h.labelNames, labelValues, "le", "+Inf"), child.count)
+		fmt.Fprintf(w, "%v_sum%v %v\n", h.name, formatLabels(h.labelNames, labelValues), formatFloat(child.sum))
+		fmt.Fprintf(w, "%v_count%v %v\n", h.name, formatLabels(h.labelNames, labelValues), child.count)
+		child.Unlock()
+	}
+}
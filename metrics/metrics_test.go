@@ -0,0 +1,124 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCounterVec(t *testing.T) {
+	c := NewCounterVec("test_counter_total", "A test counter.", "code")
+	c.With("200").Inc()
+	c.With("200").Add(2)
+	c.With("500").Inc()
+
+	b := new(bytes.Buffer)
+	c.Write(b)
+	expected := "# HELP test_counter_total A test counter.\n" +
+		"# TYPE test_counter_total counter\n" +
+		"test_counter_total{code=\"200\"} 3\n" +
+		"test_counter_total{code=\"500\"} 1\n"
+	if b.String() != expected {
+		t.Errorf("Counter written incorrectly, got\n%v\nexpected\n%v", b.String(), expected)
+	}
+}
+
+func TestGaugeVec(t *testing.T) {
+	g := NewGaugeVec("test_gauge", "A test gauge.")
+	g.With().Set(5)
+	g.With().Dec()
+
+	b := new(bytes.Buffer)
+	g.Write(b)
+	if !strings.Contains(b.String(), "test_gauge 4\n") {
+		t.Errorf("Gauge written incorrectly, got\n%v", b.String())
+	}
+}
+
+func TestHistogramVec(t *testing.T) {
+	h := NewHistogramVec("test_histogram_seconds", "A test histogram.", []float64{1, 0.1}, "endpoint")
+	h.With("search").Observe(0.05)
+	h.With("search").Observe(0.5)
+	h.With("search").Observe(5)
+
+	b := new(bytes.Buffer)
+	h.Write(b)
+	for _, line := range []string{
+		"test_histogram_seconds_bucket{endpoint=\"search\",le=\"0.1\"} 1\n",
+		"test_histogram_seconds_bucket{endpoint=\"search\",le=\"1\"} 2\n",
+		"test_histogram_seconds_bucket{endpoint=\"search\",le=\"+Inf\"} 3\n",
+		"test_histogram_seconds_sum{endpoint=\"search\"} 5.55\n",
+		"test_histogram_seconds_count{endpoint=\"search\"} 3\n",
+	} {
+		if !strings.Contains(b.String(), line) {
+			t.Errorf("Histogram output missing %q, got\n%v", line, b.String())
+		}
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	c := NewCounterVec("test_escaped_total", "Escaping.", "origin")
+	c.With("a\"b\\c\n").Inc()
+
+	b := new(bytes.Buffer)
+	c.Write(b)
+	if !strings.Contains(b.String(), `test_escaped_total{origin="a\"b\\c\n"} 1`) {
+		t.Errorf("Label value escaped incorrectly, got\n%v", b.String())
+	}
+}
+
+func TestWrongNumberOfLabels(t *testing.T) {
+	c := NewCounterVec("test_labels_total", "Labels.", "a", "b")
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected a panic with the wrong number of label values.")
+		}
+	}()
+	c.With("a")
+}
+
+func TestHandler(t *testing.T) {
+	reg := NewRegistry()
+	c := &CounterVec{newVec("test_handler_total", "Handler.", nil)}
+	reg.Register(c)
+	c.With().Inc()
+
+	req, err := http.NewRequest("GET", "/metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	reg.Handler().ServeHTTP(w, req)
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("Bad Content-Type %v.", w.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(w.Body.String(), "test_handler_total 1\n") {
+		t.Errorf("Handler didn't serve the metrics, got\n%v", w.Body.String())
+	}
+}
+
+func TestCollectorFunc(t *testing.T) {
+	c := NewCollectorFunc("test_collector", func(w io.Writer) {
+		WriteGauge(w, "test_collector_gauge", "A gauge.", 1.5)
+		WriteCounter(w, "test_collector_total", "A counter.", 2)
+	})
+
+	b := new(bytes.Buffer)
+	c.Write(b)
+	expected := "# HELP test_collector_gauge A gauge.\n" +
+		"# TYPE test_collector_gauge gauge\n" +
+		"test_collector_gauge 1.5\n" +
+		"# HELP test_collector_total A counter.\n" +
+		"# TYPE test_collector_total counter\n" +
+		"test_collector_total 2\n"
+	if b.String() != expected {
+		t.Errorf("CollectorFunc written incorrectly, got\n%v\nexpected\n%v", b.String(), expected)
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"github.com/cu-library/lorica/metrics"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStatusClassLabel(t *testing.T) {
+	for code, expected := range map[int]string{
+		http.StatusOK:                  "2xx",
+		http.StatusNotModified:         "3xx",
+		http.StatusTooManyRequests:     "4xx",
+		http.StatusInternalServerError: "5xx",
+		42:                             "other",
+	} {
+		if label := statusClassLabel(code); label != expected {
+			t.Errorf("Status code %v had label %v, expected %v.", code, label, expected)
+		}
+	}
+}
+
+func TestEndpointLabel(t *testing.T) {
+	for path, expected := range map[string]string{
+		"/2.0.0/search":             "search",
+		"/2.0.0/search/ping":        "search",
+		"/2.0.0/availability/12345": "availability",
+		"/2.0.0/suggest":            "suggest",
+		"/":                         "other",
+		"/favicon.ico":              "other",
+		"/2.0.0/unknown":            "other",
+	} {
+		if label := endpointLabel(path); label != expected {
+			t.Errorf("Path %v had label %v, expected %v.", path, label, expected)
+		}
+	}
+}
+
+func TestOriginLabel(t *testing.T) {
+	oldAllowedOrigins := *allowedOrigins
+	*allowedOrigins = "http://test.com; http://test2.com"
+	defer func() { *allowedOrigins = oldAllowedOrigins }()
+
+	for origin, expected := range map[string]string{
+		"":                  "none",
+		"http://test.com":   "http://test.com",
+		"http://test2.com":  "http://test2.com",
+		"http://random.com": "other",
+	} {
+		if label := originLabel(origin); label != expected {
+			t.Errorf("Origin %v had label %v, expected %v.", origin, label, expected)
+		}
+	}
+}
+
+// The Summon API's latency is recorded by endpoint and status class.
+func TestUpstreamDuration(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+	oldAPIURL := *apiURL
+	*apiURL = ts.URL
+	defer func() { *apiURL = oldAPIURL }()
+
+	proxyHandler(httptest.NewRecorder(), httptest.NewRequest("GET", "/2.0.0/availability?s.q=forest", nil))
+
+	w := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(w.Body.String(), `lorica_upstream_duration_seconds_count{endpoint="availability",status_class="5xx"}`) {
+		t.Error("The Summon API's latency wasn't recorded.")
+	}
+}
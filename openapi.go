@@ -0,0 +1,299 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// OpenAPIPath is the path of the OpenAPI document describing Lorica's endpoints.
+const OpenAPIPath = "/openapi.json"
+
+// OpenAPIVersion is the version of the OpenAPI Specification the document follows.
+const OpenAPIVersion = "3.0.3"
+
+// openAPISchemaNames are the names of schemas which aren't their Go type's name.
+var openAPISchemaNames = map[reflect.Type]string{
+	reflect.TypeOf(jsonError{}): "Error",
+}
+
+// openAPIObject is a JSON object in the OpenAPI document.
+type openAPIObject map[string]interface{}
+
+// openAPIBuilder builds the OpenAPI document. The schemas of the JSON
+// responses are made from the Go types which are marshalled, so the document
+// doesn't drift from the code.
+type openAPIBuilder struct {
+	schemas openAPIObject
+}
+
+// schemaName returns the name of the schema for a named Go type, like JobStatus for jobStatus.
+func schemaName(t reflect.Type) string {
+	if name, ok := openAPISchemaNames[t]; ok {
+		return name
+	}
+	return strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+}
+
+// schema returns the schema of the JSON encoding/json makes from a value of
+// the type. Named structs are added to the components, and referred to.
+func (b *openAPIBuilder) schema(t reflect.Type) openAPIObject {
+	switch {
+	case t == reflect.TypeOf(time.Time{}):
+		return openAPIObject{"type": "string", "format": "date-time"}
+	case t == reflect.TypeOf(json.RawMessage{}):
+		return openAPIObject{"description": "Any JSON value."}
+	case t == reflect.TypeOf(http.Header{}):
+		values := openAPIObject{"type": "array", "items": openAPIObject{"type": "string"}}
+		return openAPIObject{"type": "object", "additionalProperties": values}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return b.schema(t.Elem())
+	case reflect.String:
+		return openAPIObject{"type": "string"}
+	case reflect.Bool:
+		return openAPIObject{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return openAPIObject{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return openAPIObject{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return openAPIObject{"type": "string", "format": "byte"}
+		}
+		return openAPIObject{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return openAPIObject{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.structSchema(t)
+		}
+		name := schemaName(t)
+		if _, ok := b.schemas[name]; !ok {
+			// The name is added first, so a type which refers to itself doesn't recurse forever.
+			b.schemas[name] = openAPIObject{}
+			b.schemas[name] = b.structSchema(t)
+		}
+		return openAPIObject{"$ref": "#/components/schemas/" + name}
+	}
+	return openAPIObject{}
+}
+
+// structSchema returns the schema of a struct's JSON object. Fields without
+// omitempty are always sent, so they are required.
+func (b *openAPIBuilder) structSchema(t reflect.Type) openAPIObject {
+	properties := openAPIObject{}
+	required := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+		tag := strings.Split(field.Tag.Get("json"), ",")
+		if tag[0] == "-" {
+			continue
+		}
+		name := tag[0]
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = b.schema(field.Type)
+		if len(tag) < 2 || tag[1] != "omitempty" {
+			required = append(required, name)
+		}
+	}
+	s := openAPIObject{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+// response returns a successful response with the schema of the value's
+// type, or with the content type if the value is nil.
+func (b *openAPIBuilder) response(description string, value interface{}, contentType string) openAPIObject {
+	media := openAPIObject{}
+	if value != nil {
+		contentType = "application/json"
+		media["schema"] = b.schema(reflect.TypeOf(value))
+	}
+	return openAPIObject{"description": description, "content": openAPIObject{contentType: media}}
+}
+
+// operation returns an operation with the successful response, and errors
+// sent as Lorica's structured errors.
+func (b *openAPIBuilder) operation(summary, tag string, parameters []openAPIObject, ok openAPIObject) openAPIObject {
+	op := openAPIObject{
+		"summary": summary,
+		"tags":    []string{tag},
+		"responses": openAPIObject{
+			"200":     ok,
+			"default": b.response("An error.", jsonError{}, ""),
+		},
+	}
+	if len(parameters) > 0 {
+		op["parameters"] = parameters
+	}
+	return op
+}
+
+// openAPIQueryParameters returns optional string query parameters with the names.
+func openAPIQueryParameters(names ...string) []openAPIObject {
+	var parameters []openAPIObject
+	for _, name := range names {
+		parameters = append(parameters, openAPIObject{"name": name, "in": "query", "schema": openAPIObject{"type": "string"}})
+	}
+	return parameters
+}
+
+// summonParameters are the Summon API query parameters, like s.q, which are passed on.
+var summonParameters = openAPIObject{
+	"name":        "parameters",
+	"in":          "query",
+	"description": "Summon API parameters, like s.q and s.fvf. See the Summon API documentation.",
+	"style":       "form",
+	"explode":     true,
+	"schema":      openAPIObject{"type": "object", "additionalProperties": openAPIObject{"type": "string"}},
+}
+
+// pathItem returns a path item with the operation for each method.
+func pathItem(methods []string, op openAPIObject) openAPIObject {
+	item := openAPIObject{}
+	for _, method := range methods {
+		item[strings.ToLower(method)] = op
+	}
+	return item
+}
+
+// openAPIDocument returns the OpenAPI document describing the endpoints
+// Lorica serves with its current options.
+func openAPIDocument() openAPIObject {
+	b := &openAPIBuilder{schemas: openAPIObject{}}
+	paths := openAPIObject{}
+
+	// The Summon API endpoints which are proxied.
+	session := openAPIObject{"name": "x-summon-session-id", "in": "header", "schema": openAPIObject{"type": "string"}}
+	for _, endpoint := range knownEndpoints {
+		path := "/" + apiVersion() + "/" + endpoint
+		if !pathAllowed(path) {
+			continue
+		}
+		summary := "Sends the request to the Summon API " + endpoint + " endpoint, signed with Lorica's credentials."
+		ok := openAPIObject{
+			"description": "The Summon API's response.",
+			"content":     openAPIObject{"application/json": openAPIObject{"schema": openAPIObject{"type": "object"}}},
+		}
+		paths[path] = pathItem(proxiedMethods(), b.operation(summary, "summon", []openAPIObject{summonParameters, session}, ok))
+	}
+
+	// The endpoints Lorica answers itself, using the Summon API.
+	if *batchMax > 0 {
+		op := b.operation("Runs several searches at once.", "lorica", nil, b.response("The results, in the same order.", []batchResult{}, ""))
+		op["requestBody"] = openAPIObject{
+			"required":    true,
+			"description": "The query strings of the searches, like s.q=forest.",
+			"content": openAPIObject{"application/json": openAPIObject{
+				"schema": openAPIObject{"type": "array", "items": openAPIObject{"type": "string"}, "maxItems": *batchMax},
+			}},
+		}
+		paths[BatchPath] = pathItem([]string{"POST"}, op)
+	}
+	paths[CountPath] = pathItem([]string{"GET", "HEAD"}, b.operation("Counts the results of a search.", "lorica",
+		[]openAPIObject{summonParameters}, b.response("The number of results.", resultCount{}, "")))
+	paths[DidYouMeanPath] = pathItem([]string{"GET"}, b.operation("Suggests spellings for a search.", "lorica",
+		openAPIQueryParameters("q"), b.response("The suggestions.", didYouMean{}, "")))
+	paths[CapabilitiesPath] = pathItem([]string{"GET", "HEAD"}, b.operation("Lists what this instance of Lorica supports.",
+		"lorica", nil, b.response("The capabilities.", capabilities{}, "")))
+	paths["/status"] = pathItem([]string{"GET"}, b.operation("Shows whether search is working, for patrons.",
+		"lorica", nil, b.response("The status page.", nil, "text/html")))
+	paths[ErrorsPath] = pathItem([]string{"GET", "HEAD"}, b.operation("Lists the error codes Lorica sends.",
+		"lorica", nil, b.response("The error codes.", errorCodes, "")))
+	paths[OpenAPIPath] = pathItem([]string{"GET", "HEAD"}, b.operation("Sends this document.",
+		"lorica", nil, b.response("The OpenAPI document.", nil, "application/json")))
+
+	// The admin endpoints are on the admin address, when there is one.
+	var adminServers []openAPIObject
+	if *adminAddress != "" {
+		adminServers = []openAPIObject{{
+			"url":         "http://{adminAddress}",
+			"description": "The admin address.",
+			"variables":   openAPIObject{"adminAddress": openAPIObject{"default": *adminAddress}},
+		}}
+	}
+	for _, p := range []struct{ path, summary string }{
+		{"/metrics", "Sends the metrics in the Prometheus text format."},
+		{"/healthz", "Reports that Lorica is alive."},
+		{"/readyz", "Reports whether Lorica is ready to serve requests."},
+	} {
+		item := pathItem([]string{"GET"}, b.operation(p.summary, "admin", nil, b.response("OK.", nil, "text/plain")))
+		if adminServers != nil {
+			item["servers"] = adminServers
+		}
+		paths[p.path] = item
+	}
+	if *adminAddress != "" {
+		// Without admin tokens, the admin endpoints don't need one.
+		security := []openAPIObject{{"adminToken": []string{}}, {"bearerToken": []string{}}, {}}
+		for _, e := range adminEndpoints() {
+			op := b.operation(e.summary, "admin", openAPIQueryParameters(e.params...), b.response("OK.", e.response, e.contentType))
+			op["security"] = security
+			item := pathItem(e.methods, op)
+			item["servers"] = adminServers
+			paths[e.path] = item
+		}
+	}
+
+	// The code of an error is one of the registered codes.
+	b.schema(reflect.TypeOf(jsonError{}))
+	b.schemas["Error"].(openAPIObject)["properties"].(openAPIObject)["code"].(openAPIObject)["enum"] = errorCodeNames()
+
+	return openAPIObject{
+		"openapi": OpenAPIVersion,
+		"info": openAPIObject{
+			"title": "Lorica",
+			"description": "A proxy for the Summon API, which signs requests with the library's credentials. " +
+				"Paths can start with a credential prefix, like /sandbox/2.0.0/search, to use other credentials.",
+			"version": version,
+		},
+		"paths": paths,
+		"components": openAPIObject{
+			"schemas": b.schemas,
+			"securitySchemes": openAPIObject{
+				"adminToken":  openAPIObject{"type": "apiKey", "in": "header", "name": AdminTokenHeader},
+				"bearerToken": openAPIObject{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+}
+
+// openAPIHandler sends the OpenAPI document. It can be read from any allowed
+// origin, so browser tools can load it.
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Origin") != "" {
+		setACAOHeader(w, r)
+	}
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		sendJSONError(w, http.StatusMethodNotAllowed,
+			"Only GET and HEAD requests are accepted by "+OpenAPIPath+".", nil)
+		return
+	}
+	body, err := json.MarshalIndent(openAPIDocument(), "", "  ")
+	if err != nil {
+		sendJSONError(w, http.StatusInternalServerError, "Unable to build the OpenAPI document.", nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	// Like the capabilities, the document changes with the enabled features.
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	w.Header().Set("Vary", "Origin")
+	w.Write(append(body, '\n'))
+}
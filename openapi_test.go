@@ -0,0 +1,99 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Every endpoint Lorica serves is in the document.
+func TestOpenAPIDocumentPaths(t *testing.T) {
+	oldAdminAddress := *adminAddress
+	*adminAddress = "localhost:9090"
+	defer func() { *adminAddress = oldAdminAddress }()
+
+	paths := openAPIDocument()["paths"].(openAPIObject)
+	for _, path := range currentCapabilities().Endpoints {
+		if _, ok := paths[path]; !ok {
+			t.Errorf("The capability endpoint %v isn't in the OpenAPI document.", path)
+		}
+	}
+	for _, e := range adminEndpoints() {
+		item, ok := paths[e.path].(openAPIObject)
+		if !ok {
+			t.Errorf("The admin endpoint %v isn't in the OpenAPI document.", e.path)
+			continue
+		}
+		for _, method := range e.methods {
+			if _, ok := item[strings.ToLower(method)]; !ok {
+				t.Errorf("The %v method of %v isn't in the OpenAPI document.", method, e.path)
+			}
+		}
+	}
+
+	*adminAddress = ""
+	paths = openAPIDocument()["paths"].(openAPIObject)
+	if _, ok := paths["/admin/jobs"]; ok {
+		t.Error("Without an admin address, the admin endpoints are in the OpenAPI document.")
+	}
+}
+
+// The schemas are made from the Go types.
+func TestOpenAPISchema(t *testing.T) {
+	b := &openAPIBuilder{schemas: openAPIObject{}}
+	ref := b.schema(reflect.TypeOf([]jobStatus{}))
+	if ref["type"] != "array" || ref["items"].(openAPIObject)["$ref"] != "#/components/schemas/JobStatus" {
+		t.Errorf("The schema of []jobStatus was %v", ref)
+	}
+
+	b.schema(reflect.TypeOf(jsonError{}))
+	errorSchema := b.schemas["Error"].(openAPIObject)
+	required := errorSchema["required"].([]string)
+	if !reflect.DeepEqual(required, []string{"status", "error", "message"}) {
+		t.Errorf("The required properties of Error were %v", required)
+	}
+	properties := errorSchema["properties"].(openAPIObject)
+	if properties["status"].(openAPIObject)["type"] != "integer" || properties["hints"].(openAPIObject)["type"] != "array" {
+		t.Errorf("The properties of Error were %v", properties)
+	}
+
+	schemas := openAPIDocument()["components"].(openAPIObject)["schemas"].(openAPIObject)
+	code := schemas["Error"].(openAPIObject)["properties"].(openAPIObject)["code"].(openAPIObject)
+	if len(code["enum"].([]string)) != len(errorCodes) {
+		t.Errorf("The code of Error was %v", code)
+	}
+
+	b.schema(reflect.TypeOf(evidenceEntry{}))
+	header := b.schemas["EvidenceEntry"].(openAPIObject)["properties"].(openAPIObject)["request_header"].(openAPIObject)
+	if header["type"] != "object" || header["additionalProperties"].(openAPIObject)["type"] != "array" {
+		t.Errorf("The schema of a http.Header was %v", header)
+	}
+}
+
+func TestOpenAPIHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	openAPIHandler(w, httptest.NewRequest("GET", OpenAPIPath, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("The status was %v", w.Code)
+	}
+	var document map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &document); err != nil {
+		t.Fatal(err)
+	}
+	if document["openapi"] != OpenAPIVersion {
+		t.Errorf("The document was %v", document)
+	}
+
+	w = httptest.NewRecorder()
+	openAPIHandler(w, httptest.NewRequest("POST", OpenAPIPath, nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("A POST got %v", w.Code)
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"container/list"
+	"flag"
+	"fmt"
+	l "github.com/cu-library/lorica/loglevel"
+	"github.com/cu-library/lorica/metrics"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultOriginAuthTTL is how long the answer for an origin is cached by default.
+	DefaultOriginAuthTTL = 5 * time.Minute
+
+	// OriginAuthErrorTTL is how long an origin is refused after the endpoint couldn't be asked about it.
+	// While the endpoint is failing, it isn't asked about other origins either.
+	OriginAuthErrorTTL = 10 * time.Second
+
+	// MaxOriginAuthEntries is the most origins whose answers are cached. Allowed and refused origins are
+	// cached apart, so a flood of made up origins can't push out the allowed ones.
+	MaxOriginAuthEntries = 10000
+
+	// MaxOriginAuthInFlight is the most requests sent to the endpoint at once. Origins which would need
+	// another request are refused without asking.
+	MaxOriginAuthInFlight = 8
+)
+
+var (
+	originAuthURL = flag.String("originauthurl", "", "The URL of an endpoint which decides whether origins "+
+		"which aren't allowed by -allowedorigins or -allowedoriginsfile are allowed. Lorica sends a GET request "+
+		"with the origin in the origin parameter. A 200 response allows it, and a 403 or 404 refuses it.")
+	originAuthTTL = flag.Duration("originauthttl", DefaultOriginAuthTTL, "How long the origin authorization "+
+		"endpoint's answer for an origin is cached.")
+
+	// originAuth asks the origin authorization endpoint, if there is one.
+	originAuth *originAuthorizer
+
+	originAuthRequestsTotal = metrics.NewCounterVec("lorica_origin_auth_requests_total",
+		"The number of requests to the origin authorization endpoint, by result. Origins refused without "+
+			"asking, because the endpoint is busy or failing, are skipped.", "result")
+)
+
+// The results of asking the origin authorization endpoint.
+const (
+	OriginAuthAllowed = "allowed"
+	OriginAuthDenied  = "denied"
+	OriginAuthError   = "error"
+	OriginAuthSkipped = "skipped"
+)
+
+// originAuthEntry is a cached answer from the origin authorization endpoint.
+type originAuthEntry struct {
+	origin  string
+	allowed bool
+	expires time.Time
+}
+
+// originAuthAnswers holds answers from the origin authorization endpoint,
+// removing the least recently used ones when it is full.
+type originAuthAnswers struct {
+	entries map[string]*list.Element
+	order   *list.List
+	max     int
+}
+
+func newOriginAuthAnswers(max int) *originAuthAnswers {
+	return &originAuthAnswers{entries: make(map[string]*list.Element), order: list.New(), max: max}
+}
+
+// get returns the answer for the origin, if there is one which hasn't expired.
+func (a *originAuthAnswers) get(origin string, now time.Time) (originAuthEntry, bool) {
+	e, ok := a.entries[origin]
+	if !ok {
+		return originAuthEntry{}, false
+	}
+	entry := e.Value.(originAuthEntry)
+	if !now.Before(entry.expires) {
+		a.remove(origin)
+		return originAuthEntry{}, false
+	}
+	a.order.MoveToFront(e)
+	return entry, true
+}
+
+// add keeps the answer, removing the least recently used answers to make room.
+func (a *originAuthAnswers) add(entry originAuthEntry) {
+	a.remove(entry.origin)
+	for a.order.Len() >= a.max && a.order.Len() > 0 {
+		a.remove(a.order.Back().Value.(originAuthEntry).origin)
+	}
+	a.entries[entry.origin] = a.order.PushFront(entry)
+}
+
+// remove removes the answer for the origin, if there is one.
+func (a *originAuthAnswers) remove(origin string) {
+	if e, ok := a.entries[origin]; ok {
+		a.order.Remove(e)
+		delete(a.entries, origin)
+	}
+}
+
+// originAuthCall is a request to the endpoint which others are waiting for.
+type originAuthCall struct {
+	done    chan struct{}
+	allowed bool
+}
+
+// originAuthorizer asks an external endpoint whether origins are allowed,
+// and caches the answers. An origin is only asked about once at a time.
+type originAuthorizer struct {
+	sync.Mutex
+	url     string
+	ttl     time.Duration
+	client  *http.Client
+	allowed *originAuthAnswers
+	refused *originAuthAnswers
+	calls   map[string]*originAuthCall
+	slots   chan struct{}
+	failing bool
+	retry   time.Time
+	now     func() time.Time
+}
+
+func newOriginAuthorizer(endpoint string, ttl, timeout time.Duration) (*originAuthorizer, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, withoutURL(err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("the URL's scheme is %v, not http or https", u.Scheme)
+	}
+	return &originAuthorizer{
+		url:     endpoint,
+		ttl:     ttl,
+		client:  &http.Client{Timeout: timeout},
+		allowed: newOriginAuthAnswers(MaxOriginAuthEntries),
+		refused: newOriginAuthAnswers(MaxOriginAuthEntries),
+		calls:   make(map[string]*originAuthCall),
+		slots:   make(chan struct{}, MaxOriginAuthInFlight),
+		now:     time.Now,
+	}, nil
+}
+
+// Allowed returns true if the endpoint allows the origin. Origins are
+// refused if the endpoint can't be asked, or is busy.
+func (oa *originAuthorizer) Allowed(origin string) bool {
+	oa.Lock()
+	now := oa.now()
+	if entry, ok := oa.allowed.get(origin, now); ok {
+		oa.Unlock()
+		return entry.allowed
+	}
+	if entry, ok := oa.refused.get(origin, now); ok {
+		oa.Unlock()
+		return entry.allowed
+	}
+	if c, ok := oa.calls[origin]; ok {
+		oa.Unlock()
+		<-c.done
+		return c.allowed
+	}
+	if oa.failing && now.Before(oa.retry) {
+		oa.Unlock()
+		originAuthRequestsTotal.With(OriginAuthSkipped).Inc()
+		return false
+	}
+	select {
+	case oa.slots <- struct{}{}:
+	default:
+		oa.Unlock()
+		originAuthRequestsTotal.With(OriginAuthSkipped).Inc()
+		return false
+	}
+	c := &originAuthCall{done: make(chan struct{})}
+	oa.calls[origin] = c
+	oa.Unlock()
+
+	result, err := oa.ask(origin)
+	<-oa.slots
+	originAuthRequestsTotal.With(result).Inc()
+	c.allowed = result == OriginAuthAllowed
+
+	oa.Lock()
+	delete(oa.calls, origin)
+	now = oa.now()
+	entry := originAuthEntry{origin: origin, allowed: c.allowed, expires: now.Add(oa.ttl)}
+	if err != nil {
+		entry.expires = now.Add(OriginAuthErrorTTL)
+		oa.retry = entry.expires
+	}
+	if c.allowed {
+		oa.allowed.add(entry)
+	} else {
+		oa.allowed.remove(origin)
+		oa.refused.add(entry)
+	}
+	// Only the first failure is an error, so a broken endpoint doesn't flood the log.
+	switch {
+	case err != nil && !oa.failing:
+		l.Logf(l.ErrorMessage, "Unable to authorize origin %v: %v", origin, err)
+	case err != nil:
+		l.Logf(l.DebugMessage, "Unable to authorize origin %v: %v", origin, err)
+	case oa.failing:
+		l.Log(l.InfoMessage, "The origin authorization endpoint is answering again.")
+	}
+	oa.failing = err != nil
+	oa.Unlock()
+	close(c.done)
+	return c.allowed
+}
+
+// withoutURL removes the URL from an error returned by the HTTP client or
+// url.Parse, since secret URLs, like -originauthurl, often carry a token.
+func withoutURL(err error) error {
+	if ue, ok := err.(*url.Error); ok {
+		return fmt.Errorf("%v: %v", ue.Op, ue.Err)
+	}
+	return err
+}
+
+// ask sends the origin to the endpoint.
+func (oa *originAuthorizer) ask(origin string) (string, error) {
+	u, err := url.Parse(oa.url)
+	if err != nil {
+		return OriginAuthError, err
+	}
+	query := u.Query()
+	query.Set("origin", origin)
+	u.RawQuery = query.Encode()
+	resp, err := oa.client.Get(u.String())
+	if err != nil {
+		return OriginAuthError, withoutURL(err)
+	}
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, MaxUpstreamErrorBody))
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return OriginAuthAllowed, nil
+	case http.StatusForbidden, http.StatusNotFound:
+		return OriginAuthDenied, nil
+	default:
+		return OriginAuthError, fmt.Errorf("the endpoint responded with %v", resp.Status)
+	}
+}
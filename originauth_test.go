@@ -0,0 +1,187 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Answers from the endpoint are cached until they expire, and errors refuse the origin.
+func TestOriginAuthorizer(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.FormValue("origin") {
+		case "https://member.example":
+		case "https://broken.example":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer ts.Close()
+
+	oa, err := newOriginAuthorizer(ts.URL+"/cors?instance=main", time.Minute, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	oa.now = func() time.Time { return now }
+
+	if !oa.Allowed("https://member.example") || !oa.Allowed("https://member.example") {
+		t.Error("Member origin wasn't allowed.")
+	}
+	if oa.Allowed("https://stranger.example") {
+		t.Error("Refused origin was allowed.")
+	}
+	if requests != 2 {
+		t.Errorf("Endpoint got %v requests, expected 2.", requests)
+	}
+
+	now = now.Add(2 * time.Minute)
+	oa.Allowed("https://member.example")
+	if requests != 3 {
+		t.Errorf("Expired answer wasn't refreshed, endpoint got %v requests.", requests)
+	}
+
+	if oa.Allowed("https://broken.example") {
+		t.Error("Origin was allowed when the endpoint failed.")
+	}
+	now = now.Add(OriginAuthErrorTTL)
+	oa.Allowed("https://broken.example")
+	if requests != 5 {
+		t.Errorf("Error was cached too long, endpoint got %v requests.", requests)
+	}
+
+	if _, err := newOriginAuthorizer("ftp://token@example", time.Minute, time.Second); err == nil || strings.Contains(err.Error(), "token") {
+		t.Errorf("Non-HTTP URL didn't return an error without the URL, got %v", err)
+	}
+}
+
+// Origins allowed by the endpoint get an Access-Control-Allow-Origin header.
+func TestSetACAOHeaderOriginAuth(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("origin") != "https://member.example" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	oldOriginAuth := originAuth
+	originAuth, _ = newOriginAuthorizer(ts.URL, time.Minute, time.Second)
+	defer func() { originAuth = oldOriginAuth }()
+
+	for origin, expected := range map[string]string{
+		"https://member.example":   "https://member.example",
+		"https://stranger.example": "",
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Origin", origin)
+		w := httptest.NewRecorder()
+		setACAOHeader(w, r)
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != expected {
+			t.Errorf("Origin %v got %q, expected %q.", origin, got, expected)
+		}
+		// The endpoint could allow any number of origins, so they aren't metric labels.
+		if label := originLabel(origin); label != "other" {
+			t.Errorf("Origin %v had the label %v", origin, label)
+		}
+	}
+}
+
+// An origin is only asked about once at a time, and refusals don't push out allowed origins.
+func TestOriginAuthorizerBounded(t *testing.T) {
+	var mu sync.Mutex
+	asked := make(map[string]int)
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		asked[r.FormValue("origin")]++
+		mu.Unlock()
+		if r.FormValue("origin") == "https://slow.example" {
+			<-release
+		}
+		if !strings.HasPrefix(r.FormValue("origin"), "https://member") {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer ts.Close()
+	oa, err := newOriginAuthorizer(ts.URL, time.Minute, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oa.refused = newOriginAuthAnswers(2)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			oa.Allowed("https://slow.example")
+		}()
+	}
+	for {
+		oa.Lock()
+		_, ok := oa.calls["https://slow.example"]
+		oa.Unlock()
+		if ok {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if asked["https://slow.example"] != 1 {
+		t.Errorf("The endpoint was asked about one origin %v times.", asked["https://slow.example"])
+	}
+
+	if !oa.Allowed("https://member.example") {
+		t.Fatal("The member origin wasn't allowed.")
+	}
+	for i := 0; i < 5; i++ {
+		oa.Allowed(fmt.Sprintf("https://spoofed%v.example", i))
+	}
+	oa.Allowed("https://member.example")
+	if asked["https://member.example"] != 1 || oa.refused.order.Len() != 2 {
+		t.Errorf("The member origin was asked about %v times, and %v refusals were kept.",
+			asked["https://member.example"], oa.refused.order.Len())
+	}
+}
+
+// While the endpoint is failing, it isn't asked about other origins.
+func TestOriginAuthorizerFailing(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+	oa, err := newOriginAuthorizer(ts.URL, time.Minute, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	oa.now = func() time.Time { return now }
+
+	for i := 0; i < 5; i++ {
+		if oa.Allowed(fmt.Sprintf("https://origin%v.example", i)) {
+			t.Error("An origin was allowed while the endpoint was failing.")
+		}
+	}
+	if requests != 1 {
+		t.Errorf("The failing endpoint got %v requests.", requests)
+	}
+	now = now.Add(OriginAuthErrorTTL)
+	oa.Allowed("https://origin9.example")
+	if requests != 2 {
+		t.Errorf("The endpoint wasn't asked again after %v.", OriginAuthErrorTTL)
+	}
+}
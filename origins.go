@@ -0,0 +1,173 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	l "github.com/cu-library/lorica/loglevel"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OriginsFileCheckInterval is the time between checks for changes to the allowed origins file.
+const OriginsFileCheckInterval = 5 * time.Second
+
+var (
+	allowedOriginsFile = flag.String("allowedoriginsfile", "", "A file of allowed origins for CORS, one per line, "+
+		"used along with -allowedorigins. Lines starting with # are ignored. The file is reloaded when it changes.")
+
+	// fileOrigins are the origins read from the allowed origins file.
+	fileOrigins = &originList{}
+)
+
+// originList is a list of allowed origins which can be replaced while serving requests.
+type originList struct {
+	sync.RWMutex
+	origins []string
+	modTime time.Time
+	size    int64
+}
+
+// Origins returns the allowed origins.
+func (ol *originList) Origins() []string {
+	ol.RLock()
+	defer ol.RUnlock()
+	return ol.origins
+}
+
+// Load reads the allowed origins from the file if it has changed since it was
+// last loaded, and returns true if it was.
+func (ol *originList) Load(filePath string) (bool, error) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return false, err
+	}
+	ol.RLock()
+	unchanged := info.ModTime().Equal(ol.modTime) && info.Size() == ol.size
+	ol.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+	origins, err := readOriginsFile(filePath)
+	if err != nil {
+		return false, err
+	}
+	ol.Lock()
+	ol.origins, ol.modTime, ol.size = origins, info.ModTime(), info.Size()
+	ol.Unlock()
+	return true, nil
+}
+
+// readOriginsFile reads a file of origins, one per line.
+// Blank lines and lines starting with # are ignored.
+func readOriginsFile(filePath string) ([]string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	origins := []string{}
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, err := path.Match(line, ""); err != nil {
+			return nil, fmt.Errorf("%v:%v: bad pattern %v", filePath, lineNumber, line)
+		}
+		origins = append(origins, line)
+	}
+	return origins, scanner.Err()
+}
+
+// reloadOriginsFile reloads the allowed origins file if it has changed.
+// If the file can't be read, the origins loaded before are kept.
+func reloadOriginsFile(filePath string) error {
+	changed, err := fileOrigins.Load(filePath)
+	if err != nil {
+		return fmt.Errorf("unable to reload allowed origins file: %v", err)
+	}
+	if changed {
+		l.Logf(l.InfoMessage, "Reloaded %v Allowed Origins from %v", len(fileOrigins.Origins()), filePath)
+	}
+	return nil
+}
+
+// defaultPorts are the ports which are left out of normalized origins.
+var defaultPorts = map[string]string{"http": "80", "https": "443"}
+
+// normalizeOrigin returns the origin with its scheme and host in lower case,
+// the scheme's default port removed, and any trailing slash removed, so
+// different spellings of one origin can be compared. Values which aren't
+// scheme://host origins, like null, are only lower cased.
+func normalizeOrigin(origin string) string {
+	origin = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(origin), "/"))
+	parts := strings.SplitN(origin, "://", 2)
+	if len(parts) != 2 {
+		return origin
+	}
+	scheme, host := parts[0], parts[1]
+	if port, ok := defaultPorts[scheme]; ok && !strings.HasSuffix(host, "]") {
+		host = strings.TrimSuffix(host, ":"+port)
+	}
+	return scheme + "://" + host
+}
+
+// matchOrigin returns the allowed origin or pattern which matches the origin,
+// or an empty string if none do. Origins which aren't listed are allowed if
+// the origin authorization endpoint allows them.
+func matchOrigin(origin string) string {
+	if okOrigin := matchListedOrigin(origin); okOrigin != "" || origin == "" {
+		return okOrigin
+	}
+	normalized := normalizeOrigin(origin)
+	if originAuth != nil && originAuth.Allowed(normalized) {
+		return normalized
+	}
+	return ""
+}
+
+// matchListedOrigin returns the allowed origin or pattern in -allowedorigins
+// or -allowedoriginsfile which matches the origin, or an empty string if none
+// do. Patterns can use * to match any part of the host, like
+// https://*.example.edu. Origins are normalized before they are compared.
+func matchListedOrigin(origin string) string {
+	if *allowedOrigins == "*" {
+		return "*"
+	}
+	if origin == "" {
+		return ""
+	}
+	normalized := normalizeOrigin(origin)
+	for _, okOrigins := range [][]string{strings.Split(*allowedOrigins, ";"), fileOrigins.Origins()} {
+		for _, okOrigin := range okOrigins {
+			okOrigin = strings.TrimSpace(okOrigin)
+			if okOrigin == "" {
+				continue
+			}
+			if originMatches(okOrigin, normalized) {
+				return okOrigin
+			}
+		}
+	}
+	return ""
+}
+
+// originMatches returns true if the allowed origin or pattern matches the
+// normalized origin. Allowed origins are normalized before they are compared.
+func originMatches(okOrigin, normalized string) bool {
+	okOrigin = normalizeOrigin(okOrigin)
+	if okOrigin == normalized {
+		return true
+	}
+	matched, _ := path.Match(okOrigin, normalized)
+	return matched
+}
@@ -0,0 +1,135 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// The origins file is read again only when it changes.
+func TestOriginListLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lorica")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filePath := filepath.Join(dir, "origins")
+	if err := ioutil.WriteFile(filePath, []byte("# Campus sites\nhttp://library.example\n\nhttps://*.example.edu\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ol := &originList{}
+	changed, err := ol.Load(filePath)
+	if err != nil || !changed {
+		t.Fatalf("First load got %v, %v", changed, err)
+	}
+	if origins := ol.Origins(); len(origins) != 2 || origins[1] != "https://*.example.edu" {
+		t.Errorf("Origins were %v", origins)
+	}
+	if changed, _ := ol.Load(filePath); changed {
+		t.Error("Unchanged file was reloaded.")
+	}
+
+	if err := ioutil.WriteFile(filePath, []byte("http://other.example\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(filePath, later, later)
+	if changed, err := ol.Load(filePath); err != nil || !changed {
+		t.Errorf("Changed file got %v, %v", changed, err)
+	}
+	if origins := ol.Origins(); len(origins) != 1 || origins[0] != "http://other.example" {
+		t.Errorf("Origins were %v", origins)
+	}
+
+	if err := ioutil.WriteFile(filePath, []byte("http://[bad\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(filePath, later.Add(time.Minute), later.Add(time.Minute))
+	if _, err := ol.Load(filePath); err == nil {
+		t.Error("Bad pattern didn't return an error.")
+	}
+	if origins := ol.Origins(); len(origins) != 1 {
+		t.Errorf("Origins were replaced after an error, got %v", origins)
+	}
+}
+
+// Origins from the flag and the file are allowed, and patterns match hosts.
+func TestMatchOrigin(t *testing.T) {
+	oldAllowedOrigins := *allowedOrigins
+	*allowedOrigins = "http://test.com"
+	oldFileOrigins := fileOrigins
+	fileOrigins = &originList{origins: []string{"https://*.example.edu"}}
+	defer func() {
+		*allowedOrigins = oldAllowedOrigins
+		fileOrigins = oldFileOrigins
+	}()
+
+	for origin, expected := range map[string]string{
+		"":                             "",
+		"http://test.com":              "http://test.com",
+		"https://library.example.edu":  "https://*.example.edu",
+		"http://library.example.edu":   "",
+		"https://example.edu.evil.com": "",
+	} {
+		if match := matchOrigin(origin); match != expected {
+			t.Errorf("Origin %v matched %q, expected %q.", origin, match, expected)
+		}
+	}
+	if label := originLabel("https://library.example.edu"); label != "https://*.example.edu" {
+		t.Errorf("Origin label was %v", label)
+	}
+}
+
+func TestNormalizeOrigin(t *testing.T) {
+	for origin, expected := range map[string]string{
+		"https://library.example.org":      "https://library.example.org",
+		"HTTPS://Library.Example.ORG":      "https://library.example.org",
+		"https://library.example.org:443":  "https://library.example.org",
+		"https://library.example.org/":     "https://library.example.org",
+		"http://library.example.org:80":    "http://library.example.org",
+		"http://library.example.org:443":   "http://library.example.org:443",
+		"https://library.example.org:8443": "https://library.example.org:8443",
+		"https://[::1]:443":                "https://[::1]",
+		"https://[::443]":                  "https://[::443]",
+		"null":                             "null",
+		"":                                 "",
+	} {
+		if got := normalizeOrigin(origin); got != expected {
+			t.Errorf("Origin %q was normalized to %q, expected %q.", origin, got, expected)
+		}
+	}
+}
+
+// Different spellings of an allowed origin are allowed.
+func TestMatchOriginNormalized(t *testing.T) {
+	oldAllowedOrigins := *allowedOrigins
+	*allowedOrigins = "https://Library.Example.org:443; https://*.Example.EDU"
+	defer func() { *allowedOrigins = oldAllowedOrigins }()
+
+	for _, origin := range []string{
+		"https://library.example.org",
+		"HTTPS://Library.Example.ORG",
+		"https://library.example.org:443",
+		"https://www.example.edu:443",
+	} {
+		if matchOrigin(origin) == "" {
+			t.Errorf("Origin %v wasn't allowed.", origin)
+		}
+	}
+	for _, origin := range []string{
+		"http://library.example.org",
+		"https://library.example.org:8443",
+		"https://library.example.org.evil.com",
+	} {
+		if matchOrigin(origin) != "" {
+			t.Errorf("Origin %v was allowed.", origin)
+		}
+	}
+}
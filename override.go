@@ -0,0 +1,110 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	// AdminTokenHeader is the request header which carries the admin token.
+	AdminTokenHeader = "X-Lorica-Admin-Token"
+
+	// UpstreamOverrideHeader is the request header which names an upstream override.
+	UpstreamOverrideHeader = "X-Lorica-Upstream"
+)
+
+var (
+	adminToken = flag.String("admintoken", "", "A secret token which lets staff use admin features "+
+		"on the main address, sent in the "+AdminTokenHeader+" header.")
+	upstreamOverrideList = flag.String("upstreamoverrides", "", "A list of upstreams which requests with the admin "+
+		"token can be sent to instead, delimited by the ; character. Each entry looks like "+
+		"sandbox=/sandbox@https://sandbox.example.com, a name, then default or a credential prefix, optionally "+
+		"followed by @ and a Summon API URL. Requests choose one with the "+UpstreamOverrideHeader+" header.")
+
+	// upstreamOverrides are the parsed upstream overrides, by name.
+	upstreamOverrides map[string]diffBackend
+
+	// errAdminTokenInvalid is returned when a request's admin token is missing or wrong.
+	errAdminTokenInvalid = errors.New("the admin token is missing or wrong")
+)
+
+// parseUpstreamOverrides parses a list like sandbox=/sandbox@https://sandbox.example.com.
+func parseUpstreamOverrides(list string) (map[string]diffBackend, error) {
+	overrides := make(map[string]diffBackend)
+	for _, entry := range splitList(list) {
+		parts := strings.SplitN(entry, "=", 2)
+		name := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || name == "" {
+			return nil, fmt.Errorf("upstream override %v should look like sandbox=/sandbox@https://sandbox.example.com", entry)
+		}
+		if _, ok := overrides[name]; ok {
+			return nil, fmt.Errorf("upstream override %v is listed more than once", name)
+		}
+		backend, err := parseDiffBackend(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("upstream override %v: %v", name, err)
+		}
+		overrides[name] = backend
+	}
+	return overrides, nil
+}
+
+// upstreamOverrideNames returns the names of the upstream overrides, in order.
+func upstreamOverrideNames() []string {
+	names := make([]string, 0, len(upstreamOverrides))
+	for name := range upstreamOverrides {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// validAdminToken returns true if the request has the admin token.
+func validAdminToken(r *http.Request) bool {
+	token := r.Header.Get(AdminTokenHeader)
+	if token == "" {
+		return false
+	}
+	attempt := startAuthAttempt()
+	if *adminToken == "" || !secureEqual(token, *adminToken) {
+		attempt.Failed()
+		return false
+	}
+	return true
+}
+
+// upstreamOverrideFor returns the upstream the request asked to be sent to,
+// or nil if it didn't ask. Only requests with the admin token can ask.
+func upstreamOverrideFor(r *http.Request) (*diffBackend, error) {
+	name := r.Header.Get(UpstreamOverrideHeader)
+	if name == "" {
+		return nil, nil
+	}
+	if !validAdminToken(r) {
+		return nil, errAdminTokenInvalid
+	}
+	backend, ok := upstreamOverrides[name]
+	if !ok {
+		return nil, fmt.Errorf("the upstream %v isn't one of the upstream overrides", name)
+	}
+	return &backend, nil
+}
+
+// sendUpstreamOverrideError tells the client why its upstream override was refused.
+func sendUpstreamOverrideError(w http.ResponseWriter, r *http.Request, err error) {
+	audit.Record(r, AuditUpstreamOverride, "refused: "+err.Error())
+	if err == errAdminTokenInvalid {
+		sendJSONError(w, http.StatusForbidden, "Only staff can choose the upstream: "+err.Error()+".", nil)
+		return
+	}
+	sendJSONError(w, http.StatusBadRequest, "Unable to choose the upstream: "+err.Error()+".",
+		[]string{"The upstream overrides are " + strings.Join(upstreamOverrideNames(), ", ") + "."})
+}
@@ -0,0 +1,74 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseUpstreamOverrides(t *testing.T) {
+	oldPrefixes := credentialPrefixes
+	credentialPrefixes, _ = parseCredentialPrefixes("/sandbox=sand:sandkey")
+	defer func() { credentialPrefixes = oldPrefixes }()
+
+	overrides, err := parseUpstreamOverrides("sandbox=/sandbox@https://sandbox.example; prod=default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if overrides["sandbox"].url != "https://sandbox.example" || overrides["sandbox"].creds.accessID != "sand" ||
+		overrides["prod"].url != *apiURL {
+		t.Errorf("Overrides were %+v", overrides)
+	}
+	for _, bad := range []string{"sandbox", "=default", "a=/unknown", "a=default@ftp://x", "a=default;a=default"} {
+		if _, err := parseUpstreamOverrides(bad); err == nil {
+			t.Errorf("Overrides %v didn't return an error.", bad)
+		}
+	}
+}
+
+// Only requests with the admin token can choose an upstream from the list.
+func TestProxyHandlerUpstreamOverride(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(AdminTokenHeader) != "" || r.Header.Get(UpstreamOverrideHeader) != "" {
+			t.Error("Override headers were forwarded to Summon.")
+		}
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "Summon sand;") {
+			t.Errorf("Request signed with %v", r.Header.Get("Authorization"))
+		}
+		fmt.Fprint(w, `{"from": "sandbox"}`)
+	}))
+	defer ts.Close()
+
+	oldAdminToken, oldOverrides := *adminToken, upstreamOverrides
+	*adminToken = "staff-secret"
+	upstreamOverrides = map[string]diffBackend{"sandbox": {name: "/sandbox", url: ts.URL, creds: credentials{"sand", "key"}}}
+	defer func() { *adminToken, upstreamOverrides = oldAdminToken, oldOverrides }()
+
+	for _, c := range []struct {
+		token, upstream string
+		code            int
+	}{
+		{"staff-secret", "sandbox", http.StatusOK},
+		{"wrong", "sandbox", http.StatusForbidden},
+		{"", "sandbox", http.StatusForbidden},
+		{"staff-secret", "staging", http.StatusBadRequest},
+	} {
+		req := httptest.NewRequest("GET", "/2.0.0/search?s.q=test", nil)
+		req.Header.Set(AdminTokenHeader, c.token)
+		req.Header.Set(UpstreamOverrideHeader, c.upstream)
+		w := httptest.NewRecorder()
+		proxyHandler(w, req)
+		if w.Code != c.code {
+			t.Errorf("Token %q and upstream %v got %v, expected %v: %v", c.token, c.upstream, w.Code, c.code, w.Body.String())
+		}
+		if c.code == http.StatusOK && !strings.Contains(w.Body.String(), "sandbox") {
+			t.Errorf("Response didn't come from the override, got %v", w.Body.String())
+		}
+	}
+}
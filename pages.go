@@ -0,0 +1,141 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"html/template"
+	"net/http"
+)
+
+var demo = flag.Bool("demo", false, "Serve an interactive test page at /demo, which searches through Lorica "+
+	"so new integrators can check their setup. Searches from the page are same-origin, so they don't test CORS "+
+	"unless the proxy URL is changed to another Lorica.")
+
+// RobotsTxt asks crawlers not to crawl anything, since every page is an API call.
+const RobotsTxt = "User-agent: *\nDisallow: /\n"
+
+// registerPageHandlers adds the pages Lorica serves itself, instead of proxying.
+func registerPageHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/robots.txt", robotsHandler)
+	mux.HandleFunc("/favicon.ico", faviconHandler)
+	mux.HandleFunc("/status", statusHandler)
+	mux.HandleFunc(CapabilitiesPath, capabilitiesHandler)
+	mux.HandleFunc(OpenAPIPath, openAPIHandler)
+	mux.HandleFunc(ErrorsPath, errorsHandler)
+	if *demo {
+		mux.HandleFunc("/demo", demoHandler)
+	}
+}
+
+// robotsHandler serves a robots.txt which disallows everything.
+func robotsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write([]byte(RobotsTxt))
+}
+
+// faviconHandler serves an empty response, so browsers don't keep asking.
+func faviconHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=604800")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// demoTemplate is the interactive test page. By default the search is sent to
+// the Lorica serving the page, which is same-origin, so it checks the
+// credentials and the proxy but not CORS. If the proxy URL is changed to
+// another Lorica, the search is cross-origin, and goes through CORS like an
+// integrator's own page would.
+var demoTemplate = template.Must(template.New("demo").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Lorica Demo</title>
+<style nonce="{{.Nonce}}">
+body { font-family: sans-serif; max-width: 50em; margin: 2em auto; padding: 0 1em; }
+input[type=text] { width: 30em; }
+pre { background: #eee; padding: 1em; overflow-x: auto; }
+.error { color: #a00; }
+</style>
+</head>
+<body>
+<h1>Lorica Demo</h1>
+<p>Version {{.Version}}. Allowed origins for CORS: <code>{{if .AllowedOrigins}}{{.AllowedOrigins}}{{else}}none{{end}}</code></p>
+<p>Searches sent to this Lorica are same-origin, so they don't test CORS. To test CORS, change the proxy URL
+to another Lorica, which must allow this page's origin.</p>
+<form id="search">
+<p><label>Proxy URL <input type="text" id="proxy" value=""></label></p>
+<p><label>Search <input type="text" id="query" value="forest"></label> <button type="submit">Search</button></p>
+</form>
+<p id="status"></p>
+<ol id="results"></ol>
+<h2>Response headers</h2>
+<pre id="headers"></pre>
+<script nonce="{{.Nonce}}">
+document.getElementById("proxy").value = window.location.origin;
+document.getElementById("search").addEventListener("submit", function (event) {
+  event.preventDefault();
+  var status = document.getElementById("status");
+  var results = document.getElementById("results");
+  var headers = document.getElementById("headers");
+  var proxy = document.getElementById("proxy").value.replace(/\/+$/, "");
+  var query = document.getElementById("query").value;
+  status.className = "";
+  status.textContent = "Searching...";
+  results.textContent = "";
+  headers.textContent = "";
+  fetch(proxy + "/{{.APIVersion}}/search?s.ps=10&s.q=" + encodeURIComponent(query), {
+    mode: "cors",
+    headers: {"Accept": "application/json"}
+  }).then(function (response) {
+    var lines = [];
+    response.headers.forEach(function (value, name) { lines.push(name + ": " + value); });
+    headers.textContent = lines.join("\n");
+    if (!response.ok) {
+      throw new Error("Lorica returned " + response.status + " " + response.statusText);
+    }
+    return response.json();
+  }).then(function (data) {
+    status.textContent = "Found " + data.recordCount + " results.";
+    (data.documents || []).forEach(function (doc) {
+      var li = document.createElement("li");
+      li.textContent = doc.Title ? doc.Title[0] : "(untitled)";
+      results.appendChild(li);
+    });
+  }).catch(function (err) {
+    status.className = "error";
+    status.textContent = err.message + (proxy === window.location.origin ? "." :
+      ". The proxy URL is another origin, so check it allows this page's origin.");
+  });
+});
+</script>
+</body>
+</html>
+`))
+
+// demoHandler serves the interactive test page.
+func demoHandler(w http.ResponseWriter, r *http.Request) {
+	nonce, err := newNonce()
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "Unable to build demo page.")
+		return
+	}
+	b := new(bytes.Buffer)
+	err = demoTemplate.Execute(b, struct {
+		Version        string
+		AllowedOrigins string
+		APIVersion     string
+		Nonce          string
+	}{version, *allowedOrigins, "2.0.0", nonce})
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "Unable to build demo page.")
+		return
+	}
+	// The demo page searches through whichever proxy URL is entered.
+	setPageSecurityPolicy(w, nonce, true)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(b.Bytes())
+}
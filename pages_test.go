@@ -0,0 +1,75 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// robots.txt and the favicon should be served by Lorica, not proxied.
+func TestPageHandlers(t *testing.T) {
+	mux := http.NewServeMux()
+	registerPageHandlers(mux)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Request for %v was proxied.", r.URL.Path)
+	})
+
+	req, err := http.NewRequest("GET", "/robots.txt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != RobotsTxt {
+		t.Errorf("robots.txt served incorrectly, got %v: %v", w.Code, w.Body.String())
+	}
+
+	req, err = http.NewRequest("GET", "/favicon.ico", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent || w.Body.Len() != 0 {
+		t.Errorf("favicon.ico served incorrectly, got %v: %v", w.Code, w.Body.String())
+	}
+}
+
+// The demo page should only be served when enabled.
+func TestDemoPage(t *testing.T) {
+	req, err := http.NewRequest("GET", "/demo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	registerPageHandlers(mux)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Demo page was served when disabled, got %v.", w.Code)
+	}
+
+	oldDemo := *demo
+	*demo = true
+	defer func() { *demo = oldDemo }()
+
+	mux = http.NewServeMux()
+	registerPageHandlers(mux)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Demo page wasn't served when enabled, got %v.", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "/2.0.0/search?s.ps=10&s.q=") {
+		t.Error("Demo page doesn't search through the proxy.")
+	}
+	if !strings.Contains(w.Body.String(), "don't test CORS") {
+		t.Error("Demo page doesn't say same-origin searches don't test CORS.")
+	}
+}
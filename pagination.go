@@ -0,0 +1,105 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// DefaultSummonPageSize is the page size Summon uses when s.ps isn't set.
+const DefaultSummonPageSize = 10
+
+var paginationLinkHeaders = flag.Bool("paginationlinks", true, "Add a Link header to search responses, with "+
+	"the first, previous, next, and last pages, so clients can page through results without using "+
+	"Summon's paging parameters.")
+
+// paginationLinks returns the value of the Link header for a search response,
+// with links to the first, previous, next, and last pages of results. The
+// links keep the client's path and query parameters, and change s.pn.
+// An empty string is returned for responses which aren't searches, or
+// which don't have a record count.
+func paginationLinks(r *http.Request, body []byte) string {
+	if endpointLabel(r.URL.Path) != "search" {
+		return ""
+	}
+	values := r.URL.Query()
+	page, last, ok := searchPages(values, body)
+	if !ok {
+		return ""
+	}
+
+	link := func(n int, rel string) string {
+		values.Set("s.pn", strconv.Itoa(n))
+		u := url.URL{Path: r.URL.Path, RawQuery: values.Encode()}
+		return fmt.Sprintf("<%v>; rel=\"%v\"", u.String(), rel)
+	}
+	links := []string{link(1, "first")}
+	if page > 1 {
+		links = append(links, link(minInt(page-1, last), "prev"))
+	}
+	if page < last {
+		links = append(links, link(page+1, "next"))
+	}
+	links = append(links, link(last, "last"))
+	return strings.Join(links, ", ")
+}
+
+// searchPages returns the page of a search response, and the last page,
+// from the query parameters and the response's record count.
+func searchPages(values url.Values, body []byte) (int, int, bool) {
+	var response struct {
+		RecordCount *int `json:"recordCount"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil || response.RecordCount == nil {
+		return 0, 0, false
+	}
+	pageSize, page := DefaultSummonPageSize, 1
+	if ps := values.Get("s.ps"); ps != "" {
+		n, err := strconv.Atoi(ps)
+		if err != nil || n < 1 {
+			return 0, 0, false
+		}
+		pageSize = n
+	}
+	if pn := values.Get("s.pn"); pn != "" {
+		n, err := strconv.Atoi(pn)
+		if err != nil || n < 1 {
+			return 0, 0, false
+		}
+		page = n
+	}
+	last := (*response.RecordCount + pageSize - 1) / pageSize
+	if last < 1 {
+		last = 1
+	}
+	return page, last, true
+}
+
+// setPaginationLinks adds the Link header to a search response, and lets
+// CORS requests read it.
+func setPaginationLinks(w http.ResponseWriter, r *http.Request, body []byte) {
+	link := paginationLinks(r, body)
+	if link == "" {
+		return
+	}
+	w.Header().Set("Link", link)
+	if w.Header().Get("Access-Control-Allow-Origin") != "" {
+		w.Header().Add("Access-Control-Expose-Headers", "Link")
+	}
+}
+
+// minInt returns the smaller of two ints.
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,53 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPaginationLinks(t *testing.T) {
+	for _, c := range []struct {
+		url, body, links string
+	}{
+		{"/2.0.0/search?s.q=forest", `{"recordCount":25}`,
+			`</2.0.0/search?s.pn=1&s.q=forest>; rel="first", </2.0.0/search?s.pn=2&s.q=forest>; rel="next", ` +
+				`</2.0.0/search?s.pn=3&s.q=forest>; rel="last"`},
+		{"/2.0.1/search?s.q=forest&s.ps=5&s.pn=3", `{"recordCount":15}`,
+			`</2.0.1/search?s.pn=1&s.ps=5&s.q=forest>; rel="first", ` +
+				`</2.0.1/search?s.pn=2&s.ps=5&s.q=forest>; rel="prev", ` +
+				`</2.0.1/search?s.pn=3&s.ps=5&s.q=forest>; rel="last"`},
+		{"/2.0.0/search?s.q=forest", `{"recordCount":0}`,
+			`</2.0.0/search?s.pn=1&s.q=forest>; rel="first", </2.0.0/search?s.pn=1&s.q=forest>; rel="last"`},
+		{"/2.0.0/search?s.q=forest&s.pn=9", `{"recordCount":20}`,
+			`</2.0.0/search?s.pn=1&s.q=forest>; rel="first", </2.0.0/search?s.pn=2&s.q=forest>; rel="prev", ` +
+				`</2.0.0/search?s.pn=2&s.q=forest>; rel="last"`},
+		{"/2.0.0/search?s.q=forest&s.ps=none", `{"recordCount":20}`, ""},
+		{"/2.0.0/search?s.q=forest", `{"documents":[]}`, ""},
+		{"/2.0.0/search?s.q=forest", `<response/>`, ""},
+		{"/2.0.0/availability/123", `{"recordCount":20}`, ""},
+	} {
+		links := paginationLinks(httptest.NewRequest("GET", c.url, nil), []byte(c.body))
+		if links != c.links {
+			t.Errorf("Links for %v with %v were\n%v\nexpected\n%v", c.url, c.body, links, c.links)
+		}
+	}
+}
+
+// CORS requests can read the Link header.
+func TestSetPaginationLinks(t *testing.T) {
+	w := httptest.NewRecorder()
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Expose-Headers", "Content-Type")
+	setPaginationLinks(w, httptest.NewRequest("GET", "/2.0.0/search?s.q=forest", nil), []byte(`{"recordCount":5}`))
+	if w.Header().Get("Link") == "" {
+		t.Error("The Link header wasn't set.")
+	}
+	if exposed := w.Header()[http.CanonicalHeaderKey("Access-Control-Expose-Headers")]; len(exposed) != 2 || exposed[1] != "Link" {
+		t.Errorf("The exposed headers were %v", exposed)
+	}
+}
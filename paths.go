@@ -0,0 +1,131 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	l "github.com/cu-library/lorica/loglevel"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// The error codes sent for paths which aren't proxied.
+const (
+	ErrorPathNotFound   = "path_not_found"
+	ErrorPathNotAllowed = "path_not_allowed"
+)
+
+var (
+	strictPaths = flag.Bool("strictpaths", false, "Only proxy paths which look like Summon API paths, "+
+		"a version followed by a known endpoint, like /2.0.0/search. Other paths get a 404 response, "+
+		"which is logged at the DEBUG level, so crawlers don't fill the log.")
+	allowedPaths = flag.String("allowedpaths", "", "The Summon API paths which are proxied, delimited by the ; "+
+		"character, like /2.0.0/search. A path also allows the paths below it, so /2.0.0/availability allows "+
+		"/2.0.0/availability/12345. Other paths get a 403 response. If not set, every Summon API path is proxied.")
+)
+
+// summonPathPattern matches paths like /2.0.0/search and /2.0.0/availability/12345.
+var summonPathPattern = regexp.MustCompile(`^/[0-9]+\.[0-9]+\.[0-9]+/(` +
+	strings.Join(knownEndpoints, "|") + `)(/[^/]+)*/?$`)
+
+// validSummonPath returns true if the path looks like a Summon API path.
+func validSummonPath(path string) bool {
+	return summonPathPattern.MatchString(path)
+}
+
+// checkAllowedPaths checks a list of allowed paths like /2.0.0/search;/2.0.0/availability.
+func checkAllowedPaths(list string) error {
+	for _, path := range splitList(list) {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("the allowed path %v should start with /, like /2.0.0/search", path)
+		}
+	}
+	return nil
+}
+
+// pathAllowed returns true if the Summon API path is one of the allowed
+// paths, or below one. Every path is allowed if -allowedpaths isn't set.
+func pathAllowed(path string) bool {
+	paths := splitList(*allowedPaths)
+	if len(paths) == 0 {
+		return true
+	}
+	path = strings.TrimRight(path, "/")
+	for _, allowed := range paths {
+		allowed = strings.TrimRight(allowed, "/")
+		if path == allowed || strings.HasPrefix(path, allowed+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// sendPathNotAllowed tells the client the Summon API path isn't one Lorica proxies.
+func sendPathNotAllowed(w http.ResponseWriter, r *http.Request, path string) {
+	audit.Record(r, AuditEndpointNotAllowed, "path "+path)
+	sendJSONErrorCode(w, http.StatusForbidden, ErrorPathNotAllowed,
+		fmt.Sprintf("Lorica doesn't proxy the Summon API path %v.", path),
+		[]string{"The paths which are proxied are " + strings.Join(splitList(*allowedPaths), ", ") + "."})
+}
+
+// jsonError is the body of the structured error responses.
+type jsonError struct {
+	Status  int      `json:"status"`
+	Error   string   `json:"error"`
+	Code    string   `json:"code,omitempty"`
+	Message string   `json:"message"`
+	Hints   []string `json:"hints,omitempty"`
+}
+
+// sendJSONError sends a structured error to the client, and logs the error.
+func sendJSONError(w http.ResponseWriter, statuscode int, message string, hints []string) {
+	sendJSONErrorCode(w, statuscode, "", message, hints)
+}
+
+// sendJSONErrorCode sends a structured error with one of Lorica's error codes.
+func sendJSONErrorCode(w http.ResponseWriter, statuscode int, code, message string, hints []string) {
+	if writeJSONError(w, statuscode, code, message, hints) {
+		l.Logf(l.ErrorMessage, "%v - %v", statuscode, message)
+	}
+}
+
+// writeJSONError sends a structured error without logging it, and returns
+// false if it couldn't be built.
+func writeJSONError(w http.ResponseWriter, statuscode int, code, message string, hints []string) bool {
+	body, err := json.Marshal(jsonError{
+		Status:  statuscode,
+		Error:   http.StatusText(statuscode),
+		Code:    code,
+		Message: message,
+		Hints:   hints,
+	})
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "Unable to build error response.")
+		return false
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(statuscode)
+	w.Write(body)
+	return true
+}
+
+// sendPathNotFound tells the client the path isn't a Summon API path,
+// and how to fix it. Crawlers ask for paths like /robots.txt all day, so
+// it is only logged at the DEBUG level.
+func sendPathNotFound(w http.ResponseWriter, r *http.Request) {
+	message := fmt.Sprintf("The path %v is not a Summon API path.", r.URL.Path)
+	if writeJSONError(w, http.StatusNotFound, ErrorPathNotFound, message,
+		[]string{
+			"Summon API paths start with a version, like /2.0.0/search.",
+			"The known endpoints are " + strings.Join(knownEndpoints, ", ") + ".",
+			"See http://api.summon.serialssolutions.com/help/api/ for the Summon API documentation.",
+		}) {
+		l.Logf(l.DebugMessage, "%v - %v", http.StatusNotFound, message)
+	}
+}
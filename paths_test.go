@@ -0,0 +1,127 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	l "github.com/cu-library/lorica/loglevel"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidSummonPath(t *testing.T) {
+	for path, expected := range map[string]bool{
+		"/2.0.0/search":             true,
+		"/2.0.0/search/":            true,
+		"/2.0.0/search/ping":        true,
+		"/2.0.0/availability/12345": true,
+		"/2.0.0/suggest":            true,
+		"/":                         false,
+		"/search":                   false,
+		"/2.0/search":               false,
+		"/2.0.0/unknown":            false,
+		"/2.0.0/search//ping":       false,
+		"/wp-login.php":             false,
+		"/2.0.0/searchfoo":          false,
+	} {
+		if validSummonPath(path) != expected {
+			t.Errorf("validSummonPath(%v) should be %v.", path, expected)
+		}
+	}
+}
+
+// Paths which aren't Summon API paths should get a structured 404, which
+// isn't logged as an error.
+func TestProxyHanderBadPath(t *testing.T) {
+	oldStrictPaths := *strictPaths
+	*strictPaths = true
+	defer func() { *strictPaths = oldStrictPaths }()
+	records, unsubscribe := l.Subscribe(l.WarnMessage, 100)
+	defer unsubscribe()
+
+	req, err := http.NewRequest("GET", "/wp-login.php", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	proxyHandler(w, req)
+	for len(records) > 0 {
+		if record := <-records; strings.Contains(record.Message, "/wp-login.php") {
+			t.Errorf("The 404 was logged at the %v level: %v", record.Level, record.Message)
+		}
+	}
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Request for a bad path got %v, expected 404.", w.Code)
+	}
+	if w.Header().Get("Content-Type") != "application/json; charset=utf-8" {
+		t.Errorf("Bad Content-Type %v.", w.Header().Get("Content-Type"))
+	}
+	var body jsonError
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Status != http.StatusNotFound || len(body.Hints) == 0 {
+		t.Errorf("Structured 404 was missing fields: %#v", body)
+	}
+}
+
+// Only the allowed paths, and the paths below them, are allowed.
+func TestPathAllowed(t *testing.T) {
+	oldAllowedPaths := *allowedPaths
+	defer func() { *allowedPaths = oldAllowedPaths }()
+
+	*allowedPaths = ""
+	if !pathAllowed("/2.0.0/suggest") {
+		t.Error("Without allowed paths, a path wasn't allowed.")
+	}
+
+	*allowedPaths = "/2.0.0/search;/2.0.0/availability/"
+	tests := map[string]bool{
+		"/2.0.0/search":             true,
+		"/2.0.0/search/":            true,
+		"/2.0.0/availability/12345": true,
+		"/2.0.0/searchable":         false,
+		"/2.0.0/suggest":            false,
+		"/2.0.1/search":             false,
+	}
+	for path, expected := range tests {
+		if pathAllowed(path) != expected {
+			t.Errorf("pathAllowed(%v) should be %v.", path, expected)
+		}
+	}
+
+	if err := checkAllowedPaths("/2.0.0/search;2.0.0/suggest"); err == nil {
+		t.Error("No error for an allowed path without a leading /.")
+	}
+}
+
+// Paths which aren't allowed get a structured 403, without being sent to Summon.
+func TestProxyHandlerPathNotAllowed(t *testing.T) {
+	oldAllowedPaths := *allowedPaths
+	*allowedPaths = "/2.0.0/search"
+	defer func() { *allowedPaths = oldAllowedPaths }()
+
+	w := httptest.NewRecorder()
+	proxyHandler(w, httptest.NewRequest("GET", "/2.0.0/suggest?s.q=fore", nil))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("Request for a path which isn't allowed got %v, expected 403.", w.Code)
+	}
+	var body jsonError
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Code != ErrorPathNotAllowed {
+		t.Errorf("The error code was %v", body.Code)
+	}
+	for _, endpoint := range currentCapabilities().Endpoints {
+		if endpoint == "/"+apiVersion()+"/suggest" {
+			t.Errorf("The capabilities listed %v, which isn't allowed.", endpoint)
+		}
+	}
+}
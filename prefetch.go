@@ -0,0 +1,109 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"flag"
+	l "github.com/cu-library/lorica/loglevel"
+	"github.com/cu-library/lorica/metrics"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// DefaultPrefetchMaxConcurrent is the default number of next pages which can be prefetched at once.
+const DefaultPrefetchMaxConcurrent = 4
+
+// The values of the result label on the prefetch metric.
+const (
+	PrefetchSent    = "sent"
+	PrefetchCached  = "cached"
+	PrefetchSkipped = "skipped"
+	PrefetchFailed  = "failed"
+)
+
+var (
+	prefetch = flag.Bool("prefetch", false, "After sending a page of search results which could be cached, "+
+		"fetch the next page into the cache, so the next page click is instant. Only used while the cache "+
+		"feature is enabled. Prefetches are background requests.")
+	prefetchMaxConcurrent = flag.Int("prefetchmaxconcurrent", DefaultPrefetchMaxConcurrent, "The most next "+
+		"pages prefetched at once. When there are more, the extra next pages aren't prefetched.")
+
+	// prefetchSlots bounds the number of prefetches. It is made once the
+	// flags are parsed, and is nil if prefetching is off.
+	prefetchSlots chan struct{}
+
+	prefetchRequestsTotal = metrics.NewCounterVec("lorica_prefetch_requests_total",
+		"The number of next pages prefetched, by whether they were sent to the Summon API, already cached, "+
+			"skipped because too many were being prefetched, or failed.", "result")
+)
+
+// nextPageQuery returns the query string of the next page of a search, or
+// false if it is the last page.
+func nextPageQuery(r *http.Request, body []byte) (string, bool) {
+	if endpointLabel(r.URL.Path) != "search" {
+		return "", false
+	}
+	values := r.URL.Query()
+	page, last, ok := searchPages(values, body)
+	if !ok || page >= last {
+		return "", false
+	}
+	values.Set("s.pn", strconv.Itoa(page+1))
+	return values.Encode(), true
+}
+
+// prefetchNextPage fetches the next page of a search into the cache, in the
+// background. Prefetches don't lead to more prefetches.
+func prefetchNextPage(r *http.Request, body []byte) {
+	slots := prefetchSlots
+	if slots == nil || getRequestInfo(r).prefetch || !features.Enabled(FeatureCache) {
+		return
+	}
+	query, ok := nextPageQuery(r, body)
+	if !ok {
+		return
+	}
+	if memoryWatch.UnderPressure() {
+		prefetchRequestsTotal.With(PrefetchSkipped).Inc()
+		return
+	}
+	select {
+	case slots <- struct{}{}:
+	default:
+		prefetchRequestsTotal.With(PrefetchSkipped).Inc()
+		return
+	}
+	// The prefetch outlives the client's request, so it gets its own context.
+	nextURL := url.URL{Path: r.URL.Path, RawQuery: query}
+	next, err := http.NewRequestWithContext(context.Background(), "GET", nextURL.String(), nil)
+	if err != nil {
+		<-slots
+		return
+	}
+	next.RemoteAddr = r.RemoteAddr
+	copyHeaders(next.Header, r.Header, []string{"Accept", "Accept-Language", "X-Forwarded-For", "X-Real-IP"})
+	next, _ = withResponseOrigin(next)
+	next, info := withRequestInfo(next)
+	info.prefetch = true
+	info.tier = getRequestInfo(r).tier
+
+	go func() {
+		defer func() { <-slots }()
+		// Like other requests, prefetches wait for a slot to send them to the Summon API.
+		rec := &responseBuffer{header: make(http.Header)}
+		withPriority(http.HandlerFunc(proxyHandler)).ServeHTTP(rec, next)
+		switch {
+		case rec.status != 0 && rec.status != http.StatusOK:
+			l.Logf(l.DebugMessage, "Unable to prefetch %v: %v.", next.URL, rec.status)
+			prefetchRequestsTotal.With(PrefetchFailed).Inc()
+		case info.cache == CacheHit:
+			prefetchRequestsTotal.With(PrefetchCached).Inc()
+		default:
+			prefetchRequestsTotal.With(PrefetchSent).Inc()
+		}
+	}()
+}
@@ -0,0 +1,76 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"github.com/cu-library/lorica/internal/summonmock"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// The next page keeps the client's parameters, and there isn't one after the last page.
+func TestNextPageQuery(t *testing.T) {
+	body := []byte(`{"recordCount":25}`)
+	for _, c := range []struct {
+		target, want string
+		ok           bool
+	}{
+		{"/2.0.0/search?s.q=forest", "s.pn=2&s.q=forest", true},
+		{"/2.0.0/search?s.q=forest&s.ps=10&s.pn=2", "s.pn=3&s.ps=10&s.q=forest", true},
+		{"/2.0.0/search?s.q=forest&s.pn=3", "", false},
+		{"/2.0.0/search?s.q=forest&s.ps=50", "", false},
+		{"/2.0.0/availability?s.q=forest", "", false},
+	} {
+		got, ok := nextPageQuery(httptest.NewRequest("GET", c.target, nil), body)
+		if got != c.want || ok != c.ok {
+			t.Errorf("The next page of %v was %q, %v", c.target, got, ok)
+		}
+	}
+}
+
+// After a page of results is sent, the next page is fetched into the cache.
+func TestPrefetchNextPage(t *testing.T) {
+	s, stop := startSummonMock()
+	defer stop()
+	s.Script(summonmock.JSON(http.StatusOK, `{"recordCount":15}`))
+	oldCachedResponses, oldPrefetchSlots := cachedResponses, prefetchSlots
+	defer func() {
+		cachedResponses, prefetchSlots = oldCachedResponses, oldPrefetchSlots
+		features.Set("")
+	}()
+	cachedResponses, prefetchSlots = newResponseCache(), make(chan struct{}, 1)
+	features.Set(FeatureCache)
+
+	search := func(target string) *httptest.ResponseRecorder {
+		r, _ := withRequestInfo(httptest.NewRequest("GET", target, nil))
+		w := httptest.NewRecorder()
+		proxyHandler(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%v got %v %v", target, w.Code, w.Body.String())
+		}
+		return w
+	}
+	// Taking every slot waits for the prefetches to finish.
+	waitForPrefetches := func() {
+		prefetchSlots <- struct{}{}
+		<-prefetchSlots
+	}
+
+	search("/2.0.0/search?s.q=forest")
+	waitFor(t, func() bool { return len(s.Requests()) == 2 })
+	waitForPrefetches()
+	if pn := s.LastRequest().Query.Get("s.pn"); pn != "2" {
+		t.Fatalf("The prefetched page was %q.", pn)
+	}
+
+	if w := search("/2.0.0/search?s.q=forest&s.pn=2"); w.Header().Get(CacheStatusHeader) != CacheHit {
+		t.Errorf("The next page was a cache %v.", w.Header().Get(CacheStatusHeader))
+	}
+	waitForPrefetches()
+	if n := len(s.Requests()); n != 2 {
+		t.Errorf("%v requests were sent to the Summon API after the last page.", n)
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"github.com/cu-library/lorica/metrics"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// PreflightCacheTTL is how long a prepared preflight response is used. It bounds
+	// how long a change to the allowed origins takes to reach preflight responses.
+	PreflightCacheTTL = 10 * time.Second
+
+	// MaxPreflightCacheEntries is the most origins whose preflight responses are kept.
+	MaxPreflightCacheEntries = 1000
+)
+
+// The codes of the errors sent when a CORS preflight request is refused.
+const (
+	// ErrorBadPreflight is sent when the preflight request asks for a method
+	// or header which isn't allowed.
+	ErrorBadPreflight = "bad_preflight"
+
+	// ErrorOriginRejected is sent when the origin isn't allowed by the
+	// allowed origins or the origin authorizer.
+	ErrorOriginRejected = "origin_rejected"
+)
+
+// The values of the result label on the preflight metric.
+const (
+	PreflightCached   = "cached"
+	PreflightBuilt    = "built"
+	PreflightRejected = "rejected"
+)
+
+var (
+	// preflights holds the prepared preflight responses.
+	preflights = newPreflightCache()
+
+	preflightRequestsTotal = metrics.NewCounterVec("lorica_preflight_requests_total",
+		"The number of CORS preflight requests, by whether the response was prepared earlier, "+
+			"built, or the request was rejected.", "result")
+)
+
+// preflightResponse is the headers of a prepared preflight response for one origin.
+type preflightResponse struct {
+	header  http.Header
+	config  string
+	expires time.Time
+}
+
+// preflightCache keeps the prepared preflight responses for each origin.
+type preflightCache struct {
+	sync.RWMutex
+	responses map[string]*preflightResponse
+	now       func() time.Time
+}
+
+func newPreflightCache() *preflightCache {
+	return &preflightCache{responses: make(map[string]*preflightResponse), now: time.Now}
+}
+
+// preflightConfig returns the settings a preflight response depends on,
+// besides the origin, so prepared responses are rebuilt when they change.
+func preflightConfig() string {
+	return strings.Join(proxiedMethods(), ", ") + "\n" + strings.Join(allowedCORSRequestHeaders(), ", ") +
+		"\n" + *allowedOrigins
+}
+
+// Header returns the preflight response headers for the origin. They are
+// shared between requests, so must not be changed.
+func (pc *preflightCache) Header(origin string) http.Header {
+	config := preflightConfig()
+	now := pc.now()
+	pc.RLock()
+	response, ok := pc.responses[origin]
+	pc.RUnlock()
+	if ok && response.config == config && now.Before(response.expires) {
+		preflightRequestsTotal.With(PreflightCached).Inc()
+		return response.header
+	}
+
+	preflightRequestsTotal.With(PreflightBuilt).Inc()
+	response = &preflightResponse{header: buildPreflightHeader(origin), config: config, expires: now.Add(PreflightCacheTTL)}
+	pc.Lock()
+	defer pc.Unlock()
+	if len(pc.responses) >= MaxPreflightCacheEntries {
+		for o, r := range pc.responses {
+			if !now.Before(r.expires) {
+				delete(pc.responses, o)
+			}
+		}
+	}
+	if len(pc.responses) < MaxPreflightCacheEntries {
+		pc.responses[origin] = response
+	}
+	return response.header
+}
+
+// buildPreflightHeader builds the preflight response headers for the origin.
+func buildPreflightHeader(origin string) http.Header {
+	h := http.Header{}
+	h.Set("Access-Control-Allow-Methods", strings.Join(proxiedMethods(), ", "))
+	h.Set("Access-Control-Allow-Headers", strings.Join(allowedCORSRequestHeaders(), ", "))
+	h.Set("Access-Control-Max-Age", DefaultMaxAge)
+	switch matchOrigin(origin) {
+	case "":
+	case "*":
+		h.Set("Access-Control-Allow-Origin", "*")
+	default:
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	return h
+}
+
+// setPreflightHeaders adds the prepared preflight response headers for the request's origin.
+func setPreflightHeaders(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	for name, values := range preflights.Header(origin) {
+		w.Header()[name] = values
+	}
+	// In report-only mode, the origin is allowed anyway.
+	if origin != "" && w.Header().Get("Access-Control-Allow-Origin") == "" && !enforcePolicy(r, PolicyOrigins, origin) {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
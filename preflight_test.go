@@ -0,0 +1,52 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Preflight responses are prepared once per origin, and rebuilt when they
+// expire or the settings they depend on change.
+func TestPreflightCache(t *testing.T) {
+	oldAllowedOrigins := *allowedOrigins
+	*allowedOrigins = "http://test.com"
+	defer func() {
+		*allowedOrigins = oldAllowedOrigins
+		features.Set("")
+	}()
+	pc := newPreflightCache()
+	now := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	pc.now = func() time.Time { return now }
+
+	first := pc.Header("http://test.com")
+	if first.Get("Access-Control-Allow-Origin") != "http://test.com" || first.Get("Access-Control-Allow-Methods") != "GET" {
+		t.Errorf("Preflight headers were %v", first)
+	}
+	if pc.Header("http://other.com").Get("Access-Control-Allow-Origin") != "" {
+		t.Error("Origin which isn't allowed got Access-Control-Allow-Origin.")
+	}
+	if second := pc.Header("http://test.com"); &second["Access-Control-Allow-Origin"][0] != &first["Access-Control-Allow-Origin"][0] {
+		t.Error("Preflight headers weren't reused.")
+	}
+
+	features.Set(FeaturePost)
+	if methods := pc.Header("http://test.com").Get("Access-Control-Allow-Methods"); methods != "GET, POST" {
+		t.Errorf("Preflight headers weren't rebuilt when the methods changed, got %v", methods)
+	}
+
+	*allowedOrigins = "http://other.com"
+	if pc.Header("http://test.com").Get("Access-Control-Allow-Origin") != "" {
+		t.Error("Preflight headers weren't rebuilt when the allowed origins changed.")
+	}
+
+	old := pc.responses["http://other.com"]
+	now = now.Add(PreflightCacheTTL)
+	pc.Header("http://other.com")
+	if pc.responses["http://other.com"] == old {
+		t.Error("Expired preflight headers were reused.")
+	}
+}
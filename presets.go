@@ -0,0 +1,113 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/cu-library/lorica/metrics"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+const (
+	// PresetParameter is the query parameter clients use to ask for a facet preset.
+	PresetParameter = "preset"
+
+	// ErrorUnknownPreset is the error code sent when a request asks for a preset which isn't defined.
+	ErrorUnknownPreset = "unknown_preset"
+)
+
+var (
+	facetPresetList = flag.String("facetpresets", "", "A list of named facet presets, delimited by the ; character, "+
+		"like scholarly=s.fvf=IsScholarly,true&s.fvf=IsPeerReviewed,true. Requests with preset=scholarly "+
+		"have the preset's query parameters added before they are signed.")
+
+	// facetPresets are the parsed facet presets, by name.
+	facetPresets map[string]url.Values
+
+	presetRequestsTotal = metrics.NewCounterVec("lorica_preset_requests_total",
+		"The number of requests which used each facet preset.", "preset")
+)
+
+// parseFacetPresets parses a list of facet presets, delimited by the ; character.
+// Each preset is a name, then =, then the query string it expands to.
+func parseFacetPresets(list string) (map[string]url.Values, error) {
+	parsed := make(map[string]url.Values)
+	for _, entry := range splitList(list) {
+		parts := strings.SplitN(entry, "=", 2)
+		name := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || name == "" {
+			return nil, fmt.Errorf("facet preset %v should look like name=query string", entry)
+		}
+		if _, ok := parsed[name]; ok {
+			return nil, fmt.Errorf("facet preset %v is listed more than once", name)
+		}
+		values, err := url.ParseQuery(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("facet preset %v has a malformed query string: %v", name, err)
+		}
+		if len(values) == 0 {
+			return nil, fmt.Errorf("facet preset %v has no query parameters", name)
+		}
+		if _, ok := values[PresetParameter]; ok {
+			return nil, fmt.Errorf("facet preset %v can't use another preset", name)
+		}
+		parsed[name] = values
+	}
+	return parsed, nil
+}
+
+// facetPresetNames returns the names of the facet presets, in order.
+func facetPresetNames() []string {
+	var names []string
+	for name := range facetPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// expandPresets replaces the preset parameters in the query string with the
+// query parameters of the presets. The other parameters are left as they are.
+// If a preset isn't defined, its name is returned with ok set to false.
+func expandPresets(rawQuery string) (expanded, unknown string, ok bool) {
+	var kept, names []string
+	for _, param := range strings.Split(rawQuery, "&") {
+		rawKey, rawValue := param, ""
+		if i := strings.Index(param, "="); i >= 0 {
+			rawKey, rawValue = param[:i], param[i+1:]
+		}
+		if key, err := url.QueryUnescape(rawKey); err != nil || key != PresetParameter {
+			kept = append(kept, param)
+			continue
+		}
+		name, err := url.QueryUnescape(rawValue)
+		if err != nil {
+			return "", rawValue, false
+		}
+		if _, ok := facetPresets[name]; !ok {
+			return "", name, false
+		}
+		names = append(names, name)
+	}
+	for _, name := range names {
+		presetRequestsTotal.With(name).Inc()
+		kept = append(kept, facetPresets[name].Encode())
+	}
+	return strings.Trim(strings.Join(kept, "&"), "&"), "", true
+}
+
+// sendUnknownPreset tells the client the preset it asked for isn't defined.
+func sendUnknownPreset(w http.ResponseWriter, name string) {
+	hints := []string{"There are no facet presets."}
+	if len(facetPresets) > 0 {
+		hints = []string{"The facet presets are " + strings.Join(facetPresetNames(), ", ") + "."}
+	}
+	sendJSONErrorCode(w, http.StatusBadRequest, ErrorUnknownPreset,
+		fmt.Sprintf("The facet preset %q isn't defined.", name), hints)
+}
@@ -0,0 +1,87 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseFacetPresets(t *testing.T) {
+	presets, err := parseFacetPresets("scholarly=s.fvf=IsScholarly,true&s.fvf=IsPeerReviewed,true; " +
+		"newspapers=s.fvf=ContentType,Newspaper Article")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(presets["scholarly"]["s.fvf"]) != 2 || presets["newspapers"].Get("s.fvf") != "ContentType,Newspaper Article" {
+		t.Errorf("Presets were %v", presets)
+	}
+	for _, list := range []string{
+		"scholarly",
+		"=s.fvf=IsScholarly,true",
+		"scholarly=",
+		"scholarly=s.fvf=%zz",
+		"a=s.ps=5;a=s.ps=10",
+		"a=preset=b",
+	} {
+		if _, err := parseFacetPresets(list); err == nil {
+			t.Errorf("Parsing %v should have failed.", list)
+		}
+	}
+}
+
+func TestExpandPresets(t *testing.T) {
+	oldPresets := facetPresets
+	facetPresets = map[string]url.Values{
+		"scholarly": {"s.fvf": {"IsScholarly,true"}},
+		"av-only":   {"s.fvf": {"ContentType,Video Recording"}, "s.cmd": {"addFacetValueFilters(ContentType,Audio Recording)"}},
+	}
+	defer func() { facetPresets = oldPresets }()
+
+	for _, c := range []struct {
+		rawQuery, expanded string
+		ok                 bool
+	}{
+		{"s.q=forest", "s.q=forest", true},
+		{"", "", true},
+		{"s.q=forest&preset=scholarly", "s.q=forest&s.fvf=IsScholarly%2Ctrue", true},
+		{"preset=av-only&s.q=forest",
+			"s.q=forest&s.cmd=addFacetValueFilters%28ContentType%2CAudio+Recording%29&s.fvf=ContentType%2CVideo+Recording", true},
+		{"preset=newspapers&s.q=forest", "", false},
+	} {
+		expanded, unknown, ok := expandPresets(c.rawQuery)
+		if expanded != c.expanded || ok != c.ok {
+			t.Errorf("Expanding %v got %v, %v, %v", c.rawQuery, expanded, unknown, ok)
+		}
+	}
+}
+
+// Presets are expanded before the request is signed, and unknown presets are rejected.
+func TestProxyHandlerPresets(t *testing.T) {
+	var sent string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent = r.URL.RawQuery
+	}))
+	defer ts.Close()
+	oldAPIURL, oldPresets := *apiURL, facetPresets
+	*apiURL, facetPresets = ts.URL, map[string]url.Values{"scholarly": {"s.fvf": {"IsScholarly,true"}}}
+	defer func() { *apiURL, facetPresets = oldAPIURL, oldPresets }()
+
+	w := httptest.NewRecorder()
+	proxyHandler(w, httptest.NewRequest("GET", "/2.0.0/search?s.q=forest&preset=scholarly", nil))
+	if w.Code != http.StatusOK || sent != "s.q=forest&s.fvf=IsScholarly%2Ctrue" {
+		t.Errorf("Summon got %v, the client got %v", sent, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	proxyHandler(w, httptest.NewRequest("GET", "/2.0.0/search?s.q=forest&preset=newspapers", nil))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), ErrorUnknownPreset) ||
+		!strings.Contains(w.Body.String(), "scholarly") {
+		t.Errorf("An unknown preset got %v %v", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,262 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/cu-library/lorica/metrics"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// PriorityHeader is the request header a trusted client uses to mark a request's priority class.
+	PriorityHeader = "X-Lorica-Priority"
+
+	// The priority classes.
+	PriorityInteractive = "interactive"
+	PriorityBackground  = "background"
+
+	// DefaultBackgroundShare is the default fraction of -maxinflight background requests can use.
+	DefaultBackgroundShare = 0.5
+
+	// ErrorOverloaded is the error code sent when a background request is shed.
+	ErrorOverloaded = "overloaded"
+)
+
+var (
+	maxInFlight = flag.Int("maxinflight", 0, "The most requests sent to the Summon API at once. Interactive "+
+		"requests wait for a free slot before background requests do. 0 means no limit, and the priority "+
+		"classes have no effect.")
+	backgroundShare = flag.Float64("backgroundshare", DefaultBackgroundShare, "The fraction of -maxinflight "+
+		"background requests can use at once, so the rest is kept for interactive requests.")
+	backgroundQueueTimeout = flag.Duration("backgroundqueuetimeout", 0, "How long a background request waits "+
+		"for a free slot before it is shed with a 503. 0 sheds them as soon as there isn't one.")
+	backgroundTiers = flag.String("backgroundtiers", "", "The client tiers whose clients can mark requests "+
+		"as background with the "+PriorityHeader+" header, delimited by the ; character. * means any client.")
+
+	// inFlight holds the slots for requests to the Summon API. It is made once
+	// the flags are parsed, and is nil if there is no limit.
+	inFlight *priorityLimiter
+
+	priorityRequestsTotal = metrics.NewCounterVec("lorica_priority_requests_total",
+		"The number of requests in each priority class, by whether they were sent at once, "+
+			"after waiting for a slot, or shed.", "priority", "result")
+
+	_ = metrics.NewCollectorFunc("priority_in_flight", func(w io.Writer) {
+		if inFlight == nil {
+			return
+		}
+		interactive, background := inFlight.InFlight()
+		metrics.WriteGauge(w, "lorica_priority_in_flight_interactive",
+			"The number of interactive requests being sent to the Summon API.", float64(interactive))
+		metrics.WriteGauge(w, "lorica_priority_in_flight_background",
+			"The number of background requests being sent to the Summon API.", float64(background))
+	})
+)
+
+// priorityLimiter bounds the requests sent to the Summon API at once. When it
+// is full, waiting interactive requests get the next free slot before waiting
+// background requests, and background requests can only use some of the slots.
+type priorityLimiter struct {
+	sync.Mutex
+	max           int
+	backgroundMax int
+	interactive   int
+	background    int
+	waiting       map[string][]chan struct{}
+}
+
+// newPriorityLimiter returns a priorityLimiter with max slots, and a share of
+// them for background requests. Background requests get at least one slot.
+func newPriorityLimiter(max int, share float64) *priorityLimiter {
+	backgroundMax := int(float64(max) * share)
+	if backgroundMax < 1 {
+		backgroundMax = 1
+	}
+	if backgroundMax > max {
+		backgroundMax = max
+	}
+	return &priorityLimiter{
+		max:           max,
+		backgroundMax: backgroundMax,
+		waiting:       make(map[string][]chan struct{}),
+	}
+}
+
+// InFlight returns the number of interactive and background requests holding slots.
+func (pl *priorityLimiter) InFlight() (int, int) {
+	pl.Lock()
+	defer pl.Unlock()
+	return pl.interactive, pl.background
+}
+
+// free returns true if a request in the class can take a slot now. The lock must be held.
+func (pl *priorityLimiter) free(class string) bool {
+	if pl.interactive+pl.background >= pl.max {
+		return false
+	}
+	return class == PriorityInteractive || pl.background < pl.backgroundMax
+}
+
+// take gives a request in the class a slot. The lock must be held.
+func (pl *priorityLimiter) take(class string) {
+	if class == PriorityBackground {
+		pl.background++
+	} else {
+		pl.interactive++
+	}
+}
+
+// Acquire takes a slot for a request in the class, waiting at most wait for
+// one. It returns false if there wasn't a slot in time, or ctx was done first.
+// The second result is true if the request had to wait.
+func (pl *priorityLimiter) Acquire(ctx context.Context, class string, wait time.Duration) (bool, bool) {
+	pl.Lock()
+	queued := len(pl.waiting[PriorityInteractive]) > 0
+	if class == PriorityBackground {
+		queued = queued || len(pl.waiting[PriorityBackground]) > 0
+	}
+	if !queued && pl.free(class) {
+		pl.take(class)
+		pl.Unlock()
+		return true, false
+	}
+	if wait <= 0 {
+		pl.Unlock()
+		return false, false
+	}
+	granted := make(chan struct{})
+	pl.waiting[class] = append(pl.waiting[class], granted)
+	pl.Unlock()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-granted:
+		return true, true
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	pl.Lock()
+	defer pl.Unlock()
+	for i, ch := range pl.waiting[class] {
+		if ch == granted {
+			pl.waiting[class] = append(pl.waiting[class][:i], pl.waiting[class][i+1:]...)
+			return false, true
+		}
+	}
+	// The slot was given just as the wait ended, so it is passed on.
+	pl.release(class)
+	return false, true
+}
+
+// Release gives back a request's slot.
+func (pl *priorityLimiter) Release(class string) {
+	pl.Lock()
+	defer pl.Unlock()
+	pl.release(class)
+}
+
+// release gives back a slot, and hands the free slots to the waiting
+// requests, interactive ones first. The lock must be held.
+func (pl *priorityLimiter) release(class string) {
+	if class == PriorityBackground {
+		pl.background--
+	} else {
+		pl.interactive--
+	}
+	for _, next := range []string{PriorityInteractive, PriorityBackground} {
+		for len(pl.waiting[next]) > 0 && pl.free(next) {
+			pl.take(next)
+			close(pl.waiting[next][0])
+			pl.waiting[next] = pl.waiting[next][1:]
+		}
+		if len(pl.waiting[next]) > 0 {
+			// Background requests don't jump ahead of waiting interactive requests.
+			return
+		}
+	}
+}
+
+// requestPriority returns the request's priority class. Requests are
+// interactive unless a client in one of -backgroundtiers marks them as
+// background. Prefetches are always background requests.
+func requestPriority(r *http.Request) string {
+	if getRequestInfo(r).prefetch {
+		return PriorityBackground
+	}
+	if !strings.EqualFold(strings.TrimSpace(r.Header.Get(PriorityHeader)), PriorityBackground) {
+		return PriorityInteractive
+	}
+	tier := getRequestInfo(r).tier
+	for _, allowed := range splitList(*backgroundTiers) {
+		if allowed == "*" || (tier != "" && allowed == tier) {
+			return PriorityBackground
+		}
+	}
+	return PriorityInteractive
+}
+
+// withPriority is a middleware which holds requests until there is a slot to
+// send them to the Summon API, and sheds background requests which can't get
+// one, or arrive while memory is short. Interactive requests wait for up to
+// the Summon API timeout.
+func withPriority(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "OPTIONS" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		class := requestPriority(r)
+		if class == PriorityBackground && memoryWatch.UnderPressure() {
+			// Background requests are shed while memory is short, whether or not there is a limit.
+			priorityRequestsTotal.With(class, "shed").Inc()
+			sendOverloaded(w, r, class)
+			return
+		}
+		limiter := inFlight
+		if limiter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		wait := *timeout
+		if class == PriorityBackground {
+			wait = *backgroundQueueTimeout
+		}
+		ok, waited := limiter.Acquire(r.Context(), class, wait)
+		if !ok {
+			priorityRequestsTotal.With(class, "shed").Inc()
+			sendOverloaded(w, r, class)
+			return
+		}
+		defer limiter.Release(class)
+		if waited {
+			priorityRequestsTotal.With(class, "queued").Inc()
+		} else {
+			priorityRequestsTotal.With(class, "sent").Inc()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sendOverloaded rejects a request which couldn't get a slot.
+func sendOverloaded(w http.ResponseWriter, r *http.Request, class string) {
+	if r.Header.Get("Origin") != "" {
+		setACAOHeader(w, r)
+	}
+	setRetryAfter(w, time.Second)
+	message := "Lorica is busy, and this request couldn't be sent to the Summon API in time."
+	if class == PriorityBackground {
+		message = "Lorica is busy, and background requests are being shed so searches keep flowing."
+	}
+	sendJSONErrorCode(w, http.StatusServiceUnavailable, ErrorOverloaded, message,
+		[]string{"Send the request again after the time in the Retry-After header."})
+}
@@ -0,0 +1,148 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Waiting interactive requests get free slots before waiting background requests,
+// and background requests only use their share.
+func TestPriorityLimiter(t *testing.T) {
+	pl := newPriorityLimiter(2, 0.5)
+	ctx := context.Background()
+
+	if ok, waited := pl.Acquire(ctx, PriorityBackground, 0); !ok || waited {
+		t.Fatal("The first background request didn't get a slot.")
+	}
+	if ok, _ := pl.Acquire(ctx, PriorityBackground, 0); ok {
+		t.Error("A background request got more than its share of the slots.")
+	}
+	if ok, waited := pl.Acquire(ctx, PriorityInteractive, 0); !ok || waited {
+		t.Fatal("An interactive request didn't get the slot kept for it.")
+	}
+
+	order := make(chan string, 2)
+	wait := func(class string) {
+		if ok, waited := pl.Acquire(ctx, class, time.Second); ok && waited {
+			order <- class
+		} else {
+			order <- "failed"
+		}
+	}
+	go wait(PriorityBackground)
+	waitFor(t, func() bool { pl.Lock(); defer pl.Unlock(); return len(pl.waiting[PriorityBackground]) == 1 })
+	go wait(PriorityInteractive)
+	waitFor(t, func() bool { pl.Lock(); defer pl.Unlock(); return len(pl.waiting[PriorityInteractive]) == 1 })
+
+	pl.Release(PriorityBackground)
+	if got := <-order; got != PriorityInteractive {
+		t.Errorf("The first waiting request to get a slot was %v, not interactive.", got)
+	}
+	pl.Release(PriorityInteractive)
+	if got := <-order; got != PriorityBackground {
+		t.Errorf("The waiting background request got %v.", got)
+	}
+	if interactive, background := pl.InFlight(); interactive != 1 || background != 1 {
+		t.Errorf("%v interactive and %v background requests are in flight.", interactive, background)
+	}
+
+	if ok, waited := pl.Acquire(ctx, PriorityBackground, 10*time.Millisecond); ok || !waited {
+		t.Error("A background request got a slot when they were all in use.")
+	}
+	if len(pl.waiting[PriorityBackground]) != 0 {
+		t.Error("A background request which gave up is still waiting.")
+	}
+}
+
+// waitFor waits until the condition is true, failing the test after a second.
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting.")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Only clients in -backgroundtiers can mark requests as background.
+func TestRequestPriority(t *testing.T) {
+	oldBackgroundTiers := *backgroundTiers
+	defer func() { *backgroundTiers = oldBackgroundTiers }()
+
+	request := func(tier, priority string) *http.Request {
+		r, info := withRequestInfo(httptest.NewRequest("GET", "/2.0.0/search?s.q=forest", nil))
+		info.tier = tier
+		if priority != "" {
+			r.Header.Set(PriorityHeader, priority)
+		}
+		return r
+	}
+
+	*backgroundTiers = "harvester"
+	for _, c := range []struct {
+		tier, priority, want string
+	}{
+		{"harvester", "background", PriorityBackground},
+		{"harvester", "Background", PriorityBackground},
+		{"harvester", "", PriorityInteractive},
+		{"harvester", "urgent", PriorityInteractive},
+		{TierAnonymous, "background", PriorityInteractive},
+		{"", "background", PriorityInteractive},
+	} {
+		if got := requestPriority(request(c.tier, c.priority)); got != c.want {
+			t.Errorf("Tier %q with priority %q was %v, not %v.", c.tier, c.priority, got, c.want)
+		}
+	}
+
+	*backgroundTiers = "*"
+	if got := requestPriority(request("", "background")); got != PriorityBackground {
+		t.Errorf("With * the priority was %v.", got)
+	}
+}
+
+// Background requests are shed when there isn't a slot, and interactive requests are sent.
+func TestWithPriority(t *testing.T) {
+	oldInFlight, oldBackgroundTiers, oldBackgroundQueueTimeout := inFlight, *backgroundTiers, *backgroundQueueTimeout
+	defer func() {
+		inFlight, *backgroundTiers, *backgroundQueueTimeout = oldInFlight, oldBackgroundTiers, oldBackgroundQueueTimeout
+	}()
+	inFlight, *backgroundTiers, *backgroundQueueTimeout = newPriorityLimiter(2, 0.5), "*", 0
+
+	release := make(chan struct{})
+	handler := withPriority(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	serve := func(priority string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/2.0.0/search?s.q=forest", nil)
+		r.Header.Set(PriorityHeader, priority)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	done := make(chan struct{})
+	go func() {
+		serve(PriorityBackground)
+		close(done)
+	}()
+	waitFor(t, func() bool { _, background := inFlight.InFlight(); return background == 1 })
+
+	w := serve(PriorityBackground)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "1" {
+		t.Errorf("A background request over its share got %v with headers %v", w.Code, w.Header())
+	}
+	close(release)
+	if w := serve(PriorityInteractive); w.Code != http.StatusOK {
+		t.Errorf("An interactive request got %v", w.Code)
+	}
+	<-done
+}
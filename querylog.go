@@ -0,0 +1,238 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	l "github.com/cu-library/lorica/loglevel"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The formats of the query log export.
+const (
+	ExportCSV   = "csv"
+	ExportJSONL = "jsonl"
+)
+
+var (
+	queryLogPath = flag.String("querylog", "", "A file which a JSON line is appended to for every search, "+
+		"with the search terms and filters, but not the client's IP address, for assessment. "+
+		"Entries are purged like the audit log, and can be exported from /admin/export. "+
+		"If not set, searches aren't stored.")
+
+	// queries is the query log. If it is nil, nothing is recorded.
+	queries *queryLog
+
+	// queryFilterParameters are the parameters recorded as a search's filters.
+	queryFilterParameters = []string{"s.fvf", "s.fvgf", "s.rf", "s.fq"}
+
+	// queryLogCSVHeader is the first row of a CSV export.
+	queryLogCSVHeader = []string{"time", "request_id", "endpoint", "query", "filters", "status", "tenant", "session"}
+)
+
+// queryEntry is one line of the query log.
+type queryEntry struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id"`
+	Endpoint  string    `json:"endpoint"`
+	Query     string    `json:"query"`
+	Filters   []string  `json:"filters,omitempty"`
+	Status    int       `json:"status"`
+	Tenant    string    `json:"tenant"`
+	Session   string    `json:"session,omitempty"`
+}
+
+// csvRecord returns the entry as a row of a CSV export.
+func (e queryEntry) csvRecord() []string {
+	return []string{
+		e.Time.Format(time.RFC3339), e.RequestID, e.Endpoint, e.Query,
+		strings.Join(e.Filters, "|"), strconv.Itoa(e.Status), e.Tenant, e.Session,
+	}
+}
+
+// queryLog appends searches to a file as JSON lines.
+type queryLog struct {
+	sync.Mutex
+	w    io.Writer
+	file *os.File
+	path string
+	now  func() time.Time
+}
+
+// openQueryLog opens the file for appending, creating it if needed.
+func openQueryLog(path string) (*queryLog, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &queryLog{w: file, file: file, path: path, now: time.Now}, nil
+}
+
+// Record appends an entry for a search. Other requests aren't recorded.
+// It is safe to call on a nil queryLog.
+func (q *queryLog) Record(r *http.Request, statusCode int) {
+	if q == nil || endpointLabel(r.URL.Path) != "search" {
+		return
+	}
+	values := r.URL.Query()
+	var filters []string
+	for _, name := range queryFilterParameters {
+		for _, value := range values[name] {
+			filters = append(filters, name+"="+value)
+		}
+	}
+	info := getRequestInfo(r)
+
+	q.Lock()
+	defer q.Unlock()
+	line, err := json.Marshal(queryEntry{
+		Time:      q.now().UTC(),
+		RequestID: info.id,
+		Endpoint:  "search",
+		Query:     values.Get("s.q"),
+		Filters:   filters,
+		Status:    statusCode,
+		Tenant:    tenantLabel(r.Header.Get("Origin")),
+		Session:   info.session,
+	})
+	if err != nil {
+		return
+	}
+	if _, err := q.w.Write(append(line, '\n')); err != nil {
+		l.Logf(l.ErrorMessage, "Unable to write to query log: %v", err)
+	}
+}
+
+// Purge removes the entries which the retention policy says should be purged.
+func (q *queryLog) Purge(policy retentionPolicy) (int, error) {
+	if q == nil || q.file == nil {
+		return 0, nil
+	}
+	q.Lock()
+	defer q.Unlock()
+
+	// Close the file while it is replaced, then open the new one.
+	q.file.Close()
+	purged, purgeErr := purgeJSONLines(q.path, policy, q.now())
+	file, err := os.OpenFile(q.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		q.w = ioutil.Discard
+		return purged, err
+	}
+	q.w, q.file = file, file
+	return purged, purgeErr
+}
+
+// purgeQueryLog purges the query log entries the retention policy no longer keeps.
+func purgeQueryLog(q *queryLog) error {
+	purged, err := q.Purge(retentionPolicyFromFlags())
+	if err != nil {
+		return fmt.Errorf("unable to purge query log: %v", err)
+	}
+	if purged > 0 {
+		l.Logf(l.InfoMessage, "Purged %v query log entries.", purged)
+	}
+	return nil
+}
+
+// parseExportTime parses a date like 2016-01-31, or a time in RFC 3339 format.
+// If end is true, a date means the end of that day.
+func parseExportTime(value string, end bool) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		if end {
+			t = t.Add(24 * time.Hour)
+		}
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// exportQueries writes the entries in the query log from the time range
+// [from, to) in the format, and returns the number written.
+func exportQueries(w io.Writer, entries io.Reader, from, to time.Time, format string) (int, error) {
+	var csvWriter *csv.Writer
+	if format == ExportCSV {
+		csvWriter = csv.NewWriter(w)
+		csvWriter.Write(queryLogCSVHeader)
+		defer csvWriter.Flush()
+	}
+	exported := 0
+	scanner := bufio.NewScanner(entries)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var entry queryEntry
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil {
+			continue
+		}
+		if (!from.IsZero() && entry.Time.Before(from)) || (!to.IsZero() && !entry.Time.Before(to)) {
+			continue
+		}
+		if csvWriter != nil {
+			if err := csvWriter.Write(entry.csvRecord()); err != nil {
+				return exported, err
+			}
+		} else if _, err := w.Write(append(append([]byte(nil), scanner.Bytes()...), '\n')); err != nil {
+			return exported, err
+		}
+		exported++
+	}
+	return exported, scanner.Err()
+}
+
+// exportHandler streams the query log entries between from and to, which are
+// dates or RFC 3339 times, as CSV or JSON lines.
+func exportHandler(w http.ResponseWriter, r *http.Request) {
+	if queries == nil {
+		sendJSONError(w, http.StatusNotFound, "The query log isn't enabled.", []string{"Set -querylog to store searches."})
+		return
+	}
+	var from, to time.Time
+	var err error
+	if value := r.FormValue("from"); value != "" {
+		if from, err = parseExportTime(value, false); err != nil {
+			sendJSONError(w, http.StatusBadRequest, "The from parameter should be a date like 2016-01-31.", nil)
+			return
+		}
+	}
+	if value := r.FormValue("to"); value != "" {
+		if to, err = parseExportTime(value, true); err != nil {
+			sendJSONError(w, http.StatusBadRequest, "The to parameter should be a date like 2016-01-31.", nil)
+			return
+		}
+	}
+	format := r.FormValue("format")
+	switch format {
+	case "", ExportCSV:
+		format = ExportCSV
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	case ExportJSONL:
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	default:
+		sendJSONError(w, http.StatusBadRequest, "The format parameter should be csv or jsonl.", nil)
+		return
+	}
+
+	file, err := os.Open(queries.path)
+	if err != nil {
+		sendJSONError(w, http.StatusInternalServerError, "Unable to read the query log.", nil)
+		return
+	}
+	defer file.Close()
+	w.Header().Set("Content-Disposition", "attachment; filename=queries."+format)
+	if _, err := exportQueries(w, file, from, to, format); err != nil {
+		l.Logf(l.ErrorMessage, "Unable to export query log: %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Searches are recorded with their terms and filters, and other requests aren't.
+func TestQueryLogRecord(t *testing.T) {
+	buf := new(bytes.Buffer)
+	q := &queryLog{w: buf, now: func() time.Time { return time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC) }}
+
+	r := httptest.NewRequest("GET", "/2.0.0/search?s.q=climate+change&s.fvf=ContentType,Book,false", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	r, _ = withRequestInfo(r)
+	q.Record(r, http.StatusOK)
+	q.Record(httptest.NewRequest("GET", "/2.0.0/availability/123", nil), http.StatusOK)
+
+	line := buf.String()
+	if strings.Count(line, "\n") != 1 {
+		t.Fatalf("Query log was %v", line)
+	}
+	for _, want := range []string{`"query":"climate change"`, `"s.fvf=ContentType,Book,false"`, `"status":200`, `"2016-01-02T03:04:05Z"`} {
+		if !strings.Contains(line, want) {
+			t.Errorf("Query log entry %v doesn't have %v", line, want)
+		}
+	}
+	if strings.Contains(line, "192.0.2.1") {
+		t.Error("Query log entry has the client's IP address.")
+	}
+
+	var nilLog *queryLog
+	nilLog.Record(r, http.StatusOK)
+}
+
+func TestExportQueries(t *testing.T) {
+	entries := `{"time":"2016-01-01T10:00:00Z","request_id":"a","endpoint":"search","query":"one","status":200,"tenant":"other"}
+not json
+{"time":"2016-01-02T10:00:00Z","request_id":"b","endpoint":"search","query":"two, \"quoted\"","filters":["s.fvf=a","s.rf=b"],"status":200,"tenant":"other"}
+{"time":"2016-01-03T10:00:00Z","request_id":"c","endpoint":"search","query":"three","status":500,"tenant":"other"}
+`
+	from, _ := parseExportTime("2016-01-02", false)
+	to, _ := parseExportTime("2016-01-02", true)
+
+	csvOut := new(bytes.Buffer)
+	n, err := exportQueries(csvOut, strings.NewReader(entries), from, to, ExportCSV)
+	if err != nil || n != 1 {
+		t.Fatalf("Exported %v, %v", n, err)
+	}
+	expected := "time,request_id,endpoint,query,filters,status,tenant,session\n" +
+		"2016-01-02T10:00:00Z,b,search,\"two, \"\"quoted\"\"\",s.fvf=a|s.rf=b,200,other,\n"
+	if csvOut.String() != expected {
+		t.Errorf("CSV export was %q", csvOut.String())
+	}
+
+	jsonlOut := new(bytes.Buffer)
+	n, _ = exportQueries(jsonlOut, strings.NewReader(entries), time.Time{}, time.Time{}, ExportJSONL)
+	if n != 3 || strings.Count(jsonlOut.String(), "\n") != 3 {
+		t.Errorf("JSON lines export was %v", jsonlOut.String())
+	}
+}
+
+func TestExportHandler(t *testing.T) {
+	oldQueries := queries
+	defer func() { queries = oldQueries }()
+
+	queries = nil
+	w := httptest.NewRecorder()
+	exportHandler(w, httptest.NewRequest("GET", "/admin/export", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Export without a query log got %v", w.Code)
+	}
+
+	dir, err := ioutil.TempDir("", "lorica")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	queries, err = openQueryLog(filepath.Join(dir, "queries.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _ := withRequestInfo(httptest.NewRequest("GET", "/2.0.0/search?s.q=test", nil))
+	queries.Record(r, http.StatusOK)
+
+	for _, c := range []struct {
+		query string
+		code  int
+		body  string
+	}{
+		{"format=jsonl", http.StatusOK, `"query":"test"`},
+		{"from=2000-01-01&format=csv", http.StatusOK, ",test,"},
+		{"to=2000-01-01", http.StatusOK, "time,request_id"},
+		{"from=yesterday", http.StatusBadRequest, "from parameter"},
+		{"format=xml", http.StatusBadRequest, "format parameter"},
+	} {
+		w := httptest.NewRecorder()
+		exportHandler(w, httptest.NewRequest("GET", "/admin/export?"+c.query, nil))
+		if w.Code != c.code || !strings.Contains(w.Body.String(), c.body) {
+			t.Errorf("Export %v got %v %v", c.query, w.Code, w.Body.String())
+		}
+	}
+}
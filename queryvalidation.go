@@ -0,0 +1,91 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// DefaultMaxSearchLength is the default maximum length of a query parameter, like s.q.
+	DefaultMaxSearchLength = 1000
+
+	// MaxFacetParameterLength is the maximum length of a facet parameter value.
+	MaxFacetParameterLength = 500
+
+	// ErrorInvalidQuery is the error code sent when a query parameter is rejected.
+	ErrorInvalidQuery = "invalid_query"
+)
+
+var (
+	validateQueries = flag.Bool("validatequeries", false, "Check the query and facet parameters, and reject "+
+		"requests with values which are too long, have control characters, or have unbalanced quotes "+
+		"before sending them to the Summon API. Try it with -reportonly=queries first.")
+	maxSearchLength = flag.Int("maxsearchlength", DefaultMaxSearchLength, "The maximum length of the s.q and s.fq "+
+		"query parameters, when -validatequeries is set.")
+
+	// queryParameters use the fielded query syntax, so their quotes must be balanced.
+	queryParameters = []string{"s.q", "s.fq"}
+
+	// facetParameters are the facet and filter parameters which are checked.
+	facetParameters = []string{"s.ff", "s.fvf", "s.fvgf", "s.rf", "s.rff"}
+)
+
+// validateQuery returns a description of the first problem with the query
+// string's parameters, or an empty string if there are none.
+func validateQuery(rawQuery string) string {
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "The query string is malformed."
+	}
+	for _, name := range queryParameters {
+		for _, value := range values[name] {
+			if problem := validateValue(name, value, *maxSearchLength); problem != "" {
+				return problem
+			}
+			if strings.Count(value, `"`)%2 != 0 {
+				return fmt.Sprintf("The %v parameter has an unbalanced quote.", name)
+			}
+		}
+	}
+	for _, name := range facetParameters {
+		for _, value := range values[name] {
+			if problem := validateValue(name, value, MaxFacetParameterLength); problem != "" {
+				return problem
+			}
+		}
+	}
+	return ""
+}
+
+// validateValue checks the length and characters of a parameter's value.
+func validateValue(name, value string, maxLength int) string {
+	if maxLength > 0 && utf8.RuneCountInString(value) > maxLength {
+		return fmt.Sprintf("The %v parameter is longer than the maximum of %v characters.", name, maxLength)
+	}
+	if !utf8.ValidString(value) {
+		return fmt.Sprintf("The %v parameter is not valid UTF-8.", name)
+	}
+	for _, c := range value {
+		if unicode.IsControl(c) && c != '\t' {
+			return fmt.Sprintf("The %v parameter has a control character.", name)
+		}
+	}
+	return ""
+}
+
+// sendInvalidQuery tells the client what is wrong with the query.
+func sendInvalidQuery(w http.ResponseWriter, problem string) {
+	sendJSONErrorCode(w, http.StatusBadRequest, ErrorInvalidQuery, problem, []string{
+		"Quotes in s.q and s.fq must be balanced, like Title:\"open access\".",
+		"See http://api.summon.serialssolutions.com/help/api/ for the Summon API documentation.",
+	})
+}
@@ -0,0 +1,53 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidateQuery(t *testing.T) {
+	oldMaxSearchLength := *maxSearchLength
+	*maxSearchLength = 20
+	defer func() { *maxSearchLength = oldMaxSearchLength }()
+
+	for query, valid := range map[string]bool{
+		"":                                  true,
+		"s.q=test":                          true,
+		"s.q=Title%3A%22open+access%22":     true,
+		"s.q=caf%C3%A9%09menu":              true,
+		"s.fvf=ContentType,Book,false":      true,
+		"s.q=Title%3A%22open+access":        false,
+		"s.fq=Author%3A%22Smith":            false,
+		"s.q=" + strings.Repeat("a", 21):    false,
+		"s.q=bell%07":                       false,
+		"s.q=%FF":                           false,
+		"s.fvf=" + strings.Repeat("a", 501): false,
+		"s.fvf=ContentType,Book%00,false":   false,
+		"s.q=%zz":                           false,
+		"s.ho=" + strings.Repeat("%22", 3):  true,
+	} {
+		if problem := validateQuery(query); (problem == "") != valid {
+			t.Errorf("Query %v got %q, expected valid to be %v.", query, problem, valid)
+		}
+	}
+}
+
+// Bad queries get a 400 with an error code, without a request to Summon.
+func TestProxyHandlerInvalidQuery(t *testing.T) {
+	oldValidateQueries := *validateQueries
+	*validateQueries = true
+	defer func() { *validateQueries = oldValidateQueries }()
+
+	req := httptest.NewRequest("GET", "/2.0.0/search?s.q=%22unbalanced", nil)
+	w := httptest.NewRecorder()
+	proxyHandler(w, req)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), ErrorInvalidQuery) {
+		t.Errorf("Got %v %v", w.Code, w.Body.String())
+	}
+}
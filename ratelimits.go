@@ -0,0 +1,232 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"github.com/cu-library/lorica/metrics"
+	"github.com/didip/tollbooth"
+	"github.com/didip/tollbooth/libstring"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// LimiterDefault is the name of the rate limiter used when there are no client tiers.
+	LimiterDefault = "default"
+
+	// MaxLimiterClients is the most clients tracked by each rate limiter.
+	MaxLimiterClients = 10000
+
+	// LimiterClientIdleTime is how long a client is tracked after its last request.
+	LimiterClientIdleTime = 10 * time.Minute
+
+	// DefaultTopLimitedClients is how many clients are listed by the admin endpoint by default.
+	DefaultTopLimitedClients = 20
+)
+
+var (
+	// limiterStats are the stats for each rate limiter, by name.
+	limiterStats   = make(map[string]*rateLimiterStats)
+	limiterStatsMu sync.Mutex
+
+	rateLimitRejectionsTotal = metrics.NewCounterVec("lorica_rate_limit_rejections_total",
+		"The number of requests rejected by each rate limiter.", "limiter")
+	_ = metrics.NewCollectorFunc("rate_limit_clients", func(w io.Writer) {
+		tracked, limited := 0, 0
+		for _, s := range allLimiterStats() {
+			t, lim := s.Counts()
+			tracked += t
+			limited += lim
+		}
+		metrics.WriteGauge(w, "lorica_rate_limit_tracked_clients",
+			"The number of clients whose requests were recently counted by a rate limiter.", float64(tracked))
+		metrics.WriteGauge(w, "lorica_rate_limit_limited_clients",
+			"The number of clients rejected by a rate limiter in the last minute.", float64(limited))
+	})
+)
+
+// limitedClient is what a rate limiter's stats know about a client.
+type limitedClient struct {
+	IP           string    `json:"ip"`
+	Allowed      int64     `json:"allowed"`
+	Rejected     int64     `json:"rejected"`
+	Tokens       float64   `json:"tokens"`
+	LastRequest  time.Time `json:"last_request"`
+	LastRejected time.Time `json:"last_rejected"`
+}
+
+// rateLimiterStats mirrors a rate limiter's token buckets, so the state of
+// each client can be seen. The limiter keys its buckets by path as well as
+// IP address, so the token levels are an estimate for clients which use
+// more than one endpoint.
+type rateLimiterStats struct {
+	sync.Mutex
+	name    string
+	rate    float64
+	burst   int
+	clients map[string]*limitedClient
+	now     func() time.Time
+}
+
+func newRateLimiterStats(name string, rate float64, burst int) *rateLimiterStats {
+	return &rateLimiterStats{
+		name:    name,
+		rate:    rate,
+		burst:   burst,
+		clients: make(map[string]*limitedClient),
+		now:     time.Now,
+	}
+}
+
+// Observe records a request from the client which was allowed or rejected.
+func (s *rateLimiterStats) Observe(ip string, allowed bool) {
+	s.Lock()
+	defer s.Unlock()
+	now := s.now()
+	c, ok := s.clients[ip]
+	if !ok {
+		if len(s.clients) >= MaxLimiterClients {
+			s.forgetIdleClients(now)
+			if len(s.clients) >= MaxLimiterClients {
+				return
+			}
+		}
+		c = &limitedClient{IP: ip, Tokens: float64(s.burst), LastRequest: now}
+		s.clients[ip] = c
+	}
+	c.Tokens = s.tokensAt(c, now)
+	c.LastRequest = now
+	if allowed {
+		c.Allowed++
+		c.Tokens = math.Max(0, c.Tokens-1)
+	} else {
+		c.Rejected++
+		c.LastRejected = now
+	}
+}
+
+// tokensAt returns the client's tokens at the time. The caller must hold the lock.
+func (s *rateLimiterStats) tokensAt(c *limitedClient, now time.Time) float64 {
+	return math.Min(float64(s.burst), c.Tokens+now.Sub(c.LastRequest).Seconds()*s.rate)
+}
+
+// forgetIdleClients stops tracking clients which haven't sent a request recently.
+// The caller must hold the lock.
+func (s *rateLimiterStats) forgetIdleClients(now time.Time) {
+	for ip, c := range s.clients {
+		if now.Sub(c.LastRequest) > LimiterClientIdleTime {
+			delete(s.clients, ip)
+		}
+	}
+}
+
+// Counts returns the number of clients tracked, and the number rejected in the last minute.
+func (s *rateLimiterStats) Counts() (tracked, limited int) {
+	s.Lock()
+	defer s.Unlock()
+	now := s.now()
+	s.forgetIdleClients(now)
+	for _, c := range s.clients {
+		if now.Sub(c.LastRejected) < time.Minute {
+			limited++
+		}
+	}
+	return len(s.clients), limited
+}
+
+// Top returns the n clients with the most rejected requests, with their current tokens.
+// Clients which haven't been rejected aren't included.
+func (s *rateLimiterStats) Top(n int) []limitedClient {
+	s.Lock()
+	defer s.Unlock()
+	now := s.now()
+	s.forgetIdleClients(now)
+	top := []limitedClient{}
+	for _, c := range s.clients {
+		if c.Rejected == 0 {
+			continue
+		}
+		copied := *c
+		copied.Tokens = math.Floor(s.tokensAt(c, now)*100) / 100
+		top = append(top, copied)
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Rejected != top[j].Rejected {
+			return top[i].Rejected > top[j].Rejected
+		}
+		return top[i].IP < top[j].IP
+	})
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// allLimiterStats returns the stats of every rate limiter, ordered by name.
+func allLimiterStats() []*rateLimiterStats {
+	limiterStatsMu.Lock()
+	defer limiterStatsMu.Unlock()
+	all := make([]*rateLimiterStats, 0, len(limiterStats))
+	for _, s := range limiterStats {
+		all = append(all, s)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].name < all[j].name })
+	return all
+}
+
+// limitHandler rate limits requests to max per second from each client,
+// and keeps stats on the clients under the name.
+func limitHandler(name string, max float64, next http.Handler) http.Handler {
+	lmt := newRateLimiter(max)
+	stats := newRateLimiterStats(name, max, lmt.GetBurst())
+	limiterStatsMu.Lock()
+	limiterStats[name] = stats
+	limiterStatsMu.Unlock()
+
+	lmt.SetOnLimitReached(func(w http.ResponseWriter, r *http.Request) {
+		rateLimitRejectionsTotal.With(name).Inc()
+		stats.Observe(libstring.RemoteIP(lmt.GetIPLookups(), 0, r), false)
+		rateLimitReached(w, r)
+	})
+	return tollbooth.LimitHandler(lmt, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stats.Observe(libstring.RemoteIP(lmt.GetIPLookups(), 0, r), true)
+		next.ServeHTTP(w, r)
+	}))
+}
+
+// rateLimitsHandler lists each rate limiter's settings and the clients
+// with the most rejected requests. The top parameter sets how many.
+func rateLimitsHandler(w http.ResponseWriter, r *http.Request) {
+	n := DefaultTopLimitedClients
+	if top := r.FormValue("top"); top != "" {
+		var err error
+		n, err = strconv.Atoi(top)
+		if err != nil || n < 1 {
+			sendJSONError(w, http.StatusBadRequest, "The top parameter must be a positive number.", nil)
+			return
+		}
+	}
+	type limiterState struct {
+		Name    string          `json:"name"`
+		Rate    float64         `json:"rate"`
+		Burst   int             `json:"burst"`
+		Tracked int             `json:"tracked_clients"`
+		Limited int             `json:"limited_clients"`
+		Top     []limitedClient `json:"top_limited"`
+	}
+	states := []limiterState{}
+	for _, s := range allLimiterStats() {
+		tracked, limited := s.Counts()
+		states = append(states, limiterState{s.name, s.rate, s.burst, tracked, limited, s.Top(n)})
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(states)
+}
@@ -0,0 +1,76 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// The token levels follow the token bucket, and rejections are counted.
+func TestRateLimiterStatsObserve(t *testing.T) {
+	now := time.Date(2016, 1, 1, 12, 0, 0, 0, time.UTC)
+	s := newRateLimiterStats("test", 1, 2)
+	s.now = func() time.Time { return now }
+
+	s.Observe("192.0.2.1", true)
+	s.Observe("192.0.2.1", true)
+	s.Observe("192.0.2.1", false)
+	s.Observe("192.0.2.2", true)
+	now = now.Add(500 * time.Millisecond)
+
+	top := s.Top(10)
+	if len(top) != 1 {
+		t.Fatalf("Expected only the rejected client, got %v", top)
+	}
+	if top[0].IP != "192.0.2.1" || top[0].Allowed != 2 || top[0].Rejected != 1 || top[0].Tokens != 0.5 {
+		t.Errorf("Bad client state, got %#v", top[0])
+	}
+	if tracked, limited := s.Counts(); tracked != 2 || limited != 1 {
+		t.Errorf("Expected 2 tracked and 1 limited, got %v and %v", tracked, limited)
+	}
+
+	now = now.Add(LimiterClientIdleTime + time.Second)
+	if tracked, _ := s.Counts(); tracked != 0 {
+		t.Errorf("Idle clients not forgotten, got %v", tracked)
+	}
+}
+
+// Requests through a rate limiter are tracked, and listed by the admin endpoint.
+func TestLimitHandlerStats(t *testing.T) {
+	defer func() {
+		limiterStatsMu.Lock()
+		delete(limiterStats, "test-tier")
+		limiterStatsMu.Unlock()
+	}()
+	handler := limitHandler("test-tier", 1, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest("GET", "/2.0.0/search", nil)
+		req.RemoteAddr = "192.0.2.9:1234"
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	w := httptest.NewRecorder()
+	newAdminMux().ServeHTTP(w, httptest.NewRequest("GET", "/admin/ratelimits?top=5", nil))
+	var states []struct {
+		Name string          `json:"name"`
+		Top  []limitedClient `json:"top_limited"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &states); err != nil {
+		t.Fatalf("Bad response %v", w.Body.String())
+	}
+	for _, state := range states {
+		if state.Name == "test-tier" {
+			if len(state.Top) != 1 || state.Top[0].IP != "192.0.2.9" || state.Top[0].Rejected != 2 {
+				t.Errorf("Bad limiter state, got %#v", state)
+			}
+			return
+		}
+	}
+	t.Errorf("Limiter not listed, got %v", w.Body.String())
+}
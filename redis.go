@@ -0,0 +1,222 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxRedisIdleConns is the most idle connections kept to Redis.
+const MaxRedisIdleConns = 16
+
+// errRedisNil is returned when Redis replies with a nil, like GET for a missing key.
+var errRedisNil = errors.New("redis: nil")
+
+// redisClient sends commands to a Redis server, keeping a few connections open.
+type redisClient struct {
+	address  string
+	useTLS   bool
+	username string
+	password string
+	db       int
+	timeout  time.Duration
+	idle     chan *redisConn
+}
+
+// redisConn is a connection to Redis.
+type redisConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// newRedisClient returns a client for the Redis server at a URL like
+// redis://:password@host:6379/0. rediss:// URLs connect with TLS.
+func newRedisClient(rawURL string, timeout time.Duration) (*redisClient, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return nil, fmt.Errorf("the scheme %v isn't redis or rediss", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, errors.New("there is no host")
+	}
+	c := &redisClient{
+		address: u.Host,
+		useTLS:  u.Scheme == "rediss",
+		timeout: timeout,
+		idle:    make(chan *redisConn, MaxRedisIdleConns),
+	}
+	if u.Port() == "" {
+		c.address = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		c.username = u.User.Username()
+		c.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		c.db, err = strconv.Atoi(db)
+		if err != nil || c.db < 0 {
+			return nil, fmt.Errorf("the database %v isn't a number", db)
+		}
+	}
+	return c, nil
+}
+
+// dial opens a connection, and logs in and selects the database if needed.
+func (c *redisClient) dial() (*redisConn, error) {
+	dialer := &net.Dialer{Timeout: c.timeout}
+	var conn net.Conn
+	var err error
+	if c.useTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", c.address, &tls.Config{MinVersion: tls.VersionTLS12})
+	} else {
+		conn, err = dialer.Dial("tcp", c.address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rc := &redisConn{Conn: conn, reader: bufio.NewReader(conn)}
+	var setup [][]string
+	switch {
+	case c.password != "" && c.username != "":
+		setup = append(setup, []string{"AUTH", c.username, c.password})
+	case c.password != "":
+		setup = append(setup, []string{"AUTH", c.password})
+	}
+	if c.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.db)})
+	}
+	for _, args := range setup {
+		if _, err := rc.do(c.timeout, args...); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return rc, nil
+}
+
+// Do sends a command, and returns the reply. Bulk strings are returned as
+// []byte, integers as int64, simple strings as string, and arrays as []interface{}.
+func (c *redisClient) Do(args ...string) (interface{}, error) {
+	var conn *redisConn
+	select {
+	case conn = <-c.idle:
+	default:
+		var err error
+		conn, err = c.dial()
+		if err != nil {
+			return nil, err
+		}
+	}
+	reply, err := conn.do(c.timeout, args...)
+	var replyErr redisError
+	if err != nil && err != errRedisNil && !errors.As(err, &replyErr) {
+		// The connection is in an unknown state.
+		conn.Close()
+		return nil, err
+	}
+	select {
+	case c.idle <- conn:
+	default:
+		conn.Close()
+	}
+	return reply, err
+}
+
+// Close closes the idle connections.
+func (c *redisClient) Close() {
+	for {
+		select {
+		case conn := <-c.idle:
+			conn.Close()
+		default:
+			return
+		}
+	}
+}
+
+// do writes a command and reads its reply.
+func (rc *redisConn) do(timeout time.Duration, args ...string) (interface{}, error) {
+	if timeout > 0 {
+		rc.SetDeadline(time.Now().Add(timeout))
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(rc, b.String()); err != nil {
+		return nil, err
+	}
+	return readRedisReply(rc.reader)
+}
+
+// redisError is an error reply from Redis.
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// readRedisReply reads one reply in the Redis protocol.
+func readRedisReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, rest := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return rest, nil
+	case '-':
+		return nil, redisError(rest)
+	case ':':
+		return strconv.ParseInt(rest, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(rest)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, errRedisNil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(rest)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, errRedisNil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			items[i], err = readRedisReply(r)
+			if err != nil && err != errRedisNil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+}
@@ -0,0 +1,182 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	l "github.com/cu-library/lorica/loglevel"
+	"github.com/cu-library/lorica/metrics"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRegionCheckInterval is the default time between measurements of the Summon API endpoints.
+	DefaultRegionCheckInterval = time.Minute
+
+	// RegionLatencySmoothing is the weight given to each new latency measurement.
+	RegionLatencySmoothing = 0.3
+
+	// RegionSwitchMargin is how much faster another endpoint must be before it
+	// is preferred, so the choice doesn't flap between endpoints which are
+	// about as fast as each other.
+	RegionSwitchMargin = 0.2
+)
+
+var (
+	regionalAPIURLs = flag.String("regionalapis", "", "Other Summon API URLs, delimited by the ; character, "+
+		"like the endpoints serving other regions. The latency of a small signed search to each of them and "+
+		"-summonapi is measured every -regioncheckinterval, and requests are sent to the fastest healthy one.")
+	regionCheckInterval = flag.Duration("regioncheckinterval", DefaultRegionCheckInterval, "The time between "+
+		"measurements of the Summon API endpoints in -regionalapis. It must be more than 0.")
+
+	// regions chooses between the Summon API endpoints. It is nil if -regionalapis isn't set.
+	regions *regionSelector
+
+	regionLatencyGauge = metrics.NewGaugeVec("lorica_region_latency_seconds",
+		"The smoothed latency of each Summon API endpoint, in seconds.", "url")
+	regionHealthyGauge = metrics.NewGaugeVec("lorica_region_healthy",
+		"Whether the last check of each Summon API endpoint succeeded.", "url")
+	regionSelectedGauge = metrics.NewGaugeVec("lorica_region_selected",
+		"Whether requests are being sent to each Summon API endpoint.", "url")
+)
+
+// regionEndpoint is a Summon API endpoint and its measurements.
+type regionEndpoint struct {
+	url      string
+	latency  time.Duration
+	healthy  bool
+	measured bool
+}
+
+// regionSelector measures the Summon API endpoints, and chooses the fastest healthy one.
+type regionSelector struct {
+	sync.Mutex
+	endpoints []*regionEndpoint
+	selected  *regionEndpoint
+	probe     func(apiURL string) error
+}
+
+// newRegionSelector returns a regionSelector for the endpoints, which are
+// measured with the probe.
+func newRegionSelector(urls []string, probe func(apiURL string) error) *regionSelector {
+	rs := &regionSelector{probe: probe}
+	for _, u := range urls {
+		rs.endpoints = append(rs.endpoints, &regionEndpoint{url: u})
+	}
+	return rs
+}
+
+// parseRegionalAPIURLs returns -summonapi and the URLs in -regionalapis.
+func parseRegionalAPIURLs(list string) ([]string, error) {
+	urls := []string{*apiURL}
+	seen := map[string]bool{*apiURL: true}
+	for _, u := range splitList(list) {
+		parsed, err := url.Parse(u)
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			return nil, fmt.Errorf("%#v isn't an absolute URL", u)
+		}
+		if !seen[u] {
+			urls = append(urls, u)
+			seen[u] = true
+		}
+	}
+	return urls, nil
+}
+
+// checkRegion sends a small signed search to a Summon API endpoint.
+func checkRegion(apiURL string) error {
+	_, err := checkCredentials(upstreamClientWithTimeout(*timeout), apiURL, defaultCredentials())
+	return err
+}
+
+// URL returns the URL of the chosen endpoint, or -summonapi if none has been
+// chosen, because they haven't been measured or none are healthy.
+func (rs *regionSelector) URL() string {
+	if rs == nil {
+		return *apiURL
+	}
+	rs.Lock()
+	defer rs.Unlock()
+	if rs.selected == nil {
+		return *apiURL
+	}
+	return rs.selected.url
+}
+
+// Check measures every endpoint at once, and chooses again.
+func (rs *regionSelector) Check() {
+	var wg sync.WaitGroup
+	for _, e := range rs.endpoints {
+		wg.Add(1)
+		go func(e *regionEndpoint) {
+			defer wg.Done()
+			start := time.Now()
+			err := rs.probe(e.url)
+			rs.record(e, time.Since(start), err)
+		}(e)
+	}
+	wg.Wait()
+	rs.choose()
+}
+
+// record adds a measurement of an endpoint.
+func (rs *regionSelector) record(e *regionEndpoint, latency time.Duration, err error) {
+	rs.Lock()
+	defer rs.Unlock()
+	if err != nil {
+		if e.healthy || !e.measured {
+			l.Logf(l.WarnMessage, "The Summon API at %v failed its check: %v.", e.url, err)
+		}
+		e.healthy, e.measured = false, true
+		regionHealthyGauge.With(e.url).Set(0)
+		return
+	}
+	if e.healthy {
+		e.latency += time.Duration(RegionLatencySmoothing * float64(latency-e.latency))
+	} else {
+		e.latency = latency
+	}
+	e.healthy, e.measured = true, true
+	regionHealthyGauge.With(e.url).Set(1)
+	regionLatencyGauge.With(e.url).Set(e.latency.Seconds())
+}
+
+// choose selects the fastest healthy endpoint, unless the selected one is
+// healthy and not much slower.
+func (rs *regionSelector) choose() {
+	rs.Lock()
+	defer rs.Unlock()
+	var fastest *regionEndpoint
+	for _, e := range rs.endpoints {
+		if e.healthy && (fastest == nil || e.latency < fastest.latency) {
+			fastest = e
+		}
+	}
+	current := rs.selected
+	if current != nil && current.healthy && fastest != nil &&
+		float64(fastest.latency) > float64(current.latency)*(1-RegionSwitchMargin) {
+		return
+	}
+	if fastest != current {
+		switch {
+		case fastest == nil:
+			l.Logf(l.WarnMessage, "None of the Summon API endpoints are healthy, using %v.", *apiURL)
+		default:
+			l.Logf(l.InfoMessage, "Sending requests to the Summon API at %v, which responded in %v.",
+				fastest.url, fastest.latency.Round(time.Millisecond))
+		}
+	}
+	rs.selected = fastest
+	for _, e := range rs.endpoints {
+		selected := 0.0
+		if e == fastest {
+			selected = 1
+		}
+		regionSelectedGauge.With(e.url).Set(selected)
+	}
+}
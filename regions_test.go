@@ -0,0 +1,83 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"github.com/cu-library/lorica/internal/summonmock"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// The fastest healthy endpoint is chosen, and kept unless another is much faster.
+func TestRegionSelector(t *testing.T) {
+	oldAPIURL := *apiURL
+	*apiURL = "https://east"
+	defer func() { *apiURL = oldAPIURL }()
+
+	rs := newRegionSelector([]string{"https://east", "https://west"}, nil)
+	east, west := rs.endpoints[0], rs.endpoints[1]
+	measure := func(eastLatency, westLatency time.Duration, westErr error) {
+		rs.record(east, eastLatency, nil)
+		rs.record(west, westLatency, westErr)
+		rs.choose()
+	}
+
+	if rs.URL() != "https://east" {
+		t.Errorf("Before the endpoints were measured, %v was chosen.", rs.URL())
+	}
+	measure(100*time.Millisecond, 50*time.Millisecond, nil)
+	if rs.URL() != "https://west" {
+		t.Errorf("The slower endpoint %v was chosen.", rs.URL())
+	}
+	east.latency = 45 * time.Millisecond
+	rs.choose()
+	if rs.URL() != "https://west" {
+		t.Errorf("The choice changed to %v, though it wasn't much faster.", rs.URL())
+	}
+	east.latency = 30 * time.Millisecond
+	rs.choose()
+	if rs.URL() != "https://east" {
+		t.Errorf("The choice stayed %v, though the other was much faster.", rs.URL())
+	}
+	measure(50*time.Millisecond, 0, errors.New("timeout"))
+	if rs.URL() != "https://east" {
+		t.Errorf("The unhealthy endpoint %v was kept.", rs.URL())
+	}
+	rs.record(east, 0, errors.New("timeout"))
+	rs.choose()
+	if rs.URL() != *apiURL {
+		t.Errorf("With no healthy endpoints, %v was chosen.", rs.URL())
+	}
+}
+
+// Endpoints are measured with signed requests, and the chosen one is used by the proxy.
+func TestRegionCheck(t *testing.T) {
+	fast, stopFast := startSummonMock()
+	defer stopFast()
+	slow, stopSlow := startSummonMock()
+	defer stopSlow()
+	slowResponse := summonmock.JSON(http.StatusOK, `{"recordCount":0}`)
+	slowResponse.Delay = 50 * time.Millisecond
+	slow.Script(slowResponse)
+
+	// -summonapi is the slow one.
+	urls, err := parseRegionalAPIURLs(fast.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldRegions := regions
+	regions = newRegionSelector(urls, checkRegion)
+	defer func() { regions = oldRegions }()
+	regions.Check()
+	if upstreams.URL() != fast.URL || !fast.LastRequest().SignatureOK {
+		t.Errorf("%v was chosen, expected %v", upstreams.URL(), fast.URL)
+	}
+
+	if _, err := parseRegionalAPIURLs("summon.example.com"); err == nil {
+		t.Error("A URL without a scheme was accepted.")
+	}
+}
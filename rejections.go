@@ -0,0 +1,70 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// The error codes of rejected requests.
+const (
+	ErrorRateLimited = "rate_limited"
+	ErrorBlocked     = "blocked"
+)
+
+var (
+	rejectionCacheTTL = flag.Duration("rejectioncachettl", 0, "How long a shared cache in front of Lorica, "+
+		"like a CDN, may keep the 429 and 403 responses to rate limited and blocked clients, so it can absorb "+
+		"their retries. The cache sends them to every client asking for the same URL. 0 tells caches not "+
+		"to store them.")
+
+	// The bodies of rejections are built once, so rejecting a request costs
+	// as little as possible while a client is retrying as fast as it can.
+	rateLimitedBody = constantErrorBody(http.StatusTooManyRequests, ErrorRateLimited,
+		"Too many requests were sent, slow down and try again.")
+	blockedBody = constantErrorBody(http.StatusForbidden, ErrorBlocked,
+		"Requests from this client look like a scraper's, and are blocked for now.")
+)
+
+// constantErrorBody returns the body sendJSONErrorCode would send for the error.
+func constantErrorBody(statuscode int, code, message string) []byte {
+	body, err := json.Marshal(jsonError{
+		Status:  statuscode,
+		Error:   http.StatusText(statuscode),
+		Code:    code,
+		Message: message,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return body
+}
+
+// setRejectionHeaders sets the headers of a rejection. Caches may keep it
+// for -rejectioncachettl, and browsers never do. The rate limiter's headers
+// which describe the client are removed, so they aren't cached for others.
+func setRejectionHeaders(h http.Header) {
+	if *rejectionCacheTTL > 0 {
+		h.Set("Cache-Control", fmt.Sprintf("public, max-age=0, s-maxage=%d", int(*rejectionCacheTTL/time.Second)))
+	} else {
+		h.Set("Cache-Control", "no-store")
+	}
+	h.Del("X-Rate-Limit-Request-Forwarded-For")
+	h.Del("X-Rate-Limit-Request-Remote-Addr")
+	h.Set("X-Content-Type-Options", "nosniff")
+}
+
+// sendRejection sends one of the constant rejections. Unlike
+// sendJSONErrorCode, nothing is logged.
+func sendRejection(w http.ResponseWriter, statuscode int, body []byte) {
+	setRejectionHeaders(w.Header())
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(statuscode)
+	w.Write(body)
+}
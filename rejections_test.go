@@ -0,0 +1,58 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Rate limited clients get the constant rejection, which caches can keep if allowed.
+func TestRateLimitRejection(t *testing.T) {
+	h := limitHandler("rejections", 1, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/2.0.0/search?s.q=forest", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	serve()
+	w := serve()
+	if w.Code != http.StatusTooManyRequests || !bytes.Equal(w.Body.Bytes(), rateLimitedBody) {
+		t.Fatalf("Expected the constant rejection, got %v %v", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Cache-Control") != "no-store" || w.Header().Get("Retry-After") != "1" ||
+		w.Header().Get("Content-Type") != "application/json; charset=utf-8" {
+		t.Errorf("The rejection had headers %v", w.Header())
+	}
+	if _, ok := w.Header()["X-Rate-Limit-Request-Remote-Addr"]; ok {
+		t.Error("The rejection described the client.")
+	}
+
+	oldRejectionCacheTTL := *rejectionCacheTTL
+	*rejectionCacheTTL = 30 * time.Second
+	defer func() { *rejectionCacheTTL = oldRejectionCacheTTL }()
+	w = serve()
+	if w.Header().Get("Cache-Control") != "public, max-age=0, s-maxage=30" {
+		t.Errorf("The rejection had Cache-Control %v", w.Header().Get("Cache-Control"))
+	}
+}
+
+// The constant rejections are the errors sendJSONErrorCode would send.
+func TestSendRejection(t *testing.T) {
+	expected := httptest.NewRecorder()
+	sendJSONErrorCode(expected, http.StatusForbidden, ErrorBlocked,
+		"Requests from this client look like a scraper's, and are blocked for now.", nil)
+	w := httptest.NewRecorder()
+	sendRejection(w, http.StatusForbidden, blockedBody)
+	if w.Code != expected.Code || w.Body.String() != expected.Body.String() {
+		t.Errorf("Expected %v, got %v", expected.Body.String(), w.Body.String())
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	l "github.com/cu-library/lorica/loglevel"
+	"github.com/cu-library/lorica/metrics"
+	"net/http"
+	"strings"
+)
+
+// The policies which can be put in report-only mode.
+const (
+	PolicyPaths     = "paths"
+	PolicyQueries   = "queries"
+	PolicyEndpoints = "endpoints"
+	PolicyQuotas    = "quotas"
+	PolicyOrigins   = "origins"
+)
+
+// The actions taken on a request which breaks a policy.
+const (
+	PolicyBlocked  = "blocked"
+	PolicyReported = "reported"
+)
+
+var (
+	reportOnlyList = flag.String("reportonly", "", "A list of policies which are only reported, not enforced, "+
+		"delimited by the ; character. The policies are paths (-allowedpaths), queries (-validatequeries), "+
+		"endpoints and quotas (-tiers), and origins (-allowedorigins). Requests which break them are logged "+
+		"and counted, but not blocked.")
+
+	// knownPolicies are the policies which can be put in report-only mode.
+	knownPolicies = []string{PolicyPaths, PolicyQueries, PolicyEndpoints, PolicyQuotas, PolicyOrigins}
+
+	// reportOnly are the policies in report-only mode.
+	reportOnly = make(map[string]bool)
+
+	policyViolationsTotal = metrics.NewCounterVec("lorica_policy_violations_total",
+		"Requests which broke a policy, by whether they were blocked or only reported.", "policy", "action")
+)
+
+// parseReportOnly parses a list of policies, delimited by the ; character.
+func parseReportOnly(list string) (map[string]bool, error) {
+	policies := make(map[string]bool)
+	for _, name := range splitList(list) {
+		name = strings.ToLower(name)
+		known := false
+		for _, k := range knownPolicies {
+			if name == k {
+				known = true
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown policy %v, the policies are %v", name, strings.Join(knownPolicies, ", "))
+		}
+		policies[name] = true
+	}
+	return policies, nil
+}
+
+// enforcePolicy counts a request which broke the policy, and returns true if
+// it should be blocked. In report-only mode, the request is logged instead,
+// so the impact of a policy can be measured on real traffic before it is
+// enforced.
+func enforcePolicy(r *http.Request, policy, detail string) bool {
+	if !reportOnly[policy] {
+		policyViolationsTotal.With(policy, PolicyBlocked).Inc()
+		return true
+	}
+	policyViolationsTotal.With(policy, PolicyReported).Inc()
+	l.Logf(l.WarnMessage, "Report only: request %v for %v broke the %v policy, but wasn't blocked: %v",
+		getRequestInfo(r).id, r.URL.Path, policy, detail)
+	return false
+}
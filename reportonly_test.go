@@ -0,0 +1,88 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseReportOnly(t *testing.T) {
+	policies, err := parseReportOnly("quotas; Origins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !policies[PolicyQuotas] || !policies[PolicyOrigins] || policies[PolicyPaths] {
+		t.Errorf("The policies were %v", policies)
+	}
+	if _, err := parseReportOnly("quotas;everything"); err == nil {
+		t.Error("No error for an unknown policy.")
+	}
+}
+
+// Requests which break a report-only policy are counted, but not blocked.
+func TestEnforcePolicy(t *testing.T) {
+	oldReportOnly := reportOnly
+	defer func() { reportOnly = oldReportOnly }()
+	r := httptest.NewRequest("GET", "/2.0.0/suggest", nil)
+
+	reportOnly = map[string]bool{}
+	blocked := policyViolationsTotal.With(PolicyPaths, PolicyBlocked).Value()
+	if !enforcePolicy(r, PolicyPaths, "/2.0.0/suggest") {
+		t.Error("An enforced policy didn't block the request.")
+	}
+	if policyViolationsTotal.With(PolicyPaths, PolicyBlocked).Value() != blocked+1 {
+		t.Error("The blocked request wasn't counted.")
+	}
+
+	reportOnly = map[string]bool{PolicyPaths: true}
+	reported := policyViolationsTotal.With(PolicyPaths, PolicyReported).Value()
+	if enforcePolicy(r, PolicyPaths, "/2.0.0/suggest") {
+		t.Error("A report-only policy blocked the request.")
+	}
+	if policyViolationsTotal.With(PolicyPaths, PolicyReported).Value() != reported+1 {
+		t.Error("The reported request wasn't counted.")
+	}
+}
+
+// In report-only mode, origins which aren't allowed still get CORS headers.
+func TestSetACAOHeaderReportOnly(t *testing.T) {
+	oldAllowedOrigins, oldReportOnly := *allowedOrigins, reportOnly
+	*allowedOrigins = "https://library.example"
+	defer func() { *allowedOrigins, reportOnly = oldAllowedOrigins, oldReportOnly }()
+
+	for policies, expected := range map[string]string{
+		"":        "",
+		"origins": "https://stranger.example",
+	} {
+		reportOnly, _ = parseReportOnly(policies)
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Origin", "https://stranger.example")
+		w := httptest.NewRecorder()
+		setACAOHeader(w, r)
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != expected {
+			t.Errorf("With report-only policies %q, got %q, expected %q.", policies, got, expected)
+		}
+	}
+}
+
+// In report-only mode, paths which aren't allowed are sent on to Summon.
+func TestProxyHandlerPathReportOnly(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"suggestions":[]}`))
+	}))
+	defer ts.Close()
+	oldAPIURL, oldAllowedPaths, oldReportOnly := *apiURL, *allowedPaths, reportOnly
+	*apiURL, *allowedPaths, reportOnly = ts.URL, "/2.0.0/search", map[string]bool{PolicyPaths: true}
+	defer func() { *apiURL, *allowedPaths, reportOnly = oldAPIURL, oldAllowedPaths, oldReportOnly }()
+
+	w := httptest.NewRecorder()
+	proxyHandler(w, httptest.NewRequest("GET", "/2.0.0/suggest?s.q=fore", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Request for a report-only path got %v, expected 200.", w.Code)
+	}
+}
@@ -0,0 +1,39 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// MaxRequestIDLength is the longest X-Request-ID header which is accepted from a client.
+const MaxRequestIDLength = 128
+
+// newRequestID returns a random request ID.
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// requestID returns the client's X-Request-ID if it is safe to log,
+// so a request can be followed through a chain of proxies. Otherwise,
+// a new request ID is returned.
+func requestID(r *http.Request) string {
+	id := r.Header.Get("X-Request-ID")
+	if id == "" || len(id) > MaxRequestIDLength {
+		return newRequestID()
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return newRequestID()
+		}
+	}
+	return id
+}
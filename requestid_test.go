@@ -0,0 +1,48 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// A safe X-Request-ID from the client is used, and others are replaced.
+func TestRequestID(t *testing.T) {
+	req, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requestID(req)) != 32 {
+		t.Errorf("Generated request ID is wrong, got %v", requestID(req))
+	}
+	req.Header.Set("X-Request-ID", "from-the-load-balancer")
+	if requestID(req) != "from-the-load-balancer" {
+		t.Errorf("Client request ID not used, got %v", requestID(req))
+	}
+	for _, id := range []string{"has space", "new\nline", strings.Repeat("a", MaxRequestIDLength+1)} {
+		req.Header.Set("X-Request-ID", id)
+		if requestID(req) == id {
+			t.Errorf("Unsafe request ID %#v was used.", id)
+		}
+	}
+}
+
+// Every response gets an X-Request-ID header.
+func TestRecordResponsesRequestID(t *testing.T) {
+	handler := recordResponses(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	req, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Request-ID", "abc123")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Header().Get("X-Request-ID") != "abc123" {
+		t.Errorf("X-Request-ID not set on response, got %v", w.Header().Get("X-Request-ID"))
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// DefaultRetentionMaxAge is the default age after which stored records are purged.
+	DefaultRetentionMaxAge = 90 * 24 * time.Hour
+
+	// DefaultRetentionMaxSize is the default maximum size, in bytes, of each store of records.
+	DefaultRetentionMaxSize = 100 << 20
+
+	// RetentionCheckInterval is how often the stored records are purged.
+	RetentionCheckInterval = time.Hour
+)
+
+var (
+	retentionMaxAge = flag.Duration("retentionmaxage", DefaultRetentionMaxAge, "Stored records, like audit log "+
+		"entries, older than this are purged. 0 means records are never purged because of their age.")
+	retentionMaxSize = flag.Int64("retentionmaxsize", DefaultRetentionMaxSize, "The maximum size in bytes of each "+
+		"store of records, like the audit log. The oldest records are purged to stay under it. 0 means no limit.")
+)
+
+// retentionPolicy says which stored records should be purged.
+type retentionPolicy struct {
+	maxAge  time.Duration
+	maxSize int64
+}
+
+// retentionPolicyFromFlags returns the retention policy set by the flags.
+func retentionPolicyFromFlags() retentionPolicy {
+	return retentionPolicy{maxAge: *retentionMaxAge, maxSize: *retentionMaxSize}
+}
+
+// timestamped is the part of a JSON line record needed to purge it.
+type timestamped struct {
+	Time time.Time `json:"time"`
+}
+
+// purgeJSONLines removes the records in a JSON lines file which the policy
+// says should be purged. Each line should have a time field. Lines without
+// one are treated as old. The file is replaced, not edited in place, so a
+// partial purge never loses the records which should be kept.
+func purgeJSONLines(path string, policy retentionPolicy, now time.Time) (purged int, err error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	var kept [][]byte
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	scanner.Buffer(nil, len(contents)+1)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var record timestamped
+		if json.Unmarshal(line, &record) != nil || record.Time.IsZero() {
+			purged++
+			continue
+		}
+		if policy.maxAge > 0 && now.Sub(record.Time) > policy.maxAge {
+			purged++
+			continue
+		}
+		kept = append(kept, append([]byte(nil), line...))
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	// The newest records are at the end, so drop from the start to fit the size.
+	if policy.maxSize > 0 {
+		var size int64
+		first := len(kept)
+		for first > 0 && size+int64(len(kept[first-1]))+1 <= policy.maxSize {
+			size += int64(len(kept[first-1])) + 1
+			first--
+		}
+		purged += first
+		kept = kept[first:]
+	}
+	if purged == 0 {
+		return 0, nil
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".purge")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	for _, line := range kept {
+		if _, err := tmp.Write(append(line, '\n')); err != nil {
+			tmp.Close()
+			return 0, err
+		}
+	}
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	return purged, os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,105 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTempFile writes the contents to a new file, and returns its path.
+func writeTempFile(t *testing.T, dir, contents string) string {
+	path := filepath.Join(dir, "records.jsonl")
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Old records, and records without a time, are purged.
+func TestPurgeJSONLinesMaxAge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lorica")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := writeTempFile(t, dir, `{"time":"2019-01-01T00:00:00Z","n":1}
+{"n":2}
+{"time":"2019-06-01T00:00:00Z","n":3}
+`)
+	now := time.Date(2019, 6, 2, 0, 0, 0, 0, time.UTC)
+	purged, err := purgeJSONLines(path, retentionPolicy{maxAge: 30 * 24 * time.Hour}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, _ := ioutil.ReadFile(path)
+	if purged != 2 || string(contents) != `{"time":"2019-06-01T00:00:00Z","n":3}`+"\n" {
+		t.Errorf("Wrong records purged, purged %v, left %v", purged, string(contents))
+	}
+}
+
+// The oldest records are purged to fit the maximum size.
+func TestPurgeJSONLinesMaxSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lorica")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	line := `{"time":"2019-06-01T00:00:00Z","n":0}`
+	path := writeTempFile(t, dir, line+"\n"+strings.Replace(line, "0}", "1}", 1)+"\n")
+	now := time.Date(2019, 6, 2, 0, 0, 0, 0, time.UTC)
+	purged, err := purgeJSONLines(path, retentionPolicy{maxSize: int64(len(line) + 1)}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, _ := ioutil.ReadFile(path)
+	if purged != 1 || !strings.Contains(string(contents), `"n":1`) || strings.Contains(string(contents), `"n":0`) {
+		t.Errorf("Wrong records purged, purged %v, left %v", purged, string(contents))
+	}
+}
+
+// The audit log can still be written to after it is purged.
+func TestAuditLogPurge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lorica")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a, err := openAuditLog(filepath.Join(dir, "audit.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.file.Close()
+	now := time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)
+	a.now = func() time.Time { return now }
+
+	req, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Record(req, AuditRateLimited, "old")
+	now = now.Add(48 * time.Hour)
+	a.Record(req, AuditRateLimited, "new")
+
+	purged, err := a.Purge(retentionPolicy{maxAge: 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Record(req, AuditRateLimited, "after")
+
+	contents, _ := ioutil.ReadFile(a.path)
+	if purged != 1 || strings.Contains(string(contents), `"old"`) ||
+		!strings.Contains(string(contents), `"new"`) || !strings.Contains(string(contents), `"after"`) {
+		t.Errorf("Audit log purged incorrectly, purged %v, left %v", purged, string(contents))
+	}
+}
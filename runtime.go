@@ -0,0 +1,53 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"github.com/cu-library/lorica/metrics"
+	"io"
+	"runtime"
+	"time"
+)
+
+// The runtime metrics are calculated when the metrics are scraped,
+// with one call to runtime.ReadMemStats per scrape.
+var _ = metrics.NewCollectorFunc("runtime", writeRuntimeMetrics)
+
+// writeRuntimeMetrics writes the goroutine, memory, GC, and file descriptor metrics.
+func writeRuntimeMetrics(w io.Writer) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	metrics.WriteGauge(w, "go_goroutines", "The number of goroutines that currently exist.",
+		float64(runtime.NumGoroutine()))
+	metrics.WriteGauge(w, "go_memstats_heap_alloc_bytes", "The number of heap bytes allocated and still in use.",
+		float64(m.HeapAlloc))
+	metrics.WriteGauge(w, "go_memstats_heap_inuse_bytes", "The number of heap bytes in in-use spans.",
+		float64(m.HeapInuse))
+	metrics.WriteGauge(w, "go_memstats_heap_objects", "The number of allocated heap objects.",
+		float64(m.HeapObjects))
+	metrics.WriteGauge(w, "go_memstats_sys_bytes", "The number of bytes obtained from the OS.",
+		float64(m.Sys))
+	metrics.WriteCounter(w, "go_gc_cycles_total", "The number of completed GC cycles.",
+		float64(m.NumGC))
+	metrics.WriteCounter(w, "go_gc_pause_seconds_total", "The total time spent in GC stop-the-world pauses, in seconds.",
+		time.Duration(m.PauseTotalNs).Seconds())
+
+	// PauseNs is a circular buffer, the most recent pause is at (NumGC+255)%256.
+	var lastPause float64
+	if m.NumGC > 0 {
+		lastPause = time.Duration(m.PauseNs[(m.NumGC+255)%256]).Seconds()
+	}
+	metrics.WriteGauge(w, "go_gc_last_pause_seconds", "The duration of the most recent GC stop-the-world pause, in seconds.",
+		lastPause)
+
+	// Open file descriptors can only be counted on some platforms.
+	if open, ok := openFileDescriptors(); ok {
+		metrics.WriteGauge(w, "process_open_fds", "The number of open file descriptors.", float64(open))
+	}
+	if max, ok := maxFileDescriptors(); ok {
+		metrics.WriteGauge(w, "process_max_fds", "The maximum number of open file descriptors.", float64(max))
+	}
+}
@@ -0,0 +1,35 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// The runtime metrics should all be written.
+func TestWriteRuntimeMetrics(t *testing.T) {
+	b := new(bytes.Buffer)
+	writeRuntimeMetrics(b)
+
+	expected := []string{
+		"go_goroutines ",
+		"go_memstats_heap_alloc_bytes ",
+		"go_memstats_heap_inuse_bytes ",
+		"go_gc_cycles_total ",
+		"go_gc_pause_seconds_total ",
+		"go_gc_last_pause_seconds ",
+	}
+	if runtime.GOOS == "linux" {
+		expected = append(expected, "process_open_fds ", "process_max_fds ")
+	}
+	for _, metric := range expected {
+		if !strings.Contains(b.String(), "\n"+metric) {
+			t.Errorf("Runtime metrics missing %v, got\n%v", metric, b.String())
+		}
+	}
+}
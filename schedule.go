@@ -0,0 +1,343 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	l "github.com/cu-library/lorica/loglevel"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	scheduleList = flag.String("schedule", "", "A list of time windows with their own rate limits and quotas, "+
+		"delimited by the ; character. Each window is a name, a local time range, and settings, like: "+
+		"overnight 00:00-07:00 rate.anonymous=0.5 quota.anonymous=200/24h. rate.NAME sets the rate limit of a "+
+		"client tier, or of default when there are no tiers, and quota.NAME sets a tier's quota. "+
+		"dates=2016-12-01..2016-12-20 limits a window to some days. The first window which covers the current time "+
+		"is used. When set in the configuration file, the list is reloaded when Lorica receives a SIGHUP.")
+
+	// schedule holds the time windows.
+	schedule = &policySchedule{now: time.Now}
+)
+
+// windowQuota is a tier's quota during a time window.
+type windowQuota struct {
+	quota  int
+	period time.Duration
+	usage  *quotaUsage
+}
+
+// scheduleWindow is a time of day, and optionally a range of dates, with its own
+// rate limits and quotas.
+type scheduleWindow struct {
+	name       string
+	start, end int
+	from       time.Time
+	until      time.Time
+	rates      map[string]float64
+	quotas     map[string]*windowQuota
+
+	sync.Mutex
+	handlers map[string]http.Handler
+}
+
+// covers returns true if the window covers the time.
+func (w *scheduleWindow) covers(t time.Time) bool {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	if (!w.from.IsZero() && day.Before(w.from)) || (!w.until.IsZero() && day.After(w.until)) {
+		return false
+	}
+	minute := t.Hour()*60 + t.Minute()
+	switch {
+	case w.start == w.end:
+		return true
+	case w.start < w.end:
+		return w.start <= minute && minute < w.end
+	default:
+		return minute >= w.start || minute < w.end
+	}
+}
+
+// handler returns the rate limited handler for the limiter during the window,
+// building it the first time it is needed.
+func (w *scheduleWindow) handler(name string, next http.Handler) http.Handler {
+	w.Lock()
+	defer w.Unlock()
+	if h, ok := w.handlers[name]; ok {
+		return h
+	}
+	h := next
+	if rate := w.rates[name]; rate > 0 {
+		h = limitHandler(name+"@"+w.name, rate, next)
+	}
+	w.handlers[name] = h
+	return h
+}
+
+// policySchedule is the list of time windows. It can be replaced while serving
+// requests, and by the SIGHUP and configuration file reloads.
+type policySchedule struct {
+	sync.RWMutex
+	list    string
+	windows []*scheduleWindow
+	active  string
+	now     func() time.Time
+}
+
+// Active returns the window which covers the current time, or nil if none do.
+func (ps *policySchedule) Active() *scheduleWindow {
+	now := ps.now()
+	ps.RLock()
+	var active *scheduleWindow
+	for _, w := range ps.windows {
+		if w.covers(now) {
+			active = w
+			break
+		}
+	}
+	changed := (active == nil && ps.active != "") || (active != nil && active.name != ps.active)
+	ps.RUnlock()
+	if changed {
+		ps.Lock()
+		if active == nil {
+			ps.active = ""
+			l.Log(l.InfoMessage, "No schedule window is active, using the usual rate limits and quotas.")
+		} else {
+			ps.active = active.name
+			l.Log(l.InfoMessage, "Schedule window "+active.name+" is active.")
+		}
+		ps.Unlock()
+	}
+	return active
+}
+
+// Set replaces the windows with those in the list. Every rate and quota must
+// be for one of the limiters, and quotas are only allowed for client tiers.
+func (ps *policySchedule) Set(list string, limiters []string, tiersEnabled bool) error {
+	windows, err := parseSchedule(list)
+	if err != nil {
+		return err
+	}
+	known := make(map[string]bool)
+	for _, name := range limiters {
+		known[name] = true
+	}
+	for _, w := range windows {
+		for name := range w.rates {
+			if !known[name] {
+				return fmt.Errorf("window %v has a rate for %v, which should be one of %v", w.name, name, strings.Join(limiters, ", "))
+			}
+		}
+		for name := range w.quotas {
+			if !known[name] || !tiersEnabled {
+				return fmt.Errorf("window %v has a quota for %v, which isn't a client tier", w.name, name)
+			}
+		}
+	}
+	ps.Lock()
+	ps.list = list
+	ps.windows = windows
+	ps.Unlock()
+	return nil
+}
+
+// String returns the list the windows were set from.
+func (ps *policySchedule) String() string {
+	ps.RLock()
+	defer ps.RUnlock()
+	return ps.list
+}
+
+// parseSchedule parses a list of windows, like: overnight 00:00-07:00 rate.anonymous=0.5.
+func parseSchedule(list string) ([]*scheduleWindow, error) {
+	var windows []*scheduleWindow
+	seen := make(map[string]bool)
+	for _, entry := range splitList(list) {
+		w, err := parseScheduleWindow(entry)
+		if err != nil {
+			return nil, err
+		}
+		if seen[w.name] {
+			return nil, fmt.Errorf("window %v is listed more than once", w.name)
+		}
+		seen[w.name] = true
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+// parseScheduleWindow parses a window like: evenings 18:00-23:00 rate.staff=20.
+func parseScheduleWindow(entry string) (*scheduleWindow, error) {
+	fields := strings.Fields(entry)
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("window %v should have a name and a time range, like overnight 00:00-07:00", entry)
+	}
+	w := &scheduleWindow{
+		name:     strings.ToLower(fields[0]),
+		rates:    make(map[string]float64),
+		quotas:   make(map[string]*windowQuota),
+		handlers: make(map[string]http.Handler),
+	}
+	if !tierNamePattern.MatchString(w.name) {
+		return nil, fmt.Errorf("window name %v should only have lowercase letters, numbers, and dashes", w.name)
+	}
+	times := strings.SplitN(fields[1], "-", 2)
+	var err error
+	if len(times) == 2 {
+		w.start, err = parseTimeOfDay(times[0])
+		if err == nil {
+			w.end, err = parseTimeOfDay(times[1])
+		}
+	}
+	if len(times) != 2 || err != nil {
+		return nil, fmt.Errorf("window %v has a bad time range %v, it should look like 18:00-23:00", w.name, fields[1])
+	}
+	for _, field := range fields[2:] {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("window %v setting %v should look like name=value", w.name, field)
+		}
+		var err error
+		switch {
+		case strings.HasPrefix(parts[0], "rate."):
+			var rate float64
+			rate, err = strconv.ParseFloat(parts[1], 64)
+			if err == nil && rate < 0 {
+				err = errors.New("it can't be negative")
+			}
+			w.rates[strings.TrimPrefix(parts[0], "rate.")] = rate
+		case strings.HasPrefix(parts[0], "quota."):
+			t := &tier{}
+			if err = t.parseQuota(parts[1]); err == nil {
+				w.quotas[strings.TrimPrefix(parts[0], "quota.")] = &windowQuota{t.quota, t.quotaPeriod, t.usage}
+			}
+		case parts[0] == "dates":
+			dates := strings.SplitN(parts[1], "..", 2)
+			w.from, err = time.Parse("2006-01-02", dates[0])
+			if err == nil && len(dates) == 2 {
+				w.until, err = time.Parse("2006-01-02", dates[1])
+			} else {
+				w.until = w.from
+			}
+			if err == nil && w.until.Before(w.from) {
+				err = errors.New("the last date is before the first")
+			}
+		default:
+			err = errors.New("it isn't a window setting")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("window %v has a bad setting %v: %v", w.name, field, err)
+		}
+	}
+	return w, nil
+}
+
+// parseTimeOfDay parses a time like 18:30, and returns the minutes since midnight.
+// 24:00 is allowed, as the end of the day.
+func parseTimeOfDay(s string) (int, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return 0, errors.New("it should look like 18:30")
+	}
+	hour, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, err
+	}
+	minute, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, err
+	}
+	if hour < 0 || minute < 0 || minute > 59 || hour > 24 || (hour == 24 && minute != 0) {
+		return 0, errors.New("it is out of range")
+	}
+	return hour*60 + minute, nil
+}
+
+// scheduledHandler sends requests to the limiter's handler for the active
+// window, or to the usual handler if the window doesn't change its rate.
+type scheduledHandler struct {
+	name   string
+	usual  http.Handler
+	next   http.Handler
+	window func() *scheduleWindow
+}
+
+// newScheduledHandler returns a handler which rate limits requests like usual,
+// except during windows which change the limiter's rate.
+func newScheduledHandler(name string, usual, next http.Handler) http.Handler {
+	return &scheduledHandler{name: name, usual: usual, next: next, window: schedule.Active}
+}
+
+func (sh *scheduledHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if window := sh.window(); window != nil {
+		if _, ok := window.rates[sh.name]; ok {
+			window.handler(sh.name, sh.next).ServeHTTP(w, r)
+			return
+		}
+	}
+	sh.usual.ServeHTTP(w, r)
+}
+
+// scheduledQuota returns the quota for the tier during the active window.
+func scheduledQuota(t *tier) (int, time.Duration, *quotaUsage) {
+	if window := schedule.Active(); window != nil {
+		if q, ok := window.quotas[t.name]; ok {
+			return q.quota, q.period, q.usage
+		}
+	}
+	return t.quota, t.quotaPeriod, t.usage
+}
+
+// reloadSchedule sets the windows from the configuration file,
+// unless they were set by a flag or an environment variable. The reloaded
+// list is kept by the schedule, under its lock, not in the flag.
+func reloadSchedule(path string, limiters []string, tiersEnabled bool) error {
+	source := configSource("schedule")
+	if source != SourceFile && source != SourceDefault {
+		return fmt.Errorf("the schedule was set by %v, not the configuration file", source)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	values, err := parseConfig(file)
+	if err != nil {
+		return fmt.Errorf("%v: %v", path, err)
+	}
+	list, ok := values["schedule"]
+	if err := schedule.Set(list, limiters, tiersEnabled); err != nil {
+		return err
+	}
+	if ok {
+		setConfigSource("schedule", SourceFile)
+	} else {
+		setConfigSource("schedule", SourceDefault)
+	}
+	return nil
+}
+
+// reloadScheduleOnHangup reloads the schedule from the configuration file
+// every time the process receives a SIGHUP.
+func reloadScheduleOnHangup(path string, limiters []string, tiersEnabled bool) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	for range hangups {
+		if err := reloadSchedule(path, limiters, tiersEnabled); err != nil {
+			l.Logf(l.ErrorMessage, "Unable to reload schedule: %v", err)
+			continue
+		}
+		l.Log(l.InfoMessage, "Reloaded Schedule: "+schedule.String())
+	}
+}
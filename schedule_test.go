@@ -0,0 +1,180 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	windows, err := parseSchedule("exams 18:00-23:00 dates=2016-12-01..2016-12-20 rate.anonymous=5 quota.staff=100/1h; " +
+		"overnight 23:00-07:00 rate.anonymous=0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(windows) != 2 || windows[0].rates["anonymous"] != 5 || windows[0].quotas["staff"].quota != 100 ||
+		windows[1].start != 23*60 || windows[1].end != 7*60 {
+		t.Fatalf("Windows were %+v", windows)
+	}
+	for _, bad := range []string{
+		"overnight",
+		"overnight 7:00",
+		"overnight 25:00-07:00",
+		"overnight 00:00-07:60",
+		"Over_night 00:00-07:00",
+		"overnight 00:00-07:00 rate.anonymous=-1",
+		"overnight 00:00-07:00 quota.anonymous=100",
+		"overnight 00:00-07:00 dates=2016-12-20..2016-12-01",
+		"overnight 00:00-07:00 burst=5",
+		"overnight 00:00-07:00; overnight 01:00-02:00",
+	} {
+		if _, err := parseSchedule(bad); err == nil {
+			t.Errorf("Schedule %v didn't return an error.", bad)
+		}
+	}
+}
+
+func TestScheduleWindowCovers(t *testing.T) {
+	windows, _ := parseSchedule("overnight 23:00-07:00; exams 18:00-24:00 dates=2016-12-01..2016-12-20; always 00:00-00:00")
+	overnight, exams, always := windows[0], windows[1], windows[2]
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2016, month, day, hour, minute, 0, 0, time.Local)
+	}
+	for _, c := range []struct {
+		w       *scheduleWindow
+		t       time.Time
+		covered bool
+	}{
+		{overnight, at(6, 1, 23, 0), true},
+		{overnight, at(6, 1, 3, 0), true},
+		{overnight, at(6, 1, 7, 0), false},
+		{overnight, at(6, 1, 12, 0), false},
+		{exams, at(12, 1, 18, 0), true},
+		{exams, at(12, 20, 23, 59), true},
+		{exams, at(12, 21, 20, 0), false},
+		{exams, at(12, 10, 17, 59), false},
+		{always, at(6, 1, 12, 0), true},
+	} {
+		if c.w.covers(c.t) != c.covered {
+			t.Errorf("Window %v covering %v wasn't %v.", c.w.name, c.t, c.covered)
+		}
+	}
+}
+
+// Rates and quotas must be for known limiters, and quotas for client tiers.
+func TestPolicyScheduleSet(t *testing.T) {
+	ps := &policySchedule{now: time.Now}
+	if err := ps.Set("overnight 00:00-07:00 rate.default=1", []string{LimiterDefault}, false); err != nil {
+		t.Error(err)
+	}
+	if err := ps.Set("overnight 00:00-07:00 rate.staff=1", []string{LimiterDefault}, false); err == nil {
+		t.Error("Rate for an unknown limiter didn't return an error.")
+	}
+	if err := ps.Set("overnight 00:00-07:00 quota.default=1/1h", []string{LimiterDefault}, false); err == nil {
+		t.Error("Quota without client tiers didn't return an error.")
+	}
+	if len(ps.windows) != 1 {
+		t.Error("Windows were replaced by a bad schedule.")
+	}
+}
+
+// During a window, requests are rate limited by the window's rate.
+func TestScheduledHandler(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	usual := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusAccepted) })
+	windows, _ := parseSchedule("overnight 00:00-07:00 rate.default=1; quiet 07:00-08:00 rate.staff=1")
+	var active *scheduleWindow
+	h := &scheduledHandler{name: LimiterDefault, usual: usual, next: next, window: func() *scheduleWindow { return active }}
+
+	serve := func() int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+	if serve() != http.StatusAccepted {
+		t.Error("Usual handler wasn't used outside the windows.")
+	}
+	active = windows[1]
+	if serve() != http.StatusAccepted {
+		t.Error("Usual handler wasn't used in a window which doesn't change this limiter.")
+	}
+	active = windows[0]
+	if first, second := serve(), serve(); first != http.StatusOK || second != http.StatusTooManyRequests {
+		t.Errorf("Window's rate wasn't used, got %v and %v", first, second)
+	}
+}
+
+// Quotas during a window are counted separately from the usual quota.
+func TestScheduledQuota(t *testing.T) {
+	oldSchedule := schedule
+	defer func() { schedule = oldSchedule }()
+	now := time.Date(2016, 1, 1, 3, 0, 0, 0, time.Local)
+	schedule = &policySchedule{now: func() time.Time { return now }}
+	if err := schedule.Set("overnight 00:00-07:00 quota.staff=10/1h", []string{"staff"}, true); err != nil {
+		t.Fatal(err)
+	}
+	staff := &tier{name: "staff"}
+	staff.parseQuota("1000/24h")
+
+	if quota, period, usage := scheduledQuota(staff); quota != 10 || period != time.Hour || usage == staff.usage {
+		t.Errorf("Overnight quota was %v per %v", quota, period)
+	}
+	now = now.Add(5 * time.Hour)
+	if quota, _, usage := scheduledQuota(staff); quota != 1000 || usage != staff.usage {
+		t.Errorf("Daytime quota was %v", quota)
+	}
+}
+
+// The schedule is reloaded from the configuration file, but not if a flag set it.
+func TestReloadSchedule(t *testing.T) {
+	file, err := ioutil.TempFile("", "lorica")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("schedule = overnight 00:00-07:00 rate.default=1\nschedule = evenings 18:00-23:00 rate.default=5\n")
+	file.Close()
+
+	oldScheduleList, oldSchedule := *scheduleList, schedule
+	schedule = &policySchedule{now: time.Now}
+	defer func() {
+		*scheduleList, schedule = oldScheduleList, oldSchedule
+		delete(configSources, "schedule")
+	}()
+
+	if err := reloadSchedule(file.Name(), []string{LimiterDefault}, false); err != nil {
+		t.Fatal(err)
+	}
+	if len(schedule.windows) != 2 || schedule.windows[1].name != "evenings" {
+		t.Errorf("Schedule not reloaded, got %v", schedule.String())
+	}
+	if *scheduleList != oldScheduleList {
+		t.Errorf("The flag was written by the reload, got %v", *scheduleList)
+	}
+
+	// The SIGHUP and configuration file reloads can run at the same time.
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reloadSchedule(file.Name(), []string{LimiterDefault}, false)
+		}()
+	}
+	wg.Wait()
+
+	setConfigSource("schedule", SourceFlag)
+	if err := reloadSchedule(file.Name(), []string{LimiterDefault}, false); err == nil {
+		t.Error("No error reloading a schedule which was set by a flag.")
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	l "github.com/cu-library/lorica/loglevel"
+	"github.com/cu-library/lorica/metrics"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultJobJitter is the default fraction of a job's interval its runs are randomly delayed by.
+const DefaultJobJitter = 0.1
+
+var (
+	jobJitter = flag.Float64("jobjitter", DefaultJobJitter, "The fraction of a periodic job's interval each run "+
+		"is randomly delayed by, so the jobs of several Lorica instances don't all run at once. 0 means no delay.")
+
+	// jobs runs the periodic background jobs, like the health checks and log purges.
+	jobs = newScheduler()
+
+	jobRunsTotal = metrics.NewCounterVec("lorica_job_runs_total",
+		"The number of runs of each periodic job, by whether they succeeded.", "job", "result")
+	jobDuration = metrics.NewHistogramVec("lorica_job_duration_seconds",
+		"The time taken by each run of a periodic job, in seconds.", nil, "job")
+	jobLastSuccess = metrics.NewGaugeVec("lorica_job_last_success_timestamp_seconds",
+		"When each periodic job last succeeded, in seconds since the epoch.", "job")
+)
+
+// job is a function the scheduler runs every interval.
+type job struct {
+	name     string
+	interval time.Duration
+	run      func() error
+	trigger  chan struct{}
+
+	// These are guarded by the scheduler's lock.
+	running      bool
+	runs         int
+	failures     int
+	lastRun      time.Time
+	lastDuration time.Duration
+	lastErr      error
+	nextRun      time.Time
+}
+
+// jobStatus is how a job is shown by /admin/jobs.
+type jobStatus struct {
+	Name         string  `json:"name"`
+	Interval     string  `json:"interval"`
+	Running      bool    `json:"running"`
+	Runs         int     `json:"runs"`
+	Failures     int     `json:"failures"`
+	LastRun      string  `json:"last_run,omitempty"`
+	LastDuration float64 `json:"last_duration_seconds"`
+	LastError    string  `json:"last_error,omitempty"`
+	NextRun      string  `json:"next_run,omitempty"`
+}
+
+// scheduler runs periodic jobs, each in its own goroutine, so features don't
+// each need their own ticker. Every run is delayed by a random part of
+// -jobjitter, and the runs of a job never overlap.
+type scheduler struct {
+	sync.Mutex
+	jobs   map[string]*job
+	now    func() time.Time
+	random func() float64
+}
+
+func newScheduler() *scheduler {
+	return &scheduler{jobs: make(map[string]*job), now: time.Now, random: rand.Float64}
+}
+
+// Schedule starts running the job every interval. If immediate is true, it
+// is run once right away. A job's name must be unique.
+func (s *scheduler) Schedule(name string, interval time.Duration, immediate bool, run func() error) {
+	j := &job{name: name, interval: interval, run: run, trigger: make(chan struct{}, 1)}
+	s.Lock()
+	if _, ok := s.jobs[name]; ok {
+		s.Unlock()
+		panic(fmt.Sprintf("the %v job is already scheduled", name))
+	}
+	s.jobs[name] = j
+	s.Unlock()
+	go s.loop(j, immediate)
+}
+
+// delay returns the time until a job's next run, with up to the jitter
+// fraction of its interval added.
+func (s *scheduler) delay(interval time.Duration, jitter float64) time.Duration {
+	if jitter <= 0 {
+		return interval
+	}
+	return interval + time.Duration(s.random()*jitter*float64(interval))
+}
+
+// loop runs the job whenever it is due or triggered, forever.
+func (s *scheduler) loop(j *job, immediate bool) {
+	if immediate {
+		s.runJob(j)
+	}
+	for {
+		delay := s.delay(j.interval, *jobJitter)
+		s.Lock()
+		j.nextRun = s.now().Add(delay)
+		s.Unlock()
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-j.trigger:
+			timer.Stop()
+		}
+		s.runJob(j)
+	}
+}
+
+// runJob runs the job once, and records the result.
+func (s *scheduler) runJob(j *job) {
+	s.Lock()
+	j.running = true
+	s.Unlock()
+
+	start := s.now()
+	err := j.run()
+	took := s.now().Sub(start)
+
+	s.Lock()
+	defer s.Unlock()
+	j.running = false
+	j.runs++
+	j.lastRun, j.lastDuration, j.lastErr = start, took, err
+	jobDuration.With(j.name).Observe(took.Seconds())
+	if err != nil {
+		j.failures++
+		jobRunsTotal.With(j.name, "failed").Inc()
+		l.Logf(l.ErrorMessage, "The %v job failed: %v", j.name, err)
+		return
+	}
+	jobRunsTotal.With(j.name, "succeeded").Inc()
+	jobLastSuccess.With(j.name).Set(float64(start.Unix()))
+}
+
+// RunNow runs the job with the name as soon as it isn't running, instead of
+// waiting for its next run. It returns false if there is no such job.
+func (s *scheduler) RunNow(name string) bool {
+	s.Lock()
+	j, ok := s.jobs[name]
+	s.Unlock()
+	if !ok {
+		return false
+	}
+	select {
+	case j.trigger <- struct{}{}:
+	default:
+		// A run has already been triggered.
+	}
+	return true
+}
+
+// Status returns the state of every job, sorted by name.
+func (s *scheduler) Status() []jobStatus {
+	s.Lock()
+	defer s.Unlock()
+	statuses := []jobStatus{}
+	for _, j := range s.jobs {
+		status := jobStatus{
+			Name:         j.name,
+			Interval:     j.interval.String(),
+			Running:      j.running,
+			Runs:         j.runs,
+			Failures:     j.failures,
+			LastDuration: j.lastDuration.Seconds(),
+		}
+		if !j.lastRun.IsZero() {
+			status.LastRun = j.lastRun.UTC().Format(time.RFC3339)
+		}
+		if j.lastErr != nil {
+			status.LastError = j.lastErr.Error()
+		}
+		if !j.nextRun.IsZero() && !j.running {
+			status.NextRun = j.nextRun.UTC().Format(time.RFC3339)
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, k int) bool { return statuses[i].Name < statuses[k].Name })
+	return statuses
+}
+
+// jobsHandler lists the periodic jobs. A POST request with the job parameter
+// runs that job now.
+func jobsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET", "HEAD":
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(jobs.Status())
+	case "POST":
+		name := r.FormValue("job")
+		if name == "" {
+			sendJSONError(w, http.StatusBadRequest, "The job parameter is required.", nil)
+			return
+		}
+		if !jobs.RunNow(name) {
+			sendJSONError(w, http.StatusNotFound, fmt.Sprintf("There is no %v job.", name), nil)
+			return
+		}
+		l.Logf(l.InfoMessage, "Running the %v job now.", name)
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, "Running the %v job.\n", name)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		sendJSONError(w, http.StatusMethodNotAllowed, "Only GET, HEAD, and POST requests accepted.", nil)
+	}
+}
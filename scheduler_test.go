@@ -0,0 +1,93 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Runs are delayed by up to the jitter fraction of the interval, never less than the interval.
+func TestSchedulerDelay(t *testing.T) {
+	s := newScheduler()
+	s.random = func() float64 { return 0.5 }
+	if d := s.delay(time.Minute, 0.1); d != time.Minute+3*time.Second {
+		t.Errorf("The delay with jitter was %v", d)
+	}
+	if d := s.delay(time.Minute, 0); d != time.Minute {
+		t.Errorf("The delay without jitter was %v", d)
+	}
+}
+
+// Jobs can be run now, and their failures are kept.
+func TestSchedulerRunNow(t *testing.T) {
+	s := newScheduler()
+	var runs int32
+	s.Schedule("test", time.Hour, true, func() error {
+		if atomic.AddInt32(&runs, 1) == 2 {
+			return errors.New("broken")
+		}
+		return nil
+	})
+	waitFor(t, func() bool { st := s.Status(); return st[0].Runs == 1 && st[0].NextRun != "" })
+	if !s.RunNow("test") {
+		t.Fatal("The job wasn't found.")
+	}
+	waitFor(t, func() bool { return s.Status()[0].Runs == 2 })
+	if st := s.Status()[0]; st.Failures != 1 || st.LastError != "broken" || st.Interval != "1h0m0s" {
+		t.Errorf("The job's status was %+v", st)
+	}
+	if s.RunNow("missing") {
+		t.Error("A missing job was run.")
+	}
+}
+
+func TestJobsHandler(t *testing.T) {
+	old := jobs
+	defer func() { jobs = old }()
+	jobs = newScheduler()
+	ran := make(chan struct{}, 1)
+	jobs.Schedule("test", time.Hour, false, func() error {
+		ran <- struct{}{}
+		return nil
+	})
+
+	w := httptest.NewRecorder()
+	jobsHandler(w, httptest.NewRequest("GET", "/admin/jobs", nil))
+	var statuses []jobStatus
+	if err := json.NewDecoder(w.Body).Decode(&statuses); err != nil || len(statuses) != 1 || statuses[0].Name != "test" {
+		t.Errorf("The jobs were %+v, %v", statuses, err)
+	}
+
+	for _, c := range []struct {
+		method, job string
+		code        int
+	}{
+		{"POST", "test", http.StatusAccepted},
+		{"POST", "missing", http.StatusNotFound},
+		{"POST", "", http.StatusBadRequest},
+		{"PUT", "test", http.StatusMethodNotAllowed},
+	} {
+		r := httptest.NewRequest(c.method, "/admin/jobs", strings.NewReader(url.Values{"job": {c.job}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		jobsHandler(w, r)
+		if w.Code != c.code {
+			t.Errorf("%v %q got %v", c.method, c.job, w.Code)
+		}
+	}
+	select {
+	case <-ran:
+	case <-time.After(5 * time.Second):
+		t.Error("The job wasn't run.")
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	l "github.com/cu-library/lorica/loglevel"
+	"github.com/cu-library/lorica/metrics"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// SchemaSampleSize is how much of a malformed response body is logged.
+const SchemaSampleSize = 200
+
+var (
+	schemaGuard = flag.Bool("schemaguard", true, "Check that successful responses from the Summon API are JSON "+
+		"or XML with the expected structure before they are transformed or sent, and send a 502 instead "+
+		"of a malformed response.")
+
+	// expectedXMLRoots are the root elements of each endpoint's XML responses.
+	expectedXMLRoots = map[string]string{
+		"search": "response",
+	}
+
+	schemaMismatchesTotal = metrics.NewCounterVec("lorica_schema_mismatches_total",
+		"The number of successful responses from the Summon API which didn't have the expected structure.", "endpoint")
+)
+
+// checkSchema returns an error if the body isn't what the endpoint sends
+// for the content type. JSON responses must be an object, and XML responses
+// must be well-formed, with the endpoint's root element if it has an expected
+// one. Other content types aren't checked.
+func checkSchema(endpoint, contentType string, body []byte) error {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil
+	}
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(body, &fields); err != nil {
+			return fmt.Errorf("the JSON body isn't an object: %v", err)
+		}
+	case mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml"):
+		root, err := xmlRoot(body)
+		if err != nil {
+			return fmt.Errorf("the XML body is malformed: %v", err)
+		}
+		if expected, ok := expectedXMLRoots[endpoint]; ok && root != expected {
+			return fmt.Errorf("the XML body's root element is %v, not %v", root, expected)
+		}
+	}
+	return nil
+}
+
+// xmlRoot returns the name of the root element of an XML document,
+// after checking the whole document is well-formed.
+func xmlRoot(body []byte) (string, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	root := ""
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return "", err
+		}
+		if start, ok := token.(xml.StartElement); ok && root == "" {
+			root = start.Name.Local
+		}
+	}
+	if root == "" {
+		return "", errors.New("there is no root element")
+	}
+	return root, nil
+}
+
+// sendSchemaMismatch logs a sample of a malformed response from the
+// Summon API, and tells the client Summon's response was unusable.
+func sendSchemaMismatch(w http.ResponseWriter, r *http.Request, body []byte, err error) {
+	endpoint := endpointLabel(r.URL.Path)
+	schemaMismatchesTotal.With(endpoint).Inc()
+	sample := body
+	if len(sample) > SchemaSampleSize {
+		sample = sample[:SchemaSampleSize]
+	}
+	l.Logf(l.ErrorMessage, "Malformed %v response from Summon API for request %v, %v: %q",
+		endpoint, getRequestInfo(r).id, err, sample)
+	sendJSONErrorCode(w, http.StatusBadGateway, ErrorSummonBadResponse,
+		"The Summon API sent a response which couldn't be understood.",
+		[]string{"Try the request again. If it keeps failing, tell the library, and include the X-Request-ID."})
+}
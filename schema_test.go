@@ -0,0 +1,58 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCheckSchema(t *testing.T) {
+	for _, c := range []struct {
+		endpoint, contentType, body string
+		ok                          bool
+	}{
+		{"search", "application/json; charset=utf-8", `{"recordCount":1}`, true},
+		{"search", "application/json", `[1, 2]`, false},
+		{"search", "application/json", `{"recordCount":1`, false},
+		{"search", "application/json", ``, false},
+		{"other", "application/vnd.example+json", `"text"`, false},
+		{"search", "text/xml", `<?xml version="1.0"?><response><documents/></response>`, true},
+		{"search", "application/xml", `<error/>`, false},
+		{"search", "application/xml", `<response><documents></response>`, false},
+		{"availability", "application/xml", `<availability/>`, true},
+		{"availability", "application/xml", `just text`, false},
+		{"search", "text/plain", `anything`, true},
+		{"search", "", `anything`, true},
+	} {
+		err := checkSchema(c.endpoint, c.contentType, []byte(c.body))
+		if (err == nil) != c.ok {
+			t.Errorf("Checking %v %v %v returned %v", c.endpoint, c.contentType, c.body, err)
+		}
+	}
+}
+
+// Malformed responses from Summon get a structured 502.
+func TestProxyHandlerSchemaMismatch(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"recordCount":`))
+	}))
+	defer ts.Close()
+	oldAPIURL := *apiURL
+	*apiURL = ts.URL
+	defer func() { *apiURL = oldAPIURL }()
+
+	w := httptest.NewRecorder()
+	proxyHandler(w, httptest.NewRequest("GET", "/2.0.0/search?s.q=test", nil))
+	if w.Code != http.StatusBadGateway || !strings.Contains(w.Body.String(), ErrorSummonBadResponse) {
+		t.Errorf("A malformed response got %v %v", w.Code, w.Body.String())
+	}
+	if w.Header().Get("ETag") != "" {
+		t.Error("A malformed response was given an ETag.")
+	}
+}
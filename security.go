@@ -0,0 +1,132 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"flag"
+	"net/http"
+)
+
+// ContentSecurityPolicy is the Content-Security-Policy of the responses Lorica
+// makes itself. They are errors and data, which never load anything.
+const ContentSecurityPolicy = "default-src 'none'; base-uri 'none'; form-action 'none'; frame-ancestors 'none'"
+
+var (
+	securityHeaders = flag.Bool("securityheaders", true, "Add the X-Content-Type-Options, Referrer-Policy, and "+
+		"Content-Security-Policy headers to the responses Lorica makes itself, like errors and the status page. "+
+		"Responses proxied from the Summon API aren't changed.")
+	referrerPolicy = flag.String("referrerpolicy", "no-referrer", "The Referrer-Policy header of the responses "+
+		"Lorica makes itself, when -securityheaders is set.")
+)
+
+// responseOrigin records whether a response came from the Summon API.
+type responseOrigin struct {
+	proxied bool
+}
+
+// withResponseOrigin returns a copy of the request which records whether its
+// response came from the Summon API. Searches Lorica makes for the client,
+// like the queries in a batch, get their own, so the response Lorica builds
+// from theirs isn't taken for a proxied one.
+func withResponseOrigin(r *http.Request) (*http.Request, *responseOrigin) {
+	origin := &responseOrigin{}
+	return r.WithContext(context.WithValue(r.Context(), responseOriginKey, origin)), origin
+}
+
+// markProxied records that the response to the request came from the Summon API.
+func markProxied(r *http.Request) {
+	if origin, ok := r.Context().Value(responseOriginKey).(*responseOrigin); ok {
+		origin.proxied = true
+	}
+}
+
+// securityRecorder is a http.ResponseWriter which adds the security headers
+// before the response is written, unless the response came from the Summon API.
+type securityRecorder struct {
+	http.ResponseWriter
+	origin  *responseOrigin
+	written bool
+}
+
+func (rec *securityRecorder) addHeaders() {
+	if rec.written {
+		return
+	}
+	rec.written = true
+	if rec.origin.proxied {
+		return
+	}
+	h := rec.Header()
+	setDefaultHeader(h, "X-Content-Type-Options", "nosniff")
+	if *referrerPolicy != "" {
+		setDefaultHeader(h, "Referrer-Policy", *referrerPolicy)
+	}
+	setDefaultHeader(h, "Content-Security-Policy", ContentSecurityPolicy)
+}
+
+func (rec *securityRecorder) WriteHeader(statusCode int) {
+	rec.addHeaders()
+	rec.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rec *securityRecorder) Write(b []byte) (int, error) {
+	rec.addHeaders()
+	return rec.ResponseWriter.Write(b)
+}
+
+// Flush sends any buffered data to the client, for streamed responses like the log stream.
+func (rec *securityRecorder) Flush() {
+	rec.addHeaders()
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// setDefaultHeader sets the header, unless the handler already set it.
+func setDefaultHeader(h http.Header, name, value string) {
+	if h.Get(name) == "" {
+		h.Set(name, value)
+	}
+}
+
+// secureResponses is a middleware which adds the security headers to the
+// responses Lorica makes itself, if -securityheaders is set.
+func secureResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !*securityHeaders {
+			next.ServeHTTP(w, r)
+			return
+		}
+		r, origin := withResponseOrigin(r)
+		next.ServeHTTP(&securityRecorder{ResponseWriter: w, origin: origin}, r)
+	})
+}
+
+// newNonce returns a random nonce for the inline scripts and styles of a page.
+// It is URL-safe base64, so the templates don't escape it.
+func newNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// setPageSecurityPolicy sets the Content-Security-Policy of a page Lorica
+// serves, which only allows its own inline scripts and styles, with the nonce.
+// Pages which search through Lorica can be allowed to connect to other hosts.
+func setPageSecurityPolicy(w http.ResponseWriter, nonce string, connectAnywhere bool) {
+	if !*securityHeaders {
+		return
+	}
+	policy := ContentSecurityPolicy + "; script-src 'nonce-" + nonce + "'; style-src 'nonce-" + nonce + "'"
+	if connectAnywhere {
+		policy += "; connect-src *"
+	}
+	w.Header().Set("Content-Security-Policy", policy)
+}
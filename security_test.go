@@ -0,0 +1,103 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// Responses Lorica makes get the security headers, proxied responses don't.
+func TestSecureResponses(t *testing.T) {
+	lorica := secureResponses(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sendJSONError(w, http.StatusNotFound, "Not found.", nil)
+	}))
+	proxied := secureResponses(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		markProxied(r)
+		w.Write([]byte("{}"))
+	}))
+	ownPolicy := secureResponses(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy", "default-src 'self'")
+		w.(http.Flusher).Flush()
+	}))
+
+	w := httptest.NewRecorder()
+	lorica.ServeHTTP(w, httptest.NewRequest("GET", "/nothing", nil))
+	if w.Header().Get("X-Content-Type-Options") != "nosniff" || w.Header().Get("Referrer-Policy") != "no-referrer" ||
+		w.Header().Get("Content-Security-Policy") != ContentSecurityPolicy {
+		t.Errorf("A response from Lorica had headers %v", w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	proxied.ServeHTTP(w, httptest.NewRequest("GET", "/2.0.0/search", nil))
+	if w.Header().Get("Referrer-Policy") != "" || w.Header().Get("Content-Security-Policy") != "" {
+		t.Errorf("A proxied response had headers %v", w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	ownPolicy.ServeHTTP(w, httptest.NewRequest("GET", "/page", nil))
+	if w.Header().Get("Content-Security-Policy") != "default-src 'self'" || !w.Flushed {
+		t.Errorf("A page's own policy was replaced, or it wasn't flushed: %v", w.Header())
+	}
+
+	oldSecurityHeaders := *securityHeaders
+	*securityHeaders = false
+	defer func() { *securityHeaders = oldSecurityHeaders }()
+	w = httptest.NewRecorder()
+	lorica.ServeHTTP(w, httptest.NewRequest("GET", "/nothing", nil))
+	if w.Header().Get("Referrer-Policy") != "" {
+		t.Errorf("Security headers were added while they were turned off: %v", w.Header())
+	}
+}
+
+// A batch is Lorica's response, though the searches in it were proxied.
+func TestSecureResponsesBatch(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"recordCount":0}`))
+	}))
+	defer ts.Close()
+	oldAPIURL := *apiURL
+	*apiURL = ts.URL
+	defer func() { *apiURL = oldAPIURL }()
+
+	handler := secureResponses(withBatch(http.HandlerFunc(proxyHandler)))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/batch", strings.NewReader(`["s.q=forest"]`)))
+	if w.Header().Get("Content-Security-Policy") != ContentSecurityPolicy {
+		t.Errorf("A batch response had headers %v", w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/2.0.0/search?s.q=forest", nil))
+	if w.Header().Get("Content-Security-Policy") != "" {
+		t.Errorf("A proxied search had headers %v", w.Header())
+	}
+}
+
+// The pages only allow their own inline scripts and styles.
+func TestPageSecurityPolicy(t *testing.T) {
+	for _, c := range []struct {
+		handler http.HandlerFunc
+		connect bool
+	}{
+		{demoHandler, true},
+		{statusHandler, false},
+	} {
+		w := httptest.NewRecorder()
+		c.handler(w, httptest.NewRequest("GET", "/", nil))
+		policy := w.Header().Get("Content-Security-Policy")
+		nonce := regexp.MustCompile(`script-src 'nonce-([^']+)'`).FindStringSubmatch(policy)
+		if nonce == nil || !strings.Contains(w.Body.String(), `<style nonce="`+nonce[1]+`">`) {
+			t.Errorf("The page's policy %v doesn't match its nonces", policy)
+		}
+		if strings.Contains(policy, "connect-src *") != c.connect {
+			t.Errorf("The page's policy %v should allow connections anywhere: %v", policy, c.connect)
+		}
+	}
+}
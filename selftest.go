@@ -0,0 +1,93 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	l "github.com/cu-library/lorica/loglevel"
+	"net/http"
+	"net/url"
+	"sort"
+)
+
+// The example request from the Summon API's authentication documentation,
+// http://api.summon.serialssolutions.com/help/api/authentication, and its
+// Authorization header.
+const (
+	signingVectorURL           = "http://api.summon.serialssolutions.com/2.0.0/search?s.q=forest&s.ff=ContentType,or,1,15"
+	signingVectorAccept        = "application/xml"
+	signingVectorDate          = "Tue, 30 Jun 2009 12:10:24 GMT"
+	signingVectorAccessID      = "test"
+	signingVectorSecretKey     = "ed2ee2e0-65c1-11de-8a39-0800200c9a66"
+	signingVectorAuthorization = "Summon test;3a4+j0Wrrx6LF8X4iwOLDetVOu4="
+)
+
+var verifyCredentials = flag.Bool("verifycredentials", false, "At startup, send a small signed search to the "+
+	"Summon API with each set of credentials, and exit if Summon refuses any of them.")
+
+// selfTestSigning signs the documented example request, and returns an
+// error if the signature isn't the documented one.
+func selfTestSigning() error {
+	apiRequestURL, err := url.Parse(signingVectorURL)
+	if err != nil {
+		return err
+	}
+	creds := credentials{accessID: signingVectorAccessID, secretKey: signingVectorSecretKey}
+	header := buildHeaderWithCredentials(creds, apiRequestURL, signingVectorAccept, signingVectorDate)
+	if header != signingVectorAuthorization {
+		return fmt.Errorf("the documented example request was signed as %#v, not %#v", header, signingVectorAuthorization)
+	}
+	return nil
+}
+
+// allCredentials returns every set of credentials Lorica signs requests with:
+// the default credentials, the credential prefixes', and the extra credentials.
+func allCredentials() []credentials {
+	seen := make(map[credentials]bool)
+	var all []credentials
+	add := func(creds credentials) {
+		if creds.accessID != "" && !seen[creds] {
+			seen[creds] = true
+			all = append(all, creds)
+		}
+	}
+	add(defaultCredentials())
+	for _, p := range credentialPrefixes {
+		add(p.credentials)
+	}
+	profiles := make([]string, 0, len(credentialPools))
+	for profile := range credentialPools {
+		profiles = append(profiles, profile)
+	}
+	sort.Strings(profiles)
+	for _, profile := range profiles {
+		for _, creds := range credentialPools[profile].sets {
+			add(creds)
+		}
+	}
+	return all
+}
+
+// verifyAllCredentials sends a small signed search with each set of
+// credentials, and returns an error if Summon refuses any. If Summon can't be
+// reached, or fails for another reason, a warning is logged instead, so an
+// outage doesn't stop Lorica from starting.
+func verifyAllCredentials(client *http.Client, apiURLString string) error {
+	for _, creds := range allCredentials() {
+		found, err := checkCredentials(client, apiURLString, creds)
+		var refused *credentialsRefusedError
+		switch {
+		case errors.As(err, &refused):
+			return err
+		case err != nil:
+			l.Logf(l.WarnMessage, "Unable to verify access ID %v: %v.", creds.accessID, err)
+		default:
+			l.Logf(l.InfoMessage, "Verified credentials: %v.", found)
+		}
+	}
+	return nil
+}
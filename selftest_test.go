@@ -0,0 +1,46 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// The signing code signs the documented example correctly.
+func TestSelfTestSigning(t *testing.T) {
+	if err := selfTestSigning(); err != nil {
+		t.Error(err)
+	}
+}
+
+// Refused credentials stop Lorica from starting, and an unreachable Summon API doesn't.
+func TestVerifyAllCredentials(t *testing.T) {
+	s, stop := startSummonMock()
+	defer stop()
+	client := &http.Client{Timeout: time.Second}
+
+	oldCredentialPrefixes := credentialPrefixes
+	defer func() { credentialPrefixes = oldCredentialPrefixes }()
+	credentialPrefixes = []prefixCredentials{{prefix: "/same", credentials: credentials{s.AccessID, s.SecretKey}}}
+	if err := verifyAllCredentials(client, s.URL); err != nil {
+		t.Errorf("Accepted credentials gave %v", err)
+	}
+	if n := len(s.Requests()); n != 1 {
+		t.Errorf("The same credentials were checked %v times.", n)
+	}
+
+	credentialPrefixes = []prefixCredentials{{prefix: "/sandbox", credentials: credentials{"SANDBOX", "wrong"}}}
+	if err := verifyAllCredentials(client, s.URL); err == nil {
+		t.Error("Refused credentials were accepted.")
+	}
+
+	unreachable := s.URL
+	s.Close()
+	if err := verifyAllCredentials(client, unreachable); err != nil {
+		t.Errorf("An unreachable Summon API gave %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	// DefaultReadTimeout is the default time allowed to read a client's request.
+	DefaultReadTimeout = 30 * time.Second
+
+	// DefaultReadHeaderTimeout is the default time allowed to read a client's request headers.
+	DefaultReadHeaderTimeout = 10 * time.Second
+
+	// DefaultWriteTimeout is the default time allowed to write a response to a client.
+	DefaultWriteTimeout = 30 * time.Second
+
+	// DefaultIdleTimeout is the default time a client's keep-alive connection can be idle.
+	DefaultIdleTimeout = 120 * time.Second
+)
+
+var (
+	readTimeout = flag.Duration("readtimeout", DefaultReadTimeout, "The time allowed to read a client's "+
+		"entire request. 0 means no timeout.")
+	readHeaderTimeout = flag.Duration("readheadertimeout", DefaultReadHeaderTimeout, "The time allowed to "+
+		"read a client's request headers. 0 means no timeout.")
+	writeTimeout = flag.Duration("writetimeout", DefaultWriteTimeout, "The time allowed to write a "+
+		"response to a client. This should be longer than the Summon API timeout. 0 means no timeout.")
+	idleTimeout = flag.Duration("idletimeout", DefaultIdleTimeout, "The time a client's keep-alive "+
+		"connection can be idle. 0 means no timeout.")
+	maxHeaderBytes = flag.Int("maxheaderbytes", http.DefaultMaxHeaderBytes, "The maximum number of bytes "+
+		"allowed in a client's request headers.")
+	keepAlive = flag.Bool("keepalive", true, "Enable and disable HTTP keep-alives on client connections. "+
+		"Disabling them closes every connection after one response, which some older load balancers need.")
+	tcpKeepAlivePeriod = flag.Duration("tcpkeepaliveperiod", 0, "The time between TCP keep-alive probes "+
+		"on client connections. 0 uses the system default, and a negative number disables TCP keep-alive probes.")
+	h2cEnabled = flag.Bool("h2c", false, "Accept HTTP/2 cleartext (h2c) connections, as well as HTTP/1.1. "+
+		"Useful behind a gateway or service mesh which terminates TLS.")
+)
+
+// newServer returns a http.Server for the address and handler,
+// with the timeouts and limits from the command line flags.
+func newServer(addr string, handler http.Handler) *http.Server {
+	handler = identifyResponses(secureResponses(rejectAmbiguousRequests(handler)))
+	s := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadTimeout:       *readTimeout,
+		ReadHeaderTimeout: *readHeaderTimeout,
+		WriteTimeout:      *writeTimeout,
+		IdleTimeout:       *idleTimeout,
+		MaxHeaderBytes:    *maxHeaderBytes,
+		TLSConfig:         serverTLSConfig,
+	}
+	s.SetKeepAlivesEnabled(*keepAlive)
+
+	// The h2c handler upgrades HTTP/2 cleartext connections, and passes
+	// everything else through to the handler.
+	if *h2cEnabled {
+		s.Handler = h2c.NewHandler(handler, &http2.Server{IdleTimeout: s.IdleTimeout})
+	}
+	return s
+}
+
+// listenAndServe listens on the server's address, with the TCP keep-alive
+// period from the command line flags, and serves requests, over HTTPS if the
+// server has a TLS configuration. It always returns a non-nil error.
+func listenAndServe(s *http.Server) error {
+	lc := net.ListenConfig{KeepAlive: *tcpKeepAlivePeriod}
+	ln, err := lc.Listen(context.Background(), "tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	if s.TLSConfig != nil {
+		return s.ServeTLS(ln, "", "")
+	}
+	return s.Serve(ln)
+}
@@ -0,0 +1,104 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/tls"
+	"golang.org/x/net/http2"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// The server should use the timeouts and limits from the flags.
+func TestNewServer(t *testing.T) {
+	oldReadHeaderTimeout := *readHeaderTimeout
+	*readHeaderTimeout = 5 * time.Second
+	defer func() { *readHeaderTimeout = oldReadHeaderTimeout }()
+
+	oldMaxHeaderBytes := *maxHeaderBytes
+	*maxHeaderBytes = 4096
+	defer func() { *maxHeaderBytes = oldMaxHeaderBytes }()
+
+	s := newServer(":8080", http.NotFoundHandler())
+	if s.Addr != ":8080" {
+		t.Errorf("Server has address %v, expected :8080.", s.Addr)
+	}
+	if s.ReadHeaderTimeout != 5*time.Second {
+		t.Errorf("Server has read header timeout %v, expected 5s.", s.ReadHeaderTimeout)
+	}
+	if s.MaxHeaderBytes != 4096 {
+		t.Errorf("Server has max header bytes %v, expected 4096.", s.MaxHeaderBytes)
+	}
+	if s.ReadTimeout == 0 || s.WriteTimeout == 0 || s.IdleTimeout == 0 {
+		t.Error("Server should have timeouts by default.")
+	}
+}
+
+// Disabling keep-alives should close client connections after each response.
+func TestKeepAliveDisabled(t *testing.T) {
+	oldKeepAlive := *keepAlive
+	*keepAlive = false
+	defer func() { *keepAlive = oldKeepAlive }()
+
+	s := newServer("127.0.0.1:0", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts := httptest.NewUnstartedServer(s.Handler)
+	ts.Config = s
+	ts.Start()
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if !resp.Close {
+		t.Error("Server did not close the connection with keep-alives disabled.")
+	}
+}
+
+// listenAndServe should return listener errors.
+func TestListenAndServeBadAddress(t *testing.T) {
+	oldTCPKeepAlivePeriod := *tcpKeepAlivePeriod
+	*tcpKeepAlivePeriod = 30 * time.Second
+	defer func() { *tcpKeepAlivePeriod = oldTCPKeepAlivePeriod }()
+
+	s := newServer("127.0.0.1:-1", http.NotFoundHandler())
+	if err := listenAndServe(s); err == nil {
+		t.Error("Expected an error listening on a bad address.")
+	}
+}
+
+// With h2c enabled, the server should accept HTTP/2 cleartext connections.
+func TestH2C(t *testing.T) {
+	oldH2CEnabled := *h2cEnabled
+	*h2cEnabled = true
+	defer func() { *h2cEnabled = oldH2CEnabled }()
+
+	s := newServer("127.0.0.1:0", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}))
+	ts := httptest.NewUnstartedServer(s.Handler)
+	ts.Config = s
+	ts.Start()
+	defer ts.Close()
+
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}}
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Errorf("Expected an HTTP/2 response, got %v.", resp.Proto)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"net/http"
+)
+
+// SessionHashLength is the number of hex characters kept from a session ID's hash.
+const SessionHashLength = 16
+
+var (
+	sessionSalt = flag.String("sessionsalt", "", "A secret mixed into the hashes of x-summon-session-id values "+
+		"written to the logs and audit log. Use the same value on every instance so a session can be traced across them.")
+	issueSessions = flag.Bool("issuesessions", false, "If a request has no x-summon-session-id header, "+
+		"make a new session ID, send it to Summon, and return it to the client in the x-summon-session-id "+
+		"response header, so the client can send it with its next request.")
+)
+
+// newSessionID returns a random session ID, formatted like a version 4 UUID.
+func newSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// sessionHash returns a short hash of a Summon session ID, so a patron's
+// searches in one session can be correlated without recording the session ID.
+// An empty session ID has an empty hash.
+func sessionHash(sessionID string) string {
+	if sessionID == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, []byte(*sessionSalt))
+	mac.Write([]byte(sessionID))
+	return hex.EncodeToString(mac.Sum(nil))[:SessionHashLength]
+}
+
+// redactedHeader returns a copy of the header which is safe to log.
+// The session ID is replaced by its hash, and the Authorization, API key,
+// challenge token, and admin token headers are masked.
+func redactedHeader(h http.Header) http.Header {
+	redacted := cloneHeader(h)
+	if sessionID := redacted.Get("x-summon-session-id"); sessionID != "" {
+		redacted.Set("x-summon-session-id", "hash:"+sessionHash(sessionID))
+	}
+	for _, name := range []string{"Authorization", APIKeyHeader, ChallengeTokenHeader, AdminTokenHeader} {
+		if redacted.Get(name) != "" {
+			redacted.Set(name, MaskedValue)
+		}
+	}
+	return redacted
+}
+
+// sessionLogValue returns the session hash for a log message.
+func sessionLogValue(hash string) string {
+	if hash == "" {
+		return "none"
+	}
+	return hash
+}
@@ -0,0 +1,111 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// The hash is short, stable, and depends on the salt.
+func TestSessionHash(t *testing.T) {
+	if sessionHash("") != "" {
+		t.Error("An empty session ID should have an empty hash.")
+	}
+	hash := sessionHash("4f0a1c2e-session")
+	if len(hash) != SessionHashLength || hash != sessionHash("4f0a1c2e-session") {
+		t.Errorf("Session hash is wrong, got %v", hash)
+	}
+	if hash == sessionHash("another-session") {
+		t.Error("Different sessions have the same hash.")
+	}
+
+	oldSessionSalt := *sessionSalt
+	*sessionSalt = "pepper"
+	defer func() { *sessionSalt = oldSessionSalt }()
+	if hash == sessionHash("4f0a1c2e-session") {
+		t.Error("The salt didn't change the session hash.")
+	}
+}
+
+// The session ID and Authorization header don't appear in a redacted header.
+func TestRedactedHeader(t *testing.T) {
+	h := http.Header{}
+	h.Set("x-summon-session-id", "4f0a1c2e-session")
+	h.Set("Authorization", "Summon test;abc")
+	h.Set("Accept", "application/json")
+
+	redacted := redactedHeader(h)
+	if redacted.Get("x-summon-session-id") != "hash:"+sessionHash("4f0a1c2e-session") {
+		t.Errorf("Session ID not replaced, got %v", redacted.Get("x-summon-session-id"))
+	}
+	if redacted.Get("Authorization") != MaskedValue || redacted.Get("Accept") != "application/json" {
+		t.Errorf("Header redacted incorrectly, got %v", redacted)
+	}
+	if h.Get("x-summon-session-id") != "4f0a1c2e-session" {
+		t.Error("The original header was changed.")
+	}
+}
+
+// A new session ID looks like a UUID.
+func TestNewSessionID(t *testing.T) {
+	id, err := newSessionID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(id) {
+		t.Errorf("Session ID doesn't look like a UUID, got %v", id)
+	}
+}
+
+// Mock the Summon API, and test that a session ID is issued to a client without one.
+func TestProxyHanderIssueSession(t *testing.T) {
+	var upstreamSessionID string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamSessionID = r.Header.Get("x-summon-session-id")
+		fmt.Fprintln(w, "")
+	}))
+	defer ts.Close()
+
+	oldAPIURL := *apiURL
+	*apiURL = ts.URL
+	defer func() { *apiURL = oldAPIURL }()
+
+	oldIssueSessions := *issueSessions
+	*issueSessions = true
+	defer func() { *issueSessions = oldIssueSessions }()
+
+	oldAllowedOrigins := *allowedOrigins
+	*allowedOrigins = "*"
+	defer func() { *allowedOrigins = oldAllowedOrigins }()
+
+	req, err := http.NewRequest("GET", "/2.0.0/search?s.q=test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Origin", "http://test.com")
+	w := httptest.NewRecorder()
+	proxyHandler(w, req)
+
+	issued := w.Header().Get("x-summon-session-id")
+	if issued == "" || issued != upstreamSessionID {
+		t.Errorf("Issued session ID %#v wasn't sent to Summon, which got %#v", issued, upstreamSessionID)
+	}
+	if !strings.Contains(w.Header().Get("Access-Control-Expose-Headers"), "X-Summon-Session-Id") {
+		t.Errorf("Session ID header not exposed, got %v", w.Header().Get("Access-Control-Expose-Headers"))
+	}
+
+	// A client's own session ID is kept.
+	req.Header.Set("x-summon-session-id", "client-session")
+	w = httptest.NewRecorder()
+	proxyHandler(w, req)
+	if upstreamSessionID != "client-session" || w.Header().Get("x-summon-session-id") != "" {
+		t.Errorf("Client session ID not kept, Summon got %v", upstreamSessionID)
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/cu-library/lorica/metrics"
+	"net/http"
+	"strings"
+)
+
+// ErrorAmbiguousRequest is the error code sent when a request could be
+// read differently by Lorica and a proxy in front of it.
+const ErrorAmbiguousRequest = "ambiguous_request"
+
+// The reasons a request is ambiguous, used as metric labels.
+const (
+	AmbiguousChunked         = "chunked"
+	AmbiguousDuplicateHeader = "duplicate_header"
+	AmbiguousAbsoluteForm    = "absolute_form"
+)
+
+var (
+	strictRequests = flag.Bool("strictrequests", true, "Reject requests which a proxy in front of Lorica "+
+		"could read differently: requests with a chunked body, which is how a conflicting Content-Length is "+
+		"hidden, requests with conflicting copies of a header Lorica uses, and requests with an absolute URL "+
+		"as the target.")
+
+	// singleValueHeaders are the headers Lorica uses which must not be sent
+	// more than once. Copies with the same value are merged into one.
+	singleValueHeaders = []string{"Content-Length", "Content-Type", "Authorization", "Origin",
+		"X-Summon-Session-Id", "X-Request-Id", "Access-Control-Request-Method", "If-None-Match",
+		"If-Modified-Since"}
+
+	ambiguousRequestsTotal = metrics.NewCounterVec("lorica_ambiguous_requests_total",
+		"The number of requests rejected because they could be read differently by a proxy in front "+
+			"of Lorica, by reason.", "reason")
+)
+
+// checkRequestFraming returns the reason the request is ambiguous, and the
+// problem to tell the client, or empty strings if it isn't. Copies of a
+// single value header with the same value are merged.
+//
+// Go's server already rejects conflicting Content-Length headers and unknown
+// transfer codings, but it accepts a chunked body with a Content-Length,
+// dropping the Content-Length before the request gets here, so every chunked
+// body is rejected instead. Browsers never send them.
+func checkRequestFraming(r *http.Request) (reason, problem string) {
+	if len(r.TransferEncoding) > 0 {
+		return AmbiguousChunked, "Requests with a Transfer-Encoding aren't accepted, send a Content-Length instead."
+	}
+	for _, name := range singleValueHeaders {
+		values := r.Header[name]
+		if len(values) < 2 {
+			continue
+		}
+		for _, value := range values[1:] {
+			if strings.TrimSpace(value) != strings.TrimSpace(values[0]) {
+				return AmbiguousDuplicateHeader, fmt.Sprintf("The %v header was sent more than once, "+
+					"with different values.", name)
+			}
+		}
+		r.Header[name] = values[:1]
+	}
+	if r.RequestURI != "" && r.RequestURI != "*" && !strings.HasPrefix(r.RequestURI, "/") {
+		return AmbiguousAbsoluteForm, "The request target should be a path, not an absolute URL."
+	}
+	return "", ""
+}
+
+// rejectAmbiguousRequests is a middleware which rejects ambiguous requests
+// before they are handled, if -strictrequests is set. The connection is
+// closed afterwards, so nothing left unread on it is taken for another request.
+func rejectAmbiguousRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !*strictRequests {
+			next.ServeHTTP(w, r)
+			return
+		}
+		reason, problem := checkRequestFraming(r)
+		if reason == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ambiguousRequestsTotal.With(reason).Inc()
+		status := http.StatusBadRequest
+		if reason == AmbiguousChunked {
+			status = http.StatusLengthRequired
+		}
+		w.Header().Set("Connection", "close")
+		sendJSONErrorCode(w, status, ErrorAmbiguousRequest, problem, nil)
+	})
+}
@@ -0,0 +1,119 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// rawRequest sends a raw request to the server, and returns every response read back.
+func rawRequest(t *testing.T, ts *httptest.Server, request string) []*http.Response {
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(request)); err != nil {
+		t.Fatal(err)
+	}
+	var responses []*http.Response
+	reader := bufio.NewReader(conn)
+	for {
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			return responses
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		responses = append(responses, resp)
+		if resp.Close {
+			return responses
+		}
+	}
+}
+
+// Requests a proxy in front of Lorica could read differently are rejected,
+// and nothing smuggled in their bodies is handled.
+func TestRejectAmbiguousRequests(t *testing.T) {
+	var handled []string
+	s := newServer("127.0.0.1:0", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handled = append(handled, r.URL.Path)
+	}))
+	ts := httptest.NewUnstartedServer(s.Handler)
+	ts.Config = s
+	ts.Start()
+	defer ts.Close()
+
+	smuggled := "GET /smuggled HTTP/1.1\r\nHost: a\r\n\r\n"
+	cases := []struct {
+		name    string
+		request string
+		status  int
+	}{
+		{"conflicting framing", "POST /2.0.0/search HTTP/1.1\r\nHost: a\r\nContent-Length: 4\r\n" +
+			"Transfer-Encoding: chunked\r\n\r\n0\r\n\r\n" + smuggled, http.StatusLengthRequired},
+		{"chunked body", "POST /2.0.0/search HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n" +
+			"0\r\n\r\n" + smuggled, http.StatusLengthRequired},
+		{"conflicting Origin headers", "GET /2.0.0/search HTTP/1.1\r\nHost: a\r\nOrigin: https://a.ca\r\n" +
+			"Origin: https://b.ca\r\n\r\n", http.StatusBadRequest},
+		{"absolute-form target", "GET http://b/2.0.0/search HTTP/1.1\r\nHost: a\r\n\r\n", http.StatusBadRequest},
+		{"conflicting Content-Length headers", "POST /2.0.0/search HTTP/1.1\r\nHost: a\r\nContent-Length: 1\r\n" +
+			"Content-Length: 2\r\n\r\nab", http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		handled = nil
+		responses := rawRequest(t, ts, c.request)
+		if len(responses) != 1 || responses[0].StatusCode != c.status {
+			t.Errorf("%v: expected one %v response, got %v.", c.name, c.status, len(responses))
+		} else if !responses[0].Close {
+			t.Errorf("%v: the connection wasn't closed.", c.name)
+		}
+		if len(handled) != 0 {
+			t.Errorf("%v: the requests %v were handled.", c.name, handled)
+		}
+	}
+
+	handled = nil
+	responses := rawRequest(t, ts, "GET /2.0.0/search HTTP/1.1\r\nHost: a\r\nOrigin: https://a.ca\r\n"+
+		"Origin: https://a.ca\r\nConnection: close\r\n\r\n")
+	if len(responses) != 1 || responses[0].StatusCode != http.StatusOK || len(handled) != 1 {
+		t.Error("A request with the same Origin header twice wasn't handled.")
+	}
+}
+
+// Copies of a header with the same value are merged.
+func TestCheckRequestFraming(t *testing.T) {
+	r := httptest.NewRequest("GET", "/2.0.0/search", nil)
+	r.Header["Origin"] = []string{"https://a.ca", " https://a.ca"}
+	r.Header["Accept"] = []string{"application/json", "text/xml"}
+	if reason, problem := checkRequestFraming(r); reason != "" {
+		t.Fatalf("The request was rejected: %v", problem)
+	}
+	if len(r.Header["Origin"]) != 1 || len(r.Header["Accept"]) != 2 {
+		t.Errorf("The headers weren't merged as expected: %v", r.Header)
+	}
+
+	r = httptest.NewRequest("GET", "/2.0.0/search", nil)
+	r.Header["X-Summon-Session-Id"] = []string{"a", "b"}
+	if reason, problem := checkRequestFraming(r); reason != AmbiguousDuplicateHeader ||
+		!strings.Contains(problem, "X-Summon-Session-Id") {
+		t.Errorf("Conflicting session IDs gave %v, %v.", reason, problem)
+	}
+
+	oldStrictRequests := *strictRequests
+	*strictRequests = false
+	defer func() { *strictRequests = oldStrictRequests }()
+	w := httptest.NewRecorder()
+	rejectAmbiguousRequests(http.NotFoundHandler()).ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("A request was rejected with -strictrequests=false: %v", w.Code)
+	}
+}
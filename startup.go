@@ -0,0 +1,162 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	l "github.com/cu-library/lorica/loglevel"
+)
+
+// StartupLogPrefix starts the log message with the startup summary, so
+// deployment automation can find it.
+const StartupLogPrefix = "Startup: "
+
+// listener is an address Lorica serves on.
+type listener struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	Scheme  string `json:"scheme"`
+	H2C     bool   `json:"h2c,omitempty"`
+}
+
+// optionSummary is the effective value of an option, and where it was set.
+type optionSummary struct {
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// credentialSummary is an access ID requests are signed with. Secret keys
+// are never included.
+type credentialSummary struct {
+	AccessID string `json:"access_id"`
+	Prefix   string `json:"prefix,omitempty"`
+	Version  string `json:"version,omitempty"`
+	Pool     int    `json:"pool,omitempty"`
+	Strategy string `json:"strategy,omitempty"`
+}
+
+// tierSummary is a client tier's rate limit and quota.
+type tierSummary struct {
+	Name  string  `json:"name"`
+	Rate  float64 `json:"rate"`
+	Quota string  `json:"quota,omitempty"`
+}
+
+// containerSummary is the container limits the options were sized from.
+type containerSummary struct {
+	CPUs   float64 `json:"cpus"`
+	Memory int64   `json:"memory"`
+}
+
+// startupSummary is what Lorica is running with, logged once as JSON when it
+// starts serving. The options are as they were set, and the rest is what
+// Lorica parsed from them.
+type startupSummary struct {
+	Version           string                   `json:"version"`
+	SummonAPI         string                   `json:"summon_api"`
+	Listeners         []listener               `json:"listeners"`
+	Features          []string                 `json:"features"`
+	Credentials       []credentialSummary      `json:"credentials"`
+	AdminTokens       map[string]int           `json:"admin_tokens,omitempty"`
+	Tiers             []tierSummary            `json:"tiers,omitempty"`
+	Tenants           map[string][]string      `json:"tenants,omitempty"`
+	FacetPresets      []string                 `json:"facet_presets,omitempty"`
+	UpstreamOverrides []string                 `json:"upstream_overrides,omitempty"`
+	ForwardedHeaders  []string                 `json:"forwarded_headers,omitempty"`
+	ProxiedHeaders    []string                 `json:"proxied_headers"`
+	FileOrigins       int                      `json:"file_origins,omitempty"`
+	Container         *containerSummary        `json:"container,omitempty"`
+	Options           map[string]optionSummary `json:"options"`
+}
+
+// currentStartupSummary returns the effective configuration. Secret values are masked.
+func currentStartupSummary() startupSummary {
+	scheme := "http"
+	if serverTLSConfig != nil {
+		scheme = "https"
+	}
+	s := startupSummary{
+		Version:           version,
+		SummonAPI:         upstreams.URL(),
+		Listeners:         []listener{{Name: "proxy", Address: *address, Scheme: scheme, H2C: *h2cEnabled}},
+		Features:          []string{},
+		Credentials:       currentCredentialSummaries(),
+		FacetPresets:      facetPresetNames(),
+		UpstreamOverrides: upstreamOverrideNames(),
+		ForwardedHeaders:  forwardableHeaders(),
+		ProxiedHeaders:    proxiableHeaders(),
+		FileOrigins:       len(fileOrigins.Origins()),
+		Options:           make(map[string]optionSummary),
+	}
+	if *adminAddress != "" {
+		s.Listeners = append(s.Listeners, listener{Name: "admin", Address: *adminAddress, Scheme: scheme, H2C: *h2cEnabled})
+	}
+	for _, name := range knownFeatures {
+		if features.Enabled(name) {
+			s.Features = append(s.Features, name)
+		}
+	}
+	if adminAuth.Enabled() {
+		observers, operators := adminAuth.Len()
+		s.AdminTokens = map[string]int{RoleObserver: observers, RoleOperator: operators}
+	}
+	for _, t := range clientTiers {
+		summary := tierSummary{Name: t.name, Rate: t.rate}
+		if t.quota > 0 {
+			summary.Quota = fmt.Sprintf("%v/%v", t.quota, t.quotaPeriod)
+		}
+		s.Tiers = append(s.Tiers, summary)
+	}
+	if len(tenants) > 0 {
+		s.Tenants = make(map[string][]string)
+		for _, t := range tenants {
+			s.Tenants[t.name] = t.origins
+		}
+	}
+	if containerLimitsRead != (containerLimits{}) {
+		s.Container = &containerSummary{CPUs: containerLimitsRead.cpus, Memory: containerLimitsRead.memory}
+	}
+	flag.VisitAll(func(f *flag.Flag) {
+		s.Options[f.Name] = optionSummary{Value: configValue(f), Source: configSource(f.Name)}
+	})
+	return s
+}
+
+// currentCredentialSummaries returns the access IDs of the default
+// credentials and the credential prefixes, with their pools.
+func currentCredentialSummaries() []credentialSummary {
+	summaries := []credentialSummary{}
+	if creds := defaultCredentials(); creds.accessID != "" {
+		summary := credentialSummary{AccessID: creds.accessID}
+		if v, ok := credentialVersions.Active(); ok && v.accessID == creds.accessID {
+			summary.Version = v.name
+		}
+		summaries = append(summaries, summary)
+	}
+	for _, p := range credentialPrefixes {
+		summaries = append(summaries, credentialSummary{AccessID: p.accessID, Prefix: p.prefix})
+	}
+	for _, pool := range credentialPools {
+		for i := range summaries {
+			if summaries[i].AccessID == pool.sets[0].accessID {
+				summaries[i].Pool = len(pool.sets)
+				summaries[i].Strategy = pool.strategy
+			}
+		}
+	}
+	return summaries
+}
+
+// logStartupSummary logs the effective configuration as a single line of JSON.
+func logStartupSummary() {
+	line, err := json.Marshal(currentStartupSummary())
+	if err != nil {
+		l.Logf(l.ErrorMessage, "Unable to build the startup summary: %v", err)
+		return
+	}
+	l.Log(l.InfoMessage, StartupLogPrefix+string(line))
+}
@@ -0,0 +1,85 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	l "github.com/cu-library/lorica/loglevel"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The summary has the listeners and every option, with secrets masked.
+func TestCurrentStartupSummary(t *testing.T) {
+	oldAdminAddress, oldSecretKey := *adminAddress, *secretKey
+	*adminAddress, *secretKey = "localhost:9090", "hunter2"
+	defer func() { *adminAddress, *secretKey = oldAdminAddress, oldSecretKey }()
+
+	s := currentStartupSummary()
+	if len(s.Listeners) != 2 || s.Listeners[0].Name != "proxy" || s.Listeners[1].Address != "localhost:9090" {
+		t.Errorf("The listeners were %+v", s.Listeners)
+	}
+	if option := s.Options["secretkey"]; option.Value != MaskedValue {
+		t.Errorf("The secret key was summarized as %+v", option)
+	}
+	if option, ok := s.Options["timeout"]; !ok || option.Value != timeout.String() || option.Source == "" {
+		t.Errorf("The timeout was summarized as %+v", option)
+	}
+}
+
+// The summary has what Lorica parsed from the options, without secret keys.
+func TestCurrentStartupSummaryParsed(t *testing.T) {
+	oldPrefixes, oldTiers := credentialPrefixes, clientTiers
+	credentialPrefixes = []prefixCredentials{{"/2.0.0/search", credentials{"library", "hunter2"}}}
+	clientTiers = []*tier{{name: "partner", rate: 5, quota: 1000, quotaPeriod: 24 * time.Hour}}
+	defer func() { credentialPrefixes, clientTiers = oldPrefixes, oldTiers }()
+
+	s := currentStartupSummary()
+	found := false
+	for _, c := range s.Credentials {
+		if c.AccessID == "library" && c.Prefix == "/2.0.0/search" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("The credentials were summarized as %+v", s.Credentials)
+	}
+	if len(s.Tiers) != 1 || s.Tiers[0].Name != "partner" || s.Tiers[0].Quota != "1000/24h0m0s" {
+		t.Errorf("The tiers were summarized as %+v", s.Tiers)
+	}
+	line, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(line), "hunter2") {
+		t.Errorf("The summary has a secret key: %s", line)
+	}
+}
+
+// The summary is logged as one line of JSON.
+func TestLogStartupSummary(t *testing.T) {
+	records, unsubscribe := l.Subscribe(l.InfoMessage, 100)
+	defer unsubscribe()
+	logStartupSummary()
+	for {
+		select {
+		case record := <-records:
+			if !strings.HasPrefix(record.Message, StartupLogPrefix) {
+				continue
+			}
+			var s startupSummary
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(record.Message, StartupLogPrefix)), &s); err != nil {
+				t.Fatalf("The startup summary %v isn't JSON: %v", record.Message, err)
+			}
+			if s.Version != version || len(s.Options) == 0 {
+				t.Errorf("The startup summary was %+v", s)
+			}
+			return
+		default:
+			t.Fatal("The startup summary wasn't logged.")
+		}
+	}
+}
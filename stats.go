@@ -0,0 +1,168 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	l "github.com/cu-library/lorica/loglevel"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// StatsBucketWidth is the width of each bucket of response counts.
+	StatsBucketWidth = 10 * time.Second
+
+	// StatsRetention is how long response counts are kept for.
+	StatsRetention = time.Hour
+)
+
+var (
+	// responses keeps track of the status codes of recent responses to clients.
+	responses = newResponseStats()
+
+	// upstreamResponses keeps track of the status codes of recent responses from
+	// the Summon API. Requests which failed without a response count as 502s.
+	upstreamResponses = newResponseStats()
+)
+
+// statsBucket holds the response counts for one StatsBucketWidth interval.
+type statsBucket struct {
+	total    int
+	byStatus map[int]int
+}
+
+// responseStats counts responses by status code in fixed width buckets,
+// so the rates over a recent window can be calculated cheaply.
+type responseStats struct {
+	sync.Mutex
+	buckets map[int64]*statsBucket
+	now     func() time.Time
+}
+
+func newResponseStats() *responseStats {
+	return &responseStats{
+		buckets: make(map[int64]*statsBucket),
+		now:     time.Now,
+	}
+}
+
+// bucketKey returns the key of the bucket which covers t.
+func bucketKey(t time.Time) int64 {
+	return t.UnixNano() / int64(StatsBucketWidth)
+}
+
+// Record a response with the given status code.
+func (s *responseStats) Record(statusCode int) {
+	s.Lock()
+	defer s.Unlock()
+
+	now := s.now()
+	key := bucketKey(now)
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &statsBucket{byStatus: make(map[int]int)}
+		s.buckets[key] = b
+
+		// Remove any buckets which are too old to be useful.
+		oldest := bucketKey(now.Add(-StatsRetention))
+		for k := range s.buckets {
+			if k < oldest {
+				delete(s.buckets, k)
+			}
+		}
+	}
+	b.total++
+	b.byStatus[statusCode]++
+}
+
+// Count returns the number of responses which match the status class,
+// and the total number of responses, over the window.
+func (s *responseStats) Count(class string, window time.Duration) (matched, total int) {
+	s.Lock()
+	defer s.Unlock()
+
+	now := s.now()
+	oldest := bucketKey(now.Add(-window))
+	for k, b := range s.buckets {
+		if k <= oldest {
+			continue
+		}
+		total += b.total
+		for statusCode, count := range b.byStatus {
+			if statusMatchesClass(statusCode, class) {
+				matched += count
+			}
+		}
+	}
+	return matched, total
+}
+
+// statusMatchesClass returns true if the status code is part of the class.
+// A class is either an exact status code, like 429, or a class of status
+// codes, like 5xx.
+func statusMatchesClass(statusCode int, class string) bool {
+	if len(class) == 3 && class[1:] == "xx" {
+		return strconv.Itoa(statusCode/100) == class[:1]
+	}
+	return strconv.Itoa(statusCode) == class
+}
+
+// statusRecorder is a http.ResponseWriter which remembers the status code
+// written to it.
+type statusRecorder struct {
+	http.ResponseWriter
+	statusCode int
+}
+
+func (rec *statusRecorder) WriteHeader(statusCode int) {
+	if rec.statusCode == 0 {
+		rec.statusCode = statusCode
+	}
+	rec.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.statusCode == 0 {
+		rec.statusCode = http.StatusOK
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+// Status returns the status code sent to the client.
+func (rec *statusRecorder) Status() int {
+	if rec.statusCode == 0 {
+		return http.StatusOK
+	}
+	return rec.statusCode
+}
+
+// recordResponses is a middleware which counts the status codes
+// of every response, and updates the request metrics.
+func recordResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		r, info := withRequestInfo(r)
+		w.Header().Set("X-Request-ID", info.id)
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		responses.Record(rec.Status())
+
+		labels := []string{
+			statusClassLabel(rec.Status()),
+			endpointLabel(r.URL.Path),
+			info.cache,
+			originLabel(r.Header.Get("Origin")),
+		}
+		requestsTotal.With(labels...).Inc()
+		requestDuration.With(labels...).Observe(time.Since(start).Seconds())
+		usage.Record(tenantLabel(r.Header.Get("Origin")), rec.Status(), info.cache)
+		queries.Record(r, rec.Status())
+
+		l.Logf(l.DebugMessage, "Request %v: %v %v %v in %v, session %v",
+			info.id, r.Method, r.URL.Path, rec.Status(), time.Since(start), sessionLogValue(info.session))
+	})
+}
@@ -0,0 +1,74 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Responses should be counted by class, and only inside the window.
+func TestResponseStatsCount(t *testing.T) {
+	now := time.Date(2016, 1, 1, 12, 0, 0, 0, time.UTC)
+	s := newResponseStats()
+	s.now = func() time.Time { return now }
+
+	s.Record(http.StatusOK)
+	s.Record(http.StatusBadGateway)
+	now = now.Add(10 * time.Minute)
+	s.Record(http.StatusOK)
+	s.Record(http.StatusInternalServerError)
+	s.Record(http.StatusTooManyRequests)
+
+	matched, total := s.Count("5xx", 5*time.Minute)
+	if matched != 1 || total != 3 {
+		t.Errorf("Expected 1 of 3 5xx responses in the window, got %v of %v.", matched, total)
+	}
+	matched, total = s.Count("5xx", 30*time.Minute)
+	if matched != 2 || total != 5 {
+		t.Errorf("Expected 2 of 5 5xx responses in the window, got %v of %v.", matched, total)
+	}
+	matched, _ = s.Count("429", 5*time.Minute)
+	if matched != 1 {
+		t.Errorf("Expected 1 429 response in the window, got %v.", matched)
+	}
+}
+
+// Old buckets should be removed.
+func TestResponseStatsRetention(t *testing.T) {
+	now := time.Date(2016, 1, 1, 12, 0, 0, 0, time.UTC)
+	s := newResponseStats()
+	s.now = func() time.Time { return now }
+
+	s.Record(http.StatusOK)
+	now = now.Add(2 * StatsRetention)
+	s.Record(http.StatusOK)
+
+	if len(s.buckets) != 1 {
+		t.Errorf("Expected old buckets to be removed, have %v buckets.", len(s.buckets))
+	}
+}
+
+// The recordResponses middleware should record the status code sent.
+func TestRecordResponses(t *testing.T) {
+	oldResponses := responses
+	responses = newResponseStats()
+	defer func() { responses = oldResponses }()
+
+	handler := recordResponses(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	req, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if matched, _ := responses.Count("503", time.Minute); matched != 1 {
+		t.Error("recordResponses did not record the status code.")
+	}
+}
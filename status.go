@@ -0,0 +1,128 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"time"
+)
+
+const (
+	// StatusWindow is how far back the status page looks at Summon API responses.
+	StatusWindow = 5 * time.Minute
+
+	// StatusDegradedPercent is the percentage of failed Summon API responses at which search is degraded.
+	StatusDegradedPercent = 5
+
+	// StatusDownPercent is the percentage of failed Summon API responses at which search is down.
+	StatusDownPercent = 50
+
+	// StatusMinimumResponses is the fewest Summon API responses in the window
+	// which can change the state, so one failed search doesn't mark search down.
+	StatusMinimumResponses = 10
+)
+
+// The possible states of the search service.
+const (
+	StatusOperational = "operational"
+	StatusDegraded    = "degraded"
+	StatusDown        = "down"
+)
+
+// searchStatus summarizes recent Summon API responses.
+type searchStatus struct {
+	State   string
+	Failed  int
+	Total   int
+	Window  time.Duration
+	Checked time.Time
+}
+
+// Description returns a sentence describing the state, for patrons.
+func (s searchStatus) Description() string {
+	switch s.State {
+	case StatusDown:
+		return "Search is currently unavailable. We are working to restore it."
+	case StatusDegraded:
+		return "Search is experiencing problems. Some searches may fail or be slow."
+	}
+	return "Search is operating normally."
+}
+
+// currentSearchStatus calculates the state of the search service from the
+// recent responses from the Summon API. Server errors, and authentication
+// errors which mean Lorica's credentials are being refused, are failures.
+func currentSearchStatus(stats *responseStats) searchStatus {
+	serverErrors, total := stats.Count("5xx", StatusWindow)
+	authErrors, _ := stats.Count("401", StatusWindow)
+	status := searchStatus{
+		State:   StatusOperational,
+		Failed:  serverErrors + authErrors,
+		Total:   total,
+		Window:  StatusWindow,
+		Checked: time.Now().UTC(),
+	}
+	if total < StatusMinimumResponses {
+		return status
+	}
+	percent := 100 * float64(status.Failed) / float64(total)
+	switch {
+	case percent >= StatusDownPercent:
+		status.State = StatusDown
+	case percent >= StatusDegradedPercent:
+		status.State = StatusDegraded
+	}
+	return status
+}
+
+var statusTemplate = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="60">
+<title>Search Status</title>
+<style nonce="{{.Nonce}}">
+body { font-family: sans-serif; max-width: 40em; margin: 2em auto; padding: 0 1em; }
+.operational { color: #060; }
+.degraded { color: #a60; }
+.down { color: #a00; }
+</style>
+</head>
+<body>
+<h1>Search Status</h1>
+<p class="{{.State}}"><strong>{{.Description}}</strong></p>
+<p><small>Based on the last {{.Window}} of searches. Checked {{.Checked.Format "2006-01-02 15:04:05 MST"}}.</small></p>
+</body>
+</html>
+`))
+
+// statusHandler serves a page showing whether search is operating normally.
+// When search is down, the status code is 503, for the benefit of monitoring tools.
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	status := currentSearchStatus(upstreamResponses)
+	nonce, err := newNonce()
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "Unable to build status page.")
+		return
+	}
+	b := new(bytes.Buffer)
+	err = statusTemplate.Execute(b, struct {
+		searchStatus
+		Nonce string
+	}{status, nonce})
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "Unable to build status page.")
+		return
+	}
+	setPageSecurityPolicy(w, nonce, false)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=30")
+	if status.State == StatusDown {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write(b.Bytes())
+}
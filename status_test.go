@@ -0,0 +1,75 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCurrentSearchStatus(t *testing.T) {
+	tests := []struct {
+		ok       int
+		failed   int
+		expected string
+	}{
+		{0, 0, StatusOperational},
+		{100, 0, StatusOperational},
+		{96, 4, StatusOperational},
+		{90, 10, StatusDegraded},
+		{50, 50, StatusDown},
+		{0, 1, StatusOperational},
+		{0, StatusMinimumResponses - 1, StatusOperational},
+		{0, StatusMinimumResponses, StatusDown},
+	}
+	for _, test := range tests {
+		stats := newResponseStats()
+		for i := 0; i < test.ok; i++ {
+			stats.Record(http.StatusOK)
+		}
+		for i := 0; i < test.failed; i++ {
+			stats.Record(http.StatusBadGateway)
+		}
+		if state := currentSearchStatus(stats).State; state != test.expected {
+			t.Errorf("%v ok and %v failed responses was %v, expected %v.", test.ok, test.failed, state, test.expected)
+		}
+	}
+
+	// Authentication errors mean Lorica's credentials are being refused.
+	stats := newResponseStats()
+	for i := 0; i < StatusMinimumResponses; i++ {
+		stats.Record(http.StatusUnauthorized)
+	}
+	if state := currentSearchStatus(stats).State; state != StatusDown {
+		t.Errorf("Authentication errors gave state %v, expected %v.", state, StatusDown)
+	}
+}
+
+func TestStatusHandler(t *testing.T) {
+	oldUpstreamResponses := upstreamResponses
+	upstreamResponses = newResponseStats()
+	defer func() { upstreamResponses = oldUpstreamResponses }()
+
+	req, err := http.NewRequest("GET", "/status", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	statusHandler(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Search is operating normally.") {
+		t.Errorf("Expected search to be operating normally, got %v: %v", w.Code, w.Body.String())
+	}
+
+	for i := 0; i < StatusMinimumResponses; i++ {
+		upstreamResponses.Record(http.StatusGatewayTimeout)
+	}
+	w = httptest.NewRecorder()
+	statusHandler(w, req)
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "Search is currently unavailable.") {
+		t.Errorf("Expected search to be down, got %v: %v", w.Code, w.Body.String())
+	}
+}
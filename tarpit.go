@@ -0,0 +1,53 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"github.com/cu-library/lorica/metrics"
+	"net/http"
+	"time"
+)
+
+// DefaultTarpitMaxConcurrent is the default number of requests which can be held in the tarpit at once.
+const DefaultTarpitMaxConcurrent = 100
+
+var (
+	tarpitDelay = flag.Duration("tarpitdelay", 0, "Hold rejected responses to rate limited and blocked clients "+
+		"for this long before sending them, to slow down scrapers which retry immediately. 0 disables the tarpit.")
+	tarpitMaxConcurrent = flag.Int("tarpitmaxconcurrent", DefaultTarpitMaxConcurrent, "The most requests held "+
+		"in the tarpit at once. When it is full, rejected responses are sent immediately.")
+
+	// tarpitSlots bounds the number of requests held in the tarpit.
+	// It is made when the tarpit is first used, so the flag can be set first.
+	tarpitSlots chan struct{}
+
+	tarpitTotal = metrics.NewCounterVec("lorica_tarpit_requests_total",
+		"The number of rejected responses held in the tarpit, and the number sent immediately because it was full.",
+		"result")
+)
+
+// tarpit holds a rejected request for the tarpit delay, unless the tarpit
+// is full or the client goes away first.
+func tarpit(r *http.Request) {
+	if *tarpitDelay <= 0 || tarpitSlots == nil {
+		return
+	}
+	select {
+	case tarpitSlots <- struct{}{}:
+	default:
+		tarpitTotal.With("full").Inc()
+		return
+	}
+	defer func() { <-tarpitSlots }()
+	tarpitTotal.With("held").Inc()
+
+	timer := time.NewTimer(*tarpitDelay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-r.Context().Done():
+	}
+}
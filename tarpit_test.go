@@ -0,0 +1,39 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// A rejected request is held for the delay, unless the tarpit is full.
+func TestTarpit(t *testing.T) {
+	oldTarpitDelay := *tarpitDelay
+	*tarpitDelay = 50 * time.Millisecond
+	defer func() { *tarpitDelay = oldTarpitDelay }()
+
+	oldTarpitSlots := tarpitSlots
+	tarpitSlots = make(chan struct{}, 1)
+	defer func() { tarpitSlots = oldTarpitSlots }()
+
+	req, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	tarpit(req)
+	if time.Since(start) < *tarpitDelay {
+		t.Error("Request wasn't held in the tarpit.")
+	}
+
+	tarpitSlots <- struct{}{}
+	start = time.Now()
+	tarpit(req)
+	if time.Since(start) >= *tarpitDelay {
+		t.Error("Request was held in a full tarpit.")
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	l "github.com/cu-library/lorica/loglevel"
+	"github.com/cu-library/lorica/metrics"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultUpstreamBackoff is the default time requests are held back after Summon rate limits Lorica.
+	DefaultUpstreamBackoff = 5 * time.Second
+
+	// MaxUpstreamBackoff is the longest requests are held back after Summon rate limits Lorica.
+	MaxUpstreamBackoff = 5 * time.Minute
+)
+
+var (
+	upstreamBackoff = flag.Duration("upstreambackoff", DefaultUpstreamBackoff, "When the Summon API rate "+
+		"limits Lorica without a Retry-After header, the time requests are rejected before they are sent again. "+
+		"It doubles each time Summon rate limits Lorica in a row. If 0, Lorica only backs off when Summon sends Retry-After.")
+
+	// throttles hold back requests to the Summon API after it rate limits
+	// Lorica, apart for each access ID and upstream, so one rate limited key
+	// doesn't hold back the others.
+	throttles = newUpstreamThrottles()
+
+	upstreamRateLimitedTotal = metrics.NewCounterVec("lorica_upstream_rate_limited_total",
+		"The number of requests rate limited by the Summon API, or rejected by Lorica while backing off.", "source")
+)
+
+// upstreamThrottles holds the throttle of each access ID and upstream.
+type upstreamThrottles struct {
+	sync.Mutex
+	throttles map[string]*upstreamThrottle
+	now       func() time.Time
+}
+
+func newUpstreamThrottles() *upstreamThrottles {
+	return &upstreamThrottles{throttles: make(map[string]*upstreamThrottle), now: time.Now}
+}
+
+// For returns the throttle of requests sent with the access ID to the upstream,
+// which is the host of the Summon API URL.
+func (ts *upstreamThrottles) For(accessID, upstream string) *upstreamThrottle {
+	ts.Lock()
+	defer ts.Unlock()
+	key := accessID + "@" + upstream
+	t, ok := ts.throttles[key]
+	if !ok {
+		t = &upstreamThrottle{now: ts.now}
+		ts.throttles[key] = t
+	}
+	return t
+}
+
+// Waiting returns how long requests are held back for each access ID and
+// upstream which is being backed off from, keyed by ACCESSID@UPSTREAM.
+func (ts *upstreamThrottles) Waiting() map[string]time.Duration {
+	ts.Lock()
+	defer ts.Unlock()
+	waiting := make(map[string]time.Duration)
+	for key, t := range ts.throttles {
+		if wait := t.Wait(); wait > 0 {
+			waiting[key] = wait
+		}
+	}
+	return waiting
+}
+
+// upstreamThrottle backs off from the Summon API when it rate limits Lorica.
+type upstreamThrottle struct {
+	sync.Mutex
+	until       time.Time
+	consecutive uint
+	now         func() time.Time
+}
+
+// Wait returns how long until requests can be sent to the Summon API again, or 0.
+func (t *upstreamThrottle) Wait() time.Duration {
+	t.Lock()
+	defer t.Unlock()
+	if wait := t.until.Sub(t.now()); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// RateLimited records that Summon rate limited Lorica, and returns how long to back off.
+// The Retry-After value from Summon is used if it has one.
+func (t *upstreamThrottle) RateLimited(retryAfter string, base time.Duration) time.Duration {
+	t.Lock()
+	defer t.Unlock()
+	backoff := base << t.consecutive
+	if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds >= 0 {
+		backoff = time.Duration(seconds) * time.Second
+	} else if when, err := http.ParseTime(retryAfter); err == nil {
+		backoff = when.Sub(t.now())
+	}
+	if backoff > MaxUpstreamBackoff || backoff < 0 {
+		backoff = MaxUpstreamBackoff
+	}
+	if t.consecutive < 16 {
+		t.consecutive++
+	}
+	t.until = t.now().Add(backoff)
+	return backoff
+}
+
+// Succeeded records a response from Summon which wasn't rate limited.
+func (t *upstreamThrottle) Succeeded() {
+	t.Lock()
+	defer t.Unlock()
+	t.consecutive = 0
+}
+
+// sendThrottled rejects a request while backing off from the Summon API.
+func sendThrottled(w http.ResponseWriter, wait time.Duration) {
+	upstreamRateLimitedTotal.With("lorica").Inc()
+	setRetryAfter(w, wait)
+	code, message, hints := mapUpstreamError(http.StatusTooManyRequests, "")
+	sendJSONErrorCode(w, http.StatusTooManyRequests, code, message, hints)
+}
+
+// recordUpstreamRateLimit feeds a response from the Summon API to the
+// request's throttle. Only responses to requests which were sent are recorded,
+// not cached or shared ones, so one response isn't counted more than once.
+func recordUpstreamRateLimit(throttle *upstreamThrottle, apiResp *http.Response) {
+	if apiResp.StatusCode != http.StatusTooManyRequests {
+		throttle.Succeeded()
+		return
+	}
+	upstreamRateLimitedTotal.With("summon").Inc()
+	backoff := *upstreamBackoff
+	if backoff > 0 || apiResp.Header.Get("Retry-After") != "" {
+		backoff = throttle.RateLimited(apiResp.Header.Get("Retry-After"), backoff)
+		l.Logf(l.WarnMessage, "The Summon API is rate limiting Lorica, backing off for %v.", backoff)
+	}
+}
+
+// setRetryAfter tells the client when to send the request again, rounding up to
+// whole seconds. Browsers only let CORS requests read it if it is exposed.
+func setRetryAfter(w http.ResponseWriter, d time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int((d+time.Second-1)/time.Second)))
+	if w.Header().Get("Access-Control-Allow-Origin") != "" {
+		w.Header().Add("Access-Control-Expose-Headers", "Retry-After")
+	}
+}
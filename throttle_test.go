@@ -0,0 +1,119 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The backoff doubles each time in a row, uses Summon's Retry-After, and resets after a success.
+func TestUpstreamThrottle(t *testing.T) {
+	now := time.Date(2016, 1, 1, 12, 0, 0, 0, time.UTC)
+	th := &upstreamThrottle{now: func() time.Time { return now }}
+
+	if backoff := th.RateLimited("", time.Second); backoff != time.Second {
+		t.Errorf("Expected a backoff of 1s, got %v", backoff)
+	}
+	if backoff := th.RateLimited("", time.Second); backoff != 2*time.Second || th.Wait() != 2*time.Second {
+		t.Errorf("Expected a backoff of 2s, got %v", backoff)
+	}
+	if backoff := th.RateLimited("30", time.Second); backoff != 30*time.Second {
+		t.Errorf("Expected Summon's Retry-After of 30s, got %v", backoff)
+	}
+	if backoff := th.RateLimited("3600", time.Second); backoff != MaxUpstreamBackoff {
+		t.Errorf("Expected the backoff to be capped, got %v", backoff)
+	}
+	th.Succeeded()
+	now = now.Add(MaxUpstreamBackoff)
+	if th.Wait() != 0 {
+		t.Errorf("Still waiting after the backoff, got %v", th.Wait())
+	}
+	if backoff := th.RateLimited("", time.Second); backoff != time.Second {
+		t.Errorf("Backoff not reset after a success, got %v", backoff)
+	}
+}
+
+// Each access ID and upstream backs off on its own.
+func TestUpstreamThrottles(t *testing.T) {
+	ts := newUpstreamThrottles()
+	ts.For("A", "api.summon.example").RateLimited("60", time.Second)
+	if ts.For("A", "api.summon.example").Wait() == 0 {
+		t.Error("The rate limited access ID isn't backing off.")
+	}
+	if ts.For("B", "api.summon.example").Wait() != 0 || ts.For("A", "eu.summon.example").Wait() != 0 {
+		t.Error("Another access ID or upstream is backing off.")
+	}
+	if waiting := ts.Waiting(); len(waiting) != 1 || waiting["A@api.summon.example"] == 0 {
+		t.Errorf("Expected only A@api.summon.example to be waiting, got %v", waiting)
+	}
+}
+
+// After Summon rate limits Lorica, requests are rejected without being sent until the backoff is over.
+func TestProxyHandlerUpstreamRateLimited(t *testing.T) {
+	oldThrottles := throttles
+	throttles = newUpstreamThrottles()
+	defer func() { throttles = oldThrottles }()
+
+	sent := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent++
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer ts.Close()
+	oldAPIURL := *apiURL
+	*apiURL = ts.URL
+	defer func() { *apiURL = oldAPIURL }()
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		proxyHandler(w, httptest.NewRequest("GET", "/2.0.0/search?s.q=test", nil))
+		if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+			t.Errorf("Expected a 429 with Retry-After, got %v %v", w.Code, w.Header())
+		}
+	}
+	if sent != 1 {
+		t.Errorf("Expected 1 request sent to Summon while backing off, got %v", sent)
+	}
+	if upstreamRateLimitedTotal.With("lorica").Value() < 1 || upstreamRateLimitedTotal.With("summon").Value() < 1 {
+		t.Error("Rate limited requests not counted.")
+	}
+}
+
+// Successful responses end a streak of rate limited responses.
+func TestProxyHandlerUpstreamRateLimitReset(t *testing.T) {
+	oldThrottles := throttles
+	throttles = newUpstreamThrottles()
+	defer func() { throttles = oldThrottles }()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"recordCount":0,"documents":[]}`))
+	}))
+	defer ts.Close()
+	oldAPIURL := *apiURL
+	*apiURL = ts.URL
+	defer func() { *apiURL = oldAPIURL }()
+	throttle := throttles.For(defaultCredentials().accessID, strings.TrimPrefix(ts.URL, "http://"))
+	throttle.Lock()
+	throttle.consecutive = 3
+	throttle.Unlock()
+
+	w := httptest.NewRecorder()
+	proxyHandler(w, httptest.NewRequest("GET", "/2.0.0/search?s.q=test", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("The search got %v", w.Code)
+	}
+	throttle.Lock()
+	consecutive := throttle.consecutive
+	throttle.Unlock()
+	if consecutive != 0 {
+		t.Errorf("After a success, %v rate limited responses were still counted in a row.", consecutive)
+	}
+}
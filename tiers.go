@@ -0,0 +1,403 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/cu-library/lorica/metrics"
+	"github.com/didip/tollbooth/libstring"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// TierAnonymous is the tier of clients which don't match any other tier.
+	TierAnonymous = "anonymous"
+
+	// APIKeyHeader is the request header which carries a client's API key.
+	APIKeyHeader = "X-Lorica-Key"
+)
+
+// The error codes sent when a client's tier refuses a request.
+const (
+	ErrorClientUnidentified = "client_unidentified"
+	ErrorEndpointNotAllowed = "endpoint_not_allowed"
+	ErrorQuotaExceeded      = "quota_exceeded"
+)
+
+var (
+	tierList = flag.String("tiers", "", "A list of client tiers, delimited by the ; character. Each tier is a name "+
+		"followed by settings, like: staff ips=10.0.0.0/8 rate=10 quota=10000/24h endpoints=search,availability. "+
+		"Clients are matched by keys= (API keys in the "+APIKeyHeader+" header), claims= (claim:value pairs "+
+		"in a JWT bearer token), or ips= (IP addresses and ranges). Clients which don't match a tier are in the "+
+		"anonymous tier, which uses -maxrequests unless it is listed. A rate of 0 means no rate limit.")
+	jwtSecret = flag.String("jwtsecret", "", "The secret used to check the HS256 signature of JWT bearer tokens "+
+		"used to match client tiers. Tokens without an exp claim are refused.")
+
+	// tierNamePattern matches the allowed tier names.
+	tierNamePattern = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+	tierRequestsTotal = metrics.NewCounterVec("lorica_tier_requests_total",
+		"The number of requests from each client tier.", "tier")
+)
+
+// tier is a class of clients with its own limits.
+type tier struct {
+	name        string
+	rate        float64
+	quota       int
+	quotaPeriod time.Duration
+	endpoints   map[string]bool
+	keys        *keySet
+	claims      map[string]string
+	networks    []*net.IPNet
+
+	handler http.Handler
+	usage   *quotaUsage
+}
+
+// parseTiers parses the list of tiers. Tiers without a rate use the default rate.
+// An anonymous tier is added if it isn't listed.
+func parseTiers(list string, defaultRate float64) ([]*tier, error) {
+	var tiers []*tier
+	seen := make(map[string]bool)
+	for _, entry := range splitList(list) {
+		t, err := parseTier(entry)
+		if err != nil {
+			return nil, err
+		}
+		if seen[t.name] {
+			return nil, fmt.Errorf("tier %v is listed more than once", t.name)
+		}
+		seen[t.name] = true
+		if t.rate < 0 {
+			t.rate = defaultRate
+		}
+		tiers = append(tiers, t)
+	}
+	if !seen[TierAnonymous] {
+		tiers = append(tiers, &tier{name: TierAnonymous, rate: defaultRate})
+	}
+	return tiers, nil
+}
+
+// parseTier parses a tier like: staff ips=10.0.0.0/8 rate=10.
+func parseTier(entry string) (*tier, error) {
+	fields := strings.Fields(entry)
+	t := &tier{
+		name:   strings.ToLower(fields[0]),
+		rate:   -1,
+		claims: make(map[string]string),
+		keys:   newKeySet(),
+	}
+	if !tierNamePattern.MatchString(t.name) {
+		return nil, fmt.Errorf("tier name %v should only have lowercase letters, numbers, and dashes", t.name)
+	}
+	for _, field := range fields[1:] {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("tier %v setting %v should look like name=value", t.name, field)
+		}
+		values := strings.Split(parts[1], ",")
+		var err error
+		switch parts[0] {
+		case "rate":
+			t.rate, err = strconv.ParseFloat(parts[1], 64)
+			if err == nil && t.rate < 0 {
+				err = errors.New("it can't be negative")
+			}
+		case "quota":
+			err = t.parseQuota(parts[1])
+		case "endpoints":
+			t.endpoints = make(map[string]bool)
+			for _, endpoint := range values {
+				t.endpoints[endpoint] = true
+			}
+		case "keys":
+			for _, key := range values {
+				t.keys.Add(key)
+			}
+		case "claims":
+			for _, claim := range values {
+				kv := strings.SplitN(claim, ":", 2)
+				if len(kv) != 2 {
+					err = fmt.Errorf("claim %v should look like name:value", claim)
+					break
+				}
+				t.claims[kv[0]] = kv[1]
+			}
+		case "ips":
+			for _, ip := range values {
+				network, parseErr := parseNetwork(ip)
+				if parseErr != nil {
+					err = parseErr
+					break
+				}
+				t.networks = append(t.networks, network)
+			}
+		default:
+			err = errors.New("it isn't a tier setting")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("tier %v has a bad setting %v: %v", t.name, field, err)
+		}
+	}
+	if t.name == TierAnonymous && (t.keys.Len() > 0 || len(t.claims) > 0 || len(t.networks) > 0) {
+		return nil, fmt.Errorf("the anonymous tier matches every other client, so it can't have keys, claims, or ips")
+	}
+	return t, nil
+}
+
+// parseQuota parses a quota like 1000/24h.
+func (t *tier) parseQuota(quota string) error {
+	parts := strings.SplitN(quota, "/", 2)
+	if len(parts) != 2 {
+		return errors.New("it should look like 1000/24h")
+	}
+	var err error
+	t.quota, err = strconv.Atoi(parts[0])
+	if err != nil || t.quota <= 0 {
+		return errors.New("the number of requests should be a positive number")
+	}
+	t.quotaPeriod, err = time.ParseDuration(parts[1])
+	if err != nil || t.quotaPeriod <= 0 {
+		return errors.New("the period should be a positive duration, like 24h")
+	}
+	t.usage = newQuotaUsage()
+	return nil
+}
+
+// matches returns true if the client belongs in the tier.
+func (t *tier) matches(key string, claims map[string]interface{}, ip net.IP) bool {
+	if key != "" && t.keys.Contains(key) {
+		return true
+	}
+	for name, want := range t.claims {
+		if claimHasValue(claims[name], want) {
+			return true
+		}
+	}
+	for _, network := range t.networks {
+		if ip != nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// claimHasValue returns true if the claim is the value, or a list containing it.
+func claimHasValue(claim interface{}, want string) bool {
+	switch v := claim.(type) {
+	case string:
+		return v == want
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok && s == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// quotaUsage counts each client's requests in fixed periods.
+type quotaUsage struct {
+	sync.Mutex
+	counts map[string]quotaCount
+	now    func() time.Time
+}
+
+// quotaCount is the number of requests a client has made in the current period.
+type quotaCount struct {
+	count int
+	reset time.Time
+}
+
+func newQuotaUsage() *quotaUsage {
+	return &quotaUsage{counts: make(map[string]quotaCount), now: time.Now}
+}
+
+// Len returns the number of clients whose requests are being counted.
+func (q *quotaUsage) Len() int {
+	q.Lock()
+	defer q.Unlock()
+	return len(q.counts)
+}
+
+// Use counts a request, and returns false if the client has used up its quota,
+// along with the time the quota resets.
+func (q *quotaUsage) Use(client string, quota int, period time.Duration) (bool, time.Time) {
+	q.Lock()
+	defer q.Unlock()
+	now := q.now()
+	c, ok := q.counts[client]
+	if !ok || !now.Before(c.reset) {
+		// Forget the clients whose periods are over, so the map doesn't grow forever.
+		for k, old := range q.counts {
+			if !now.Before(old.reset) {
+				delete(q.counts, k)
+			}
+		}
+		c = quotaCount{reset: now.Add(period)}
+	}
+	if c.count >= quota {
+		return false, c.reset
+	}
+	c.count++
+	q.counts[client] = c
+	return true, c.reset
+}
+
+// parseJWT checks the signature and expiry of a HS256 JWT, and returns its claims.
+func parseJWT(token string, secret []byte, now time.Time) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("token should have three parts")
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, err
+	}
+	if header.Alg != "HS256" {
+		return nil, fmt.Errorf("token algorithm %v isn't HS256", header.Alg)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, errors.New("token signature is wrong")
+	}
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(claimsJSON, &claims); err != nil {
+		return nil, err
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, errors.New("token has no exp claim")
+	}
+	if !now.Before(time.Unix(int64(exp), 0)) {
+		return nil, errors.New("token has expired")
+	}
+	return claims, nil
+}
+
+// tierHandler sends each request to the handler for its client's tier.
+type tierHandler struct {
+	tiers []*tier
+}
+
+// newTierHandler builds the handler for each tier. Each tier gets its own rate limiter,
+// which can be changed by the schedule.
+func newTierHandler(tiers []*tier, next http.Handler) *tierHandler {
+	for _, t := range tiers {
+		t.handler = next
+		if t.rate > 0 {
+			t.handler = limitHandler(t.name, t.rate, next)
+		}
+		t.handler = newScheduledHandler(t.name, t.handler, next)
+	}
+	return &tierHandler{tiers: tiers}
+}
+
+// clientTier returns the client's tier, and the identity its quota is counted against.
+func (th *tierHandler) clientTier(r *http.Request) (*tier, string, error) {
+	ip := libstring.RemoteIP(clientIPLookups(), 0, r)
+	key := r.Header.Get(APIKeyHeader)
+	attempt := startAuthAttempt()
+
+	var claims map[string]interface{}
+	if bearer := r.Header.Get("Authorization"); strings.HasPrefix(bearer, "Bearer ") {
+		if *jwtSecret == "" {
+			attempt.Failed()
+			return nil, "", errors.New("bearer tokens aren't accepted")
+		}
+		var err error
+		claims, err = parseJWT(strings.TrimPrefix(bearer, "Bearer "), []byte(*jwtSecret), time.Now())
+		if err != nil {
+			attempt.Failed()
+			return nil, "", err
+		}
+	}
+
+	for _, t := range th.tiers {
+		if t.name != TierAnonymous && t.matches(key, claims, net.ParseIP(ip)) {
+			switch {
+			case key != "" && t.keys.Contains(key):
+				return t, "key:" + key, nil
+			case claims["sub"] != nil:
+				return t, fmt.Sprintf("sub:%v", claims["sub"]), nil
+			}
+			return t, "ip:" + ip, nil
+		}
+	}
+	if key != "" {
+		attempt.Failed()
+		return nil, "", errors.New("the API key isn't known")
+	}
+	for _, t := range th.tiers {
+		if t.name == TierAnonymous {
+			return t, "ip:" + ip, nil
+		}
+	}
+	return nil, "", errors.New("there is no anonymous tier")
+}
+
+func (th *tierHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t, client, err := th.clientTier(r)
+	if err != nil {
+		audit.Record(r, AuditAuthFailed, err.Error())
+		sendJSONErrorCode(w, http.StatusUnauthorized, ErrorClientUnidentified, "Unable to identify the client: "+err.Error()+".", nil)
+		return
+	}
+	getRequestInfo(r).tier = t.name
+	tierRequestsTotal.With(t.name).Inc()
+
+	// Only some endpoints might be allowed. OPTIONS requests are always allowed, for CORS.
+	if t.endpoints != nil && r.Method != "OPTIONS" {
+		if !t.endpoints[endpointLabel(r.URL.Path)] && enforcePolicy(r, PolicyEndpoints, t.name+" tier") {
+			audit.Record(r, AuditEndpointNotAllowed, t.name)
+			sendJSONErrorCode(w, http.StatusForbidden, ErrorEndpointNotAllowed,
+				fmt.Sprintf("The %v tier can't use this endpoint.", t.name), nil)
+			return
+		}
+	}
+
+	if quota, period, usage := scheduledQuota(t); quota > 0 {
+		ok, reset := usage.Use(client, quota, period)
+		if !ok && enforcePolicy(r, PolicyQuotas, t.name+" tier") {
+			audit.Record(r, AuditQuotaExceeded, t.name)
+			w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(reset).Seconds())+1))
+			sendJSONErrorCode(w, http.StatusTooManyRequests, ErrorQuotaExceeded,
+				fmt.Sprintf("The quota of %v requests per %v has been used.", quota, period), nil)
+			return
+		}
+	}
+
+	t.handler.ServeHTTP(w, r)
+}
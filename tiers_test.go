@@ -0,0 +1,165 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// signJWT makes a HS256 JWT with the claims, which should be JSON.
+func signJWT(secret, claims string) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(claims))
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Parse a list of tiers, and add the anonymous tier.
+func TestParseTiers(t *testing.T) {
+	tiers, err := parseTiers("staff ips=10.0.0.0/8,192.0.2.1 rate=10 quota=100/24h endpoints=search; "+
+		"trusted-service keys=abc,def rate=0; authenticated claims=role:patron", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tiers) != 4 || tiers[3].name != TierAnonymous || tiers[3].rate != 2 {
+		t.Fatalf("Tiers parsed incorrectly, got %v tiers", len(tiers))
+	}
+	staff := tiers[0]
+	if staff.rate != 10 || staff.quota != 100 || staff.quotaPeriod != 24*time.Hour ||
+		!staff.endpoints["search"] || len(staff.networks) != 2 {
+		t.Errorf("Staff tier parsed incorrectly, got %+v", staff)
+	}
+	if tiers[1].rate != 0 || !tiers[1].keys.Contains("def") {
+		t.Errorf("Trusted service tier parsed incorrectly, got %+v", tiers[1])
+	}
+	if tiers[2].rate != 2 || tiers[2].claims["role"] != "patron" {
+		t.Errorf("Authenticated tier parsed incorrectly, got %+v", tiers[2])
+	}
+}
+
+// Bad tiers are errors.
+func TestParseTiersErrors(t *testing.T) {
+	for _, list := range []string{
+		"Staff!", "staff rate=fast", "staff rate=-1", "staff quota=100", "staff quota=0/1h", "staff ips=10.0.0.0/99",
+		"staff claims=role", "staff colour=blue", "staff;staff", "anonymous ips=10.0.0.0/8",
+	} {
+		if _, err := parseTiers(list, 1); err == nil {
+			t.Errorf("No error parsing tiers %#v", list)
+		}
+	}
+}
+
+// A JWT is only accepted with the right signature, and an expiry which hasn't passed.
+func TestParseJWT(t *testing.T) {
+	now := time.Unix(1560000000, 0)
+	claims, err := parseJWT(signJWT("secret", `{"sub":"alice","role":["patron","staff"],"exp":1560000100}`), []byte("secret"), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims["sub"] != "alice" || !claimHasValue(claims["role"], "staff") {
+		t.Errorf("JWT claims parsed incorrectly, got %v", claims)
+	}
+	if _, err := parseJWT(signJWT("wrong", `{"sub":"alice"}`), []byte("secret"), now); err == nil {
+		t.Error("A JWT with the wrong signature was accepted.")
+	}
+	if _, err := parseJWT(signJWT("secret", `{"sub":"alice","exp":1559999999}`), []byte("secret"), now); err == nil {
+		t.Error("An expired JWT was accepted.")
+	}
+	if _, err := parseJWT(signJWT("secret", `{"sub":"alice"}`), []byte("secret"), now); err == nil {
+		t.Error("A JWT without an expiry was accepted.")
+	}
+}
+
+// Quotas reset after the period.
+func TestQuotaUsage(t *testing.T) {
+	q := newQuotaUsage()
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	q.now = func() time.Time { return now }
+	for i := 0; i < 2; i++ {
+		if ok, _ := q.Use("client", 2, time.Hour); !ok {
+			t.Errorf("Request %v was over the quota.", i)
+		}
+	}
+	if ok, _ := q.Use("client", 2, time.Hour); ok {
+		t.Error("Request over the quota was allowed.")
+	}
+	now = now.Add(time.Hour)
+	if ok, _ := q.Use("client", 2, time.Hour); !ok {
+		t.Error("Quota didn't reset.")
+	}
+}
+
+// Requests are matched to tiers, which enforce their endpoints and quotas.
+func TestTierHandler(t *testing.T) {
+	oldJWTSecret := *jwtSecret
+	*jwtSecret = "secret"
+	defer func() { *jwtSecret = oldJWTSecret }()
+
+	tiers, err := parseTiers("staff claims=role:staff rate=0; trusted keys=abc rate=0 quota=1/1h; "+
+		"anonymous rate=0 endpoints=search", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	staffClaims := fmt.Sprintf(`{"role":"staff","exp":%v}`, time.Now().Add(time.Hour).Unix())
+	var tierSeen string
+	handler := newTierHandler(tiers, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tierSeen = getRequestInfo(r).tier
+	}))
+
+	for _, test := range []struct {
+		path   string
+		header string
+		value  string
+		status int
+		tier   string
+	}{
+		{"/2.0.0/search", "", "", http.StatusOK, TierAnonymous},
+		{"/2.0.0/availability", "", "", http.StatusForbidden, ""},
+		{"/2.0.0/availability", "Authorization", "Bearer " + signJWT("secret", staffClaims), http.StatusOK, "staff"},
+		{"/2.0.0/search", "Authorization", "Bearer " + signJWT("wrong", staffClaims), http.StatusUnauthorized, ""},
+		{"/2.0.0/search", "Authorization", "Bearer " + signJWT("secret", `{"role":"staff"}`), http.StatusUnauthorized, ""},
+		{"/2.0.0/search", APIKeyHeader, "abc", http.StatusOK, "trusted"},
+		{"/2.0.0/search", APIKeyHeader, "abc", http.StatusTooManyRequests, ""},
+		{"/2.0.0/search", APIKeyHeader, "unknown", http.StatusUnauthorized, ""},
+	} {
+		tierSeen = ""
+		req, err := http.NewRequest("GET", test.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.RemoteAddr = "192.0.2.1:1234"
+		if test.header != "" {
+			req.Header.Set(test.header, test.value)
+		}
+		req, _ = withRequestInfo(req)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != test.status || tierSeen != test.tier {
+			t.Errorf("%v with %v: expected %v from tier %#v, got %v from tier %#v",
+				test.path, test.header, test.status, test.tier, w.Code, tierSeen)
+		}
+	}
+}
+
+// With client tiers, CORS requests can send an API key or a bearer token.
+func TestTierCORSRequestHeaders(t *testing.T) {
+	if corsRequestHeadersAllowed("x-lorica-key, authorization") {
+		t.Error("The API key and bearer token headers were allowed without tiers.")
+	}
+	oldTiers := clientTiers
+	clientTiers = []*tier{{name: "trusted"}}
+	defer func() { clientTiers = oldTiers }()
+	if !corsRequestHeadersAllowed("x-summon-session-id, X-Lorica-Key, Authorization") {
+		t.Errorf("The API key and bearer token headers weren't allowed, the allowed headers are %v", allowedCORSRequestHeaders())
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	l "github.com/cu-library/lorica/loglevel"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+var (
+	tlsCert = flag.String("tlscert", "", "A PEM file with the TLS certificate, and any intermediate certificates, "+
+		"to serve HTTPS with. -tlskey must be set too. The files are read again when Lorica receives a SIGHUP, "+
+		"so a renewed certificate can be used without a restart.")
+	tlsKey        = flag.String("tlskey", "", "A PEM file with the TLS certificate's private key.")
+	tlsMinVersion = flag.String("tlsminversion", "1.2", "The oldest TLS version accepted, 1.2 or 1.3.")
+
+	// serverTLSConfig is the TLS configuration of the servers. It is nil if
+	// -tlscert isn't set, and they serve plain HTTP.
+	serverTLSConfig *tls.Config
+
+	// tlsVersions are the versions -tlsminversion accepts.
+	tlsVersions = map[string]uint16{
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}
+)
+
+// certificateStore holds a TLS certificate, which can be reloaded from its files.
+type certificateStore struct {
+	sync.RWMutex
+	certFile string
+	keyFile  string
+	cert     *tls.Certificate
+}
+
+// newCertificateStore loads the certificate and key from the files.
+func newCertificateStore(certFile, keyFile string) (*certificateStore, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("both -tlscert and -tlskey must be set")
+	}
+	c := &certificateStore{certFile: certFile, keyFile: keyFile}
+	if err := c.Load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Load reads the certificate and key from their files. If they can't be
+// read, the certificate already loaded is kept.
+func (c *certificateStore) Load() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("unable to load the TLS certificate from %v and %v: %v", c.certFile, c.keyFile, err)
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.cert = &cert
+	return nil
+}
+
+// GetCertificate returns the certificate, for tls.Config.
+func (c *certificateStore) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.RLock()
+	defer c.RUnlock()
+	return c.cert, nil
+}
+
+// newTLSConfig returns the TLS configuration of the servers. Only TLS 1.2
+// cipher suites with forward secrecy and authenticated encryption are
+// allowed, and TLS 1.3 chooses its own.
+func newTLSConfig(c *certificateStore, minVersion string) (*tls.Config, error) {
+	version, ok := tlsVersions[minVersion]
+	if !ok {
+		return nil, fmt.Errorf("unknown TLS version %#v, it should be 1.2 or 1.3", minVersion)
+	}
+	return &tls.Config{
+		GetCertificate: c.GetCertificate,
+		MinVersion:     version,
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+		},
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+	}, nil
+}
+
+// reloadCertificatesOnHangup reloads the TLS certificate every time the process receives a SIGHUP.
+func reloadCertificatesOnHangup(c *certificateStore) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	for range hangups {
+		if err := c.Load(); err != nil {
+			l.Logf(l.ErrorMessage, "Keeping the current TLS certificate: %v", err)
+			continue
+		}
+		l.Log(l.InfoMessage, "Reloaded the TLS certificate from "+c.certFile)
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCertificate writes a self-signed certificate for 127.0.0.1 and its
+// key to the directory, and returns the certificate.
+func writeTestCertificate(t *testing.T, dir, name string) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := ioutil.WriteFile(filepath.Join(dir, "cert.pem"), certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "key.pem"), keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// The server serves HTTPS with modern TLS, and picks up a reloaded certificate.
+func TestServeTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lorica-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	first := writeTestCertificate(t, dir, "first")
+	certificates, err := newCertificateStore(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldServerTLSConfig := serverTLSConfig
+	serverTLSConfig, err = newTLSConfig(certificates, "1.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { serverTLSConfig = oldServerTLSConfig }()
+	s := newServer("127.0.0.1:0", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.ServeTLS(ln, "", "")
+	defer s.Close()
+
+	get := func(config *tls.Config) (*http.Response, error) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+		resp, err := client.Get("https://" + ln.Addr().String() + "/")
+		if err == nil {
+			resp.Body.Close()
+		}
+		return resp, err
+	}
+
+	resp, err := get(&tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.TLS.PeerCertificates[0].Subject.CommonName != first.Subject.CommonName {
+		t.Errorf("The server sent the certificate for %v", resp.TLS.PeerCertificates[0].Subject.CommonName)
+	}
+	if _, err := get(&tls.Config{InsecureSkipVerify: true, MaxVersion: tls.VersionTLS11}); err == nil {
+		t.Error("A TLS 1.1 connection was accepted.")
+	}
+
+	writeTestCertificate(t, dir, "second")
+	if err := certificates.Load(); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = get(&tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.TLS.PeerCertificates[0].Subject.CommonName != "second" {
+		t.Error("The reloaded certificate wasn't used.")
+	}
+}
+
+// Missing or mismatched files and unknown versions are refused.
+func TestTLSConfigErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lorica-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if _, err := newCertificateStore(filepath.Join(dir, "cert.pem"), ""); err == nil {
+		t.Error("A certificate without a key was accepted.")
+	}
+	if _, err := newCertificateStore(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")); err == nil {
+		t.Error("Missing files were accepted.")
+	}
+	writeTestCertificate(t, dir, "test")
+	certificates, err := newCertificateStore(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newTLSConfig(certificates, "1.0"); err == nil {
+		t.Error("TLS 1.0 was accepted as the minimum version.")
+	}
+
+	// A broken certificate file keeps the one already loaded.
+	if err := ioutil.WriteFile(filepath.Join(dir, "cert.pem"), []byte("broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := certificates.Load(); err == nil {
+		t.Error("A broken certificate was loaded.")
+	}
+	if cert, _ := certificates.GetCertificate(nil); cert == nil {
+		t.Error("The loaded certificate was dropped.")
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	l "github.com/cu-library/lorica/loglevel"
+)
+
+// responseTransforms are applied in order to successful JSON responses from
+// the Summon API while the transform feature is enabled.
+var responseTransforms []responseTransform
+
+// responseTransform changes a decoded JSON response from the Summon API.
+type responseTransform interface {
+	// Transform changes the response in place, and returns true if it
+	// changed anything.
+	Transform(response map[string]interface{}) bool
+}
+
+// transformResponse applies the response transforms to the body. If the body
+// can't be decoded, or no transform changed it, it is returned unchanged.
+func transformResponse(body []byte) []byte {
+	if len(responseTransforms) == 0 || !features.Enabled(FeatureTransform) {
+		return body
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var response map[string]interface{}
+	if err := decoder.Decode(&response); err != nil {
+		l.Logf(l.DebugMessage, "Not transforming response which isn't a JSON object: %v", err)
+		return body
+	}
+	changed := false
+	for _, t := range responseTransforms {
+		if t.Transform(response) {
+			changed = true
+		}
+	}
+	if !changed {
+		return body
+	}
+	var transformed bytes.Buffer
+	encoder := json.NewEncoder(&transformed)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(response); err != nil {
+		l.Logf(l.ErrorMessage, "Unable to encode transformed response: %v", err)
+		return body
+	}
+	return transformed.Bytes()
+}
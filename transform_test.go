@@ -0,0 +1,45 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// Responses are only transformed while the transform feature is enabled,
+// and bodies which aren't JSON objects are left alone.
+func TestTransformResponse(t *testing.T) {
+	oldTransforms := responseTransforms
+	responseTransforms = []responseTransform{duplicateCollapser{}}
+	defer func() {
+		responseTransforms = oldTransforms
+		features.Set("")
+	}()
+	body := []byte(`{"recordCount": 12345678901234567890, "documents": [{"DOI": ["x"]}, {"DOI": ["x"], "link": "a&b"}]}`)
+
+	if string(transformResponse(body)) != string(body) {
+		t.Error("Response transformed while the feature was disabled.")
+	}
+
+	features.Set(FeatureTransform)
+	transformed := string(transformResponse(body))
+	if !strings.Contains(transformed, DuplicateCountField) || !strings.Contains(transformed, "a&b") {
+		t.Errorf("Response not transformed, got %v", transformed)
+	}
+	if !strings.Contains(transformed, "12345678901234567890") {
+		t.Errorf("Number not preserved, got %v", transformed)
+	}
+	unchanged := []byte(`{"recordCount": 2,  "documents": [{"DOI": ["x"]}, {"DOI": ["y"], "link": "a&b"}]}`)
+	if string(transformResponse(unchanged)) != string(unchanged) {
+		t.Error("Response re-encoded when no transform changed it.")
+	}
+	if transformed := string(transformResponse([]byte(`{"recordCount": 5, "documents": [{"DOI": ["x"]}, {"DOI": ["x"]}]}`))); !strings.Contains(transformed, `"recordCount":4`) {
+		t.Errorf("recordCount not lowered, got %v", transformed)
+	}
+	if string(transformResponse([]byte("not json"))) != "not json" {
+		t.Error("Body which isn't JSON was changed.")
+	}
+}
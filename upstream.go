@@ -0,0 +1,231 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	l "github.com/cu-library/lorica/loglevel"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRollbackWindow is the default time after a switch during which errors cause a rollback.
+	DefaultRollbackWindow = 5 * time.Minute
+
+	// DefaultRollbackErrorPercent is the default percentage of 5xx responses which causes a rollback.
+	DefaultRollbackErrorPercent = 10
+
+	// RollbackMinRequests is the number of responses needed before a rollback is considered.
+	RollbackMinRequests = 20
+
+	// RollbackCheckInterval is how often the upstream-rollback job checks the error rate after a switch.
+	RollbackCheckInterval = 10 * time.Second
+)
+
+var (
+	rollbackWindow = flag.Duration("rollbackwindow", DefaultRollbackWindow, "After the Summon API URL or "+
+		"credentials are switched with the admin API, the time during which a spike in errors switches them back.")
+	rollbackErrorPercent = flag.Float64("rollbackerrorpercent", DefaultRollbackErrorPercent, "The percentage of "+
+		"5xx responses from the Summon API, after a switch, which switches back to the previous URL and credentials.")
+
+	// upstreams holds the Summon API URL and credentials switched to with the admin API.
+	upstreams = &upstreamSwitch{now: time.Now}
+)
+
+// upstreamTarget is a Summon API URL and the profile whose credentials are used with it.
+// An empty profile means -accessid and -secretkey.
+type upstreamTarget struct {
+	URL     string `json:"url"`
+	Profile string `json:"profile,omitempty"`
+	creds   *credentials
+}
+
+// upstreamSwitch allows the Summon API URL and default credentials to be switched
+// while serving requests, and switches back if errors spike after a switch.
+type upstreamSwitch struct {
+	sync.Mutex
+	active     *upstreamTarget
+	previous   *upstreamTarget
+	stats      *responseStats
+	switched   time.Time
+	generation int
+	now        func() time.Time
+}
+
+// URL returns the active Summon API URL. Unless it has been switched, it is
+// -summonapi, or the fastest of the -regionalapis.
+func (u *upstreamSwitch) URL() string {
+	u.Lock()
+	defer u.Unlock()
+	if u.active == nil {
+		return regions.URL()
+	}
+	return u.active.URL
+}
+
+// Credentials returns the active default credentials, or nil if they haven't been switched.
+func (u *upstreamSwitch) Credentials() *credentials {
+	u.Lock()
+	defer u.Unlock()
+	if u.active == nil {
+		return nil
+	}
+	return u.active.creds
+}
+
+// current returns the active target. The caller must hold the lock.
+func (u *upstreamSwitch) current() *upstreamTarget {
+	if u.active == nil {
+		return &upstreamTarget{URL: regions.URL()}
+	}
+	return u.active
+}
+
+// Switch makes the target active.
+func (u *upstreamSwitch) Switch(target *upstreamTarget) {
+	u.Lock()
+	defer u.Unlock()
+	u.previous = u.current()
+	u.active = target
+	u.stats = newResponseStats()
+	u.stats.now = u.now
+	u.switched = u.now()
+	u.generation++
+}
+
+// Rollback makes the previous target active again.
+func (u *upstreamSwitch) Rollback() error {
+	u.Lock()
+	defer u.Unlock()
+	if u.previous == nil {
+		return errors.New("there is nothing to switch back to")
+	}
+	u.active, u.previous = u.previous, nil
+	u.stats = nil
+	u.generation++
+	return nil
+}
+
+// Record a response from the Summon API since the last switch.
+func (u *upstreamSwitch) Record(statusCode int) {
+	u.Lock()
+	stats := u.stats
+	u.Unlock()
+	if stats != nil {
+		stats.Record(statusCode)
+	}
+}
+
+// checkRollback switches back if the error rate since the last switch is too
+// high, until the window after the switch is over. It is run by the
+// upstream-rollback job, and returns true if it switched back.
+func (u *upstreamSwitch) checkRollback(window time.Duration) bool {
+	u.Lock()
+	if u.stats == nil {
+		u.Unlock()
+		return false
+	}
+	since := u.now().Sub(u.switched)
+	if since > window {
+		// The switch is settled, so its responses don't need to be counted.
+		u.stats = nil
+		u.Unlock()
+		return false
+	}
+	stats, generation := u.stats, u.generation
+	u.Unlock()
+
+	failed, total := stats.Count("5xx", since+StatsBucketWidth)
+	if total < RollbackMinRequests || 100*float64(failed)/float64(total) <= *rollbackErrorPercent {
+		return false
+	}
+
+	u.Lock()
+	defer u.Unlock()
+	if u.generation != generation || u.previous == nil {
+		return false
+	}
+	l.Logf(l.WarnMessage, "%v of %v responses from %v were errors, switching back to %v.",
+		failed, total, u.active.URL, u.previous.URL)
+	u.active, u.previous = u.previous, nil
+	u.stats = nil
+	u.generation++
+	return true
+}
+
+// upstreamTargetFor checks the URL and profile, and finds the profile's credentials.
+func upstreamTargetFor(apiURLString, profile string) (*upstreamTarget, error) {
+	parsed, err := url.Parse(apiURLString)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("%v isn't a http or https URL", apiURLString)
+	}
+	target := &upstreamTarget{URL: apiURLString, Profile: profile}
+	if profile != "" {
+		for _, p := range credentialPrefixes {
+			if p.prefix == profile {
+				creds := p.credentials
+				target.creds = &creds
+			}
+		}
+		if target.creds == nil {
+			return nil, fmt.Errorf("the profile %v isn't one of the credential prefixes", profile)
+		}
+	}
+	return target, nil
+}
+
+// upstreamHandler shows the active Summon API URL and profile, and switches them on a POST.
+func upstreamHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+	case "POST":
+		apiURLString := r.FormValue("url")
+		if apiURLString == "" {
+			apiURLString = upstreams.URL()
+		}
+		target, err := upstreamTargetFor(apiURLString, r.FormValue("profile"))
+		if err != nil {
+			sendJSONError(w, http.StatusBadRequest, "Unable to switch: "+err.Error()+".", nil)
+			return
+		}
+		upstreams.Switch(target)
+		l.Logf(l.InfoMessage, "Switched to Summon API %v with profile %#v.", target.URL, target.Profile)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		sendJSONError(w, http.StatusMethodNotAllowed, "Only GET and POST requests accepted.", nil)
+		return
+	}
+
+	upstreams.Lock()
+	status := struct {
+		Active   *upstreamTarget `json:"active"`
+		Previous *upstreamTarget `json:"previous,omitempty"`
+	}{upstreams.current(), upstreams.previous}
+	upstreams.Unlock()
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(status)
+}
+
+// upstreamRollbackHandler switches back to the previous Summon API URL and profile.
+func upstreamRollbackHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		sendJSONError(w, http.StatusMethodNotAllowed, "Only POST requests accepted.", nil)
+		return
+	}
+	if err := upstreams.Rollback(); err != nil {
+		sendJSONError(w, http.StatusConflict, "Unable to switch back: "+err.Error()+".", nil)
+		return
+	}
+	l.Log(l.InfoMessage, "Switched back to Summon API "+upstreams.URL())
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "Switched back to %v.\n", upstreams.URL())
+}
@@ -0,0 +1,110 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Switching changes the URL and default credentials, and switching back restores them.
+func TestUpstreamSwitchAndRollback(t *testing.T) {
+	u := &upstreamSwitch{now: time.Now}
+	if u.URL() != *apiURL || u.Credentials() != nil {
+		t.Errorf("Unswitched upstream is not the flags, got %v", u.URL())
+	}
+	u.Switch(&upstreamTarget{URL: "http://green.example.com", creds: &credentials{accessID: "green"}})
+	if u.URL() != "http://green.example.com" || u.Credentials().accessID != "green" {
+		t.Errorf("Switch did not change the upstream, got %v", u.URL())
+	}
+	if err := u.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if u.URL() != *apiURL || u.Credentials() != nil {
+		t.Errorf("Rollback did not restore the upstream, got %v", u.URL())
+	}
+	if err := u.Rollback(); err == nil {
+		t.Error("No error rolling back twice.")
+	}
+}
+
+// A spike in errors after a switch switches back, but a few errors don't.
+func TestUpstreamCheckRollback(t *testing.T) {
+	now := time.Date(2016, 1, 1, 12, 0, 0, 0, time.UTC)
+	u := &upstreamSwitch{now: func() time.Time { return now }}
+	u.Switch(&upstreamTarget{URL: "http://green.example.com"})
+
+	for i := 0; i < RollbackMinRequests; i++ {
+		u.Record(http.StatusOK)
+	}
+	u.Record(http.StatusBadGateway)
+	now = now.Add(RollbackCheckInterval)
+	if u.checkRollback(time.Minute) {
+		t.Error("Rolled back after a few errors.")
+	}
+
+	for i := 0; i < RollbackMinRequests; i++ {
+		u.Record(http.StatusBadGateway)
+	}
+	now = now.Add(RollbackCheckInterval)
+	if !u.checkRollback(time.Minute) || u.URL() != *apiURL {
+		t.Errorf("Did not roll back after a spike in errors, got %v", u.URL())
+	}
+	if u.checkRollback(time.Minute) {
+		t.Error("Rolled back twice.")
+	}
+
+	// Once the window after a switch is over, errors don't switch back.
+	u.Switch(&upstreamTarget{URL: "http://green.example.com"})
+	now = now.Add(2 * time.Minute)
+	for i := 0; i < RollbackMinRequests; i++ {
+		u.Record(http.StatusBadGateway)
+	}
+	if u.checkRollback(time.Minute) || u.URL() != "http://green.example.com" {
+		t.Errorf("Rolled back after the window, got %v", u.URL())
+	}
+}
+
+// The admin endpoint checks the URL and profile before switching.
+func TestUpstreamHandler(t *testing.T) {
+	oldUpstreams := upstreams
+	upstreams = &upstreamSwitch{now: time.Now}
+	oldPrefixes := credentialPrefixes
+	credentialPrefixes = []prefixCredentials{{"/sandbox", credentials{"sandboxid", "sandboxkey"}}}
+	oldWindow := *rollbackWindow
+	*rollbackWindow = time.Millisecond
+	defer func() {
+		upstreams = oldUpstreams
+		credentialPrefixes = oldPrefixes
+		*rollbackWindow = oldWindow
+	}()
+
+	mux := newAdminMux()
+	for _, query := range []string{"url=notaurl", "url=ftp://example.com", "profile=/unknown"} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("POST", "/admin/upstream?"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Bad switch %v not rejected, got %v", query, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("POST", "/admin/upstream?url=https://green.example.com&profile=/sandbox", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "green.example.com") {
+		t.Errorf("Switch failed, got %v: %v", w.Code, w.Body.String())
+	}
+	if defaultCredentials().accessID != "sandboxid" {
+		t.Errorf("Default credentials not switched, got %v", defaultCredentials().accessID)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("POST", "/admin/upstream/rollback", nil))
+	if w.Code != http.StatusOK || upstreams.URL() != *apiURL {
+		t.Errorf("Rollback failed, got %v: %v", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"net/http"
+	"time"
+)
+
+const (
+	// DefaultUpstreamMaxIdleConns is the default number of idle connections kept to the Summon API.
+	DefaultUpstreamMaxIdleConns = 100
+
+	// DefaultUpstreamMaxIdleConnsPerHost is the default number of idle connections kept to each Summon API host.
+	DefaultUpstreamMaxIdleConnsPerHost = 64
+
+	// DefaultUpstreamIdleConnTimeout is the default time an idle connection to the Summon API is kept.
+	DefaultUpstreamIdleConnTimeout = 90 * time.Second
+)
+
+var (
+	upstreamMaxIdleConns = flag.Int("upstreammaxidleconns", DefaultUpstreamMaxIdleConns, "The most idle "+
+		"connections to the Summon API kept open for reuse, across all hosts. 0 means no limit.")
+	upstreamMaxIdleConnsPerHost = flag.Int("upstreammaxidleconnsperhost", DefaultUpstreamMaxIdleConnsPerHost,
+		"The most idle connections to each Summon API host kept open for reuse. Under load, connections beyond "+
+			"this are closed after each request and opened again.")
+	upstreamMaxConnsPerHost = flag.Int("upstreammaxconnsperhost", 0, "The most connections to each Summon API "+
+		"host, including ones in use. Requests wait for a connection when they are all in use. 0 means no limit.")
+	upstreamIdleConnTimeout = flag.Duration("upstreamidleconntimeout", DefaultUpstreamIdleConnTimeout, "The time "+
+		"an idle connection to the Summon API is kept open. 0 means no limit.")
+	upstreamKeepAlive = flag.Bool("upstreamkeepalive", true, "Reuse connections to the Summon API. Disabling "+
+		"this opens a new connection for every request.")
+
+	// upstreamClient sends requests to the Summon API, and pools the
+	// connections. It is built again once the flags are parsed. Timeouts are
+	// set on each request, since they can change.
+	upstreamClient = newUpstreamClient()
+)
+
+// newUpstreamClient returns a client for the Summon API, with connection
+// pooling set by the command line flags.
+func newUpstreamClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = *upstreamMaxIdleConns
+	transport.MaxIdleConnsPerHost = *upstreamMaxIdleConnsPerHost
+	transport.MaxConnsPerHost = *upstreamMaxConnsPerHost
+	transport.IdleConnTimeout = *upstreamIdleConnTimeout
+	transport.DisableKeepAlives = !*upstreamKeepAlive
+	return &http.Client{Transport: transport}
+}
+
+// upstreamClientWithTimeout returns a client which shares the upstream
+// client's connections, for requests which aren't proxied, like health checks.
+func upstreamClientWithTimeout(timeout time.Duration) *http.Client {
+	return &http.Client{Transport: upstreamClient.Transport, Timeout: timeout}
+}
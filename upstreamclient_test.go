@@ -0,0 +1,69 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// The upstream client's transport is set by the flags.
+func TestNewUpstreamClient(t *testing.T) {
+	oldPerHost, oldMaxConns, oldIdle, oldKeepAlive := *upstreamMaxIdleConnsPerHost, *upstreamMaxConnsPerHost,
+		*upstreamIdleConnTimeout, *upstreamKeepAlive
+	defer func() {
+		*upstreamMaxIdleConnsPerHost, *upstreamMaxConnsPerHost, *upstreamIdleConnTimeout, *upstreamKeepAlive =
+			oldPerHost, oldMaxConns, oldIdle, oldKeepAlive
+	}()
+	*upstreamMaxIdleConnsPerHost, *upstreamMaxConnsPerHost, *upstreamIdleConnTimeout, *upstreamKeepAlive =
+		10, 20, time.Minute, false
+
+	transport := newUpstreamClient().Transport.(*http.Transport)
+	if transport.MaxIdleConnsPerHost != 10 || transport.MaxConnsPerHost != 20 ||
+		transport.IdleConnTimeout != time.Minute || !transport.DisableKeepAlives {
+		t.Errorf("The transport wasn't set by the flags: %+v", transport)
+	}
+	if transport == http.DefaultTransport {
+		t.Error("The default transport was changed.")
+	}
+	if c := upstreamClientWithTimeout(time.Second); c.Transport != upstreamClient.Transport || c.Timeout != time.Second {
+		t.Error("The client with a timeout doesn't share the upstream client's transport.")
+	}
+}
+
+// Proxied requests reuse connections to the Summon API.
+func TestUpstreamConnectionReuse(t *testing.T) {
+	var opened int32
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"recordCount":0}`))
+	}))
+	upstream.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&opened, 1)
+		}
+	}
+	upstream.Start()
+	defer upstream.Close()
+
+	oldAPIURL, oldClient := *apiURL, upstreamClient
+	defer func() { *apiURL, upstreamClient = oldAPIURL, oldClient }()
+	*apiURL, upstreamClient = upstream.URL, newUpstreamClient()
+
+	for i := 0; i < 5; i++ {
+		w := httptest.NewRecorder()
+		proxyHandler(w, httptest.NewRequest("GET", "/2.0.0/search?s.q=forest", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Request %v got %v %v", i, w.Code, w.Body.String())
+		}
+	}
+	if n := atomic.LoadInt32(&opened); n != 1 {
+		t.Errorf("%v connections were opened for 5 requests, not 1.", n)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	l "github.com/cu-library/lorica/loglevel"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// MaxUpstreamErrorBody is the most of an error response from the Summon API which is read.
+const MaxUpstreamErrorBody = 64 << 10
+
+// The error codes sent to clients when the Summon API responds with an error.
+const (
+	ErrorSummonAuth        = "summon_auth_failed"
+	ErrorSummonBadQuery    = "summon_bad_query"
+	ErrorSummonNotFound    = "summon_not_found"
+	ErrorSummonRateLimited = "summon_rate_limited"
+	ErrorSummonUnavailable = "summon_unavailable"
+	ErrorSummonOther       = "summon_error"
+	ErrorSummonBadResponse = "summon_bad_response"
+)
+
+// The error codes sent when the Summon API doesn't respond.
+const (
+	ErrorUpstreamTimeout     = "upstream_timeout"
+	ErrorUpstreamUnreachable = "upstream_unreachable"
+)
+
+// summonErrorBody is the part of a Summon API error response which is understood.
+// Summon usually sends a list of errors, but sometimes a single message.
+type summonErrorBody struct {
+	Errors []struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+	Message string `json:"message"`
+}
+
+// summonErrorMessage returns the messages in a Summon API error response, if there are any.
+func summonErrorMessage(body []byte) string {
+	var parsed summonErrorBody
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return ""
+	}
+	var messages []string
+	for _, e := range parsed.Errors {
+		if e.Message != "" {
+			messages = append(messages, e.Message)
+		} else if e.Code != "" {
+			messages = append(messages, e.Code)
+		}
+	}
+	if len(messages) == 0 && parsed.Message != "" {
+		messages = append(messages, parsed.Message)
+	}
+	return strings.Join(messages, " ")
+}
+
+// mapUpstreamError returns Lorica's error code, message, and hints for an
+// error status from the Summon API.
+func mapUpstreamError(statusCode int, summonMessage string) (code, message string, hints []string) {
+	switch {
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		code, message = ErrorSummonAuth, "The Summon API refused Lorica's credentials."
+		hints = []string{"This is a problem with Lorica's configuration, not the request. Contact the administrator."}
+	case statusCode == http.StatusBadRequest:
+		code, message = ErrorSummonBadQuery, "The Summon API could not run the query."
+		hints = []string{"Check the query parameters against the Summon API documentation."}
+	case statusCode == http.StatusNotFound:
+		code, message = ErrorSummonNotFound, "The Summon API does not have that endpoint or record."
+	case statusCode == http.StatusTooManyRequests:
+		code, message = ErrorSummonRateLimited, "The Summon API is rate limiting Lorica."
+		hints = []string{"Wait before sending the request again."}
+	case statusCode >= 500:
+		code, message = ErrorSummonUnavailable, "The Summon API is unavailable."
+		hints = []string{"Try again later."}
+	default:
+		code, message = ErrorSummonOther, "The Summon API responded with an error."
+	}
+	if summonMessage != "" && code != ErrorSummonAuth {
+		message = fmt.Sprintf("%v Summon said: %v", message, summonMessage)
+	}
+	return code, message, hints
+}
+
+// sendUpstreamFailure tells the client the Summon API didn't respond to the
+// request in time, or couldn't be reached. The error is only logged.
+func sendUpstreamFailure(w http.ResponseWriter, r *http.Request, err error) {
+	l.Logf(l.ErrorMessage, "Error sending request %v to Summon API: %v", getRequestInfo(r).id, err)
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		sendJSONErrorCode(w, http.StatusGatewayTimeout, ErrorUpstreamTimeout,
+			fmt.Sprintf("The Summon API didn't respond within %v.", *timeout), []string{"Try again later."})
+		return
+	}
+	sendJSONErrorCode(w, http.StatusBadGateway, ErrorUpstreamUnreachable, "Lorica couldn't reach the Summon API.",
+		[]string{"Try again later."})
+}
+
+// sendUpstreamError sends the client a structured error in place of an
+// error response from the Summon API, and logs the Summon API's body.
+func sendUpstreamError(w http.ResponseWriter, r *http.Request, apiResp *http.Response) {
+	body, err := ioutil.ReadAll(io.LimitReader(apiResp.Body, MaxUpstreamErrorBody))
+	apiResp.Body.Close()
+	if err != nil {
+		l.Logf(l.DebugMessage, "Error reading error response %v from Summon API: %v", getRequestInfo(r).id, err)
+	}
+	l.Logf(l.DebugMessage, "Summon API error response to request %v: %v %s",
+		getRequestInfo(r).id, apiResp.Status, body)
+	code, message, hints := mapUpstreamError(apiResp.StatusCode, summonErrorMessage(body))
+	sendJSONErrorCode(w, apiResp.StatusCode, code, message, hints)
+}
@@ -0,0 +1,79 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Summon's messages are found in a list of errors or a single message.
+func TestSummonErrorMessage(t *testing.T) {
+	tests := []struct {
+		body     string
+		expected string
+	}{
+		{`{"errors": [{"code": "invalid.query", "message": "Unbalanced quotes."}]}`, "Unbalanced quotes."},
+		{`{"errors": [{"code": "invalid.query"}]}`, "invalid.query"},
+		{`{"message": "Slow down."}`, "Slow down."},
+		{`<html>Error</html>`, ""},
+	}
+	for _, test := range tests {
+		if message := summonErrorMessage([]byte(test.body)); message != test.expected {
+			t.Errorf("Expected %#v from %v, got %#v", test.expected, test.body, message)
+		}
+	}
+}
+
+// Errors from the Summon API are sent to the client with Lorica's error codes,
+// and Summon's messages about Lorica's credentials aren't passed on.
+func TestProxyHandlerUpstreamError(t *testing.T) {
+	tests := []struct {
+		status   int
+		code     string
+		contains string
+	}{
+		{http.StatusBadRequest, ErrorSummonBadQuery, "Unbalanced quotes."},
+		{http.StatusUnauthorized, ErrorSummonAuth, "refused Lorica's credentials"},
+		{http.StatusTooManyRequests, ErrorSummonRateLimited, "rate limiting"},
+		{http.StatusServiceUnavailable, ErrorSummonUnavailable, "unavailable"},
+	}
+	oldThrottles := throttles
+	defer func() { throttles = oldThrottles }()
+	for _, test := range tests {
+		throttles = newUpstreamThrottles()
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(test.status)
+			fmt.Fprint(w, `{"errors": [{"code": "x", "message": "Unbalanced quotes."}]}`)
+		}))
+		oldAPIURL := *apiURL
+		*apiURL = ts.URL
+
+		req := httptest.NewRequest("GET", "/2.0.0/search?s.q=test", nil)
+		w := httptest.NewRecorder()
+		proxyHandler(w, req)
+		ts.Close()
+		*apiURL = oldAPIURL
+
+		var body jsonError
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("Error response isn't JSON: %v", w.Body.String())
+		}
+		if w.Code != test.status || body.Code != test.code || !strings.Contains(body.Message, test.contains) {
+			t.Errorf("Bad error for %v from Summon, got %v %#v", test.status, w.Code, body)
+		}
+		if test.code == ErrorSummonAuth && strings.Contains(body.Message, "Unbalanced") {
+			t.Errorf("Summon's authentication message was passed on: %v", body.Message)
+		}
+		if w.Header().Get("Content-Type") != "application/json; charset=utf-8" {
+			t.Errorf("Bad Content-Type %v", w.Header().Get("Content-Type"))
+		}
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/cu-library/lorica/metrics"
+	"strings"
+)
+
+// The values of the from label on the version rewrite metric.
+const (
+	VersionRewriteBare  = "bare"
+	VersionRewriteOther = "other_version"
+)
+
+var (
+	pinnedVersion = flag.String("pinversion", "", "A Summon API version, like 2.0.0, which every request is sent to. "+
+		"Paths without a version, like /search, and paths with another version are rewritten to this version "+
+		"before they are signed.")
+
+	versionRewritesTotal = metrics.NewCounterVec("lorica_version_rewrites_total",
+		"The number of request paths rewritten to the pinned Summon API version, "+
+			"by whether the path had no version or another version.", "from")
+)
+
+// checkPinnedVersion checks the pinned version looks like a Summon API version.
+func checkPinnedVersion() error {
+	if *pinnedVersion != "" && !versionPattern.MatchString("/"+*pinnedVersion+"/") {
+		return fmt.Errorf("the version %v should look like 2.0.0", *pinnedVersion)
+	}
+	return nil
+}
+
+// pinVersion rewrites the path to use the pinned version, if there is one.
+// Paths without a version are only rewritten if they start with a known endpoint.
+func pinVersion(path string) string {
+	if *pinnedVersion == "" {
+		return path
+	}
+	pinned := "/" + *pinnedVersion + "/"
+	if strings.HasPrefix(path, pinned) {
+		return path
+	}
+	if versionPattern.MatchString(path) {
+		versionRewritesTotal.With(VersionRewriteOther).Inc()
+		return versionPattern.ReplaceAllLiteralString(path, pinned)
+	}
+	endpoint := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)[0]
+	for _, known := range knownEndpoints {
+		if endpoint == known {
+			versionRewritesTotal.With(VersionRewriteBare).Inc()
+			return strings.TrimSuffix(pinned, "/") + path
+		}
+	}
+	return path
+}
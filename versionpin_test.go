@@ -0,0 +1,39 @@
+// Copyright 2016 Carleton University Library All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+)
+
+func TestPinVersion(t *testing.T) {
+	oldPinnedVersion := *pinnedVersion
+	defer func() { *pinnedVersion = oldPinnedVersion }()
+
+	*pinnedVersion = ""
+	if pinVersion("/search") != "/search" {
+		t.Error("Path rewritten without a pinned version.")
+	}
+
+	*pinnedVersion = "2.0.0"
+	for path, expected := range map[string]string{
+		"/2.0.0/search":           "/2.0.0/search",
+		"/1.0.0/search":           "/2.0.0/search",
+		"/2.1.0/availability/123": "/2.0.0/availability/123",
+		"/search":                 "/2.0.0/search",
+		"/suggest/":               "/2.0.0/suggest/",
+		"/favicon.ico":            "/favicon.ico",
+		"/":                       "/",
+	} {
+		if got := pinVersion(path); got != expected {
+			t.Errorf("Path %v was rewritten to %v, expected %v.", path, got, expected)
+		}
+	}
+
+	*pinnedVersion = "latest"
+	if checkPinnedVersion() == nil {
+		t.Error("Bad version didn't return an error.")
+	}
+}